@@ -0,0 +1,960 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ACL_LOG_GROUPING_MAX_TIME = 60 * time.Second // Similar denials within this time share a log entry
+)
+
+var (
+	ErrACLSyntax            = errors.New("Syntax error")
+	ErrACLUnknownCommand    = errors.New("Unknown command or category name in ACL")
+	ErrACLPasswordNotFound  = errors.New("The password you are trying to remove from the user does not exist")
+	ErrACLBadHash           = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	ErrACLSelectors         = errors.New("Selectors are not supported")
+	ErrACLUsername          = errors.New("Usernames can't contain spaces or null characters")
+	ErrACLDefaultUserRemove = errors.New("ERR The 'default' user cannot be removed")
+	ErrACLNoFile            = errors.New("ERR This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+	ErrACLGenPassBits       = errors.New("ERR ACL GENPASS argument must be the number of bits for the output password, a positive number up to 4096")
+	ErrACLLogCount          = errors.New("ERR value is out of range, must be positive")
+)
+
+// aclCategories lists the ACL categories in the order ACL CAT returns them
+var aclCategories = []string{
+	"keyspace", "read", "write", "set", "sortedset", "list", "hash", "string", "bitmap",
+	"hyperloglog", "geo", "stream", "pubsub", "admin", "fast", "slow", "blocking",
+	"dangerous", "connection", "transaction", "scripting",
+}
+
+// KeyPattern is a key glob and the access it grants
+type KeyPattern struct {
+	Pattern string
+	Read    bool
+	Write   bool
+}
+
+// String formats the pattern like ACL LIST: ~pattern for read and write
+// access, %R~pattern or %W~pattern for one of them
+func (p KeyPattern) String() string {
+	switch {
+	case p.Read && p.Write:
+		return "~" + p.Pattern
+	case p.Read:
+		return "%R~" + p.Pattern
+	default:
+		return "%W~" + p.Pattern
+	}
+}
+
+// User is an ACL user: its credentials and the commands, keys and channels
+// it may use. Users are changed in place, so connected clients see changes.
+type User struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool     // Any password authenticates the user
+	Passwords []string // SHA-256 digests in hex
+	Keys      []KeyPattern
+	Channels  []string
+
+	commandRules []string        // The command rules in effect, for ACL LIST
+	allowed      map[string]bool // Allowed commands and subcommands by name
+}
+
+// NewUser creates a disabled user without passwords that can't run any command
+func NewUser(name string) *User {
+	return &User{Name: name, commandRules: []string{"-@all"}, allowed: make(map[string]bool)}
+}
+
+// newDefaultUser creates the default user, which can do anything without a password
+func newDefaultUser() *User {
+	user := NewUser("default")
+	for _, rule := range []string{"on", "nopass", "allkeys", "allchannels", "allcommands"} {
+		user.SetRule(rule)
+	}
+	return user
+}
+
+// Copy returns a deep copy of the user
+func (u *User) Copy() *User {
+	user := *u
+	user.Passwords = slices.Clone(u.Passwords)
+	user.Keys = slices.Clone(u.Keys)
+	user.Channels = slices.Clone(u.Channels)
+	user.commandRules = slices.Clone(u.commandRules)
+	user.allowed = make(map[string]bool, len(u.allowed))
+	for name, allowed := range u.allowed {
+		user.allowed[name] = allowed
+	}
+	return &user
+}
+
+// replaceRules gives the user the rules of another one. The name is kept,
+// so it can be read without holding the ACL lock.
+func (u *User) replaceRules(other *User) {
+	u.Enabled = other.Enabled
+	u.NoPass = other.NoPass
+	u.Passwords = other.Passwords
+	u.Keys = other.Keys
+	u.Channels = other.Channels
+	u.commandRules = other.commandRules
+	u.allowed = other.allowed
+}
+
+// passwordHash returns the hex SHA-256 digest ACL users store passwords as
+func passwordHash(password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// SetRule applies one ACL SETUSER rule, such as on, >password, ~pattern or +@read
+func (u *User) SetRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+	case "off":
+		u.Enabled = false
+	case "nopass":
+		u.NoPass = true
+		u.Passwords = nil
+	case "resetpass":
+		u.NoPass = false
+		u.Passwords = nil
+	case "allkeys":
+		u.Keys = []KeyPattern{{Pattern: "*", Read: true, Write: true}}
+	case "resetkeys":
+		u.Keys = nil
+	case "allchannels":
+		u.Channels = []string{"*"}
+	case "resetchannels":
+		u.Channels = nil
+	case "allcommands":
+		return u.SetRule("+@all")
+	case "nocommands":
+		return u.SetRule("-@all")
+	case "reset":
+		u.replaceRules(NewUser(u.Name))
+	case "sanitize-payload", "skip-sanitize-payload":
+		// There is no RESTORE, so there are no payloads to sanitize
+	default:
+		if rule == "" {
+			return ErrACLSyntax
+		}
+		switch rule[0] {
+		case '>', '#':
+			hash := passwordHash(rule[1:])
+			if rule[0] == '#' {
+				if hash = rule[1:]; !isPasswordHash(hash) {
+					return ErrACLBadHash
+				}
+			}
+			if !slices.Contains(u.Passwords, hash) {
+				u.Passwords = append(u.Passwords, hash)
+			}
+			u.NoPass = false
+		case '<', '!':
+			hash := passwordHash(rule[1:])
+			if rule[0] == '!' {
+				if hash = rule[1:]; !isPasswordHash(hash) {
+					return ErrACLBadHash
+				}
+			}
+			index := slices.Index(u.Passwords, hash)
+			if index < 0 {
+				return ErrACLPasswordNotFound
+			}
+			u.Passwords = slices.Delete(u.Passwords, index, index+1)
+		case '~', '%':
+			pattern, err := parseKeyPattern(rule)
+			if err != nil {
+				return err
+			}
+			u.addKeyPattern(pattern)
+		case '&':
+			if !slices.Contains(u.Channels, rule[1:]) {
+				u.Channels = append(u.Channels, rule[1:])
+			}
+		case '+', '-':
+			return u.setCommandRule(rule)
+		case '(':
+			return ErrACLSelectors
+		default:
+			return ErrACLSyntax
+		}
+	}
+	return nil
+}
+
+// parseKeyPattern parses ~pattern, %R~pattern, %W~pattern or %RW~pattern
+func parseKeyPattern(rule string) (KeyPattern, error) {
+	if rule[0] == '~' {
+		return KeyPattern{Pattern: rule[1:], Read: true, Write: true}, nil
+	}
+	permissions, pattern, found := strings.Cut(rule[1:], "~")
+	if !found || permissions == "" {
+		return KeyPattern{}, ErrACLSyntax
+	}
+	var p KeyPattern
+	for _, permission := range strings.ToUpper(permissions) {
+		switch permission {
+		case 'R':
+			p.Read = true
+		case 'W':
+			p.Write = true
+		default:
+			return KeyPattern{}, ErrACLSyntax
+		}
+	}
+	p.Pattern = pattern
+	return p, nil
+}
+
+// addKeyPattern adds a key pattern, merging the permissions of a pattern
+// that is already there
+func (u *User) addKeyPattern(pattern KeyPattern) {
+	for i, existing := range u.Keys {
+		if existing.Pattern == pattern.Pattern {
+			u.Keys[i].Read = existing.Read || pattern.Read
+			u.Keys[i].Write = existing.Write || pattern.Write
+			return
+		}
+	}
+	u.Keys = append(u.Keys, pattern)
+}
+
+// setCommandRule applies +command, -command, +command|subcommand, +@category
+// or -@category
+func (u *User) setCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := strings.ToLower(rule[1:])
+	set := func(spec *CommandSpec) {
+		u.allowed[spec.Name] = allow
+		for _, subcommand := range spec.Subcommands {
+			u.allowed[subcommand.Name] = allow
+		}
+	}
+
+	if category, ok := strings.CutPrefix(name, "@"); ok {
+		if category != "all" && !slices.Contains(aclCategories, category) {
+			return ErrACLUnknownCommand
+		}
+		for _, spec := range commandTable {
+			for _, s := range append([]*CommandSpec{spec}, spec.Subcommands...) {
+				if category == "all" || slices.Contains(s.AclCategories(), "@"+category) {
+					u.allowed[s.Name] = allow
+				}
+			}
+		}
+		if category == "all" {
+			// Everything before +@all or -@all is overridden
+			u.commandRules = nil
+		}
+	} else {
+		spec := lookupCommand(name)
+		if spec == nil {
+			return ErrACLUnknownCommand
+		}
+		set(spec)
+		// A later rule for the same command replaces an earlier one
+		u.commandRules = slices.DeleteFunc(u.commandRules, func(r string) bool { return r[1:] == name })
+	}
+	u.commandRules = append(u.commandRules, rule[:1]+name)
+	return nil
+}
+
+// Describe returns the user as an ACL LIST line, which ACL LOAD reads back
+func (u *User) Describe() string {
+	parts := []string{"user", u.Name}
+	if u.Enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.NoPass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range u.Passwords {
+		parts = append(parts, "#"+hash)
+	}
+	for _, pattern := range u.Keys {
+		parts = append(parts, pattern.String())
+	}
+	if len(u.Channels) == 0 {
+		parts = append(parts, "resetchannels")
+	}
+	for _, channel := range u.Channels {
+		parts = append(parts, "&"+channel)
+	}
+	return strings.Join(append(parts, u.commandRules...), " ")
+}
+
+// keysDescription returns the key patterns as ACL GETUSER shows them
+func (u *User) keysDescription() string {
+	patterns := make([]string, len(u.Keys))
+	for i, pattern := range u.Keys {
+		patterns[i] = pattern.String()
+	}
+	return strings.Join(patterns, " ")
+}
+
+// channelsDescription returns the channel patterns as ACL GETUSER shows them
+func (u *User) channelsDescription() string {
+	patterns := make([]string, len(u.Channels))
+	for i, channel := range u.Channels {
+		patterns[i] = "&" + channel
+	}
+	return strings.Join(patterns, " ")
+}
+
+// canAccessKey reports whether a single key pattern grants the access a key
+// spec asks for, like ACLSelectorCheckKey in Redis: ACCESS needs read
+// permission and INSERT, DELETE or UPDATE need write permission
+func (u *User) canAccessKey(key string, spec KeySpec) bool {
+	read := slices.Contains(spec.Flags, "ACCESS")
+	write := slices.Contains(spec.Flags, "INSERT") || slices.Contains(spec.Flags, "DELETE") ||
+		slices.Contains(spec.Flags, "UPDATE")
+	for _, pattern := range u.Keys {
+		if (!read || pattern.Read) && (!write || pattern.Write) && StringMatch(pattern.Pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// ACLDenial describes why a user can't run a command
+type ACLDenial struct {
+	Reason string // command, key, channel or auth
+	Object string // The command, key or channel that was denied
+}
+
+// check reports whether the user may run a command line, name included.
+// Commands that can run before authenticating are always allowed.
+func (u *User) check(spec *CommandSpec, argv []string) *ACLDenial {
+	if spec.hasFlag("no_auth") {
+		return nil
+	}
+	if !u.allowed[spec.Name] {
+		return &ACLDenial{Reason: "command", Object: spec.Name}
+	}
+	var denial *ACLDenial
+	spec.forEachKey(argv, func(key string, keySpec KeySpec) {
+		if denial == nil && !u.canAccessKey(key, keySpec) {
+			denial = &ACLDenial{Reason: "key", Object: key}
+		}
+	})
+	return denial
+}
+
+// Error returns the NOPERM error sent to a client that was denied
+func (d *ACLDenial) Error(username string) string {
+	switch d.Reason {
+	case "command":
+		return fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", username, d.Object)
+	case "key":
+		return "NOPERM No permissions to access a key"
+	default:
+		return "NOPERM No permissions to access a channel"
+	}
+}
+
+// Verbose returns the message ACL DRYRUN replies with, naming the denied object
+func (d *ACLDenial) Verbose(username string) string {
+	switch d.Reason {
+	case "command":
+		return fmt.Sprintf("User %s has no permissions to run the '%s' command", username, d.Object)
+	case "key":
+		return fmt.Sprintf("No permissions to access the '%s' key", d.Object)
+	default:
+		return fmt.Sprintf("No permissions to access the '%s' channel", d.Object)
+	}
+}
+
+// ACLLogEntry records denied commands and failed authentications for ACL LOG
+type ACLLogEntry struct {
+	Count      int64
+	Reason     string
+	Context    string
+	Object     string
+	Username   string
+	ClientInfo string
+	EntryID    int64
+	Created    time.Time
+	Updated    time.Time
+}
+
+// ACL holds the users and the log of security events
+type ACL struct {
+	mu          sync.RWMutex
+	users       map[string]*User
+	defaultUser *User
+
+	logMu     sync.Mutex
+	log       []*ACLLogEntry // Newest first
+	nextLogID int64
+}
+
+var acl = NewACL()
+
+// NewACL creates an ACL with only the default user
+func NewACL() *ACL {
+	defaultUser := newDefaultUser()
+	return &ACL{users: map[string]*User{"default": defaultUser}, defaultUser: defaultUser}
+}
+
+// DefaultUser returns the user new connections start as
+func (a *ACL) DefaultUser() *User {
+	return a.defaultUser
+}
+
+// authRequired reports whether new connections must authenticate, because
+// the default user has a password or is disabled
+func (a *ACL) authRequired() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return !a.defaultUser.NoPass || !a.defaultUser.Enabled
+}
+
+// SetRequirePass makes requirepass the only password of the default user,
+// or removes the password requirement if it is empty
+func (a *ACL) SetRequirePass(password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if password == "" {
+		a.defaultUser.SetRule("nopass")
+	} else {
+		a.defaultUser.SetRule("resetpass")
+		a.defaultUser.SetRule(">" + password)
+	}
+}
+
+// Authenticate returns the enabled user matching the credentials, or nil.
+// Every stored password is compared in constant time.
+func (a *ACL) Authenticate(username, password string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user := a.users[username]
+	if user == nil || !user.Enabled {
+		return nil
+	}
+	if user.NoPass {
+		return user
+	}
+	hash := []byte(passwordHash(password))
+	matched := false
+	for _, stored := range user.Passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(stored)) == 1 {
+			matched = true
+		}
+	}
+	if !matched {
+		return nil
+	}
+	return user
+}
+
+// authenticateCertificate returns the enabled user named by a verified TLS
+// client certificate, or nil. The certificate stands in for the password.
+func (a *ACL) authenticateCertificate(username string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user := a.users[username]
+	if user == nil || !user.Enabled {
+		return nil
+	}
+	return user
+}
+
+// Check reports whether user may run a command line, name included
+func (a *ACL) Check(user *User, spec *CommandSpec, argv []string) *ACLDenial {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return user.check(spec, argv)
+}
+
+// GetUser returns a copy of a user, or nil if it doesn't exist
+func (a *ACL) GetUser(name string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if user := a.users[name]; user != nil {
+		return user.Copy()
+	}
+	return nil
+}
+
+// Users returns the user names in order
+func (a *ACL) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// List returns the ACL LIST lines of every user, ordered by name
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = a.users[name].Describe()
+	}
+	return lines
+}
+
+// validUsername reports whether a name can be used for an ACL user
+func validUsername(name string) bool {
+	return !strings.ContainsAny(name, " \x00")
+}
+
+// SetUser creates or changes a user. The rules are applied to a copy first,
+// so an invalid rule leaves the user unchanged.
+func (a *ACL) SetUser(name string, rules []string) error {
+	if !validUsername(name) {
+		return ErrACLUsername
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	user := a.users[name]
+	updated := NewUser(name)
+	if user != nil {
+		updated = user.Copy()
+	}
+	for _, rule := range rules {
+		if err := updated.SetRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %w", rule, err)
+		}
+	}
+	if user == nil {
+		a.users[name] = updated
+	} else {
+		user.replaceRules(updated)
+	}
+	return nil
+}
+
+// DeleteUsers removes users and disconnects the clients authenticated as
+// them. It returns how many users existed.
+func (a *ACL) DeleteUsers(names []string) (int64, error) {
+	if slices.Contains(names, "default") {
+		return 0, ErrACLDefaultUserRemove
+	}
+	a.mu.Lock()
+	var deleted []*User
+	for _, name := range names {
+		if user := a.users[name]; user != nil {
+			deleted = append(deleted, user)
+			delete(a.users, name)
+		}
+	}
+	a.mu.Unlock()
+	killClientsOfUsers(deleted)
+	return int64(len(deleted)), nil
+}
+
+// killClientsOfUsers disconnects the clients authenticated as any of the users
+func killClientsOfUsers(users []*User) {
+	if len(users) == 0 {
+		return
+	}
+	for _, client := range clients.List() {
+		client.mu.Lock()
+		user := client.user
+		client.mu.Unlock()
+		if slices.Contains(users, user) {
+			client.kill()
+		}
+	}
+}
+
+// LoadFile replaces the users with the ones in an ACL file, which has one
+// ACL LIST style line per user. Nothing changes if the file has an error.
+// Users that exist in both are changed in place, so their clients stay
+// connected; clients of removed users are disconnected.
+func (a *ACL) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %v", path, err)
+	}
+
+	loaded := make(map[string]*User)
+	for i, line := range strings.Split(string(data), "\n") {
+		fail := func(err error) error {
+			return fmt.Errorf("%s:%d: %v. WARNING: ACL errors detected, no change to the previously active ACL rules was performed", path, i+1, err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := SplitConfigArgs(line)
+		if err != nil {
+			return fail(err)
+		}
+		if len(args) < 2 || args[0] != "user" {
+			return fail(errors.New("line should start with user keyword"))
+		}
+		name := args[1]
+		if !validUsername(name) {
+			return fail(ErrACLUsername)
+		}
+		if loaded[name] != nil {
+			return fail(fmt.Errorf("Duplicate user '%s' found", name))
+		}
+		user := NewUser(name)
+		for _, rule := range args[2:] {
+			if err := user.SetRule(rule); err != nil {
+				return fail(fmt.Errorf("Error in user declaration '%s': %w", rule, err))
+			}
+		}
+		loaded[name] = user
+	}
+	if loaded["default"] == nil {
+		loaded["default"] = newDefaultUser()
+	}
+
+	a.mu.Lock()
+	var removed []*User
+	for name, user := range a.users {
+		if replacement := loaded[name]; replacement != nil {
+			user.replaceRules(replacement)
+			loaded[name] = user
+		} else {
+			removed = append(removed, user)
+		}
+	}
+	a.users = loaded
+	a.mu.Unlock()
+	killClientsOfUsers(removed)
+	return nil
+}
+
+// SaveFile writes the users to an ACL file through a temporary file
+func (a *ACL) SaveFile(path string) error {
+	var b strings.Builder
+	for _, line := range a.List() {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(b.String()); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// Log records a denied command or failed authentication. Entries with the
+// same reason, context, object and user within a minute are counted together.
+func (a *ACL) Log(reason, object, username string, client *Client) {
+	now := time.Now()
+	clientInfo := ""
+	if client != nil {
+		clientInfo = client.Info()
+	}
+
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	for i, entry := range a.log {
+		if entry.Reason == reason && entry.Object == object && entry.Username == username &&
+			now.Sub(entry.Updated) < ACL_LOG_GROUPING_MAX_TIME {
+			entry.Count++
+			entry.ClientInfo = clientInfo
+			entry.Updated = now
+			// Move it back to the head, as the most recent entry
+			copy(a.log[1:i+1], a.log[:i])
+			a.log[0] = entry
+			return
+		}
+	}
+
+	entry := &ACLLogEntry{
+		Count:      1,
+		Reason:     reason,
+		Context:    "toplevel",
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		EntryID:    a.nextLogID,
+		Created:    now,
+		Updated:    now,
+	}
+	a.nextLogID++
+	a.log = append([]*ACLLogEntry{entry}, a.log...)
+	if maxLen := currentConfig().ACLLogMaxLen; len(a.log) > maxLen {
+		a.log = a.log[:maxLen]
+	}
+}
+
+// LogEntries returns up to count of the most recent log entries
+func (a *ACL) LogEntries(count int) []ACLLogEntry {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	entries := make([]ACLLogEntry, 0, min(count, len(a.log)))
+	for _, entry := range a.log[:min(count, len(a.log))] {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// ResetLog clears the log
+func (a *ACL) ResetLog() {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.log = nil
+}
+
+// checkPermissions returns a NOPERM error if the client's user can't run a
+// command, and logs the denial
+func (c *Client) checkPermissions(spec *CommandSpec, command string, args []RESPValue) *RESPValue {
+	argv := make([]string, len(args)+1)
+	argv[0] = command
+	for i, arg := range args {
+		argv[i+1] = arg.Str
+	}
+	denial := acl.Check(c.user, spec, argv)
+	if denial == nil {
+		return nil
+	}
+	acl.Log(denial.Reason, denial.Object, c.user.Name, c)
+	return &RESPValue{Type: Error, Str: denial.Error(c.user.Name)}
+}
+
+var aclHelp = []string{
+	"ACL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CAT [<category>]",
+	"    List all commands that belong to <category>, or all command categories",
+	"    when no category is specified.",
+	"DELUSER <username> [<username> ...]",
+	"    Delete a list of users.",
+	"DRYRUN <username> <command> [<arg> ...]",
+	"    Returns whether the user can execute the given command without executing the command.",
+	"GETUSER <username>",
+	"    Get the user's details.",
+	"GENPASS [<bits>]",
+	"    Generate a secure 256-bit user password. The optional `bits` argument can",
+	"    be used to specify a different size.",
+	"LIST",
+	"    Show users details in config file format.",
+	"LOAD",
+	"    Reload users from the ACL file.",
+	"LOG [<count> | RESET]",
+	"    Show the ACL log entries.",
+	"SAVE",
+	"    Save the current config to the ACL file.",
+	"SETUSER <username> <attribute> [<attribute> ...]",
+	"    Create or modify a user with the specified attributes.",
+	"USERS",
+	"    List all the registered usernames.",
+	"WHOAMI",
+	"    Return the current connection username.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleACL runs the ACL subcommands for the client sending them
+func (c *Client) handleACL(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("acl|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'ACL|%s' command", subcommand),
+		}
+	}
+	errorReply := func(err error) *RESPValue {
+		return &RESPValue{Type: Error, Str: err.Error()}
+	}
+	bulk := func(s string) RESPValue { return RESPValue{Type: BulkString, Str: s} }
+
+	switch subcommand {
+	case "SETUSER":
+		rules := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			rules[i] = arg.Str
+		}
+		if err := acl.SetUser(args[1].Str, rules); err != nil {
+			return errorReply(fmt.Errorf("ERR %w", err))
+		}
+		return okReply()
+	case "GETUSER":
+		user := acl.GetUser(args[1].Str)
+		if user == nil {
+			return &RESPValue{Type: BulkString, IsNull: true}
+		}
+		flags := []string{"off"}
+		if user.Enabled {
+			flags[0] = "on"
+		}
+		if user.NoPass {
+			flags = append(flags, "nopass")
+		}
+		passwords := user.Passwords
+		if passwords == nil {
+			passwords = []string{}
+		}
+		return &RESPValue{Type: Array, Array: []RESPValue{
+			bulk("flags"), *bulkStringArray(flags),
+			bulk("passwords"), *bulkStringArray(passwords),
+			bulk("commands"), bulk(strings.Join(user.commandRules, " ")),
+			bulk("keys"), bulk(user.keysDescription()),
+			bulk("channels"), bulk(user.channelsDescription()),
+			bulk("selectors"), {Type: Array},
+		}}
+	case "DELUSER":
+		names := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			names[i] = arg.Str
+		}
+		deleted, err := acl.DeleteUsers(names)
+		if err != nil {
+			return errorReply(err)
+		}
+		return &RESPValue{Type: Integer, Int: deleted}
+	case "LIST":
+		return bulkStringArray(acl.List())
+	case "USERS":
+		return bulkStringArray(acl.Users())
+	case "WHOAMI":
+		return &RESPValue{Type: BulkString, Str: c.user.Name}
+	case "CAT":
+		if len(args) == 1 {
+			return bulkStringArray(aclCategories)
+		}
+		category := strings.ToLower(args[1].Str)
+		if !slices.Contains(aclCategories, category) {
+			return errorReply(fmt.Errorf("ERR Unknown category '%s'", args[1].Str))
+		}
+		return bulkStringArray(ListCommands("ACLCAT", category))
+	case "DRYRUN":
+		user := acl.GetUser(args[1].Str)
+		if user == nil {
+			return errorReply(fmt.Errorf("ERR User '%s' not found", args[1].Str))
+		}
+		spec := lookupCommand(args[2].Str)
+		if spec == nil {
+			return errorReply(fmt.Errorf("ERR Command '%s' not found", args[2].Str))
+		}
+		argv := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			argv[i] = arg.Str
+		}
+		if len(spec.Subcommands) > 0 && len(argv) > 1 {
+			if subcommand := lookupCommand(spec.Name + "|" + argv[1]); subcommand != nil {
+				spec = subcommand
+			}
+		}
+		if !spec.CheckArity(len(argv)) {
+			return errorReply(fmt.Errorf("ERR wrong number of arguments for '%s' command", spec.Name))
+		}
+		if denial := user.check(spec, argv); denial != nil {
+			return &RESPValue{Type: BulkString, Str: denial.Verbose(user.Name)}
+		}
+		return okReply()
+	case "LOG":
+		count := 10
+		if len(args) > 2 {
+			return errorReply(ErrSyntax)
+		}
+		if len(args) == 2 {
+			if strings.EqualFold(args[1].Str, "RESET") {
+				acl.ResetLog()
+				return okReply()
+			}
+			n, err := strconv.Atoi(args[1].Str)
+			if err != nil {
+				return errorReply(ErrNotInteger)
+			}
+			if n < 0 {
+				return errorReply(ErrACLLogCount)
+			}
+			count = n
+		}
+		reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+		now := time.Now()
+		for _, entry := range acl.LogEntries(count) {
+			age := now.Sub(entry.Created).Seconds()
+			reply.Array = append(reply.Array, RESPValue{Type: Array, Array: []RESPValue{
+				bulk("count"), {Type: Integer, Int: entry.Count},
+				bulk("reason"), bulk(entry.Reason),
+				bulk("context"), bulk(entry.Context),
+				bulk("object"), bulk(entry.Object),
+				bulk("username"), bulk(entry.Username),
+				bulk("age-seconds"), bulk(strconv.FormatFloat(age, 'f', 3, 64)),
+				bulk("client-info"), bulk(entry.ClientInfo),
+				bulk("entry-id"), {Type: Integer, Int: entry.EntryID},
+				bulk("timestamp-created"), {Type: Integer, Int: entry.Created.UnixMilli()},
+				bulk("timestamp-last-updated"), {Type: Integer, Int: entry.Updated.UnixMilli()},
+			}})
+		}
+		return reply
+	case "GENPASS":
+		bits := 256
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1].Str)
+			if err != nil || n <= 0 || n > 4096 {
+				return errorReply(ErrACLGenPassBits)
+			}
+			bits = n
+		}
+		random := make([]byte, (bits+7)/8)
+		rand.Read(random)
+		return &RESPValue{Type: BulkString, Str: hex.EncodeToString(random)[:(bits+3)/4]}
+	case "LOAD", "SAVE":
+		path := currentConfig().ACLFile
+		if path == "" {
+			return errorReply(ErrACLNoFile)
+		}
+		var err error
+		if subcommand == "LOAD" {
+			err = acl.LoadFile(path)
+		} else {
+			err = acl.SaveFile(path)
+		}
+		if err != nil {
+			return errorReply(fmt.Errorf("ERR %v", err))
+		}
+		return okReply()
+	case "HELP":
+		return simpleStringArrayReply(aclHelp)
+	default:
+		return errorReply(fmt.Errorf("ERR unknown subcommand '%s'. Try ACL HELP.", args[0].Str))
+	}
+}
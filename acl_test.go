@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUserRules(t *testing.T) {
+	user := NewUser("alice")
+	if user.Describe() != "user alice off resetchannels -@all" {
+		t.Errorf("Unexpected new user %q", user.Describe())
+	}
+
+	rules := []string{"on", ">secret", "~app:*", "%R~shared:*", "%W~shared:*", "&news", "+@read", "-keys", "+config|get", "+get", "-get", "+get"}
+	for _, rule := range rules {
+		if err := user.SetRule(rule); err != nil {
+			t.Fatalf("%s: unexpected error %v", rule, err)
+		}
+	}
+	expected := "user alice on #" + passwordHash("secret") + " ~app:* ~shared:* &news -@all +@read -keys +config|get +get"
+	if user.Describe() != expected {
+		t.Errorf("Expected %q, got %q", expected, user.Describe())
+	}
+
+	user.SetRule("allcommands")
+	user.SetRule("nopass")
+	if !strings.HasSuffix(user.Describe(), " +@all") || !strings.Contains(user.Describe(), " nopass ") {
+		t.Errorf("Unexpected user after allcommands and nopass: %q", user.Describe())
+	}
+	user.SetRule("reset")
+	if user.Describe() != "user alice off resetchannels -@all" {
+		t.Errorf("Expected reset to start over, got %q", user.Describe())
+	}
+
+	tests := []struct {
+		rule     string
+		expected error
+	}{
+		{"+nosuchcommand", ErrACLUnknownCommand},
+		{"-@nosuchcategory", ErrACLUnknownCommand},
+		{"#abc", ErrACLBadHash},
+		{"<missing", ErrACLPasswordNotFound},
+		{"%X~key", ErrACLSyntax},
+		{"(~key +get)", ErrACLSelectors},
+		{"maybe", ErrACLSyntax},
+	}
+	for _, tt := range tests {
+		if err := user.SetRule(tt.rule); err != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.rule, tt.expected, err)
+		}
+	}
+}
+
+func TestUserCheck(t *testing.T) {
+	user := NewUser("alice")
+	for _, rule := range []string{"on", "~app:*", "%R~shared:*", "+@read", "+set", "+config|get"} {
+		if err := user.SetRule(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		argv     []string
+		expected *ACLDenial
+	}{
+		{[]string{"get", "app:1"}, nil},
+		{[]string{"get", "shared:1"}, nil},
+		{[]string{"get", "other"}, &ACLDenial{"key", "other"}},
+		{[]string{"set", "app:1", "v"}, nil},
+		{[]string{"set", "shared:1", "v"}, &ACLDenial{"key", "shared:1"}},
+		{[]string{"del", "app:1"}, &ACLDenial{"command", "del"}},
+		{[]string{"copy", "app:1", "shared:1"}, &ACLDenial{"command", "copy"}},
+		{[]string{"config|get", "port"}, nil},
+		{[]string{"config|set", "port", "1"}, &ACLDenial{"command", "config|set"}},
+		{[]string{"auth", "secret"}, nil},
+	}
+	for _, tt := range tests {
+		denial := user.check(lookupCommand(tt.argv[0]), tt.argv)
+		if !reflect.DeepEqual(denial, tt.expected) {
+			t.Errorf("%v: expected %+v, got %+v", tt.argv, tt.expected, denial)
+		}
+	}
+
+	denial := &ACLDenial{"key", "other"}
+	if denial.Error("alice") != "NOPERM No permissions to access a key" ||
+		denial.Verbose("alice") != "No permissions to access the 'other' key" {
+		t.Errorf("Unexpected messages %q and %q", denial.Error("alice"), denial.Verbose("alice"))
+	}
+}
+
+func TestACLAuthenticate(t *testing.T) {
+	a := NewACL()
+	if a.authRequired() {
+		t.Error("Expected no authentication with the default user")
+	}
+	a.SetRequirePass("secret")
+	if !a.authRequired() || a.Authenticate("default", "wrong") != nil || a.Authenticate("default", "secret") == nil {
+		t.Error("Expected requirepass to protect the default user")
+	}
+
+	if err := a.SetUser("alice", []string{">one", ">two"}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Authenticate("alice", "one") != nil {
+		t.Error("Expected a disabled user not to authenticate")
+	}
+	a.SetUser("alice", []string{"on"})
+	if a.Authenticate("alice", "one") == nil || a.Authenticate("alice", "two") == nil || a.Authenticate("alice", "three") != nil {
+		t.Error("Expected any of the passwords to authenticate")
+	}
+
+	// A failing rule leaves the user unchanged
+	err := a.SetUser("alice", []string{"off", "+nosuch"})
+	if err == nil || err.Error() != "Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL" {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if !a.GetUser("alice").Enabled {
+		t.Error("Expected alice to stay enabled")
+	}
+	if _, err := a.DeleteUsers([]string{"default"}); err != ErrACLDefaultUserRemove {
+		t.Errorf("Expected %v, got %v", ErrACLDefaultUserRemove, err)
+	}
+	if deleted, _ := a.DeleteUsers([]string{"alice", "bob"}); deleted != 1 || !reflect.DeepEqual(a.Users(), []string{"default"}) {
+		t.Errorf("Expected alice to be deleted, got %d and users %v", deleted, a.Users())
+	}
+}
+
+func TestACLLoadSaveFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.acl")
+	contents := `# Staging tenants
+user alice on >secret ~alice:* +@all -@dangerous
+user bob off nopass %R~* resetchannels +get
+`
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewACL()
+	defaultUser := a.DefaultUser()
+	if err := a.LoadFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(a.Users(), []string{"alice", "bob", "default"}) {
+		t.Errorf("Unexpected users %v", a.Users())
+	}
+	if a.DefaultUser() != defaultUser || a.GetUser("default").Describe() != "user default on nopass ~* &* +@all" {
+		t.Errorf("Expected the default user to keep its defaults, got %q", a.GetUser("default").Describe())
+	}
+
+	saved := filepath.Join(dir, "saved.acl")
+	if err := a.SaveFile(saved); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	reloaded := NewACL()
+	if err := reloaded.LoadFile(saved); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(reloaded.List(), a.List()) {
+		t.Errorf("Expected the saved file to load the same users, got %v and %v", reloaded.List(), a.List())
+	}
+
+	// An error anywhere leaves the users unchanged
+	os.WriteFile(path, []byte("user carol on\nuser dave +nosuch\n"), 0644)
+	err := a.LoadFile(path)
+	expected := path + ":2: Error in user declaration '+nosuch': Unknown command or category name in ACL. WARNING: ACL errors detected, no change to the previously active ACL rules was performed"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+	if a.GetUser("carol") != nil || a.GetUser("alice") == nil {
+		t.Error("Expected a failed load to change nothing")
+	}
+	os.WriteFile(path, []byte("alice on\n"), 0644)
+	if err := a.LoadFile(path); err == nil || !strings.Contains(err.Error(), "line should start with user keyword") {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestACLLog(t *testing.T) {
+	a := NewACL()
+	a.Log("command", "get", "alice", nil)
+	a.Log("key", "secret", "alice", nil)
+	a.Log("command", "get", "alice", nil)
+
+	entries := a.LogEntries(10)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Object != "get" || entries[0].Count != 2 || entries[0].EntryID != 0 {
+		t.Errorf("Expected repeated denials to be grouped, got %+v", entries[0])
+	}
+	if entries[1].Object != "secret" || entries[1].Count != 1 {
+		t.Errorf("Unexpected entry %+v", entries[1])
+	}
+	if len(a.LogEntries(1)) != 1 {
+		t.Error("Expected the count to limit the entries")
+	}
+	a.ResetLog()
+	if len(a.LogEntries(10)) != 0 {
+		t.Error("Expected an empty log after a reset")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoAuth            = errors.New("NOAUTH Authentication required.")
+	ErrWrongPass         = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	ErrAuthNotConfigured = errors.New("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	ErrHelloNoAuth       = errors.New("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	ErrNoProto           = errors.New("NOPROTO unsupported protocol version")
+	ErrProtocolVersion   = errors.New("ERR Protocol version is not an integer or out of range")
+)
+
+// authRequired reports whether the client must authenticate before running
+// commands. Clients connected while the default user needed no password stay
+// authenticated.
+func (c *Client) authRequired() bool {
+	return !c.authenticated && acl.authRequired()
+}
+
+// authenticate switches the client to an ACL user if the credentials match.
+// Failures are recorded in the ACL log.
+func (c *Client) authenticate(username, password string) error {
+	user := acl.Authenticate(username, password)
+	if user == nil {
+		acl.Log("auth", "AUTH", username, c)
+		return ErrWrongPass
+	}
+	c.mu.Lock()
+	c.user = user
+	c.authenticated = true
+	c.mu.Unlock()
+	return nil
+}
+
+// handleAuth runs AUTH [username] password
+func (c *Client) handleAuth(args []RESPValue) *RESPValue {
+	if len(args) > 2 {
+		return &RESPValue{Type: Error, Str: ErrSyntax.Error()}
+	}
+	username, password := "default", args[0].Str
+	if len(args) == 2 {
+		username, password = args[0].Str, args[1].Str
+	} else if acl.GetUser("default").NoPass {
+		return &RESPValue{Type: Error, Str: ErrAuthNotConfigured.Error()}
+	}
+	if err := c.authenticate(username, password); err != nil {
+		return &RESPValue{Type: Error, Str: err.Error()}
+	}
+	return okReply()
+}
+
+// handleHello runs HELLO [protover [AUTH username password] [SETNAME clientname]].
+// Only RESP2 is spoken, so the server properties are returned as a flat array.
+func (c *Client) handleHello(args []RESPValue) *RESPValue {
+	errorReply := func(err error) *RESPValue {
+		return &RESPValue{Type: Error, Str: err.Error()}
+	}
+
+	if len(args) > 0 {
+		version, err := strconv.ParseInt(args[0].Str, 10, 64)
+		if err != nil {
+			return errorReply(ErrProtocolVersion)
+		}
+		if version != 2 {
+			return errorReply(ErrNoProto)
+		}
+	}
+
+	var username, password, name string
+	auth, setName := false, false
+	for i := 1; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch {
+		case strings.EqualFold(args[i].Str, "AUTH") && remaining >= 2:
+			auth = true
+			username, password = args[i+1].Str, args[i+2].Str
+			i += 2
+		case strings.EqualFold(args[i].Str, "SETNAME") && remaining >= 1:
+			setName = true
+			name = args[i+1].Str
+			if !validClientName(name) {
+				return errorReply(ErrInvalidClientName)
+			}
+			i++
+		default:
+			return errorReply(fmt.Errorf("ERR Syntax error in HELLO option '%s'", args[i].Str))
+		}
+	}
+
+	if auth {
+		if err := c.authenticate(username, password); err != nil {
+			return errorReply(err)
+		}
+	}
+	if c.authRequired() {
+		return errorReply(ErrHelloNoAuth)
+	}
+	if setName {
+		c.mu.Lock()
+		c.name = name
+		c.mu.Unlock()
+	}
+
+	bulk := func(s string) RESPValue { return RESPValue{Type: BulkString, Str: s} }
+	role := "master"
+	if replication.IsReplica() {
+		role = "replica"
+	}
+	return &RESPValue{Type: Array, Array: []RESPValue{
+		bulk("server"), bulk("redis"),
+		bulk("version"), bulk(REDIS_VERSION),
+		bulk("proto"), {Type: Integer, Int: 2},
+		bulk("id"), {Type: Integer, Int: c.id},
+		bulk("mode"), bulk("standalone"),
+		bulk("role"), bulk(role),
+		bulk("modules"), {Type: Array},
+	}}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// newTestClient creates a client on one end of an in-memory connection
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	conn, other := net.Pipe()
+	client := &Client{id: 42, conn: conn, reader: bufio.NewReader(conn), storage: NewStorage(), user: acl.DefaultUser()}
+	t.Cleanup(func() {
+		replication.RemoveReplica(client)
+		conn.Close()
+		other.Close()
+	})
+	return client
+}
+
+func TestClientAuthentication(t *testing.T) {
+	acl.SetRequirePass("secret")
+	defer acl.SetRequirePass("")
+	client := newTestClient(t)
+	command := func(args ...string) *RESPValue {
+		values := make([]RESPValue, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = RESPValue{Type: BulkString, Str: arg}
+		}
+		return client.execute(args[0], values)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"GET", "key"}, ErrNoAuth.Error()},
+		{[]string{"NOSUCH"}, "ERR unknown command 'NOSUCH'"},
+		{[]string{"AUTH", "wrong"}, ErrWrongPass.Error()},
+		{[]string{"AUTH", "admin", "secret"}, ErrWrongPass.Error()},
+		{[]string{"AUTH", "a", "b", "c"}, ErrSyntax.Error()},
+		{[]string{"HELLO"}, ErrHelloNoAuth.Error()},
+		{[]string{"HELLO", "3"}, ErrNoProto.Error()},
+		{[]string{"HELLO", "two"}, ErrProtocolVersion.Error()},
+		{[]string{"HELLO", "2", "AUTH", "default"}, "ERR Syntax error in HELLO option 'AUTH'"},
+		{[]string{"HELLO", "2", "AUTH", "default", "wrong"}, ErrWrongPass.Error()},
+	}
+	for _, tt := range tests {
+		if resp := command(tt.args...); resp.Type != Error || resp.Str != tt.expected {
+			t.Errorf("%v: expected %q, got %v", tt.args, tt.expected, resp)
+		}
+	}
+
+	hello := command("HELLO", "2", "AUTH", "default", "secret", "SETNAME", "app")
+	expected := []RESPValue{
+		{Type: BulkString, Str: "server"}, {Type: BulkString, Str: "redis"},
+		{Type: BulkString, Str: "version"}, {Type: BulkString, Str: REDIS_VERSION},
+		{Type: BulkString, Str: "proto"}, {Type: Integer, Int: 2},
+		{Type: BulkString, Str: "id"}, {Type: Integer, Int: 42},
+		{Type: BulkString, Str: "mode"}, {Type: BulkString, Str: "standalone"},
+		{Type: BulkString, Str: "role"}, {Type: BulkString, Str: "master"},
+		{Type: BulkString, Str: "modules"}, {Type: Array},
+	}
+	if hello.Type != Array || !reflect.DeepEqual(hello.Array, expected) {
+		t.Errorf("Unexpected HELLO reply %v", hello)
+	}
+	if client.name != "app" {
+		t.Errorf("Expected HELLO to set the name, got %q", client.name)
+	}
+	if resp := command("GET", "key"); resp.Type != BulkString || !resp.IsNull {
+		t.Errorf("Expected commands to run once authenticated, got %v", resp)
+	}
+	acl.SetRequirePass("")
+
+	// Without requirepass AUTH with a password alone is a configuration mistake,
+	// while the default user accepts any password
+	client = newTestClient(t)
+	client.authenticated = true
+	if resp := client.handleAuth([]RESPValue{{Type: BulkString, Str: "pass"}}); resp.Str != ErrAuthNotConfigured.Error() {
+		t.Errorf("Expected %q, got %v", ErrAuthNotConfigured, resp)
+	}
+	if resp := client.handleAuth([]RESPValue{{Type: BulkString, Str: "default"}, {Type: BulkString, Str: "pass"}}); resp.Str != "OK" {
+		t.Errorf("Expected OK, got %v", resp)
+	}
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrNoSuchClient      = errors.New("ERR No such client")
+	ErrInvalidClientName = errors.New("ERR Client names cannot contain spaces, newlines or special characters.")
+	ErrInvalidClientID   = errors.New("ERR client-id should be greater than 0")
+	ErrPauseTimeout      = errors.New("ERR timeout is not an integer or out of range")
+)
+
+// Client holds the state of a client connection. Fields that other clients
+// read through CLIENT LIST and CLIENT KILL are guarded by mu; the rest are
+// only used by the connection's own goroutine.
+type Client struct {
+	mu              sync.Mutex
+	id              int64
+	conn            net.Conn
+	reader          *bufio.Reader
+	storage         *Storage // Selected database
+	name            string
+	libName         string
+	libVersion      string
+	createdAt       time.Time
+	lastInteraction time.Time
+	lastCommand     string
+	queryBuffered   int // Unparsed bytes in reader when the last command ran
+	noEvict         bool
+	monitor         bool     // In MONITOR mode
+	master          bool     // Applies the replication stream of the master of this server
+	replica         *replica // Set once the client asked to synchronize as a replica
+	replicaPort     int      // Port the replica listens on, from REPLCONF listening-port
+	replicaAddr     string   // Address the replica announced with REPLCONF ip-address
+	user            *User    // ACL user the client runs commands as
+	authenticated   bool
+
+	writeMu sync.Mutex   // Serializes replies with the MONITOR feed
+	called  *CommandSpec // Command that passed every check and ran, nil if it was rejected
+	failed  bool         // The command replied with an error, even if the reply was suppressed
+
+	replyOff        bool // CLIENT REPLY OFF
+	replySkip       bool // Don't reply to the current command
+	replySkipNext   bool // CLIENT REPLY SKIP, don't reply to the next command
+	closeAfterReply bool // Killed itself with CLIENT KILL or sent QUIT
+}
+
+// ClientRegistry tracks the connected clients by id
+type ClientRegistry struct {
+	mu      sync.RWMutex
+	clients map[int64]*Client
+	nextID  atomic.Int64
+}
+
+var clients = &ClientRegistry{clients: make(map[int64]*Client)}
+
+// Add registers a new connection. Every connection starts on database 0.
+func (r *ClientRegistry) Add(conn net.Conn, reader *bufio.Reader) *Client {
+	now := time.Now()
+	client := &Client{
+		id:              r.nextID.Add(1),
+		conn:            conn,
+		reader:          reader,
+		storage:         databases[0],
+		createdAt:       now,
+		lastInteraction: now,
+		lastCommand:     "NULL",
+		user:            acl.DefaultUser(),
+		authenticated:   !acl.authRequired(),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clients[client.id] = client
+	return client
+}
+
+// Remove unregisters a closed connection
+func (r *ClientRegistry) Remove(client *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.clients, client.id)
+}
+
+// List returns the connected clients ordered by id
+func (r *ClientRegistry) List() []*Client {
+	r.mu.RLock()
+	list := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		list = append(list, client)
+	}
+	r.mu.RUnlock()
+
+	slices.SortFunc(list, func(a, b *Client) int { return cmp.Compare(a.id, b.id) })
+	return list
+}
+
+// run executes a command and returns the reply to send, or nil if CLIENT
+// REPLY turned replies off for it
+func (c *Client) run(command string, args []RESPValue) *RESPValue {
+	skip := c.replySkip
+	c.replySkip = false
+
+	response := c.execute(command, args)
+	c.failed = response != nil && response.Type == Error
+
+	if c.replySkipNext {
+		c.replySkip = true
+		c.replySkipNext = false
+	}
+	if response == nil || skip || c.replyOff {
+		return nil
+	}
+	return response
+}
+
+// write sends bytes to the connection, counting them in the stats
+func (c *Client) write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	written, err := c.conn.Write(b)
+	stats.netOutputBytes.Add(int64(written))
+	return written, err
+}
+
+// touch records a command for the idle time, cmd and qbuf fields of CLIENT
+// LIST. It runs on the client's own goroutine, the only one reading from reader.
+func (c *Client) touch(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastInteraction = time.Now()
+	c.lastCommand = command
+	c.queryBuffered = c.reader.Buffered()
+}
+
+// selectDatabase switches the client to another database
+func (c *Client) selectDatabase(db *Storage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.storage = db
+}
+
+// db returns the index of the database the client is on
+func (c *Client) db() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.storage.index
+}
+
+// kill closes the connection, which ends its read loop
+func (c *Client) kill() {
+	c.conn.Close()
+}
+
+// fd returns the file descriptor of the connection, or -1 if it has none
+func (c *Client) fd() int64 {
+	fd := int64(-1)
+	if conn, ok := c.conn.(syscall.Conn); ok {
+		if raw, err := conn.SyscallConn(); err == nil {
+			raw.Control(func(descriptor uintptr) {
+				fd = int64(descriptor)
+			})
+		}
+	}
+	return fd
+}
+
+// addr returns the remote address of the client. Unix socket clients are
+// unnamed, so like Redis they show the socket path with port 0.
+func (c *Client) addr() string {
+	if c.isUnixSocket() {
+		return c.laddr()
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// laddr returns the address the client connected to
+func (c *Client) laddr() string {
+	if c.isUnixSocket() {
+		return c.conn.LocalAddr().String() + ":0"
+	}
+	return c.conn.LocalAddr().String()
+}
+
+// clientType returns the type CLIENT KILL and CLIENT LIST filter clients by:
+// master, replica or normal. The caller holds mu.
+func (c *Client) clientType() string {
+	switch {
+	case c.master:
+		return "master"
+	case c.replica != nil:
+		return "replica"
+	}
+	return "normal"
+}
+
+// isUnixSocket reports whether the client connected through the unix socket
+func (c *Client) isUnixSocket() bool {
+	return c.conn.LocalAddr().Network() == "unix"
+}
+
+// flags returns the CLIENT LIST flags of the client
+func (c *Client) flags() string {
+	flags := ""
+	if c.monitor {
+		flags += "O"
+	} else if c.replica != nil {
+		flags += "S"
+	}
+	if c.master {
+		flags += "M"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	if c.isUnixSocket() {
+		flags += "U"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// Info renders the client as a CLIENT LIST line, with the fields in the Redis order
+func (c *Client) Info() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	buffered := c.queryBuffered
+	return fmt.Sprintf("id=%d addr=%s laddr=%s fd=%d name=%s age=%d idle=%d flags=%s db=%d "+
+		"sub=0 psub=0 ssub=0 multi=-1 qbuf=%d qbuf-free=%d rbs=%d obl=0 oll=0 omem=0 "+
+		"events=r cmd=%s user=%s redir=-1 resp=2 lib-name=%s lib-ver=%s",
+		c.id, c.addr(), c.laddr(), c.fd(), c.name,
+		int64(now.Sub(c.createdAt).Seconds()), int64(now.Sub(c.lastInteraction).Seconds()),
+		c.flags(), c.storage.index, buffered, c.reader.Size()-buffered, c.reader.Size(),
+		c.lastCommand, c.user.Name, c.libName, c.libVersion)
+}
+
+// clientPause implements CLIENT PAUSE: until it ends, commands of every client
+// or only write commands wait before running
+type clientPause struct {
+	mu      sync.Mutex
+	end     time.Time
+	all     bool          // Pause every command, not only writes
+	resumed chan struct{} // Closed by Unpause
+}
+
+var pause = &clientPause{resumed: make(chan struct{})}
+
+// Pause starts or extends a pause. Like Redis, overlapping pauses keep the
+// later end time and the stricter mode.
+func (p *clientPause) Pause(timeout time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	end := time.Now().Add(timeout)
+	if time.Now().After(p.end) {
+		p.all = all
+	} else {
+		p.all = p.all || all
+	}
+	if end.After(p.end) {
+		p.end = end
+	}
+}
+
+// Unpause ends the pause and wakes up the waiting clients
+func (p *clientPause) Unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.end = time.Time{}
+	p.all = false
+	close(p.resumed)
+	p.resumed = make(chan struct{})
+}
+
+// Wait blocks while a pause applies to the command
+func (p *clientPause) Wait(spec *CommandSpec) {
+	for {
+		p.mu.Lock()
+		remaining := time.Until(p.end)
+		applies := remaining > 0 && (p.all || spec.hasFlag("write") || spec.hasFlag("may_replicate"))
+		resumed := p.resumed
+		p.mu.Unlock()
+		if !applies {
+			return
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-resumed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// clientKillFilter selects the clients killed by CLIENT KILL
+type clientKillFilter struct {
+	id         int64
+	clientType string
+	user       string
+	addr       string
+	laddr      string
+	skipMe     bool
+	maxAge     int64
+}
+
+// parseClientKillFilter parses the filter form of CLIENT KILL
+func parseClientKillFilter(args []RESPValue) (*clientKillFilter, error) {
+	filter := &clientKillFilter{skipMe: true}
+	if len(args)%2 != 0 {
+		return nil, ErrSyntax
+	}
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1].Str
+		switch strings.ToUpper(args[i].Str) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, ErrInvalidClientID
+			}
+			filter.id = id
+		case "TYPE":
+			switch clientType := strings.ToLower(value); clientType {
+			case "normal", "master", "replica", "pubsub":
+				filter.clientType = clientType
+			case "slave":
+				filter.clientType = "replica"
+			default:
+				return nil, fmt.Errorf("ERR Unknown client type '%s'", value)
+			}
+		case "USER":
+			filter.user = value
+		case "ADDR":
+			filter.addr = value
+		case "LADDR":
+			filter.laddr = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return nil, ErrSyntax
+			}
+		case "MAXAGE":
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+			filter.maxAge = maxAge
+		default:
+			return nil, ErrSyntax
+		}
+	}
+	return filter, nil
+}
+
+// matches reports whether the filter selects target when run by self
+func (f *clientKillFilter) matches(self, target *Client) bool {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+
+	switch {
+	case f.id != 0 && target.id != f.id:
+		return false
+	case f.clientType != "" && target.clientType() != f.clientType:
+		return false
+	case f.user != "" && target.user.Name != f.user:
+		return false
+	case f.addr != "" && target.addr() != f.addr:
+		return false
+	case f.laddr != "" && target.laddr() != f.laddr:
+		return false
+	case f.skipMe && target == self:
+		return false
+	case f.maxAge != 0 && time.Since(target.createdAt) < time.Duration(f.maxAge)*time.Second:
+		return false
+	}
+	return true
+}
+
+// killClients kills every client selected by the filter and returns how many
+func (c *Client) killClients(filter *clientKillFilter) int64 {
+	var killed int64
+	for _, target := range clients.List() {
+		if !filter.matches(c, target) {
+			continue
+		}
+		if target == c {
+			// Kill ourselves once the reply is sent
+			c.closeAfterReply = true
+		} else {
+			target.kill()
+		}
+		killed++
+	}
+	return killed
+}
+
+// validClientName reports whether a name only has printable characters and no spaces
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GETNAME",
+	"    Return the name of the current connection.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"INFO",
+	"    Return information about the current client connection.",
+	"KILL <ip:port>",
+	"    Kill connection made from <ip:port>.",
+	"KILL <option> <value> [<option> <value> [...]]",
+	"    Kill connections. Options are:",
+	"    * ADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made from the specified address",
+	"    * LADDR (<ip:port>|<unixsocket>:0)",
+	"      Kill connections made to specified local address",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Kill connections by type.",
+	"    * USER <username>",
+	"      Kill connections authenticated by <username>.",
+	"    * SKIPME (YES|NO)",
+	"      Skip killing current connection (default: yes).",
+	"    * ID <client-id>",
+	"      Kill connections by client id.",
+	"    * MAXAGE <maxage>",
+	"      Kill connections older than the specified age.",
+	"LIST [options ...]",
+	"    Return information about client connections. Options:",
+	"    * TYPE (NORMAL|MASTER|REPLICA|PUBSUB)",
+	"      Return clients of specified type.",
+	"    * ID <client-id> [<client-id> ...]",
+	"      Return clients of specified IDs only.",
+	"PAUSE <timeout> [WRITE|ALL]",
+	"    Suspend all, or just write, clients for <timeout> milliseconds.",
+	"UNPAUSE",
+	"    Stop the current client pause, resuming traffic.",
+	"SETNAME <name>",
+	"    Assign the name <name> to the current connection.",
+	"SETINFO <option> <value>",
+	"    Set client meta attr. Options are:",
+	"    * LIB-NAME: the client lib name.",
+	"    * LIB-VER: the client lib version.",
+	"NO-EVICT (ON|OFF)",
+	"    Protect current client connection from eviction.",
+	"REPLY (ON|OFF|SKIP)",
+	"    Control the replies sent to the current connection.",
+	"HELP",
+	"    Print this help.",
+}
+
+func okReply() *RESPValue {
+	return &RESPValue{Type: SimpleString, Str: "OK"}
+}
+
+// handleClient runs the CLIENT subcommands for the client sending them
+func (c *Client) handleClient(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("client|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'CLIENT|%s' command", subcommand),
+		}
+	}
+	errorReply := func(err error) *RESPValue {
+		return &RESPValue{Type: Error, Str: err.Error()}
+	}
+
+	switch subcommand {
+	case "ID":
+		return &RESPValue{Type: Integer, Int: c.id}
+	case "INFO":
+		return &RESPValue{Type: BulkString, Str: c.Info() + "\n"}
+	case "LIST":
+		var ids map[int64]bool
+		clientType := ""
+		for i := 1; i < len(args); i++ {
+			switch {
+			case strings.EqualFold(args[i].Str, "TYPE") && i+1 < len(args):
+				clientType = strings.ToLower(args[i+1].Str)
+				if clientType == "slave" {
+					clientType = "replica"
+				}
+				if clientType != "normal" && clientType != "master" && clientType != "replica" && clientType != "pubsub" {
+					return errorReply(fmt.Errorf("ERR Unknown client type '%s'", args[i+1].Str))
+				}
+				i++
+			case strings.EqualFold(args[i].Str, "ID") && i+1 < len(args):
+				ids = make(map[int64]bool)
+				for i++; i < len(args); i++ {
+					id, err := strconv.ParseInt(args[i].Str, 10, 64)
+					if err != nil || id <= 0 {
+						return errorReply(fmt.Errorf("ERR Invalid client ID"))
+					}
+					ids[id] = true
+				}
+			default:
+				return errorReply(ErrSyntax)
+			}
+		}
+
+		var list strings.Builder
+		for _, client := range clients.List() {
+			if ids != nil && !ids[client.id] {
+				continue
+			}
+			client.mu.Lock()
+			matches := clientType == "" || client.clientType() == clientType
+			client.mu.Unlock()
+			if !matches {
+				continue
+			}
+			list.WriteString(client.Info())
+			list.WriteByte('\n')
+		}
+		return &RESPValue{Type: BulkString, Str: list.String()}
+	case "SETNAME":
+		if !validClientName(args[1].Str) {
+			return errorReply(ErrInvalidClientName)
+		}
+		c.mu.Lock()
+		c.name = args[1].Str
+		c.mu.Unlock()
+		return okReply()
+	case "GETNAME":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.name == "" {
+			return &RESPValue{Type: BulkString, IsNull: true}
+		}
+		return &RESPValue{Type: BulkString, Str: c.name}
+	case "SETINFO":
+		value := args[2].Str
+		if !validClientName(value) {
+			return errorReply(fmt.Errorf("ERR %s cannot contain spaces, newlines or special characters.", strings.ToLower(args[1].Str)))
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch strings.ToUpper(args[1].Str) {
+		case "LIB-NAME":
+			c.libName = value
+		case "LIB-VER":
+			c.libVersion = value
+		default:
+			return errorReply(fmt.Errorf("ERR Unrecognized option '%s'", args[1].Str))
+		}
+		return okReply()
+	case "KILL":
+		if len(args) == 2 {
+			// Old form, CLIENT KILL addr
+			killed := c.killClients(&clientKillFilter{addr: args[1].Str})
+			if killed == 0 {
+				return errorReply(ErrNoSuchClient)
+			}
+			return okReply()
+		}
+		filter, err := parseClientKillFilter(args[1:])
+		if err != nil {
+			return errorReply(err)
+		}
+		return &RESPValue{Type: Integer, Int: c.killClients(filter)}
+	case "PAUSE":
+		timeout, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil || timeout < 0 {
+			return errorReply(ErrPauseTimeout)
+		}
+		all := true
+		if len(args) == 3 {
+			switch strings.ToUpper(args[2].Str) {
+			case "WRITE":
+				all = false
+			case "ALL":
+			default:
+				return errorReply(ErrSyntax)
+			}
+		} else if len(args) > 3 {
+			return errorReply(ErrSyntax)
+		}
+		pause.Pause(time.Duration(timeout)*time.Millisecond, all)
+		return okReply()
+	case "UNPAUSE":
+		pause.Unpause()
+		return okReply()
+	case "NO-EVICT":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch strings.ToUpper(args[1].Str) {
+		case "ON":
+			c.noEvict = true
+		case "OFF":
+			c.noEvict = false
+		default:
+			return errorReply(ErrSyntax)
+		}
+		return okReply()
+	case "REPLY":
+		switch strings.ToUpper(args[1].Str) {
+		case "ON":
+			c.replyOff = false
+			c.replySkipNext = false
+			return okReply()
+		case "OFF":
+			c.replyOff = true
+			return nil
+		case "SKIP":
+			if !c.replyOff {
+				c.replySkipNext = true
+			}
+			return nil
+		default:
+			return errorReply(ErrSyntax)
+		}
+	case "HELP":
+		return simpleStringArrayReply(clientHelp)
+	default:
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try CLIENT HELP.", args[0].Str),
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseClientKillFilter(t *testing.T) {
+	args := func(values ...string) []RESPValue {
+		result := make([]RESPValue, len(values))
+		for i, value := range values {
+			result[i] = RESPValue{Type: BulkString, Str: value}
+		}
+		return result
+	}
+
+	filter, err := parseClientKillFilter(args("ID", "7", "TYPE", "Normal", "SKIPME", "no", "MAXAGE", "10"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := clientKillFilter{id: 7, clientType: "normal", skipMe: false, maxAge: 10}
+	if *filter != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *filter)
+	}
+
+	tests := []struct {
+		args     []RESPValue
+		expected string
+	}{
+		{args("ID", "0"), "ERR client-id should be greater than 0"},
+		{args("TYPE", "robot"), "ERR Unknown client type 'robot'"},
+		{args("SKIPME", "maybe"), "ERR syntax error"},
+		{args("ADDR"), "ERR syntax error"},
+		{args("COLOR", "red"), "ERR syntax error"},
+	}
+	for _, tt := range tests {
+		if _, err := parseClientKillFilter(tt.args); err == nil || err.Error() != tt.expected {
+			t.Errorf("Expected error %q, got %v", tt.expected, err)
+		}
+	}
+}
+
+func TestValidClientName(t *testing.T) {
+	for name, valid := range map[string]bool{"worker-1": true, "": true, "two words": false, "line\n": false} {
+		if validClientName(name) != valid {
+			t.Errorf("validClientName(%q) expected %v", name, valid)
+		}
+	}
+}
+
+func TestClientPause(t *testing.T) {
+	p := &clientPause{resumed: make(chan struct{})}
+	get, set := lookupCommand("get"), lookupCommand("set")
+
+	p.Pause(50*time.Millisecond, false)
+	start := time.Now()
+	p.Wait(get)
+	if time.Since(start) > 20*time.Millisecond {
+		t.Error("Expected reads not to wait during a write pause")
+	}
+	p.Wait(set)
+	if time.Since(start) < 40*time.Millisecond {
+		t.Error("Expected writes to wait for the pause to end")
+	}
+
+	// A shorter pause doesn't shorten the current one, and ALL is stricter than WRITE
+	p.Pause(time.Hour, false)
+	p.Pause(time.Millisecond, true)
+	done := make(chan struct{})
+	go func() {
+		p.Wait(get)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected reads to wait during an ALL pause")
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.Unpause()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Unpause to resume waiting clients")
+	}
+}
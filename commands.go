@@ -0,0 +1,908 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrInvalidCommand     = errors.New("ERR Invalid command specified")
+	ErrInvalidCommandArgs = errors.New("ERR Invalid number of arguments specified for command")
+	ErrNoKeyArguments     = errors.New("ERR The command has no key arguments")
+)
+
+// CommandSpec describes a command: how many arguments it takes, its flags,
+// where its keys are and its documentation. The command table is the single
+// source for the COMMAND replies and for checking commands before they run.
+type CommandSpec struct {
+	Name        string // Lowercase, parent|child for subcommands
+	Arity       int    // Number of arguments including the name, negative for a minimum
+	Flags       []string
+	Categories  []string // ACL categories besides the ones implied by the flags
+	KeySpecs    []KeySpec
+	Group       string
+	Since       string
+	Summary     string
+	Complexity  string
+	Subcommands []*CommandSpec
+
+	stats commandStats // Calls and latency, for INFO commandstats and the metrics
+}
+
+// KeySpec locates a range of key arguments, like a Redis key specification
+// with an index begin_search and a range find_keys
+type KeySpec struct {
+	Flags   []string
+	Index   int // Argument position of the first key
+	LastKey int // Last key relative to the first, or negative to count from the last argument
+	KeyStep int
+}
+
+func keyRange(index, lastKey, keyStep int, flags ...string) KeySpec {
+	return KeySpec{Flags: flags, Index: index, LastKey: lastKey, KeyStep: keyStep}
+}
+
+var commandTable = []*CommandSpec{
+	{
+		Name: "ping", Arity: -1, Flags: []string{"fast"}, Categories: []string{"@connection"},
+		Group: "connection", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns the server's liveliness response.",
+	},
+	{
+		Name: "echo", Arity: 2, Flags: []string{"fast"}, Categories: []string{"@connection"},
+		Group: "connection", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns the given string.",
+	},
+	{
+		Name: "auth", Arity: -2, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth", "allow_busy"},
+		Categories: []string{"@connection"},
+		Group:      "connection", Since: "1.0.0", Complexity: "O(N) where N is the number of passwords defined for the user",
+		Summary: "Authenticates the connection.",
+	},
+	{
+		Name: "hello", Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth", "allow_busy"},
+		Categories: []string{"@connection"},
+		Group:      "connection", Since: "6.0.0", Complexity: "O(1)",
+		Summary: "Handshakes with the Redis server.",
+	},
+	{
+		Name: "quit", Arity: -1, Flags: []string{"noscript", "loading", "stale", "fast", "no_auth", "allow_busy"},
+		Categories: []string{"@connection"},
+		Group:      "connection", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Closes the connection.",
+	},
+	{
+		Name: "select", Arity: 2, Flags: []string{"loading", "stale", "fast"}, Categories: []string{"@connection"},
+		Group: "connection", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Changes the selected database.",
+	},
+	{
+		Name: "set", Arity: 3, Flags: []string{"write", "denyoom"}, Categories: []string{"@string"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "OW", "UPDATE")},
+		Group:    "string", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+	},
+	{
+		Name: "get", Arity: 2, Flags: []string{"readonly", "fast"}, Categories: []string{"@string"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "string", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns the string value of a key.",
+	},
+	{
+		Name: "del", Arity: -2, Flags: []string{"write"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, -1, 1, "RM", "DELETE")},
+		Group:    "generic", Since: "1.0.0", Complexity: "O(N) where N is the number of keys that will be removed.",
+		Summary: "Deletes one or more keys.",
+	},
+	{
+		Name: "unlink", Arity: -2, Flags: []string{"write", "fast"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, -1, 1, "RM", "DELETE")},
+		Group:    "generic", Since: "4.0.0", Complexity: "O(1) for each key removed regardless of its size.",
+		Summary: "Asynchronously deletes one or more keys.",
+	},
+	{
+		Name: "exists", Arity: -2, Flags: []string{"readonly", "fast"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, -1, 1, "RO")},
+		Group:    "generic", Since: "1.0.0", Complexity: "O(N) where N is the number of keys to check.",
+		Summary: "Determines whether one or more keys exist.",
+	},
+	{
+		Name: "touch", Arity: -2, Flags: []string{"readonly", "fast"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, -1, 1, "RO")},
+		Group:    "generic", Since: "3.2.1", Complexity: "O(N) where N is the number of keys that will be touched.",
+		Summary: "Returns the number of existing keys out of those specified after updating the time they were last accessed.",
+	},
+	{
+		Name: "type", Arity: 2, Flags: []string{"readonly", "fast"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO")},
+		Group:    "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Determines the type of value stored at a key.",
+	},
+	{
+		Name: "rename", Arity: 3, Flags: []string{"write"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RW", "ACCESS", "DELETE"), keyRange(2, 0, 1, "OW", "UPDATE")},
+		Group:    "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Renames a key and overwrites the destination.",
+	},
+	{
+		Name: "renamenx", Arity: 3, Flags: []string{"write", "fast"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RW", "ACCESS", "DELETE"), keyRange(2, 0, 1, "OW", "INSERT")},
+		Group:    "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Renames a key only when the target key name doesn't exist.",
+	},
+	{
+		Name: "copy", Arity: -3, Flags: []string{"write", "denyoom"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS"), keyRange(2, 0, 1, "OW", "UPDATE")},
+		Group:    "generic", Since: "6.2.0", Complexity: "O(N) worst case for collections, where N is the number of nested items. O(1) for string values.",
+		Summary: "Copies the value of a key to a new key.",
+	},
+	{
+		Name: "move", Arity: 3, Flags: []string{"write", "fast"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RW", "ACCESS", "DELETE")},
+		Group:    "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Moves a key to another database.",
+	},
+	{
+		Name: "randomkey", Arity: 1, Flags: []string{"readonly"}, Categories: []string{"@keyspace"},
+		Group: "generic", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns a random key name from the database.",
+	},
+	{
+		Name: "keys", Arity: 2, Flags: []string{"readonly"}, Categories: []string{"@keyspace", "@dangerous"},
+		Group: "generic", Since: "1.0.0", Complexity: "O(N) with N being the number of keys in the database, under the assumption that the key names in the database and the given pattern have limited length.",
+		Summary: "Returns all key names that match a pattern.",
+	},
+	{
+		Name: "scan", Arity: -2, Flags: []string{"readonly"}, Categories: []string{"@keyspace"},
+		Group: "generic", Since: "2.8.0", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+		Summary: "Iterates over the key names in the database.",
+	},
+	{
+		Name: "zscan", Arity: -3, Flags: []string{"readonly"}, Categories: []string{"@sortedset"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "sorted_set", Since: "2.8.0", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+		Summary: "Iterates over members and scores of a sorted set.",
+	},
+	{
+		Name: "hscan", Arity: -3, Flags: []string{"readonly"}, Categories: []string{"@hash"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "hash", Since: "2.8.0", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+		Summary: "Iterates over fields and values of a hash.",
+	},
+	{
+		Name: "sscan", Arity: -3, Flags: []string{"readonly"}, Categories: []string{"@set"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "set", Since: "2.8.0", Complexity: "O(1) for every call. O(N) for a complete iteration, including enough command calls for the cursor to return back to 0. N is the number of elements inside the collection.",
+		Summary: "Iterates over members of a set.",
+	},
+	{
+		Name: "swapdb", Arity: 3, Flags: []string{"write", "fast"}, Categories: []string{"@keyspace", "@dangerous"},
+		Group: "server", Since: "4.0.0", Complexity: "O(N) where N is the count of clients watching or blocking on keys from both databases.",
+		Summary: "Swaps two Redis databases.",
+	},
+	{
+		Name: "flushdb", Arity: -1, Flags: []string{"write"}, Categories: []string{"@keyspace", "@dangerous"},
+		Group: "server", Since: "1.0.0", Complexity: "O(N) where N is the number of keys in the selected database",
+		Summary: "Removes all keys from the current database.",
+	},
+	{
+		Name: "flushall", Arity: -1, Flags: []string{"write"}, Categories: []string{"@keyspace", "@dangerous"},
+		Group: "server", Since: "1.0.0", Complexity: "O(N) where N is the total number of keys in all databases",
+		Summary: "Removes all keys from all databases.",
+	},
+	{
+		Name: "dbsize", Arity: 1, Flags: []string{"readonly", "fast"}, Categories: []string{"@keyspace"},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns the number of keys in the database.",
+	},
+	{
+		Name: "config", Arity: -2,
+		Group: "server", Since: "2.0.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for server configuration commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "config|get", Arity: -3, Flags: []string{"admin", "noscript", "loading", "stale"},
+				Group: "server", Since: "2.0.0", Complexity: "O(N) when N is the number of configuration parameters provided",
+				Summary: "Returns the effective values of configuration parameters.",
+			},
+			{
+				Name: "config|set", Arity: -4, Flags: []string{"admin", "noscript", "loading", "stale"},
+				Group: "server", Since: "2.0.0", Complexity: "O(N) when N is the number of configuration parameters provided",
+				Summary: "Sets configuration parameters in-flight.",
+			},
+			{
+				Name: "config|resetstat", Arity: 2, Flags: []string{"admin", "noscript", "loading", "stale"},
+				Group: "server", Since: "2.0.0", Complexity: "O(1)",
+				Summary: "Resets the server's statistics.",
+			},
+			{
+				Name: "config|rewrite", Arity: 2, Flags: []string{"admin", "noscript", "loading", "stale"},
+				Group: "server", Since: "2.8.0", Complexity: "O(1)",
+				Summary: "Persists the effective configuration to file.",
+			},
+			{
+				Name: "config|help", Arity: 2, Flags: []string{"loading", "stale"},
+				Group: "server", Since: "5.0.0", Complexity: "O(1)",
+				Summary: "Returns helpful text about the different subcommands.",
+			},
+		},
+	},
+	{
+		Name: "client", Arity: -2,
+		Group: "connection", Since: "2.4.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for client connection commands.",
+		Subcommands: []*CommandSpec{
+			clientSubcommand("id", 2, false, "5.0.0", "O(1)", "Returns the unique client ID of the connection."),
+			clientSubcommand("info", 2, false, "6.2.0", "O(1)", "Returns information about the connection."),
+			clientSubcommand("list", -2, true, "2.4.0", "O(N) where N is the number of client connections", "Lists open connections."),
+			clientSubcommand("setname", 3, false, "2.6.9", "O(1)", "Sets the connection name."),
+			clientSubcommand("getname", 2, false, "2.6.9", "O(1)", "Returns the name of the connection."),
+			clientSubcommand("setinfo", 4, false, "7.2.0", "O(1)", "Sets information specific to the client or connection."),
+			clientSubcommand("kill", -3, true, "2.4.0", "O(N) where N is the number of client connections", "Terminates open connections."),
+			clientSubcommand("pause", -3, true, "3.0.0", "O(1)", "Suspends commands processing."),
+			clientSubcommand("unpause", 2, true, "6.2.0", "O(N) Where N is the number of paused clients", "Resumes processing commands from paused clients."),
+			clientSubcommand("no-evict", 3, true, "7.0.0", "O(1)", "Sets the client eviction mode of the connection."),
+			clientSubcommand("reply", 3, false, "3.2.0", "O(1)", "Instructs the server whether to reply to commands."),
+			clientSubcommand("help", 2, false, "5.0.0", "O(1)", "Returns helpful text about the different subcommands."),
+		},
+	},
+	{
+		Name: "object", Arity: -2,
+		Group: "generic", Since: "2.2.3", Complexity: "Depends on subcommand.",
+		Summary: "A container for object introspection commands.",
+		Subcommands: []*CommandSpec{
+			objectSubcommand("encoding", "O(1)", "Returns the internal encoding of a Redis object."),
+			objectSubcommand("freq", "O(1)", "Returns the logarithmic access frequency counter of a Redis object."),
+			objectSubcommand("idletime", "O(1)", "Returns the time since the last access to a Redis object."),
+			objectSubcommand("refcount", "O(1)", "Returns the reference count of a value of a key."),
+			{
+				Name: "object|help", Arity: 2, Flags: []string{"loading", "stale"}, Categories: []string{"@keyspace"},
+				Group: "generic", Since: "6.2.0", Complexity: "O(1)",
+				Summary: "Returns helpful text about the different subcommands.",
+			},
+		},
+	},
+	{
+		Name: "memory", Arity: -2,
+		Group: "server", Since: "4.0.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for memory diagnostics commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "memory|doctor", Arity: 2,
+				Group: "server", Since: "4.0.0", Complexity: "O(1)",
+				Summary: "Outputs a memory problems report.",
+			},
+			{
+				Name: "memory|help", Arity: 2, Flags: []string{"loading", "stale"},
+				Group: "server", Since: "4.0.0", Complexity: "O(1)",
+				Summary: "Returns helpful text about the different subcommands.",
+			},
+			{
+				Name: "memory|malloc-stats", Arity: 2,
+				Group: "server", Since: "4.0.0", Complexity: "Depends on how much memory is allocated, could be slow",
+				Summary: "Returns the allocator statistics.",
+			},
+			{
+				Name: "memory|purge", Arity: 2,
+				Group: "server", Since: "4.0.0", Complexity: "Depends on how much memory is allocated, could be slow",
+				Summary: "Asks the allocator to release memory.",
+			},
+			{
+				Name: "memory|stats", Arity: 2,
+				Group: "server", Since: "4.0.0", Complexity: "O(1)",
+				Summary: "Returns details about memory usage.",
+			},
+			{
+				Name: "memory|usage", Arity: -3, Flags: []string{"readonly"}, Categories: []string{"@keyspace"},
+				KeySpecs: []KeySpec{keyRange(2, 0, 1, "RO")},
+				Group:    "server", Since: "4.0.0", Complexity: "O(N) where N is the number of samples.",
+				Summary: "Estimates the memory usage of a key.",
+			},
+		},
+	},
+	{
+		Name: "monitor", Arity: 1, Flags: []string{"admin", "noscript", "loading", "stale"},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Listens for all requests received by the server in real-time.",
+	},
+	{
+		Name: "replicaof", Arity: 3, Flags: []string{"admin", "noscript", "stale", "no_async_loading"},
+		Group: "server", Since: "5.0.0", Complexity: "O(1)",
+		Summary: "Configures a server as replica of another, or promotes it to a master.",
+	},
+	{
+		Name: "slaveof", Arity: 3, Flags: []string{"admin", "noscript", "stale", "no_async_loading"},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Sets a Redis server as a replica of another, or promotes it to being a master.",
+	},
+	{
+		Name: "role", Arity: 1, Flags: []string{"noscript", "loading", "stale", "fast"},
+		Categories: []string{"@admin", "@dangerous"},
+		Group:      "server", Since: "2.8.12", Complexity: "O(1)",
+		Summary: "Returns the replication role.",
+	},
+	{
+		Name: "replconf", Arity: -1, Flags: []string{"admin", "noscript", "loading", "stale", "allow_busy"},
+		Group: "server", Since: "3.0.0", Complexity: "O(1)",
+		Summary: "An internal command for configuring the replication stream.",
+	},
+	{
+		Name: "psync", Arity: -3, Flags: []string{"admin", "noscript", "no_async_loading", "no_multi"},
+		Group: "server", Since: "2.8.0", Complexity: "O(N) where N is the number of keys sent in a full resynchronization.",
+		Summary: "An internal command used in replication.",
+	},
+	{
+		Name: "sync", Arity: 1, Flags: []string{"admin", "noscript", "no_async_loading", "no_multi"},
+		Group: "server", Since: "1.0.0", Complexity: "O(N) where N is the number of keys in the snapshot.",
+		Summary: "An internal command used in replication.",
+	},
+	{
+		Name: "slowlog", Arity: -2,
+		Group: "server", Since: "2.2.12", Complexity: "Depends on subcommand.",
+		Summary: "A container for slow log commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "slowlog|get", Arity: -2, Flags: []string{"admin", "loading", "stale"},
+				Group: "server", Since: "2.2.12", Complexity: "O(N) where N is the number of entries returned",
+				Summary: "Returns the slow log's entries.",
+			},
+			{
+				Name: "slowlog|help", Arity: 2, Flags: []string{"loading", "stale"},
+				Group: "server", Since: "6.2.0", Complexity: "O(1)",
+				Summary: "Returns helpful text about the different subcommands.",
+			},
+			{
+				Name: "slowlog|len", Arity: 2, Flags: []string{"admin", "loading", "stale"},
+				Group: "server", Since: "2.2.12", Complexity: "O(1)",
+				Summary: "Returns the number of entries in the slow log.",
+			},
+			{
+				Name: "slowlog|reset", Arity: 2, Flags: []string{"admin", "loading", "stale"},
+				Group: "server", Since: "2.2.12", Complexity: "O(N) where N is the number of entries in the slowlog",
+				Summary: "Clears all entries from the slow log.",
+			},
+		},
+	},
+	{
+		Name: "latency", Arity: -2,
+		Group: "server", Since: "2.8.13", Complexity: "Depends on subcommand.",
+		Summary: "A container for latency diagnostics commands.",
+		Subcommands: []*CommandSpec{
+			latencySubcommand("doctor", 2, "2.8.13", "O(1)", "Returns a human-readable latency analysis report."),
+			{
+				Name: "latency|help", Arity: 2, Flags: []string{"loading", "stale"},
+				Group: "server", Since: "2.8.13", Complexity: "O(1)",
+				Summary: "Returns helpful text about the different subcommands.",
+			},
+			latencySubcommand("histogram", -2, "7.0.0", "O(N) where N is the number of commands with latency information being retrieved.",
+				"Returns the cumulative distribution of latencies of a subset or all commands."),
+			latencySubcommand("history", 3, "2.8.13", "O(1)", "Returns timestamp-latency samples for an event."),
+			latencySubcommand("latest", 2, "2.8.13", "O(1)", "Returns the latest latency samples for all events."),
+			latencySubcommand("reset", -2, "2.8.13", "O(1)", "Resets the latency data for one or more events."),
+		},
+	},
+	{
+		Name: "acl", Arity: -2,
+		Group: "server", Since: "6.0.0", Complexity: "Depends on subcommand.",
+		Summary: "A container for Access List Control commands.",
+		Subcommands: []*CommandSpec{
+			aclSubcommand("cat", -2, false, "6.0.0", "O(1) since the categories and commands are a fixed set.", "Lists the ACL categories, or the commands inside a category."),
+			aclSubcommand("deluser", -3, true, "6.0.0", "O(1) amortized time considering the typical user.", "Deletes ACL users, and terminates their connections."),
+			aclSubcommand("dryrun", -4, true, "7.0.0", "O(1).", "Simulates the execution of a command by a user, without executing the command."),
+			aclSubcommand("genpass", -2, false, "6.0.0", "O(1)", "Generates a pseudorandom, secure password that can be used to identify ACL users."),
+			aclSubcommand("getuser", 3, true, "6.0.0", "O(N). Where N is the number of password, command and pattern rules that the user has.", "Lists the ACL rules of a user."),
+			aclSubcommand("list", 2, true, "6.0.0", "O(N). Where N is the number of configured users.", "Dumps the effective rules in ACL file format."),
+			aclSubcommand("load", 2, true, "6.0.0", "O(N). Where N is the number of configured users.", "Reloads the rules from the configured ACL file."),
+			aclSubcommand("log", -2, true, "6.0.0", "O(N) with N being the number of entries shown.", "Lists recent security events generated due to commands rejected by ACL rules."),
+			aclSubcommand("save", 2, true, "6.0.0", "O(N). Where N is the number of configured users.", "Saves the effective ACL rules in the configured ACL file."),
+			aclSubcommand("setuser", -3, true, "6.0.0", "O(N). Where N is the number of rules provided.", "Creates and modifies an ACL user and its rules."),
+			aclSubcommand("users", 2, true, "6.0.0", "O(N). Where N is the number of configured users.", "Lists all ACL users."),
+			aclSubcommand("whoami", 2, false, "6.0.0", "O(1)", "Returns the authenticated username of the current connection."),
+			aclSubcommand("help", 2, false, "6.0.0", "O(1)", "Returns helpful text about the different subcommands."),
+		},
+	},
+	{
+		Name: "info", Arity: -1, Flags: []string{"loading", "stale"}, Categories: []string{"@dangerous"},
+		Group: "server", Since: "1.0.0", Complexity: "O(1)",
+		Summary: "Returns information and statistics about the server.",
+	},
+	{
+		Name: "shutdown", Arity: -1, Flags: []string{"admin", "noscript", "loading", "stale", "no_multi", "allow_busy"},
+		Group: "server", Since: "1.0.0",
+		Complexity: "O(N) when saving, where N is the total number of keys in all databases when saving data, otherwise O(1)",
+		Summary:    "Synchronously saves the database(s) to disk and shuts down the Redis server.",
+	},
+	{
+		Name: "command", Arity: -1, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+		Group: "server", Since: "2.8.13", Complexity: "O(N) where N is the total number of Redis commands",
+		Summary: "Returns detailed information about all commands.",
+		Subcommands: []*CommandSpec{
+			{
+				Name: "command|count", Arity: 2, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+				Group: "server", Since: "2.8.13", Complexity: "O(1)",
+				Summary: "Returns a count of commands.",
+			},
+			{
+				Name: "command|docs", Arity: -2, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+				Group: "server", Since: "7.0.0", Complexity: "O(N) where N is the number of commands to look up",
+				Summary: "Returns documentary information about one, multiple or all commands.",
+			},
+			{
+				Name: "command|getkeys", Arity: -3, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+				Group: "server", Since: "2.8.13", Complexity: "O(N) where N is the number of arguments to the command",
+				Summary: "Extracts the key names from an arbitrary command.",
+			},
+			{
+				Name: "command|info", Arity: -2, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+				Group: "server", Since: "2.8.13", Complexity: "O(N) where N is the number of commands to look up",
+				Summary: "Returns information about one, multiple or all commands.",
+			},
+			{
+				Name: "command|list", Arity: -2, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+				Group: "server", Since: "7.0.0", Complexity: "O(N) where N is the total number of Redis commands",
+				Summary: "Returns a list of command names.",
+			},
+			{
+				Name: "command|help", Arity: 2, Flags: []string{"loading", "stale"}, Categories: []string{"@connection"},
+				Group: "server", Since: "5.0.0", Complexity: "O(1)",
+				Summary: "Returns helpful text about the different subcommands.",
+			},
+		},
+	},
+	{
+		Name: "pfadd", Arity: -2, Flags: []string{"write", "denyoom", "fast"}, Categories: []string{"@hyperloglog"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RW", "INSERT")},
+		Group:    "hyperloglog", Since: "2.8.9", Complexity: "O(1) to add every element.",
+		Summary: "Adds elements to a HyperLogLog key. Creates the key if it doesn't exist.",
+	},
+	{
+		Name: "pfcount", Arity: -2, Flags: []string{"readonly", "may_replicate"}, Categories: []string{"@hyperloglog"},
+		KeySpecs: []KeySpec{keyRange(1, -1, 1, "RW", "ACCESS")},
+		Group:    "hyperloglog", Since: "2.8.9", Complexity: "O(1) with a very small average constant time when called with a single key. O(N) with N being the number of keys, and much bigger constant times, when called with multiple keys.",
+		Summary: "Returns the approximated cardinality of the set(s) observed by the HyperLogLog key(s).",
+	},
+	{
+		Name: "pfmerge", Arity: -2, Flags: []string{"write", "denyoom"}, Categories: []string{"@hyperloglog"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RW", "ACCESS", "INSERT"), keyRange(2, -1, 1, "RO", "ACCESS")},
+		Group:    "hyperloglog", Since: "2.8.9", Complexity: "O(N) to merge N HyperLogLogs, but with high constant times.",
+		Summary: "Merges one or more HyperLogLog values into a single key.",
+	},
+	{
+		Name: "geoadd", Arity: -5, Flags: []string{"write", "denyoom"}, Categories: []string{"@geo"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RW", "UPDATE")},
+		Group:    "geo", Since: "3.2.0", Complexity: "O(log(N)) for each item added, where N is the number of elements in the sorted set.",
+		Summary: "Adds one or more members to a geospatial index. The key is created if it doesn't exist.",
+	},
+	{
+		Name: "geopos", Arity: -2, Flags: []string{"readonly"}, Categories: []string{"@geo"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "geo", Since: "3.2.0", Complexity: "O(1) for each member requested.",
+		Summary: "Returns the longitude and latitude of members from a geospatial index.",
+	},
+	{
+		Name: "geodist", Arity: -4, Flags: []string{"readonly"}, Categories: []string{"@geo"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "geo", Since: "3.2.0", Complexity: "O(1)",
+		Summary: "Returns the distance between two members of a geospatial index.",
+	},
+	{
+		Name: "geohash", Arity: -2, Flags: []string{"readonly"}, Categories: []string{"@geo"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "geo", Since: "3.2.0", Complexity: "O(1) for each member requested.",
+		Summary: "Returns members from a geospatial index as geohash strings.",
+	},
+	{
+		Name: "geosearch", Arity: -7, Flags: []string{"readonly"}, Categories: []string{"@geo"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "RO", "ACCESS")},
+		Group:    "geo", Since: "6.2.0", Complexity: "O(N+log(M)) where N is the number of elements in the grid-aligned bounding box area around the shape provided as the filter and M is the number of items inside the shape",
+		Summary: "Queries a geospatial index for members inside an area of a box or a circle.",
+	},
+	{
+		Name: "geosearchstore", Arity: -8, Flags: []string{"write", "denyoom"}, Categories: []string{"@geo"},
+		KeySpecs: []KeySpec{keyRange(1, 0, 1, "OW", "UPDATE"), keyRange(2, 0, 1, "RO", "ACCESS")},
+		Group:    "geo", Since: "6.2.0", Complexity: "O(N+log(M)) where N is the number of elements in the grid-aligned bounding box area around the shape provided as the filter and M is the number of items inside the shape",
+		Summary: "Queries a geospatial index for members inside an area of a box or a circle, optionally stores the result.",
+	},
+}
+
+// clientSubcommand describes a CLIENT subcommand, which are all in the
+// connection group and some of which are restricted to admins
+func clientSubcommand(name string, arity int, admin bool, since, complexity, summary string) *CommandSpec {
+	flags := []string{"noscript", "loading", "stale"}
+	if admin {
+		flags = append([]string{"admin"}, flags...)
+	}
+	return &CommandSpec{
+		Name: "client|" + name, Arity: arity, Flags: flags, Categories: []string{"@connection"},
+		Group: "connection", Since: since, Complexity: complexity, Summary: summary,
+	}
+}
+
+func objectSubcommand(name, complexity, summary string) *CommandSpec {
+	since := "2.2.3"
+	if name == "freq" {
+		since = "4.0.0"
+	}
+	return &CommandSpec{
+		Name: "object|" + name, Arity: 3, Flags: []string{"readonly"}, Categories: []string{"@keyspace"},
+		KeySpecs: []KeySpec{keyRange(2, 0, 1, "RO")},
+		Group:    "generic", Since: since, Complexity: complexity, Summary: summary,
+	}
+}
+
+func latencySubcommand(name string, arity int, since, complexity, summary string) *CommandSpec {
+	return &CommandSpec{
+		Name: "latency|" + name, Arity: arity, Flags: []string{"admin", "noscript", "loading", "stale"},
+		Group: "server", Since: since, Complexity: complexity, Summary: summary,
+	}
+}
+
+func aclSubcommand(name string, arity int, admin bool, since, complexity, summary string) *CommandSpec {
+	flags := []string{"noscript", "loading", "stale"}
+	if admin {
+		flags = append([]string{"admin"}, flags...)
+	}
+	return &CommandSpec{
+		Name: "acl|" + name, Arity: arity, Flags: flags,
+		Group: "server", Since: since, Complexity: complexity, Summary: summary,
+	}
+}
+
+var commandIndex = func() map[string]*CommandSpec {
+	index := make(map[string]*CommandSpec)
+	for _, spec := range commandTable {
+		index[spec.Name] = spec
+		for _, subcommand := range spec.Subcommands {
+			index[subcommand.Name] = subcommand
+		}
+	}
+	return index
+}()
+
+// lookupCommand finds a command, or a subcommand written as parent|child, by
+// its case insensitive name
+func lookupCommand(name string) *CommandSpec {
+	return commandIndex[strings.ToLower(name)]
+}
+
+// resolveCommand finds the command a client runs: the subcommand for
+// container commands like CONFIG, otherwise the command itself
+func resolveCommand(command string, args []RESPValue) *CommandSpec {
+	spec := lookupCommand(command)
+	if spec != nil && len(spec.Subcommands) > 0 && len(args) > 0 {
+		if subcommand := lookupCommand(spec.Name + "|" + args[0].Str); subcommand != nil {
+			return subcommand
+		}
+	}
+	return spec
+}
+
+// CheckArity reports whether argc arguments, including the command name, are valid
+func (c *CommandSpec) CheckArity(argc int) bool {
+	if c.Arity < 0 {
+		return argc >= -c.Arity
+	}
+	return argc == c.Arity
+}
+
+// hasFlag reports whether the command has a flag such as write
+func (c *CommandSpec) hasFlag(flag string) bool {
+	return slices.Contains(c.Flags, flag)
+}
+
+// AclCategories returns the ACL categories of the command, including the ones
+// implied by its flags like Redis setImplicitACLCategories
+func (c *CommandSpec) AclCategories() []string {
+	var categories []string
+	has := c.hasFlag
+	if has("write") {
+		categories = append(categories, "@write")
+	}
+	if has("readonly") {
+		categories = append(categories, "@read")
+	}
+	if has("admin") {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if has("fast") {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	for _, category := range c.Categories {
+		if category == "@dangerous" && has("admin") {
+			continue
+		}
+		categories = append(categories, category)
+	}
+	return categories
+}
+
+// legacyKeyRange returns the first key, last key and step of the key specs
+// combined into a single range, for the COMMAND INFO fields older clients use
+func (c *CommandSpec) legacyKeyRange() (int, int, int) {
+	if len(c.KeySpecs) == 0 {
+		return 0, 0, 0
+	}
+	first, last := c.KeySpecs[0].Index, 0
+	for _, spec := range c.KeySpecs {
+		first = min(first, spec.Index)
+		if spec.LastKey < 0 {
+			last = spec.LastKey
+		} else if last >= 0 {
+			last = max(last, spec.Index+spec.LastKey)
+		}
+	}
+	return first, last, 1
+}
+
+// GetKeys extracts the key arguments of a command line, name included
+func (c *CommandSpec) GetKeys(argv []string) ([]string, error) {
+	if !c.CheckArity(len(argv)) {
+		return nil, ErrInvalidCommandArgs
+	}
+	var keys []string
+	c.forEachKey(argv, func(key string, spec KeySpec) {
+		keys = append(keys, key)
+	})
+	if len(keys) == 0 {
+		return nil, ErrNoKeyArguments
+	}
+	return keys, nil
+}
+
+// forEachKey calls fn with every key argument of a command line, name
+// included, and the key spec that locates it
+func (c *CommandSpec) forEachKey(argv []string, fn func(key string, spec KeySpec)) {
+	for _, spec := range c.KeySpecs {
+		if spec.Index >= len(argv) {
+			continue
+		}
+		last := spec.Index + spec.LastKey
+		if spec.LastKey < 0 {
+			last = len(argv) + spec.LastKey
+		}
+		for i := spec.Index; i <= last && i < len(argv); i += spec.KeyStep {
+			fn(argv[i], spec)
+		}
+	}
+}
+
+func simpleStringArray(elements []string) RESPValue {
+	items := make([]RESPValue, len(elements))
+	for i, element := range elements {
+		items[i] = RESPValue{Type: SimpleString, Str: element}
+	}
+	return RESPValue{Type: Array, Array: items}
+}
+
+// InfoReply builds the COMMAND INFO reply of the command
+func (c *CommandSpec) InfoReply() RESPValue {
+	first, last, step := c.legacyKeyRange()
+
+	keySpecs := make([]RESPValue, len(c.KeySpecs))
+	for i, spec := range c.KeySpecs {
+		keySpecs[i] = RESPValue{Type: Array, Array: []RESPValue{
+			{Type: BulkString, Str: "flags"},
+			simpleStringArray(spec.Flags),
+			{Type: BulkString, Str: "begin_search"},
+			{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "type"},
+				{Type: BulkString, Str: "index"},
+				{Type: BulkString, Str: "spec"},
+				{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: "index"},
+					{Type: Integer, Int: int64(spec.Index)},
+				}},
+			}},
+			{Type: BulkString, Str: "find_keys"},
+			{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "type"},
+				{Type: BulkString, Str: "range"},
+				{Type: BulkString, Str: "spec"},
+				{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: "lastkey"},
+					{Type: Integer, Int: int64(spec.LastKey)},
+					{Type: BulkString, Str: "keystep"},
+					{Type: Integer, Int: int64(spec.KeyStep)},
+					{Type: BulkString, Str: "limit"},
+					{Type: Integer, Int: 0},
+				}},
+			}},
+		}}
+	}
+
+	subcommands := make([]RESPValue, len(c.Subcommands))
+	for i, subcommand := range c.Subcommands {
+		subcommands[i] = subcommand.InfoReply()
+	}
+
+	return RESPValue{Type: Array, Array: []RESPValue{
+		{Type: BulkString, Str: c.Name},
+		{Type: Integer, Int: int64(c.Arity)},
+		simpleStringArray(c.Flags),
+		{Type: Integer, Int: int64(first)},
+		{Type: Integer, Int: int64(last)},
+		{Type: Integer, Int: int64(step)},
+		simpleStringArray(c.AclCategories()),
+		{Type: Array, Array: []RESPValue{}},
+		{Type: Array, Array: keySpecs},
+		{Type: Array, Array: subcommands},
+	}}
+}
+
+// DocsReply builds the documentation map of the command in a COMMAND DOCS reply
+func (c *CommandSpec) DocsReply() RESPValue {
+	docs := []RESPValue{
+		{Type: BulkString, Str: "summary"},
+		{Type: BulkString, Str: c.Summary},
+		{Type: BulkString, Str: "since"},
+		{Type: BulkString, Str: c.Since},
+		{Type: BulkString, Str: "group"},
+		{Type: BulkString, Str: c.Group},
+		{Type: BulkString, Str: "complexity"},
+		{Type: BulkString, Str: c.Complexity},
+	}
+	if len(c.Subcommands) > 0 {
+		subcommands := []RESPValue{}
+		for _, subcommand := range c.Subcommands {
+			subcommands = append(subcommands, RESPValue{Type: BulkString, Str: subcommand.Name}, subcommand.DocsReply())
+		}
+		docs = append(docs, RESPValue{Type: BulkString, Str: "subcommands"}, RESPValue{Type: Array, Array: subcommands})
+	}
+	return RESPValue{Type: Array, Array: docs}
+}
+
+// commandsByName returns the commands named in args, or every command if args is empty
+func commandsByName(args []RESPValue) []*CommandSpec {
+	if len(args) == 0 {
+		return commandTable
+	}
+	specs := make([]*CommandSpec, len(args))
+	for i, arg := range args {
+		specs[i] = lookupCommand(arg.Str)
+	}
+	return specs
+}
+
+// ListCommands returns the names of every command and subcommand, filtered by
+// FILTERBY ACLCAT category or FILTERBY PATTERN pattern if filter is set
+func ListCommands(filter, value string) []string {
+	names := []string{}
+	add := func(spec *CommandSpec) {
+		switch filter {
+		case "ACLCAT":
+			found := false
+			for _, category := range spec.AclCategories() {
+				found = found || strings.EqualFold(category[1:], value)
+			}
+			if !found {
+				return
+			}
+		case "PATTERN":
+			if !StringMatch(value, spec.Name, true) {
+				return
+			}
+		case "MODULE":
+			// There are no modules
+			return
+		}
+		names = append(names, spec.Name)
+	}
+	for _, spec := range commandTable {
+		add(spec)
+		for _, subcommand := range spec.Subcommands {
+			add(subcommand)
+		}
+	}
+	return names
+}
+
+var commandHelp = []string{
+	"COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"(no subcommand)",
+	"    Return details about all Redis commands.",
+	"COUNT",
+	"    Return the total number of commands in this Redis server.",
+	"LIST",
+	"    Return a list of all commands in this Redis server.",
+	"INFO [<command-name> ...]",
+	"    Return details about multiple Redis commands.",
+	"    If no command names are given, documentation details for all",
+	"    commands are returned.",
+	"DOCS [<command-name> ...]",
+	"    Return documentation details about multiple Redis commands.",
+	"    If no command names are given, documentation details for all",
+	"    commands are returned.",
+	"GETKEYS <full-command>",
+	"    Return the keys from a full Redis command.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleCommand runs COMMAND and its subcommands
+func handleCommand(args []RESPValue) *RESPValue {
+	if len(args) == 0 {
+		return commandInfoReply(commandTable)
+	}
+
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("command|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'COMMAND|%s' command", subcommand),
+		}
+	}
+
+	switch subcommand {
+	case "COUNT":
+		return &RESPValue{Type: Integer, Int: int64(len(commandTable))}
+	case "INFO":
+		return commandInfoReply(commandsByName(args[1:]))
+	case "DOCS":
+		reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+		for _, spec := range commandsByName(args[1:]) {
+			if spec != nil {
+				reply.Array = append(reply.Array, RESPValue{Type: BulkString, Str: spec.Name}, spec.DocsReply())
+			}
+		}
+		return reply
+	case "GETKEYS":
+		spec := lookupCommand(args[1].Str)
+		if spec != nil && len(spec.Subcommands) > 0 && len(args) > 2 {
+			spec = lookupCommand(args[1].Str + "|" + args[2].Str)
+		}
+		if spec == nil {
+			return &RESPValue{Type: Error, Str: ErrInvalidCommand.Error()}
+		}
+		argv := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			argv[i] = arg.Str
+		}
+		keys, err := spec.GetKeys(argv)
+		if err != nil {
+			return &RESPValue{Type: Error, Str: err.Error()}
+		}
+		return bulkStringArray(keys)
+	case "LIST":
+		filter, value := "", ""
+		if len(args) > 1 {
+			if len(args) != 4 || !strings.EqualFold(args[1].Str, "FILTERBY") {
+				return &RESPValue{Type: Error, Str: ErrSyntax.Error()}
+			}
+			filter, value = strings.ToUpper(args[2].Str), args[3].Str
+			if filter != "ACLCAT" && filter != "PATTERN" && filter != "MODULE" {
+				return &RESPValue{Type: Error, Str: ErrSyntax.Error()}
+			}
+		}
+		return bulkStringArray(ListCommands(filter, value))
+	case "HELP":
+		return simpleStringArrayReply(commandHelp)
+	default:
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try COMMAND HELP.", args[0].Str),
+		}
+	}
+}
+
+// commandInfoReply builds a COMMAND INFO reply, with a null entry for unknown commands
+func commandInfoReply(specs []*CommandSpec) *RESPValue {
+	reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+	for _, spec := range specs {
+		if spec == nil {
+			reply.Array = append(reply.Array, RESPValue{Type: Array, IsNull: true})
+		} else {
+			reply.Array = append(reply.Array, spec.InfoReply())
+		}
+	}
+	return reply
+}
+
+// simpleStringArrayReply builds an array reply of simple strings, like HELP output
+func simpleStringArrayReply(lines []string) *RESPValue {
+	reply := simpleStringArray(lines)
+	return &reply
+}
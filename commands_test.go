@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandTableMatchesDispatch(t *testing.T) {
+	client := newTestClient(t)
+	for _, spec := range commandTable {
+		// Pass at least one argument so commands like FLUSHALL fail on it
+		// instead of running
+		argc := spec.Arity
+		if argc < 0 {
+			argc = max(-argc, 2)
+		}
+		args := make([]RESPValue, argc-1)
+		for i := range args {
+			args[i] = RESPValue{Type: BulkString, Str: "x"}
+		}
+
+		// Commands like SYNC may have no reply
+		response := client.execute(strings.ToUpper(spec.Name), args)
+		if response != nil && (strings.HasPrefix(response.Str, "ERR unknown command") ||
+			strings.HasPrefix(response.Str, "ERR wrong number of arguments")) {
+			t.Errorf("%s: the command table and dispatch disagree: %s", spec.Name, response.Str)
+		}
+	}
+
+	if response := client.execute("NOSUCHCOMMAND", nil); response.Str != "ERR unknown command 'NOSUCHCOMMAND'" {
+		t.Errorf("Unexpected reply for an unknown command: %v", response)
+	}
+	if response := client.execute("GET", nil); response.Str != "ERR wrong number of arguments for 'GET' command" {
+		t.Errorf("Unexpected reply for a wrong arity: %v", response)
+	}
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	tests := []struct {
+		argv     []string
+		expected []string
+		err      error
+	}{
+		{[]string{"GET", "a"}, []string{"a"}, nil},
+		{[]string{"DEL", "a", "b", "c"}, []string{"a", "b", "c"}, nil},
+		{[]string{"RENAME", "a", "b"}, []string{"a", "b"}, nil},
+		{[]string{"PFMERGE", "dest", "a", "b"}, []string{"dest", "a", "b"}, nil},
+		{[]string{"GEOSEARCHSTORE", "dest", "src", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"}, []string{"dest", "src"}, nil},
+		{[]string{"PING"}, nil, ErrNoKeyArguments},
+		{[]string{"GET"}, nil, ErrInvalidCommandArgs},
+	}
+	for _, tt := range tests {
+		keys, err := lookupCommand(tt.argv[0]).GetKeys(tt.argv)
+		if err != tt.err || !reflect.DeepEqual(keys, tt.expected) {
+			t.Errorf("GetKeys(%v) = %v, %v, expected %v, %v", tt.argv, keys, err, tt.expected, tt.err)
+		}
+	}
+}
+
+func TestCommandSpec(t *testing.T) {
+	get := lookupCommand("Get")
+	if !get.CheckArity(2) || get.CheckArity(3) || !lookupCommand("del").CheckArity(5) {
+		t.Error("Unexpected arity check")
+	}
+	if lookupCommand("config|get") == nil || lookupCommand("nosuch") != nil {
+		t.Error("Unexpected subcommand lookup")
+	}
+	if categories := get.AclCategories(); !reflect.DeepEqual(categories, []string{"@read", "@fast", "@string"}) {
+		t.Errorf("Unexpected GET categories %v", categories)
+	}
+	if categories := lookupCommand("config|set").AclCategories(); !reflect.DeepEqual(categories, []string{"@admin", "@dangerous", "@slow"}) {
+		t.Errorf("Unexpected CONFIG SET categories %v", categories)
+	}
+
+	tests := []struct {
+		name              string
+		first, last, step int
+	}{
+		{"get", 1, 1, 1},
+		{"del", 1, -1, 1},
+		{"rename", 1, 2, 1},
+		{"pfmerge", 1, -1, 1},
+		{"ping", 0, 0, 0},
+	}
+	for _, tt := range tests {
+		first, last, step := lookupCommand(tt.name).legacyKeyRange()
+		if first != tt.first || last != tt.last || step != tt.step {
+			t.Errorf("%s: expected key range %d %d %d, got %d %d %d", tt.name, tt.first, tt.last, tt.step, first, last, step)
+		}
+	}
+
+	for _, spec := range commandTable {
+		if spec.Summary == "" || spec.Since == "" || spec.Group == "" || spec.Complexity == "" {
+			t.Errorf("%s: missing documentation", spec.Name)
+		}
+	}
+}
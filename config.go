@@ -0,0 +1,968 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrConfigArgs      = errors.New("Bad directive or wrong number of arguments")
+	ErrConfigInteger   = errors.New("argument couldn't be parsed into an integer")
+	ErrConfigBool      = errors.New("argument must be 'yes' or 'no'")
+	ErrConfigMemory    = errors.New("argument must be a memory value")
+	ErrConfigPerm      = errors.New("argument must be octal permissions between 0 and 777")
+	ErrConfigQuotes    = errors.New("Unbalanced quotes in configuration line")
+	ErrConfigFilename  = errors.New("dbfilename can't be a path, just a filename")
+	ErrConfigExtraArgs = errors.New("only one config file can be given")
+	ErrConfigImmutable = errors.New("can't set immutable config")
+	ErrConfigNoFile    = errors.New("ERR The server is running without a config file")
+	ErrConfigPort      = errors.New("Invalid master port")
+)
+
+// Config holds the server settings, loaded from defaults, an optional
+// redis.conf style file and command line flags, in that order
+type Config struct {
+	File                    string // Path of the loaded config file, if any
+	Port                    int
+	Bind                    []string // Empty listens on every interface
+	UnixSocket              string   // Path of the unix socket to listen on, empty for none
+	UnixSocketPerm          os.FileMode
+	Dir                     string
+	DBFilename              string
+	Save                    []SavePoint // Empty disables snapshots
+	AppendOnly              bool
+	AppendFilename          string
+	MaxMemory               int64
+	MaxMemoryPolicy         string
+	MaxMemorySamples        int // Keys sampled per database for each eviction
+	LFULogFactor            int
+	LFUDecayTime            int // Minutes for the LFU counter to decay by one
+	ZSetMaxListpackEntries  int // Largest sorted set OBJECT ENCODING reports as listpack
+	ZSetMaxListpackValue    int // Longest member of a listpack sorted set
+	Timeout                 int // Seconds before idle clients are closed, 0 to disable
+	LogLevel                string
+	LogFile                 string // Empty logs to stderr
+	LogFormat               string // text or json
+	Databases               int
+	RequirePass             string // Password of the default user, empty for none
+	ACLFile                 string // File ACL LOAD and ACL SAVE use, empty for none
+	ACLLogMaxLen            int
+	SlowlogLogSlowerThan    int // Microseconds, negative to disable the slow log
+	SlowlogMaxLen           int
+	LatencyMonitorThreshold int    // Milliseconds an event must take to be sampled, 0 to disable
+	MetricsPort             int    // HTTP port of the Prometheus metrics, 0 to disable
+	MasterHost              string // Master to replicate from, empty for none
+	MasterPort              int
+	MasterAuth              string // Password to AUTH with on the master, empty for none
+	MasterUser              string // ACL user to AUTH as on the master, empty for the default user
+	ReplicaReadOnly         bool
+	ReplTimeout             int // Seconds without data before a replication link is considered down
+	ReplPingReplicaPeriod   int // Seconds between the PINGs a master sends to its replicas
+	ReplBacklogSize         int64
+	ReplBacklogTTL          int // Seconds a master without replicas keeps its backlog, 0 to keep it
+	ShutdownTimeout         int // Seconds SHUTDOWN waits for running commands
+
+	TLSPort            int // 0 disables TLS
+	TLSCertFile        string
+	TLSKeyFile         string
+	TLSCACertFile      string // CA used to verify client certificates
+	TLSAuthClients     string // yes, no or optional
+	TLSAuthClientsUser string // CN maps the client certificate to an ACL user, off to disable
+}
+
+// SavePoint asks for a snapshot after Seconds if at least Changes writes happened
+type SavePoint struct {
+	Seconds int
+	Changes int
+}
+
+var (
+	configMu sync.RWMutex
+	config   = DefaultConfig()
+)
+
+// currentConfig returns a copy of the settings, safe to use while CONFIG SET
+// changes them
+func currentConfig() Config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return *config
+}
+
+// DefaultConfig returns the settings used when nothing is configured
+func DefaultConfig() *Config {
+	return &Config{
+		Port:                   DEFAULT_PORT,
+		Dir:                    "./",
+		DBFilename:             "dump.rdb",
+		AppendFilename:         "appendonly.aof",
+		MaxMemoryPolicy:        "noeviction",
+		MaxMemorySamples:       5,
+		LFULogFactor:           10,
+		LFUDecayTime:           1,
+		ZSetMaxListpackEntries: 128,
+		ZSetMaxListpackValue:   64,
+		LogLevel:               "notice",
+		LogFormat:              "text",
+		Databases:              DEFAULT_DATABASES,
+		ACLLogMaxLen:           128,
+		SlowlogLogSlowerThan:   10000,
+		SlowlogMaxLen:          128,
+		ReplicaReadOnly:        true,
+		ReplTimeout:            60,
+		ReplPingReplicaPeriod:  10,
+		ReplBacklogSize:        REPL_BACKLOG_DEFAULT,
+		ReplBacklogTTL:         3600,
+		ShutdownTimeout:        10,
+
+		TLSAuthClients:     "yes",
+		TLSAuthClientsUser: "off",
+	}
+}
+
+// configDirective describes a setting accepted in the config file, as a flag
+// and by CONFIG GET and CONFIG SET
+type configDirective struct {
+	name      string
+	usage     string
+	set       func(c *Config, args []string) error
+	get       func(c *Config) string
+	apply     func(c *Config) error // Makes a runtime change take effect, if needed
+	immutable bool                  // Can only be set at startup
+	multiArg  bool                  // Takes several space separated values
+}
+
+// args splits a value given to CONFIG SET or as a flag into arguments. Only
+// directives taking several values split it, so other values may contain spaces.
+func (d *configDirective) args(value string) []string {
+	if d.multiArg {
+		return strings.Fields(value)
+	}
+	return []string{value}
+}
+
+var configDirectives = []*configDirective{
+	immutableDirective(intDirective("port", "TCP port to listen on, 0 to disable", 0, 65535, func(c *Config) *int { return &c.Port })),
+	immutableDirective(intDirective("tls-port", "TLS port to listen on, 0 to disable", 0, 65535, func(c *Config) *int { return &c.TLSPort })),
+	immutableDirective(stringDirective("tls-cert-file", "server certificate in PEM format", func(c *Config) *string { return &c.TLSCertFile })),
+	immutableDirective(stringDirective("tls-key-file", "private key of the server certificate", func(c *Config) *string { return &c.TLSKeyFile })),
+	immutableDirective(stringDirective("tls-ca-cert-file", "CA certificates used to verify clients", func(c *Config) *string { return &c.TLSCACertFile })),
+	immutableDirective(enumDirective("tls-auth-clients", "require client certificates",
+		[]string{"yes", "no", "optional"},
+		func(c *Config) *string { return &c.TLSAuthClients })),
+	immutableDirective(enumDirective("tls-auth-clients-user", "authenticate clients as the ACL user named by their certificate",
+		[]string{"CN", "off"},
+		func(c *Config) *string { return &c.TLSAuthClientsUser })),
+	{
+		name:      "bind",
+		usage:     "interfaces to listen on, separated by spaces",
+		immutable: true,
+		multiArg:  true,
+		set: func(c *Config, args []string) error {
+			if len(args) == 0 {
+				return ErrConfigArgs
+			}
+			c.Bind = args
+			return nil
+		},
+		get: func(c *Config) string { return strings.Join(c.Bind, " ") },
+	},
+	immutableDirective(stringDirective("unixsocket", "path of a unix socket to listen on", func(c *Config) *string { return &c.UnixSocket })),
+	{
+		name:      "unixsocketperm",
+		usage:     "octal permissions of the unix socket, 0 to keep the default",
+		immutable: true,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			perm, err := strconv.ParseUint(args[0], 8, 32)
+			if err != nil || perm > 0777 {
+				return ErrConfigPerm
+			}
+			c.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatUint(uint64(c.UnixSocketPerm), 8) },
+	},
+	{
+		name:  "dir",
+		usage: "working directory for database files",
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			if info, err := os.Stat(args[0]); err != nil {
+				return err
+			} else if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", args[0])
+			}
+			c.Dir = args[0]
+			return nil
+		},
+		get:   func(c *Config) string { return c.Dir },
+		apply: func(c *Config) error { return os.Chdir(c.Dir) },
+	},
+	{
+		name:  "dbfilename",
+		usage: "name of the database dump file",
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			if strings.ContainsRune(args[0], os.PathSeparator) {
+				return ErrConfigFilename
+			}
+			c.DBFilename = args[0]
+			return nil
+		},
+		get: func(c *Config) string { return c.DBFilename },
+	},
+	{
+		name:     "save",
+		usage:    "snapshot after <seconds> <changes> pairs, or \"\" to disable",
+		multiArg: true,
+		set: func(c *Config, args []string) error {
+			if len(args) == 1 && args[0] == "" {
+				args = nil
+			}
+			if len(args)%2 != 0 {
+				return ErrConfigArgs
+			}
+			points := make([]SavePoint, 0, len(args)/2)
+			for i := 0; i < len(args); i += 2 {
+				seconds, err := strconv.Atoi(args[i])
+				if err != nil || seconds < 1 {
+					return ErrConfigArgs
+				}
+				changes, err := strconv.Atoi(args[i+1])
+				if err != nil || changes < 0 {
+					return ErrConfigArgs
+				}
+				points = append(points, SavePoint{seconds, changes})
+			}
+			c.Save = points
+			return nil
+		},
+		get: func(c *Config) string {
+			params := make([]string, 0, len(c.Save)*2)
+			for _, point := range c.Save {
+				params = append(params, strconv.Itoa(point.Seconds), strconv.Itoa(point.Changes))
+			}
+			return strings.Join(params, " ")
+		},
+	},
+	boolDirective("appendonly", "enable the append only file", func(c *Config) *bool { return &c.AppendOnly }),
+	stringDirective("appendfilename", "name of the append only file", func(c *Config) *string { return &c.AppendFilename }),
+	{
+		name:  "maxmemory",
+		usage: "memory limit in bytes, with an optional k, kb, m, mb, g or gb unit",
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			bytes, err := ParseMemory(args[0])
+			if err != nil {
+				return err
+			}
+			c.MaxMemory = bytes
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatInt(c.MaxMemory, 10) },
+	},
+	enumDirective("maxmemory-policy", "eviction policy when maxmemory is reached",
+		[]string{"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+			"allkeys-lru", "allkeys-lfu", "allkeys-random", "noeviction"},
+		func(c *Config) *string { return &c.MaxMemoryPolicy }),
+	intDirective("maxmemory-samples", "keys sampled per database to pick one to evict", 1, 64,
+		func(c *Config) *int { return &c.MaxMemorySamples }),
+	withApply(intDirective("lfu-log-factor", "accesses needed to grow the LFU counter, higher is slower", 0, 1<<31-1,
+		func(c *Config) *int { return &c.LFULogFactor }), applyLFUConfig),
+	withApply(intDirective("lfu-decay-time", "minutes for the LFU counter to decrease by one, 0 to never decay", 0, 1<<31-1,
+		func(c *Config) *int { return &c.LFUDecayTime }), applyLFUConfig),
+	intDirective("zset-max-listpack-entries", "sorted sets with more members use the skiplist encoding", 0, 1<<31-1,
+		func(c *Config) *int { return &c.ZSetMaxListpackEntries }),
+	intDirective("zset-max-listpack-value", "sorted sets with longer members use the skiplist encoding", 0, 1<<31-1,
+		func(c *Config) *int { return &c.ZSetMaxListpackValue }),
+	intDirective("timeout", "close idle clients after this many seconds, 0 to disable", 0, 1<<31-1,
+		func(c *Config) *int { return &c.Timeout }),
+	withApply(enumDirective("loglevel", "log verbosity",
+		[]string{"debug", "verbose", "notice", "warning", "nothing"},
+		func(c *Config) *string { return &c.LogLevel }),
+		func(c *Config) error {
+			logLevel.Set(logLevels[c.LogLevel])
+			return nil
+		}),
+	immutableDirective(stringDirective("logfile", "log to this file instead of stderr", func(c *Config) *string { return &c.LogFile })),
+	immutableDirective(enumDirective("log-format", "log line format", []string{"text", "json"},
+		func(c *Config) *string { return &c.LogFormat })),
+	immutableDirective(intDirective("databases", "number of databases", 1, 1<<31-1, func(c *Config) *int { return &c.Databases })),
+	{
+		name:  "requirepass",
+		usage: "password of the default user, empty for none",
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			c.RequirePass = args[0]
+			return nil
+		},
+		get: func(c *Config) string { return c.RequirePass },
+		apply: func(c *Config) error {
+			acl.SetRequirePass(c.RequirePass)
+			return nil
+		},
+	},
+	immutableDirective(stringDirective("aclfile", "file with the ACL users", func(c *Config) *string { return &c.ACLFile })),
+	intDirective("acllog-max-len", "maximum number of ACL LOG entries", 0, 1<<31-1,
+		func(c *Config) *int { return &c.ACLLogMaxLen }),
+	intDirective("slowlog-log-slower-than", "microseconds a command must run to be logged, 0 logs every command and -1 none", -1, 1<<31-1,
+		func(c *Config) *int { return &c.SlowlogLogSlowerThan }),
+	intDirective("slowlog-max-len", "maximum number of SLOWLOG entries", 0, 1<<31-1,
+		func(c *Config) *int { return &c.SlowlogMaxLen }),
+	intDirective("latency-monitor-threshold", "milliseconds an event must take for the latency monitor to sample it, 0 to disable", 0, 1<<31-1,
+		func(c *Config) *int { return &c.LatencyMonitorThreshold }),
+	immutableDirective(intDirective("metrics-port", "HTTP port serving Prometheus metrics on /metrics, 0 to disable", 0, 65535,
+		func(c *Config) *int { return &c.MetricsPort })),
+	{
+		name:      "replicaof",
+		usage:     "<host> <port> of a master to replicate from at startup",
+		immutable: true,
+		multiArg:  true,
+		set: func(c *Config, args []string) error {
+			if len(args) != 2 {
+				return ErrConfigArgs
+			}
+			port, err := strconv.Atoi(args[1])
+			if err != nil || port < 0 || port > 65535 {
+				return ErrConfigPort
+			}
+			c.MasterHost, c.MasterPort = args[0], port
+			return nil
+		},
+		get: func(c *Config) string {
+			if c.MasterHost == "" {
+				return ""
+			}
+			return c.MasterHost + " " + strconv.Itoa(c.MasterPort)
+		},
+	},
+	stringDirective("masterauth", "password to authenticate with on the master", func(c *Config) *string { return &c.MasterAuth }),
+	stringDirective("masteruser", "ACL user to authenticate as on the master", func(c *Config) *string { return &c.MasterUser }),
+	boolDirective("replica-read-only", "refuse writes from clients on a replica", func(c *Config) *bool { return &c.ReplicaReadOnly }),
+	intDirective("repl-timeout", "seconds without data before a replication link is considered down", 1, 1<<31-1,
+		func(c *Config) *int { return &c.ReplTimeout }),
+	intDirective("repl-ping-replica-period", "seconds between the PINGs a master sends to its replicas", 1, 1<<31-1,
+		func(c *Config) *int { return &c.ReplPingReplicaPeriod }),
+	withApply(&configDirective{
+		name:  "repl-backlog-size",
+		usage: "bytes of replication stream kept for replicas to continue from, with an optional unit",
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			bytes, err := ParseMemory(args[0])
+			if err != nil {
+				return err
+			}
+			c.ReplBacklogSize = max(bytes, REPL_BACKLOG_MIN_SIZE)
+			return nil
+		},
+		get: func(c *Config) string { return strconv.FormatInt(c.ReplBacklogSize, 10) },
+	}, func(c *Config) error {
+		replication.ResizeBacklog(c.ReplBacklogSize)
+		return nil
+	}),
+	intDirective("repl-backlog-ttl", "seconds a master without replicas keeps its backlog, 0 to keep it", 0, 1<<31-1,
+		func(c *Config) *int { return &c.ReplBacklogTTL }),
+	intDirective("shutdown-timeout", "seconds SHUTDOWN waits for running commands to finish", 0, 1<<31-1,
+		func(c *Config) *int { return &c.ShutdownTimeout }),
+}
+
+func immutableDirective(directive *configDirective) *configDirective {
+	directive.immutable = true
+	return directive
+}
+
+// applyLFUConfig updates the LFU settings read on every key access
+func applyLFUConfig(c *Config) error {
+	lfuSettings.set(c)
+	return nil
+}
+
+// withApply sets the hook that makes a runtime change of a directive take effect
+func withApply(directive *configDirective, apply func(c *Config) error) *configDirective {
+	directive.apply = apply
+	return directive
+}
+
+func intDirective(name, usage string, min, max int, field func(c *Config) *int) *configDirective {
+	return &configDirective{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			value, err := strconv.Atoi(args[0])
+			if err != nil {
+				return ErrConfigInteger
+			}
+			if value < min || value > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field(c) = value
+			return nil
+		},
+		get: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func boolDirective(name, usage string, field func(c *Config) *bool) *configDirective {
+	return &configDirective{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			switch strings.ToLower(args[0]) {
+			case "yes":
+				*field(c) = true
+			case "no":
+				*field(c) = false
+			default:
+				return ErrConfigBool
+			}
+			return nil
+		},
+		get: func(c *Config) string {
+			if *field(c) {
+				return "yes"
+			}
+			return "no"
+		},
+	}
+}
+
+func stringDirective(name, usage string, field func(c *Config) *string) *configDirective {
+	return &configDirective{
+		name:  name,
+		usage: usage,
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			*field(c) = args[0]
+			return nil
+		},
+		get: func(c *Config) string { return *field(c) },
+	}
+}
+
+func enumDirective(name, usage string, values []string, field func(c *Config) *string) *configDirective {
+	return &configDirective{
+		name:  name,
+		usage: fmt.Sprintf("%s (%s)", usage, strings.Join(values, ", ")),
+		set: func(c *Config, args []string) error {
+			if len(args) != 1 {
+				return ErrConfigArgs
+			}
+			for _, allowed := range values {
+				if strings.EqualFold(args[0], allowed) {
+					*field(c) = allowed
+					return nil
+				}
+			}
+			return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(values, ", "))
+		},
+		get: func(c *Config) string { return *field(c) },
+	}
+}
+
+// lookupDirective finds a directive by its case insensitive name
+func lookupDirective(name string) *configDirective {
+	name = strings.ToLower(name)
+	for _, directive := range configDirectives {
+		if directive.name == name {
+			return directive
+		}
+	}
+	return nil
+}
+
+// Apply sets a directive from its arguments
+func (c *Config) Apply(name string, args []string) error {
+	directive := lookupDirective(name)
+	if directive == nil {
+		return ErrConfigArgs
+	}
+	return directive.set(c, args)
+}
+
+// LoadFile applies every directive of a redis.conf style file. Blank lines
+// and lines starting with # are ignored, and errors report the offending line.
+func (c *Config) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Fatal error, can't open config file '%s': %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	sawSave := false
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		args, err := SplitConfigArgs(line)
+		if err == nil {
+			previous := c.Save
+			err = c.Apply(args[0], args[1:])
+			// Like in Redis, save lines add up instead of replacing each other
+			if err == nil && strings.EqualFold(args[0], "save") {
+				if sawSave && len(c.Save) > 0 {
+					c.Save = append(previous[:len(previous):len(previous)], c.Save...)
+				}
+				sawSave = true
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Reading the configuration file %s, at line %d\n>>> '%s'\n%v", path, lineNumber, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	c.File = path
+	return nil
+}
+
+// SplitConfigArgs splits a config line into arguments like sdssplitargs in
+// Redis. Arguments are separated by spaces and may be quoted, with escapes
+// such as \n and \x41 inside double quotes.
+func SplitConfigArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg strings.Builder
+		quote := byte(0)
+		if line[i] == '"' || line[i] == '\'' {
+			quote = line[i]
+			i++
+		}
+		for {
+			if i == len(line) {
+				if quote != 0 {
+					return nil, ErrConfigQuotes
+				}
+				break
+			}
+			c := line[i]
+			if quote == 0 {
+				if c == ' ' || c == '\t' {
+					break
+				}
+				arg.WriteByte(c)
+				i++
+				continue
+			}
+			if c == quote {
+				// A closing quote must be followed by a space or the end of the line
+				i++
+				if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+					return nil, ErrConfigQuotes
+				}
+				break
+			}
+			if c == '\\' && i+1 < len(line) {
+				if quote == '\'' {
+					if line[i+1] == '\'' {
+						c = '\''
+						i++
+					}
+				} else if line[i+1] == 'x' && i+3 < len(line) && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					value, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					c = byte(value)
+					i += 3
+				} else {
+					i++
+					switch line[i] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					default:
+						c = line[i]
+					}
+				}
+			}
+			arg.WriteByte(c)
+			i++
+		}
+		args = append(args, arg.String())
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// ParseMemory parses a memory size like Redis memtoull: a number of bytes with
+// an optional case insensitive unit, where k, m and g are powers of 1000 and
+// kb, mb and gb powers of 1024
+func ParseMemory(arg string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	number := strings.ToLower(arg)
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSuffix(number, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value < 0 || value > (1<<63-1)/multiplier {
+		return 0, ErrConfigMemory
+	}
+	return value * multiplier, nil
+}
+
+// configFlags holds a command line flag for every directive. Flags are
+// registered on the default flag set so they are parsed alongside any other
+// flags of the binary.
+var configFlags = func() map[string]*string {
+	flags := make(map[string]*string)
+	defaults := DefaultConfig()
+	for _, directive := range configDirectives {
+		flags[directive.name] = flag.String(directive.name, directive.get(defaults), directive.usage)
+	}
+	return flags
+}()
+
+// LoadConfig builds the configuration from the command line, like
+// redis-server: an optional config file path followed or preceded by flags
+// such as --port 6380, which override the file
+func LoadConfig(arguments []string) (*Config, error) {
+	if err := flag.CommandLine.Parse(arguments); err != nil {
+		return nil, err
+	}
+
+	c := DefaultConfig()
+	if flag.NArg() > 0 {
+		if err := c.LoadFile(flag.Arg(0)); err != nil {
+			return nil, err
+		}
+		// Flags may also follow the config file
+		if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+			return nil, err
+		}
+		if flag.NArg() > 0 {
+			return nil, ErrConfigExtraArgs
+		}
+	}
+
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		directive := lookupDirective(f.Name)
+		if directive == nil || err != nil {
+			return
+		}
+		if setErr := directive.set(c, directive.args(*configFlags[f.Name])); setErr != nil {
+			err = fmt.Errorf("Invalid --%s '%s': %v", f.Name, f.Value, setErr)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ConfigGet returns the name and value of every directive matching one of the
+// glob patterns, flattened as name, value pairs
+func ConfigGet(patterns []string) []string {
+	c := currentConfig()
+	pairs := []string{}
+	for _, directive := range configDirectives {
+		for _, pattern := range patterns {
+			if StringMatch(pattern, directive.name, true) {
+				pairs = append(pairs, directive.name, directive.get(&c))
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// ConfigSet applies name, value pairs at runtime. Either every parameter is
+// changed or, if one of them is invalid, none are.
+func ConfigSet(pairs []string) error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	next := *config
+	seen := make(map[*configDirective]bool)
+	var changed []*configDirective
+	for i := 0; i < len(pairs); i += 2 {
+		name, value := pairs[i], pairs[i+1]
+		directive := lookupDirective(name)
+		if directive == nil {
+			return fmt.Errorf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", name)
+		}
+		if seen[directive] {
+			return configSetError(name, errors.New("duplicate parameter"))
+		}
+		seen[directive] = true
+		if directive.immutable {
+			return configSetError(name, ErrConfigImmutable)
+		}
+
+		if err := directive.set(&next, directive.args(value)); err != nil {
+			return configSetError(name, err)
+		}
+		changed = append(changed, directive)
+	}
+
+	for i, directive := range changed {
+		if directive.apply == nil {
+			continue
+		}
+		if err := directive.apply(&next); err != nil {
+			// Undo the changes already applied
+			for _, applied := range changed[:i] {
+				if applied.apply != nil {
+					applied.apply(config)
+				}
+			}
+			return configSetError(directive.name, err)
+		}
+	}
+	*config = next
+	return nil
+}
+
+func configSetError(name string, err error) error {
+	return fmt.Errorf("ERR CONFIG SET failed (possibly related to argument '%s') - %v", name, err)
+}
+
+// ConfigRewrite writes the current settings back to the config file the
+// server was started with. Comments, unknown lines and the order of existing
+// directives are kept, repeated directives are collapsed into one line and
+// settings changed from their defaults that aren't in the file yet are
+// appended at the end, after a signature comment.
+func ConfigRewrite() error {
+	c := currentConfig()
+	if c.File == "" {
+		return ErrConfigNoFile
+	}
+
+	contents, err := os.ReadFile(c.File)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+
+	var lines []string
+	written := make(map[*configDirective]bool)
+	signed := false
+	if len(contents) > 0 {
+		for _, line := range strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n") {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == CONFIG_REWRITE_SIGNATURE {
+				signed = true
+			}
+			var directive *configDirective
+			if trimmed != "" && trimmed[0] != '#' {
+				if args, err := SplitConfigArgs(trimmed); err == nil {
+					directive = lookupDirective(args[0])
+				}
+			}
+			if directive == nil {
+				lines = append(lines, line)
+			} else if !written[directive] {
+				lines = append(lines, formatConfigLine(directive, &c))
+				written[directive] = true
+			}
+		}
+	}
+
+	defaults := DefaultConfig()
+	for _, directive := range configDirectives {
+		if written[directive] || directive.get(&c) == directive.get(defaults) {
+			continue
+		}
+		if !signed {
+			lines = append(lines, CONFIG_REWRITE_SIGNATURE)
+			signed = true
+		}
+		lines = append(lines, formatConfigLine(directive, &c))
+	}
+
+	// Write a temporary file and rename it, so a failure never leaves a
+	// truncated config file behind
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(c.File); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp := fmt.Sprintf("%s.tmp-%d", c.File, os.Getpid())
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), mode); err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	if err := os.Rename(tmp, c.File); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	return nil
+}
+
+const CONFIG_REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+// formatConfigLine renders a directive as a config file line
+func formatConfigLine(directive *configDirective, c *Config) string {
+	args := []string{directive.get(c)}
+	if directive.name == "bind" {
+		args = c.Bind
+	}
+	line := directive.name
+	for _, arg := range args {
+		line += " " + quoteConfigArg(arg)
+	}
+	return line
+}
+
+// quoteConfigArg quotes an argument when SplitConfigArgs wouldn't read it
+// back unchanged otherwise
+func quoteConfigArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") && strconv.CanBackquote(arg) {
+		return arg
+	}
+	return quoteString(arg)
+}
+
+// quoteString returns arg in double quotes with quotes, backslashes and
+// unprintable bytes escaped, like sdscatrepr in Redis
+func quoteString(arg string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch c := arg[i]; c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		default:
+			if c < ' ' || c > '~' {
+				fmt.Fprintf(&quoted, `\x%02x`, c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+var configHelp = []string{
+	"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET <pattern>",
+	"    Return parameters matching the glob-like <pattern> and their values.",
+	"SET <directive> <value>",
+	"    Set the configuration <directive> to <value>.",
+	"RESETSTAT",
+	"    Reset statistics reported by the INFO command.",
+	"REWRITE",
+	"    Rewrite the configuration file.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleConfig runs the CONFIG subcommands
+func handleConfig(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	arityError := &RESPValue{
+		Type: Error,
+		Str:  fmt.Sprintf("ERR wrong number of arguments for 'CONFIG|%s' command", subcommand),
+	}
+	if spec := lookupCommand("config|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return arityError
+	}
+	params := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		params[i] = arg.Str
+	}
+
+	switch subcommand {
+	case "GET":
+		return bulkStringArray(ConfigGet(params))
+	case "SET":
+		if len(params)%2 != 0 {
+			return arityError
+		}
+		if err := ConfigSet(params); err != nil {
+			return &RESPValue{Type: Error, Str: err.Error()}
+		}
+		return &RESPValue{Type: SimpleString, Str: "OK"}
+	case "RESETSTAT":
+		ResetStats()
+		return &RESPValue{Type: SimpleString, Str: "OK"}
+	case "REWRITE":
+		if err := ConfigRewrite(); err != nil {
+			return &RESPValue{Type: Error, Str: err.Error()}
+		}
+		return &RESPValue{Type: SimpleString, Str: "OK"}
+	case "HELP":
+		return simpleStringArrayReply(configHelp)
+	default:
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try CONFIG HELP.", args[0].Str),
+		}
+	}
+}
+
+// bulkStringArray builds an array reply of bulk strings
+func bulkStringArray(elements []string) *RESPValue {
+	items := make([]RESPValue, len(elements))
+	for i, element := range elements {
+		items[i] = RESPValue{Type: BulkString, Str: element}
+	}
+	return &RESPValue{Type: Array, Array: items}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	contents := `# A comment
+port 7000
+bind 127.0.0.1 -::1
+
+maxmemory 100mb
+maxmemory-policy ALLKEYS-LRU
+appendonly yes
+dbfilename "my dump.rdb"
+databases 4
+save 900 1
+save 300 10
+`
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := DefaultConfig()
+	if err := c.LoadFile(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := DefaultConfig()
+	expected.File = path
+	expected.Port = 7000
+	expected.Bind = []string{"127.0.0.1", "-::1"}
+	expected.MaxMemory = 100 << 20
+	expected.MaxMemoryPolicy = "allkeys-lru"
+	expected.AppendOnly = true
+	expected.DBFilename = "my dump.rdb"
+	expected.Databases = 4
+	expected.Save = []SavePoint{{900, 1}, {300, 10}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, c)
+	}
+
+	if addresses := listenAddresses(c, c.Port); !reflect.DeepEqual(addresses, []string{"127.0.0.1:7000", "-[::1]:7000"}) {
+		t.Errorf("Unexpected listen addresses %v", addresses)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+	}{
+		{"unknown-directive yes", "Bad directive or wrong number of arguments"},
+		{"port", "Bad directive or wrong number of arguments"},
+		{"port seventy", "argument couldn't be parsed into an integer"},
+		{"port 70000", "argument must be between 0 and 65535 inclusive"},
+		{"appendonly maybe", "argument must be 'yes' or 'no'"},
+		{"maxmemory lots", "argument must be a memory value"},
+		{"loglevel loud", "argument(s) must be one of the following: debug, verbose, notice, warning, nothing"},
+		{"dbfilename dir/dump.rdb", "dbfilename can't be a path, just a filename"},
+		{`dbfilename "dump.rdb`, "Unbalanced quotes in configuration line"},
+		{"unixsocketperm 800", "argument must be octal permissions between 0 and 777"},
+		{"tls-auth-clients sometimes", "argument(s) must be one of the following: yes, no, optional"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "redis.conf")
+			os.WriteFile(path, []byte("port 7000\n"+tt.line+"\n"), 0644)
+			err := DefaultConfig().LoadFile(path)
+			if err == nil || !strings.Contains(err.Error(), "at line 2\n>>> '"+tt.line+"'\n"+tt.expected) {
+				t.Errorf("Expected error %q at line 2, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestSplitConfigArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"port 6379", []string{"port", "6379"}},
+		{"  bind\t127.0.0.1   ::1 ", []string{"bind", "127.0.0.1", "::1"}},
+		{`logfile "a b\x41\n"`, []string{"logfile", "a bA\n"}},
+		{`dir 'it\'s'`, []string{"dir", "it's"}},
+		{`dbfilename ""`, []string{"dbfilename", ""}},
+	}
+	for _, tt := range tests {
+		args, err := SplitConfigArgs(tt.line)
+		if err != nil || !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("SplitConfigArgs(%q) = %q, %v, expected %q", tt.line, args, err, tt.expected)
+		}
+	}
+
+	if _, err := SplitConfigArgs(`dir "a"b`); err != ErrConfigQuotes {
+		t.Errorf("Expected ErrConfigQuotes, got %v", err)
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := map[string]int64{
+		"0":    0,
+		"1024": 1024,
+		"1k":   1000,
+		"1kb":  1024,
+		"2MB":  2 << 20,
+		"3g":   3000000000,
+		"1gb":  1 << 30,
+		"100b": 100,
+	}
+	for arg, expected := range tests {
+		if got, err := ParseMemory(arg); err != nil || got != expected {
+			t.Errorf("ParseMemory(%q) = %d, %v, expected %d", arg, got, err, expected)
+		}
+	}
+	for _, arg := range []string{"", "-1", "1tb", "kb", "1.5mb"} {
+		if _, err := ParseMemory(arg); err != ErrConfigMemory {
+			t.Errorf("ParseMemory(%q) expected ErrConfigMemory, got %v", arg, err)
+		}
+	}
+}
+
+// withConfig runs fn with the global settings replaced by c, restoring them afterwards
+func withConfig(t *testing.T, c *Config, fn func()) {
+	t.Helper()
+	configMu.Lock()
+	saved := config
+	config = c
+	configMu.Unlock()
+	defer func() {
+		configMu.Lock()
+		config = saved
+		configMu.Unlock()
+	}()
+	fn()
+}
+
+func TestConfigGetSet(t *testing.T) {
+	withConfig(t, DefaultConfig(), func() {
+		if pairs := ConfigGet([]string{"maxmemory*", "PORT"}); !reflect.DeepEqual(pairs,
+			[]string{"port", "6379", "maxmemory", "0", "maxmemory-policy", "noeviction", "maxmemory-samples", "5"}) {
+			t.Errorf("Unexpected CONFIG GET result %v", pairs)
+		}
+
+		if err := ConfigSet([]string{"maxmemory", "1mb", "loglevel", "warning"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if c := currentConfig(); c.MaxMemory != 1<<20 || c.LogLevel != "warning" {
+			t.Errorf("Expected maxmemory and loglevel to change, got %d, %s", c.MaxMemory, c.LogLevel)
+		}
+
+		tests := []struct {
+			pairs    []string
+			expected string
+		}{
+			{[]string{"nosuch", "1"}, "ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'"},
+			{[]string{"port", "7000"}, "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"},
+			{[]string{"timeout", "5", "timeout", "6"}, "ERR CONFIG SET failed (possibly related to argument 'timeout') - duplicate parameter"},
+			{[]string{"timeout", "5", "appendonly", "maybe"}, "ERR CONFIG SET failed (possibly related to argument 'appendonly') - argument must be 'yes' or 'no'"},
+		}
+		for _, tt := range tests {
+			if err := ConfigSet(tt.pairs); err == nil || err.Error() != tt.expected {
+				t.Errorf("ConfigSet(%v): expected %q, got %v", tt.pairs, tt.expected, err)
+			}
+		}
+		// A failed CONFIG SET changes nothing
+		if c := currentConfig(); c.Timeout != 0 {
+			t.Errorf("Expected timeout to be unchanged, got %d", c.Timeout)
+		}
+	})
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	contents := `# Server settings
+port 7000
+timeout 10
+
+loglevel debug
+timeout 20
+include other.conf
+`
+	os.WriteFile(path, []byte(contents), 0600)
+
+	c := DefaultConfig()
+	if err := c.LoadFile(path); err == nil {
+		t.Fatal("Expected include to be rejected")
+	}
+	c.File = path
+	c.Port = 7000
+	withConfig(t, c, func() {
+		if err := ConfigSet([]string{"timeout", "30", "maxmemory", "2gb", "logfile", ""}); err == nil {
+			t.Fatal("Expected logfile to be immutable")
+		}
+		if err := ConfigSet([]string{"timeout", "30", "maxmemory", "2gb", "dbfilename", "my dump.rdb"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := ConfigRewrite(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	})
+
+	rewritten, _ := os.ReadFile(path)
+	expected := `# Server settings
+port 7000
+timeout 30
+
+loglevel debug
+include other.conf
+# Generated by CONFIG REWRITE
+dbfilename "my dump.rdb"
+maxmemory 2147483648
+`
+	if string(rewritten) != expected {
+		t.Errorf("Expected rewritten file:\n%s\ngot:\n%s", expected, rewritten)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600 to be kept, got %v", info.Mode().Perm())
+	}
+
+	// Rewriting again is stable
+	withConfig(t, c, func() {
+		ConfigSet([]string{"timeout", "30", "maxmemory", "2gb", "dbfilename", "my dump.rdb"})
+		ConfigRewrite()
+	})
+	if again, _ := os.ReadFile(path); string(again) != expected {
+		t.Errorf("Expected a second rewrite to be unchanged, got:\n%s", again)
+	}
+
+	withConfig(t, DefaultConfig(), func() {
+		if err := ConfigRewrite(); err != ErrConfigNoFile {
+			t.Errorf("Expected ErrConfigNoFile, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_DATABASES = 16
+
+var ErrDBIndex = errors.New("ERR DB index is out of range")
+
+// databases holds the numbered logical databases, selected per connection
+var databases []*Storage
+
+// NewDatabases creates n empty databases numbered from 0
+func NewDatabases(n int) []*Storage {
+	dbs := make([]*Storage, n)
+	for i := range dbs {
+		dbs[i] = NewStorage()
+		dbs[i].index = i
+	}
+	return dbs
+}
+
+// ParseDBIndex parses a database number and checks it is in range
+func ParseDBIndex(arg string) (int, error) {
+	index, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	if index < 0 || index >= len(databases) {
+		return 0, ErrDBIndex
+	}
+	return index, nil
+}
+
+// lockPair write locks two databases, or one if they are the same, and
+// returns the function releasing them. Databases are always locked in index
+// order so concurrent commands touching the same pair can't deadlock.
+func lockPair(a, b *Storage) func() {
+	if a == b {
+		a.mu.Lock()
+		return a.mu.Unlock
+	}
+	if b.index < a.index {
+		a, b = b, a
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+	}
+}
+
+// Move moves key to the dst database and reports whether it was moved. Like
+// Redis nothing happens if the key is missing or already exists in dst.
+func (s *Storage) Move(key string, dst *Storage) (bool, error) {
+	if s == dst {
+		return false, ErrSameObject
+	}
+
+	unlock := lockPair(s, dst)
+	defer unlock()
+
+	value, exists := s.data.Get(key)
+	if !exists {
+		return false, nil
+	}
+	if _, exists := dst.data.Get(key); exists {
+		return false, nil
+	}
+	s.deleteKey(key)
+	dst.setValue(key, value)
+	return true, nil
+}
+
+// Swap exchanges the contents of two databases. Connections keep their
+// database number, so clients of one database see the other's keys.
+func (s *Storage) Swap(other *Storage) {
+	unlock := lockPair(s, other)
+	defer unlock()
+	s.data, other.data = other.data, s.data
+	used := s.used.Load()
+	s.used.Store(other.used.Load())
+	other.used.Store(used)
+}
+
+// Flush removes every key by swapping in an empty keyspace. With async the
+// old keyspace is released by the lazy free worker, otherwise it is left to
+// the garbage collector.
+func (s *Storage) Flush(async bool) {
+	s.mu.Lock()
+	old := s.data
+	s.data = NewDict[*Value]()
+	s.used.Store(0)
+	s.mu.Unlock()
+
+	if async {
+		freeDictAsync(old)
+	}
+}
+
+// FlushAll flushes every database
+func FlushAll(async bool) {
+	for _, db := range databases {
+		db.Flush(async)
+	}
+}
+
+// parseFlushMode parses the optional ASYNC or SYNC argument of FLUSHDB and FLUSHALL
+func parseFlushMode(args []RESPValue) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if len(args) > 1 {
+		return false, ErrSyntax
+	}
+	switch strings.ToUpper(args[0].Str) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	}
+	return false, ErrSyntax
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand"
+)
+
+const (
+	DICT_INITIAL_SIZE = 4
+	DICT_MIN_FILL     = 8 // Shrink when less than 1/8 of the buckets are used
+)
+
+// Dict is a chained hash table with a power of two number of buckets. Unlike
+// a Go map its bucket layout is known, which allows Redis style reverse binary
+// cursors: Scan returns every element present for the whole iteration even if
+// the table grows or shrinks between calls.
+type Dict[V any] struct {
+	table []*dictEntry[V]
+	used  int
+	seed  maphash.Seed
+}
+
+type dictEntry[V any] struct {
+	key   string
+	value V
+	next  *dictEntry[V]
+}
+
+// NewDict creates an empty Dict
+func NewDict[V any]() *Dict[V] {
+	return &Dict[V]{seed: maphash.MakeSeed()}
+}
+
+// Len returns the number of elements
+func (d *Dict[V]) Len() int {
+	return d.used
+}
+
+// Buckets returns the number of buckets in the table
+func (d *Dict[V]) Buckets() int {
+	return len(d.table)
+}
+
+func (d *Dict[V]) bucket(key string) uint64 {
+	return maphash.String(d.seed, key) & uint64(len(d.table)-1)
+}
+
+func (d *Dict[V]) find(key string) *dictEntry[V] {
+	if len(d.table) == 0 {
+		return nil
+	}
+	for entry := d.table[d.bucket(key)]; entry != nil; entry = entry.next {
+		if entry.key == key {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Get returns the value stored for key
+func (d *Dict[V]) Get(key string) (V, bool) {
+	if entry := d.find(key); entry != nil {
+		return entry.value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set stores a value for key and reports whether the key was added
+func (d *Dict[V]) Set(key string, value V) bool {
+	if entry := d.find(key); entry != nil {
+		entry.value = value
+		return false
+	}
+	if d.used >= len(d.table) {
+		d.resize(max(len(d.table)*2, DICT_INITIAL_SIZE))
+	}
+	index := d.bucket(key)
+	d.table[index] = &dictEntry[V]{key: key, value: value, next: d.table[index]}
+	d.used++
+	return true
+}
+
+// Delete removes key and returns its value
+func (d *Dict[V]) Delete(key string) (V, bool) {
+	var zero V
+	if len(d.table) == 0 {
+		return zero, false
+	}
+	index := d.bucket(key)
+	for link := &d.table[index]; *link != nil; link = &(*link).next {
+		if entry := *link; entry.key == key {
+			*link = entry.next
+			d.used--
+			if len(d.table) > DICT_INITIAL_SIZE && d.used < len(d.table)/DICT_MIN_FILL {
+				d.resize(max(nextPowerOfTwo(d.used), DICT_INITIAL_SIZE))
+			}
+			return entry.value, true
+		}
+	}
+	return zero, false
+}
+
+// Clear removes all elements
+func (d *Dict[V]) Clear() {
+	d.table = nil
+	d.used = 0
+}
+
+// Range calls fn for every element in bucket order until fn returns false
+func (d *Dict[V]) Range(fn func(key string, value V) bool) {
+	for _, entry := range d.table {
+		for ; entry != nil; entry = entry.next {
+			if !fn(entry.key, entry.value) {
+				return
+			}
+		}
+	}
+}
+
+// Scan calls fn for the elements of the bucket addressed by cursor and returns
+// the next cursor, or 0 when the iteration is complete.
+//
+// The cursor is incremented with its bits reversed, so the high bits are
+// incremented first. Since the bucket of a key in a table of 2^n buckets is
+// the low n bits of its hash, the buckets already visited in a smaller or
+// larger table map to cursor values already passed, so resizing between calls
+// may return elements twice but never skips an element.
+func (d *Dict[V]) Scan(cursor uint64, fn func(key string, value V)) uint64 {
+	if d.used == 0 {
+		return 0
+	}
+	mask := uint64(len(d.table) - 1)
+	for entry := d.table[cursor&mask]; entry != nil; entry = entry.next {
+		fn(entry.key, entry.value)
+	}
+
+	// Set the unmasked bits so incrementing the reversed cursor carries
+	// into the masked bits
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// RandomKey returns a random element by picking a random non empty bucket and
+// then a random element in its chain
+func (d *Dict[V]) RandomKey() (string, V, bool) {
+	if d.used == 0 {
+		var zero V
+		return "", zero, false
+	}
+	var head *dictEntry[V]
+	for head == nil {
+		head = d.table[rand.Intn(len(d.table))]
+	}
+	length := 0
+	for entry := head; entry != nil; entry = entry.next {
+		length++
+	}
+	entry := head
+	for i := rand.Intn(length); i > 0; i-- {
+		entry = entry.next
+	}
+	return entry.key, entry.value, true
+}
+
+// resize rehashes every element into a table with size buckets
+func (d *Dict[V]) resize(size int) {
+	old := d.table
+	d.table = make([]*dictEntry[V], size)
+	for _, entry := range old {
+		for entry != nil {
+			next := entry.next
+			index := d.bucket(entry.key)
+			entry.next = d.table[index]
+			d.table[index] = entry
+			entry = next
+		}
+	}
+}
+
+func nextPowerOfTwo(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len(uint(n-1))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDict(t *testing.T) {
+	d := NewDict[int]()
+
+	for i := 0; i < 1000; i++ {
+		if !d.Set(fmt.Sprintf("key%d", i), i) {
+			t.Fatalf("Expected key%d to be added", i)
+		}
+	}
+	if d.Set("key1", 42) {
+		t.Error("Expected updating a key not to count as added")
+	}
+	if value, exists := d.Get("key1"); !exists || value != 42 {
+		t.Errorf("Expected 42, got %d", value)
+	}
+	if d.Len() != 1000 || d.Buckets() != 1024 {
+		t.Errorf("Expected 1000 elements in 1024 buckets, got %d in %d", d.Len(), d.Buckets())
+	}
+
+	// The table shrinks as elements are removed
+	for i := 0; i < 990; i++ {
+		if _, deleted := d.Delete(fmt.Sprintf("key%d", i)); !deleted {
+			t.Fatalf("Expected key%d to be deleted", i)
+		}
+	}
+	if d.Len() != 10 || d.Buckets() > 128 {
+		t.Errorf("Expected 10 elements in at most 128 buckets, got %d in %d", d.Len(), d.Buckets())
+	}
+	if _, exists := d.Get("key0"); exists {
+		t.Error("Expected key0 to be deleted")
+	}
+
+	if key, _, exists := d.RandomKey(); !exists || key < "key990" {
+		t.Errorf("Expected a remaining random key, got %q", key)
+	}
+}
+
+func TestDictScanWhileResizing(t *testing.T) {
+	d := NewDict[int]()
+	for i := 0; i < 100; i++ {
+		d.Set(fmt.Sprintf("stable%d", i), i)
+	}
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for step := 0; ; step++ {
+		cursor = d.Scan(cursor, func(key string, value int) {
+			seen[key] = true
+		})
+		if cursor == 0 {
+			break
+		}
+
+		// Grow the table during the first half of the iteration and
+		// shrink it during the second half
+		if step < 40 {
+			for i := 0; i < 20; i++ {
+				d.Set(fmt.Sprintf("temp%d-%d", step, i), i)
+			}
+		} else {
+			d.Range(func(key string, value int) bool {
+				if key[0] == 't' {
+					d.Delete(key)
+					return false
+				}
+				return true
+			})
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if !seen[fmt.Sprintf("stable%d", i)] {
+			t.Errorf("Expected stable%d to be returned by the scan", i)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EVPOOL_SIZE  = 16  // Best eviction candidates kept between evictions
+	LFU_INIT_VAL = 5   // Counter of new keys, so they aren't evicted right away
+	LFU_MAX      = 255 // The counter saturates like the 8 bit Redis counter
+)
+
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// lfuConfig mirrors the lfu-log-factor and lfu-decay-time settings, which
+// every key access reads, so touch doesn't copy the whole config
+type lfuConfig struct {
+	logFactor atomic.Int64
+	decayTime atomic.Int64 // Minutes for the counter to decay by one
+}
+
+var lfuSettings = func() *lfuConfig {
+	s := new(lfuConfig)
+	s.set(DefaultConfig())
+	return s
+}()
+
+// set is called at startup and when CONFIG SET changes either setting
+func (s *lfuConfig) set(c *Config) {
+	s.logFactor.Store(int64(c.LFULogFactor))
+	s.decayTime.Store(int64(c.LFUDecayTime))
+}
+
+// initAccess starts the access tracking of a value being stored
+func (v *Value) initAccess() {
+	now := time.Now()
+	v.accessed.Store(now.UnixMilli())
+	v.lfu.Store(uint64(now.Unix()/60)<<8 | LFU_INIT_VAL)
+}
+
+// touch records an access for the LRU and LFU policies. The LFU counter
+// first decays by the time since its last decrement, then grows with a
+// probability that falls as it gets larger, like in Redis.
+func (v *Value) touch() {
+	now := time.Now()
+	v.accessed.Store(now.UnixMilli())
+
+	decayTime, logFactor := int(lfuSettings.decayTime.Load()), int(lfuSettings.logFactor.Load())
+	counter := lfuLogIncr(v.lfuDecay(now, decayTime), logFactor)
+	v.lfu.Store(uint64(now.Unix()/60)<<8 | uint64(counter))
+}
+
+// lfuDecay returns the LFU counter decremented once per decayTime minutes
+// since it was last decremented
+func (v *Value) lfuDecay(now time.Time, decayTime int) uint8 {
+	lfu := v.lfu.Load()
+	counter := uint8(lfu & 0xff)
+	minutes := uint64(now.Unix() / 60)
+	if decayTime == 0 || minutes < lfu>>8 {
+		return counter
+	}
+	periods := (minutes - lfu>>8) / uint64(decayTime)
+	if periods >= uint64(counter) {
+		return 0
+	}
+	return counter - uint8(periods)
+}
+
+// lfuLogIncr increments an LFU counter with probability 1/((counter-5)*factor+1),
+// so a higher log factor needs more accesses to reach the same counter
+func lfuLogIncr(counter uint8, factor int) uint8 {
+	if counter == LFU_MAX {
+		return counter
+	}
+	base := max(float64(counter)-LFU_INIT_VAL, 0)
+	if rand.Float64() < 1/(base*float64(factor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// evictionPolicy is a parsed maxmemory-policy
+type evictionPolicy struct {
+	volatile bool   // Only keys with an expiry are candidates
+	kind     string // lru, lfu, random or ttl, empty for noeviction
+}
+
+func parseEvictionPolicy(policy string) evictionPolicy {
+	scope, kind, _ := strings.Cut(policy, "-")
+	if scope == "noeviction" {
+		return evictionPolicy{}
+	}
+	return evictionPolicy{volatile: scope == "volatile", kind: kind}
+}
+
+// evictionCandidate is a sampled key with its score, higher scores are evicted first
+type evictionCandidate struct {
+	score uint64
+	key   string
+	db    *Storage
+}
+
+// evictor picks the keys to evict. Like Redis it keeps a small pool of the
+// best candidates seen in previous samples, sorted by ascending score, so
+// each eviction compares more keys than a single sample.
+type evictor struct {
+	mu       sync.Mutex
+	pool     []evictionCandidate
+	nextDB   int // Database the random policies evict from next
+	lastKind string
+}
+
+var evictions = &evictor{}
+
+// performEvictions evicts keys until the dataset fits in maxmemory. It
+// returns ErrOOM if it is still over the limit because the policy is
+// noeviction or there is nothing left to evict.
+func performEvictions() error {
+	c := currentConfig()
+	if c.MaxMemory == 0 {
+		return nil
+	}
+	policy := parseEvictionPolicy(c.MaxMemoryPolicy)
+
+	evictions.mu.Lock()
+	defer evictions.mu.Unlock()
+	if policy.kind != evictions.lastKind {
+		// Scores of another policy aren't comparable
+		evictions.pool = evictions.pool[:0]
+		evictions.lastKind = policy.kind
+	}
+	if datasetMemory() <= c.MaxMemory {
+		return nil
+	}
+	start := time.Now()
+	defer func() { latencyMonitor.AddSampleIfNeeded("eviction-cycle", time.Since(start)) }()
+	for datasetMemory() > c.MaxMemory {
+		if policy.kind == "" {
+			return ErrOOM
+		}
+		var db *Storage
+		var key string
+		if policy.kind == "random" {
+			db, key = evictions.randomKey(policy)
+		} else {
+			db, key = evictions.bestKey(policy, c.MaxMemorySamples, c.LFUDecayTime)
+		}
+		if db == nil {
+			return ErrOOM
+		}
+
+		// Replicas delete the key when the DEL reaches them
+		deleteStart := time.Now()
+		propagate := replication.beginWrite()
+		db.mu.Lock()
+		_, deleted := db.deleteKey(key)
+		db.mu.Unlock()
+		if deleted && propagate {
+			replication.Propagate(db.index, []string{"DEL", key})
+		}
+		replication.endWrite(propagate)
+		latencyMonitor.AddSampleIfNeeded("eviction-del", time.Since(deleteStart))
+		if deleted {
+			stats.evictedKeys.Add(1)
+			logDebug("Evicted key", "key", key, "db", db.index)
+		}
+	}
+	return nil
+}
+
+// randomKey picks a random candidate, visiting the databases in turn
+func (e *evictor) randomKey(policy evictionPolicy) (*Storage, string) {
+	for range databases {
+		db := databases[e.nextDB%len(databases)]
+		e.nextDB++
+		db.mu.RLock()
+		keys := sampleKeys(db, policy, 1)
+		db.mu.RUnlock()
+		if len(keys) > 0 {
+			return db, keys[0]
+		}
+	}
+	return nil, ""
+}
+
+// bestKey samples every database into the pool and takes the candidate with
+// the highest score that still exists
+func (e *evictor) bestKey(policy evictionPolicy, samples, decayTime int) (*Storage, string) {
+	now := time.Now()
+	for _, db := range databases {
+		db.mu.RLock()
+		for _, key := range sampleKeys(db, policy, samples) {
+			value, _ := db.data.Get(key)
+			e.add(evictionCandidate{score: evictionScore(value, policy, now, decayTime), key: key, db: db})
+		}
+		db.mu.RUnlock()
+	}
+
+	for len(e.pool) > 0 {
+		best := e.pool[len(e.pool)-1]
+		e.pool = e.pool[:len(e.pool)-1]
+		best.db.mu.RLock()
+		_, exists := best.db.data.Get(best.key)
+		best.db.mu.RUnlock()
+		if exists {
+			return best.db, best.key
+		}
+	}
+	return nil, ""
+}
+
+// add inserts a candidate in the pool, replacing the entry of the same key,
+// and drops the worst candidate if the pool is full
+func (e *evictor) add(candidate evictionCandidate) {
+	e.pool = slices.DeleteFunc(e.pool, func(c evictionCandidate) bool {
+		return c.db == candidate.db && c.key == candidate.key
+	})
+	i, _ := slices.BinarySearchFunc(e.pool, candidate.score, func(c evictionCandidate, score uint64) int {
+		switch {
+		case c.score < score:
+			return -1
+		case c.score > score:
+			return 1
+		}
+		return 0
+	})
+	if len(e.pool) == EVPOOL_SIZE {
+		if i == 0 {
+			return
+		}
+		e.pool = e.pool[1:]
+		i--
+	}
+	e.pool = slices.Insert(e.pool, i, candidate)
+}
+
+// evictionScore rates a value for eviction: its idle time for LRU, how
+// rarely it is used for LFU
+func evictionScore(value *Value, policy evictionPolicy, now time.Time, decayTime int) uint64 {
+	if policy.kind == "lfu" {
+		return LFU_MAX - uint64(value.lfuDecay(now, decayTime))
+	}
+	return uint64(max(now.UnixMilli()-value.accessed.Load(), 0))
+}
+
+// sampleKeys returns up to count random keys that the policy may evict.
+// Keys can't have an expiry yet, so the volatile policies have no candidates
+// and behave like noeviction, as they do in Redis when no key has a TTL.
+// The caller must hold the lock.
+func sampleKeys(db *Storage, policy evictionPolicy, count int) []string {
+	if policy.volatile || db.data.Len() == 0 {
+		return nil
+	}
+	keys := make([]string, 0, count)
+	for range count {
+		key, _, _ := db.data.RandomKey()
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withDatabases runs fn with n empty databases in place of the global ones
+func withDatabases(t *testing.T, n int, fn func()) {
+	t.Helper()
+	saved := databases
+	databases = NewDatabases(n)
+	defer func() { databases = saved }()
+	fn()
+}
+
+func TestLFUCounter(t *testing.T) {
+	if lfuLogIncr(LFU_MAX, 0) != LFU_MAX {
+		t.Error("Expected the counter to saturate")
+	}
+	if lfuLogIncr(100, 0) != 101 {
+		t.Error("Expected a log factor of 0 to always increment")
+	}
+	incremented := 0
+	for range 1000 {
+		if lfuLogIncr(100, 10) == 101 {
+			incremented++
+		}
+	}
+	if incremented > 20 {
+		t.Errorf("Expected a high counter to rarely grow, grew %d times in 1000", incremented)
+	}
+
+	// CONFIG SET updates the settings read on every access
+	withConfig(t, DefaultConfig(), func() {
+		defer lfuSettings.set(DefaultConfig())
+		if err := ConfigSet([]string{"lfu-log-factor", "0", "lfu-decay-time", "5"}); err != nil {
+			t.Fatal(err)
+		}
+		if lfuSettings.logFactor.Load() != 0 || lfuSettings.decayTime.Load() != 5 {
+			t.Errorf("Expected the LFU settings to be updated, got %d and %d",
+				lfuSettings.logFactor.Load(), lfuSettings.decayTime.Load())
+		}
+	})
+
+	value := &Value{}
+	now := time.Now()
+	value.lfu.Store(uint64(now.Unix()/60-3)<<8 | 10)
+	if counter := value.lfuDecay(now, 1); counter != 7 {
+		t.Errorf("Expected 3 minutes to decay the counter to 7, got %d", counter)
+	}
+	if counter := value.lfuDecay(now, 2); counter != 9 {
+		t.Errorf("Expected a decay time of 2 to decay the counter to 9, got %d", counter)
+	}
+	if counter := value.lfuDecay(now, 0); counter != 10 {
+		t.Errorf("Expected no decay, got %d", counter)
+	}
+	value.lfu.Store(uint64(now.Unix()/60-100)<<8 | 10)
+	if counter := value.lfuDecay(now, 1); counter != 0 {
+		t.Errorf("Expected the counter to stop at 0, got %d", counter)
+	}
+}
+
+func TestEvictionPool(t *testing.T) {
+	e := &evictor{}
+	db := NewStorage()
+	for i := range 20 {
+		e.add(evictionCandidate{score: uint64(i * 10), key: "key" + strconv.Itoa(i), db: db})
+	}
+	if len(e.pool) != EVPOOL_SIZE || e.pool[0].score != 40 || e.pool[EVPOOL_SIZE-1].score != 190 {
+		t.Errorf("Expected the pool to keep the 16 highest scores, got %v", e.pool)
+	}
+	e.add(evictionCandidate{score: 1, key: "low", db: db})
+	if e.pool[0].key == "low" {
+		t.Error("Expected a candidate worse than a full pool to be dropped")
+	}
+	e.add(evictionCandidate{score: 1000, key: "key5", db: db})
+	if len(e.pool) != EVPOOL_SIZE || e.pool[EVPOOL_SIZE-1].key != "key5" || e.pool[0].score != 40 {
+		t.Errorf("Expected a sampled key to replace its old entry, got %v", e.pool)
+	}
+}
+
+func TestPerformEvictions(t *testing.T) {
+	value := string(make([]byte, 100))
+	entry := entryMemoryUsage("key0", &Value{Str: value})
+
+	tests := []struct {
+		policy    string
+		expected  error
+		survivors []string // Keys that must not be evicted
+	}{
+		{"noeviction", ErrOOM, nil},
+		{"volatile-lru", ErrOOM, nil},
+		{"volatile-ttl", ErrOOM, nil},
+		{"allkeys-lru", nil, []string{"key2", "key3"}},
+		{"allkeys-lfu", nil, []string{"key2", "key3"}},
+		{"allkeys-random", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			withDatabases(t, 2, func() {
+				// Spread the keys over both databases; key0 and key1 are cold,
+				// key2 and key3 were recently and often used
+				for i := range 4 {
+					db := databases[i%2]
+					key := "key" + strconv.Itoa(i)
+					db.Set(key, value)
+					stored, _ := db.data.Get(key)
+					if i < 2 {
+						stored.accessed.Store(time.Now().Add(-time.Hour).UnixMilli())
+						stored.lfu.Store(uint64(time.Now().Unix()/60)<<8 | 1)
+					} else {
+						stored.lfu.Store(uint64(time.Now().Unix()/60)<<8 | 50)
+					}
+				}
+
+				c := DefaultConfig()
+				c.MaxMemory = 2 * entry
+				c.MaxMemoryPolicy = tt.policy
+				c.MaxMemorySamples = 64
+				withConfig(t, c, func() {
+					evicted := stats.evictedKeys.Load()
+					if err := performEvictions(); err != tt.expected {
+						t.Fatalf("Expected %v, got %v", tt.expected, err)
+					}
+					if tt.expected != nil {
+						return
+					}
+					if datasetMemory() > c.MaxMemory {
+						t.Errorf("Expected the dataset to fit in %d bytes, uses %d", c.MaxMemory, datasetMemory())
+					}
+					if stats.evictedKeys.Load()-evicted != 2 {
+						t.Errorf("Expected 2 evicted keys, got %d", stats.evictedKeys.Load()-evicted)
+					}
+					for _, key := range tt.survivors {
+						if databases[0].Exists(key)+databases[1].Exists(key) != 1 {
+							t.Errorf("Expected %s to survive", key)
+						}
+					}
+				})
+			})
+		})
+	}
+}
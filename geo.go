@@ -0,0 +1,858 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geo indexes are sorted sets scored by 52 bit interleaved geohashes, using
+// the same encoding, search area estimation and distance math as Redis
+// (geohash.c, geohash_helper.c and geo.c).
+const (
+	GEO_STEP_MAX           = 26 // 26*2 = 52 bits
+	GEO_LAT_MIN            = -85.05112878
+	GEO_LAT_MAX            = 85.05112878
+	GEO_LONG_MIN           = -180.0
+	GEO_LONG_MAX           = 180.0
+	EARTH_RADIUS_IN_METERS = 6372797.560856
+	MERCATOR_MAX           = 20037726.37
+
+	geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+var (
+	ErrGeoMemberNotFound = errors.New("ERR could not decode requested zset member")
+	ErrGeoUnit           = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+	ErrSyntax            = errors.New("ERR syntax error")
+	ErrNotFloat          = errors.New("ERR value is not a valid float")
+	ErrNotInteger        = errors.New("ERR value is not an integer or out of range")
+)
+
+type geoHashRange struct {
+	min, max float64
+}
+
+// geoHashBits is a geohash of step*2 interleaved bits
+type geoHashBits struct {
+	bits uint64
+	step uint
+}
+
+type geoHashArea struct {
+	hash      geoHashBits
+	longitude geoHashRange
+	latitude  geoHashRange
+}
+
+var (
+	geoLongRange = geoHashRange{GEO_LONG_MIN, GEO_LONG_MAX}
+	geoLatRange  = geoHashRange{GEO_LAT_MIN, GEO_LAT_MAX}
+)
+
+// interleave64 interleaves the bits of x and y, with x in the even positions
+func interleave64(x, y uint32) uint64 {
+	spread := func(v uint32) uint64 {
+		u := uint64(v)
+		u = (u | u<<16) & 0x0000FFFF0000FFFF
+		u = (u | u<<8) & 0x00FF00FF00FF00FF
+		u = (u | u<<4) & 0x0F0F0F0F0F0F0F0F
+		u = (u | u<<2) & 0x3333333333333333
+		u = (u | u<<1) & 0x5555555555555555
+		return u
+	}
+	return spread(x) | spread(y)<<1
+}
+
+// deinterleave64 reverses interleave64, returning x and y
+func deinterleave64(interleaved uint64) (uint32, uint32) {
+	squash := func(u uint64) uint32 {
+		u &= 0x5555555555555555
+		u = (u | u>>1) & 0x3333333333333333
+		u = (u | u>>2) & 0x0F0F0F0F0F0F0F0F
+		u = (u | u>>4) & 0x00FF00FF00FF00FF
+		u = (u | u>>8) & 0x0000FFFF0000FFFF
+		u = (u | u>>16) & 0x00000000FFFFFFFF
+		return uint32(u)
+	}
+	return squash(interleaved), squash(interleaved >> 1)
+}
+
+// geohashEncode encodes a coordinate with the given ranges and precision,
+// latitude in the even bits and longitude in the odd bits
+func geohashEncode(longRange, latRange geoHashRange, longitude, latitude float64, step uint) (geoHashBits, bool) {
+	if longitude > GEO_LONG_MAX || longitude < GEO_LONG_MIN ||
+		latitude > GEO_LAT_MAX || latitude < GEO_LAT_MIN {
+		return geoHashBits{}, false
+	}
+	if latitude < latRange.min || latitude > latRange.max ||
+		longitude < longRange.min || longitude > longRange.max {
+		return geoHashBits{}, false
+	}
+
+	latOffset := (latitude - latRange.min) / (latRange.max - latRange.min)
+	longOffset := (longitude - longRange.min) / (longRange.max - longRange.min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return geoHashBits{bits: interleave64(uint32(latOffset), uint32(longOffset)), step: step}, true
+}
+
+func geohashEncodeWGS84(longitude, latitude float64, step uint) (geoHashBits, bool) {
+	return geohashEncode(geoLongRange, geoLatRange, longitude, latitude, step)
+}
+
+// geohashDecode returns the area covered by a geohash
+func geohashDecode(longRange, latRange geoHashRange, hash geoHashBits) geoHashArea {
+	ilato, ilono := deinterleave64(hash.bits)
+	latScale := latRange.max - latRange.min
+	longScale := longRange.max - longRange.min
+	cells := float64(uint64(1) << hash.step)
+
+	return geoHashArea{
+		hash: hash,
+		latitude: geoHashRange{
+			min: latRange.min + (float64(ilato)/cells)*latScale,
+			max: latRange.min + ((float64(ilato)+1)/cells)*latScale,
+		},
+		longitude: geoHashRange{
+			min: longRange.min + (float64(ilono)/cells)*longScale,
+			max: longRange.min + ((float64(ilono)+1)/cells)*longScale,
+		},
+	}
+}
+
+// geohashDecodeToLongLat returns the center of the area covered by a geohash
+func geohashDecodeToLongLat(hash geoHashBits) (float64, float64) {
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+	longitude := (area.longitude.min + area.longitude.max) / 2
+	latitude := (area.latitude.min + area.latitude.max) / 2
+	longitude = min(max(longitude, GEO_LONG_MIN), GEO_LONG_MAX)
+	latitude = min(max(latitude, GEO_LAT_MIN), GEO_LAT_MAX)
+	return longitude, latitude
+}
+
+// geohashAlign52Bits left aligns a geohash to 52 bits, the sorted set score
+func geohashAlign52Bits(hash geoHashBits) uint64 {
+	return hash.bits << (52 - hash.step*2)
+}
+
+// geoScoreToLongLat decodes a sorted set score back to a coordinate
+func geoScoreToLongLat(score float64) (float64, float64) {
+	return geohashDecodeToLongLat(geoHashBits{bits: uint64(score), step: GEO_STEP_MAX})
+}
+
+func geohashMoveX(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - hash.step*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= 0xaaaaaaaaaaaaaaaa >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+func geohashMoveY(hash *geoHashBits, d int) {
+	x := hash.bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - hash.step*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= 0x5555555555555555 >> (64 - hash.step*2)
+	hash.bits = x | y
+}
+
+// geohashNeighbors returns the hash followed by its eight neighbors in the
+// order Redis searches them: N, S, E, W, NE, NW, SE, SW
+func geohashNeighbors(hash geoHashBits) [9]geoHashBits {
+	moves := [9][2]int{{0, 0}, {0, 1}, {0, -1}, {1, 0}, {-1, 0}, {1, 1}, {-1, 1}, {1, -1}, {-1, -1}}
+	var neighbors [9]geoHashBits
+	for i, move := range moves {
+		neighbor := hash
+		if move[0] != 0 {
+			geohashMoveX(&neighbor, move[0])
+		}
+		if move[1] != 0 {
+			geohashMoveY(&neighbor, move[1])
+		}
+		neighbors[i] = neighbor
+	}
+	return neighbors
+}
+
+// geohashEstimateStepsByRadius picks the precision whose cells are large
+// enough for the search radius to be covered by a cell and its neighbors
+func geohashEstimateStepsByRadius(rangeMeters, latitude float64) uint {
+	if rangeMeters == 0 {
+		return GEO_STEP_MAX
+	}
+	step := 1
+	for rangeMeters < MERCATOR_MAX {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2 // Make sure range is included in most of the base cases
+
+	// Wider range towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	return uint(min(max(step, 1), GEO_STEP_MAX))
+}
+
+func degRad(deg float64) float64 { return deg * (math.Pi / 180) }
+func radDeg(rad float64) float64 { return rad / (math.Pi / 180) }
+
+func geohashGetLatDistance(lat1, lat2 float64) float64 {
+	return EARTH_RADIUS_IN_METERS * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// geohashGetDistance returns the haversine distance between two coordinates in meters
+func geohashGetDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lon1r := degRad(lon1)
+	lon2r := degRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// Avoid the expensive math when the longitudes are practically the same
+	if v == 0 {
+		return geohashGetLatDistance(lat1, lat2)
+	}
+	lat1r := degRad(lat1)
+	lat2r := degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2 * EARTH_RADIUS_IN_METERS * math.Asin(math.Sqrt(a))
+}
+
+// GeoShape is the search area of a GEOSEARCH query
+type GeoShape struct {
+	Longitude  float64
+	Latitude   float64
+	ByBox      bool
+	Radius     float64 // In the requested unit
+	Width      float64 // In the requested unit
+	Height     float64 // In the requested unit
+	Conversion float64 // Meters per requested unit
+}
+
+// boundingBox returns min longitude, min latitude, max longitude and max latitude
+func (shape *GeoShape) boundingBox() [4]float64 {
+	height := shape.Conversion * shape.Radius
+	width := shape.Conversion * shape.Radius
+	if shape.ByBox {
+		height = shape.Conversion * shape.Height / 2
+		width = shape.Conversion * shape.Width / 2
+	}
+
+	latDelta := radDeg(height / EARTH_RADIUS_IN_METERS)
+	longDeltaTop := radDeg(width / EARTH_RADIUS_IN_METERS / math.Cos(degRad(shape.Latitude+latDelta)))
+	longDeltaBottom := radDeg(width / EARTH_RADIUS_IN_METERS / math.Cos(degRad(shape.Latitude-latDelta)))
+
+	// The northern and southern hemispheres are opposite, so pick different
+	// points as the longitude bounds
+	if shape.Latitude < 0 {
+		return [4]float64{shape.Longitude - longDeltaBottom, shape.Latitude - latDelta,
+			shape.Longitude + longDeltaBottom, shape.Latitude + latDelta}
+	}
+	return [4]float64{shape.Longitude - longDeltaTop, shape.Latitude - latDelta,
+		shape.Longitude + longDeltaTop, shape.Latitude + latDelta}
+}
+
+// searchAreas returns the geohash boxes to scan for the shape. Boxes that
+// can't contain matches are zeroed.
+func (shape *GeoShape) searchAreas() [9]geoHashBits {
+	bounds := shape.boundingBox()
+	minLon, minLat, maxLon, maxLat := bounds[0], bounds[1], bounds[2], bounds[3]
+
+	radiusMeters := shape.Radius
+	if shape.ByBox {
+		radiusMeters = math.Sqrt((shape.Width/2)*(shape.Width/2) + (shape.Height/2)*(shape.Height/2))
+	}
+	radiusMeters *= shape.Conversion
+
+	steps := geohashEstimateStepsByRadius(radiusMeters, shape.Latitude)
+	hash, _ := geohashEncodeWGS84(shape.Longitude, shape.Latitude, steps)
+	neighbors := geohashNeighbors(hash)
+	area := geohashDecode(geoLongRange, geoLatRange, hash)
+
+	// The estimated step may not be small enough when the search area is
+	// close to the edge of the center box
+	north := geohashDecode(geoLongRange, geoLatRange, neighbors[1])
+	south := geohashDecode(geoLongRange, geoLatRange, neighbors[2])
+	east := geohashDecode(geoLongRange, geoLatRange, neighbors[3])
+	west := geohashDecode(geoLongRange, geoLatRange, neighbors[4])
+	decreaseStep := north.latitude.max < maxLat || south.latitude.min > minLat ||
+		east.longitude.max < maxLon || west.longitude.min > minLon
+
+	if steps > 1 && decreaseStep {
+		steps--
+		hash, _ = geohashEncodeWGS84(shape.Longitude, shape.Latitude, steps)
+		neighbors = geohashNeighbors(hash)
+		area = geohashDecode(geoLongRange, geoLatRange, hash)
+	}
+
+	// Exclude the search areas that are useless
+	if steps >= 2 {
+		zero := func(indexes ...int) {
+			for _, i := range indexes {
+				neighbors[i] = geoHashBits{}
+			}
+		}
+		if area.latitude.min < minLat {
+			zero(2, 8, 7) // South, south west, south east
+		}
+		if area.latitude.max > maxLat {
+			zero(1, 5, 6) // North, north east, north west
+		}
+		if area.longitude.min < minLon {
+			zero(4, 8, 6) // West, south west, north west
+		}
+		if area.longitude.max > maxLon {
+			zero(3, 7, 5) // East, south east, north east
+		}
+	}
+	return neighbors
+}
+
+// distanceIfWithin returns the distance in meters from the shape center to
+// the coordinate if it is within the shape
+func (shape *GeoShape) distanceIfWithin(longitude, latitude float64) (float64, bool) {
+	if shape.ByBox {
+		// The latitude distance is cheaper to compute, so check it first
+		if geohashGetLatDistance(latitude, shape.Latitude) > shape.Height*shape.Conversion/2 {
+			return 0, false
+		}
+		if geohashGetDistance(longitude, latitude, shape.Longitude, latitude) > shape.Width*shape.Conversion/2 {
+			return 0, false
+		}
+		return geohashGetDistance(shape.Longitude, shape.Latitude, longitude, latitude), true
+	}
+	distance := geohashGetDistance(shape.Longitude, shape.Latitude, longitude, latitude)
+	if distance > shape.Radius*shape.Conversion {
+		return 0, false
+	}
+	return distance, true
+}
+
+// GeoPoint is a sorted set member matched by a geo search
+type GeoPoint struct {
+	Member    string
+	Score     float64
+	Longitude float64
+	Latitude  float64
+	Distance  float64 // In meters
+}
+
+const (
+	GeoSortNone = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+// GeoSearchQuery holds the parsed options of GEOSEARCH and GEOSEARCHSTORE
+type GeoSearchQuery struct {
+	UseMember  bool // Search around FromMember rather than the FROMLONLAT position
+	FromMember string
+	Shape      GeoShape
+	Sort       int
+	Count      int64
+	Any        bool
+	WithCoord  bool
+	WithDist   bool
+	WithHash   bool
+	StoreDist  bool
+}
+
+// geoUnitConversion returns the number of meters in a distance unit
+func geoUnitConversion(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	default:
+		return 0, ErrGeoUnit
+	}
+}
+
+func parseFloatArg(arg string, message string) (float64, error) {
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(value) {
+		if message != "" {
+			return 0, errors.New("ERR " + message)
+		}
+		return 0, ErrNotFloat
+	}
+	return value, nil
+}
+
+// parseLongLat parses and validates a longitude, latitude pair
+func parseLongLat(longitudeArg, latitudeArg string) (float64, float64, error) {
+	longitude, err := parseFloatArg(longitudeArg, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	latitude, err := parseFloatArg(latitudeArg, "")
+	if err != nil {
+		return 0, 0, err
+	}
+	if longitude < GEO_LONG_MIN || longitude > GEO_LONG_MAX ||
+		latitude < GEO_LAT_MIN || latitude > GEO_LAT_MAX {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude)
+	}
+	return longitude, latitude, nil
+}
+
+// ParseGeoSearch parses the options of GEOSEARCH, or GEOSEARCHSTORE if store is set
+func ParseGeoSearch(command string, args []string, store bool) (*GeoSearchQuery, error) {
+	query := &GeoSearchQuery{}
+	fromMember, fromLonLat, byRadius, byBox := false, false, false, false
+	hasCount := false
+
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(args[i])
+		remaining := len(args) - i - 1
+		switch {
+		case arg == "FROMMEMBER" && remaining >= 1:
+			if fromLonLat {
+				return nil, ErrSyntax
+			}
+			query.FromMember = args[i+1]
+			query.UseMember = true
+			fromMember = true
+			i++
+		case arg == "FROMLONLAT" && remaining >= 2:
+			if fromMember {
+				return nil, ErrSyntax
+			}
+			longitude, latitude, err := parseLongLat(args[i+1], args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			query.Shape.Longitude, query.Shape.Latitude = longitude, latitude
+			fromLonLat = true
+			i += 2
+		case arg == "BYRADIUS" && remaining >= 2:
+			if byBox {
+				return nil, ErrSyntax
+			}
+			radius, err := parseFloatArg(args[i+1], "need numeric radius")
+			if err != nil {
+				return nil, err
+			}
+			if radius < 0 {
+				return nil, errors.New("ERR radius cannot be negative")
+			}
+			if query.Shape.Conversion, err = geoUnitConversion(args[i+2]); err != nil {
+				return nil, err
+			}
+			query.Shape.Radius = radius
+			byRadius = true
+			i += 2
+		case arg == "BYBOX" && remaining >= 3:
+			if byRadius {
+				return nil, ErrSyntax
+			}
+			width, err := parseFloatArg(args[i+1], "need numeric width")
+			if err != nil {
+				return nil, err
+			}
+			height, err := parseFloatArg(args[i+2], "need numeric height")
+			if err != nil {
+				return nil, err
+			}
+			if width < 0 || height < 0 {
+				return nil, errors.New("ERR height or width cannot be negative")
+			}
+			if query.Shape.Conversion, err = geoUnitConversion(args[i+3]); err != nil {
+				return nil, err
+			}
+			query.Shape.ByBox = true
+			query.Shape.Width, query.Shape.Height = width, height
+			byBox = true
+			i += 3
+		case arg == "ASC":
+			query.Sort = GeoSortAsc
+		case arg == "DESC":
+			query.Sort = GeoSortDesc
+		case arg == "COUNT" && remaining >= 1:
+			count, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+			if count <= 0 {
+				return nil, errors.New("ERR COUNT must be > 0")
+			}
+			query.Count = count
+			hasCount = true
+			i++
+		case arg == "ANY":
+			query.Any = true
+		case arg == "WITHCOORD" && !store:
+			query.WithCoord = true
+		case arg == "WITHDIST" && !store:
+			query.WithDist = true
+		case arg == "WITHHASH" && !store:
+			query.WithHash = true
+		case arg == "STOREDIST" && store:
+			query.StoreDist = true
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	if !fromMember && !fromLonLat {
+		return nil, fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", command)
+	}
+	if !byRadius && !byBox {
+		return nil, fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", command)
+	}
+	if query.Any && !hasCount {
+		return nil, errors.New("ERR the ANY argument requires COUNT argument")
+	}
+
+	// COUNT without ANY returns the closest matches, which requires sorting
+	if hasCount && !query.Any && query.Sort == GeoSortNone {
+		query.Sort = GeoSortAsc
+	}
+	return query, nil
+}
+
+// search collects the members of zset within the query shape
+func (query *GeoSearchQuery) search(zset *SortedSet) []GeoPoint {
+	var limit int64
+	if query.Any {
+		limit = query.Count
+	}
+
+	var points []GeoPoint
+	areas := query.Shape.searchAreas()
+	for i, area := range areas {
+		if area.bits == 0 && area.step == 0 {
+			continue
+		}
+		if limit > 0 && int64(len(points)) >= limit {
+			break
+		}
+		// With a very small step neighbors may repeat, only scan each box once
+		duplicate := false
+		for _, previous := range areas[:i] {
+			if previous == area {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+
+		min := float64(geohashAlign52Bits(area))
+		max := float64(geohashAlign52Bits(geoHashBits{bits: area.bits + 1, step: area.step}))
+		zset.RangeByScore(min, max, true, func(member string, score float64) bool {
+			if limit > 0 && int64(len(points)) >= limit {
+				return false
+			}
+			longitude, latitude := geoScoreToLongLat(score)
+			if distance, ok := query.Shape.distanceIfWithin(longitude, latitude); ok {
+				points = append(points, GeoPoint{
+					Member:    member,
+					Score:     score,
+					Longitude: longitude,
+					Latitude:  latitude,
+					Distance:  distance,
+				})
+			}
+			return true
+		})
+	}
+
+	switch query.Sort {
+	case GeoSortAsc:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Distance < points[j].Distance })
+	case GeoSortDesc:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Distance > points[j].Distance })
+	}
+	if query.Count > 0 && int64(len(points)) > query.Count {
+		points = points[:query.Count]
+	}
+	return points
+}
+
+// GeoHashString returns the standard 11 character base32 geohash of a score.
+// Unlike the score it uses the full [-90, 90] latitude range.
+func GeoHashString(score float64) string {
+	longitude, latitude := geoScoreToLongLat(score)
+	hash, _ := geohashEncode(geoHashRange{-180, 180}, geoHashRange{-90, 90}, longitude, latitude, GEO_STEP_MAX)
+
+	var buf [11]byte
+	for i := range buf {
+		index := 0
+		if i < 10 {
+			index = int((hash.bits >> (52 - (i+1)*5)) & 0x1f)
+		}
+		buf[i] = geoAlphabet[index]
+	}
+	return string(buf[:])
+}
+
+// FormatGeoCoordinate formats a coordinate like Redis replies with it
+func FormatGeoCoordinate(coordinate float64) string {
+	formatted := strconv.FormatFloat(coordinate, 'f', 17, 64)
+	formatted = strings.TrimRight(formatted, "0")
+	return strings.TrimSuffix(formatted, ".")
+}
+
+// FormatGeoDistance formats a distance like Redis replies with it
+func FormatGeoDistance(distance float64) string {
+	return strconv.FormatFloat(distance, 'f', 4, 64)
+}
+
+// GeoAdd adds or updates members with their coordinates, returning the number
+// of added members, or of added and updated members if ch is set
+func (s *Storage) GeoAdd(key string, nx, xx, ch bool, longitudes, latitudes []float64, members []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	zset, err := s.sortedSetValue(key)
+	if err != nil {
+		return 0, err
+	}
+	if zset == nil {
+		if xx {
+			return 0, nil
+		}
+		zset = NewSortedSet()
+		s.setValue(key, &Value{Type: SortedSetValue, ZSet: zset})
+	}
+	before := zset.memoryUsage()
+
+	var count int64
+	for i, member := range members {
+		hash, _ := geohashEncodeWGS84(longitudes[i], latitudes[i], GEO_STEP_MAX)
+		score := float64(geohashAlign52Bits(hash))
+
+		current, exists := zset.Score(member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		zset.Add(member, score)
+		if !exists || (ch && current != score) {
+			count++
+		}
+	}
+
+	s.used.Add(zset.memoryUsage() - before)
+	if zset.Len() == 0 {
+		s.deleteKey(key)
+	}
+	return count, nil
+}
+
+// GeoPos returns the coordinates of members. Missing members have a nil entry.
+func (s *Storage) GeoPos(key string, members ...string) ([]*[2]float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zset, err := s.sortedSetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	positions := make([]*[2]float64, len(members))
+	for i, member := range members {
+		if zset == nil {
+			continue
+		}
+		if score, exists := zset.Score(member); exists {
+			longitude, latitude := geoScoreToLongLat(score)
+			positions[i] = &[2]float64{longitude, latitude}
+		}
+	}
+	return positions, nil
+}
+
+// GeoDist returns the distance in meters between two members
+func (s *Storage) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zset, err := s.sortedSetValue(key)
+	if err != nil || zset == nil {
+		return 0, false, err
+	}
+	score1, exists1 := zset.Score(member1)
+	score2, exists2 := zset.Score(member2)
+	if !exists1 || !exists2 {
+		return 0, false, nil
+	}
+	lon1, lat1 := geoScoreToLongLat(score1)
+	lon2, lat2 := geoScoreToLongLat(score2)
+	return geohashGetDistance(lon1, lat1, lon2, lat2), true, nil
+}
+
+// GeoHash returns the geohash strings of members. Missing members have an empty string.
+func (s *Storage) GeoHash(key string, members ...string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zset, err := s.sortedSetValue(key)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(members))
+	for i, member := range members {
+		if zset == nil {
+			continue
+		}
+		if score, exists := zset.Score(member); exists {
+			hashes[i] = GeoHashString(score)
+		}
+	}
+	return hashes, nil
+}
+
+// geoSearch runs a query against the sorted set at key. The caller must hold the lock.
+func (s *Storage) geoSearch(key string, query *GeoSearchQuery) ([]GeoPoint, error) {
+	zset, err := s.sortedSetValue(key)
+	if err != nil || zset == nil {
+		return nil, err
+	}
+	if query.UseMember {
+		score, exists := zset.Score(query.FromMember)
+		if !exists {
+			return nil, ErrGeoMemberNotFound
+		}
+		query.Shape.Longitude, query.Shape.Latitude = geoScoreToLongLat(score)
+	}
+	return query.search(zset), nil
+}
+
+// GeoSearch returns the members of the geo index at key within the query shape
+func (s *Storage) GeoSearch(key string, query *GeoSearchQuery) ([]GeoPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.geoSearch(key, query)
+}
+
+// GeoSearchStore stores the matches of a query in destination as a geo index,
+// or scored by distance if STOREDIST is set, and returns the number of matches
+func (s *Storage) GeoSearchStore(destination, source string, query *GeoSearchQuery) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	points, err := s.geoSearch(source, query)
+	if err != nil {
+		return 0, err
+	}
+	if len(points) == 0 {
+		s.deleteKey(destination)
+		return 0, nil
+	}
+
+	zset := NewSortedSet()
+	for _, point := range points {
+		score := point.Score
+		if query.StoreDist {
+			score = point.Distance / query.Shape.Conversion
+		}
+		zset.Add(point.Member, score)
+	}
+	s.setValue(destination, &Value{Type: SortedSetValue, ZSet: zset})
+	return int64(len(points)), nil
+}
+
+// GeoSearchReply builds the GEOSEARCH reply, with the extra fields requested
+func GeoSearchReply(points []GeoPoint, query *GeoSearchQuery) *RESPValue {
+	reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+	for _, point := range points {
+		name := RESPValue{Type: BulkString, Str: point.Member}
+		if !query.WithDist && !query.WithHash && !query.WithCoord {
+			reply.Array = append(reply.Array, name)
+			continue
+		}
+
+		item := RESPValue{Type: Array, Array: []RESPValue{name}}
+		if query.WithDist {
+			item.Array = append(item.Array, RESPValue{
+				Type: BulkString,
+				Str:  FormatGeoDistance(point.Distance / query.Shape.Conversion),
+			})
+		}
+		if query.WithHash {
+			item.Array = append(item.Array, RESPValue{Type: Integer, Int: int64(point.Score)})
+		}
+		if query.WithCoord {
+			item.Array = append(item.Array, RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: FormatGeoCoordinate(point.Longitude)},
+				{Type: BulkString, Str: FormatGeoCoordinate(point.Latitude)},
+			}})
+		}
+		reply.Array = append(reply.Array, item)
+	}
+	return reply
+}
+
+// handleGeoAdd parses GEOADD key [NX|XX] [CH] longitude latitude member [...]
+func handleGeoAdd(storage *Storage, args []RESPValue) *RESPValue {
+	nx, xx, ch := false, false, false
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Str) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+
+	remaining := args[i:]
+	if len(remaining) == 0 || len(remaining)%3 != 0 {
+		return &RESPValue{
+			Type: Error,
+			Str:  "ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ",
+		}
+	}
+	if nx && xx {
+		return &RESPValue{Type: Error, Str: "ERR XX and NX options at the same time are not compatible"}
+	}
+
+	count := len(remaining) / 3
+	longitudes := make([]float64, count)
+	latitudes := make([]float64, count)
+	members := make([]string, count)
+	for j := 0; j < count; j++ {
+		longitude, latitude, err := parseLongLat(remaining[j*3].Str, remaining[j*3+1].Str)
+		if err != nil {
+			return &RESPValue{Type: Error, Str: err.Error()}
+		}
+		longitudes[j], latitudes[j], members[j] = longitude, latitude, remaining[j*3+2].Str
+	}
+
+	added, err := storage.GeoAdd(args[0].Str, nx, xx, ch, longitudes, latitudes, members)
+	if err != nil {
+		return &RESPValue{Type: Error, Str: err.Error()}
+	}
+	return &RESPValue{Type: Integer, Int: added}
+}
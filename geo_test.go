@@ -0,0 +1,231 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newSicily(t *testing.T) *Storage {
+	t.Helper()
+	s := NewStorage()
+	added, err := s.GeoAdd("Sicily", false, false, false,
+		[]float64{13.361389, 15.087269, 12.758489, 17.241510},
+		[]float64{38.115556, 37.502669, 38.788135, 38.788135},
+		[]string{"Palermo", "Catania", "edge1", "edge2"})
+	if err != nil || added != 4 {
+		t.Fatalf("Expected 4 members added, got %d, %v", added, err)
+	}
+	return s
+}
+
+func TestGeoAdd(t *testing.T) {
+	s := newSicily(t)
+
+	score, _ := lookupValue(s, "Sicily").ZSet.Score("Palermo")
+	if int64(score) != 3479099956230698 {
+		t.Errorf("Expected score 3479099956230698, got %d", int64(score))
+	}
+
+	// NX doesn't update existing members, XX doesn't add new ones
+	added, _ := s.GeoAdd("Sicily", true, false, false, []float64{0}, []float64{0}, []string{"Palermo"})
+	if added != 0 {
+		t.Errorf("Expected 0 added with NX, got %d", added)
+	}
+	added, _ = s.GeoAdd("Sicily", false, true, false, []float64{0}, []float64{0}, []string{"Rome"})
+	if added != 0 {
+		t.Errorf("Expected 0 added with XX, got %d", added)
+	}
+
+	// CH counts updated members
+	changed, _ := s.GeoAdd("Sicily", false, true, true, []float64{13.5}, []float64{38.1}, []string{"Palermo"})
+	if changed != 1 {
+		t.Errorf("Expected 1 changed with CH, got %d", changed)
+	}
+
+	s.Set("string", "value")
+	if _, err := s.GeoAdd("string", false, false, false, []float64{0}, []float64{0}, []string{"a"}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestGeoPosAndHash(t *testing.T) {
+	s := newSicily(t)
+
+	positions, err := s.GeoPos("Sicily", "Palermo", "Catania", "missing")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := [][2]string{
+		{"13.36138933897018433", "38.11555639549629859"},
+		{"15.08726745843887329", "37.50266842333162032"},
+	}
+	for i, want := range expected {
+		got := [2]string{FormatGeoCoordinate(positions[i][0]), FormatGeoCoordinate(positions[i][1])}
+		if got != want {
+			t.Errorf("Expected position %v, got %v", want, got)
+		}
+	}
+	if positions[2] != nil {
+		t.Error("Expected nil position for a missing member")
+	}
+
+	hashes, _ := s.GeoHash("Sicily", "Palermo", "Catania", "missing")
+	if !reflect.DeepEqual(hashes, []string{"sqc8b49rny0", "sqdtr74hyu0", ""}) {
+		t.Errorf("Unexpected geohashes %v", hashes)
+	}
+}
+
+func TestGeoDist(t *testing.T) {
+	s := newSicily(t)
+
+	distance, exists, err := s.GeoDist("Sicily", "Palermo", "Catania")
+	if err != nil || !exists {
+		t.Fatalf("Expected a distance, got %v, %v", exists, err)
+	}
+	tests := []struct {
+		unit     string
+		expected string
+	}{
+		{"m", "166274.1516"},
+		{"km", "166.2742"},
+		{"mi", "103.3182"},
+	}
+	for _, tt := range tests {
+		conversion, _ := geoUnitConversion(tt.unit)
+		if got := FormatGeoDistance(distance / conversion); got != tt.expected {
+			t.Errorf("Expected %s %s, got %s", tt.expected, tt.unit, got)
+		}
+	}
+
+	_, exists, _ = s.GeoDist("Sicily", "Palermo", "missing")
+	if exists {
+		t.Error("Expected no distance for a missing member")
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	s := newSicily(t)
+
+	parse := func(args ...string) *GeoSearchQuery {
+		t.Helper()
+		query, err := ParseGeoSearch("GEOSEARCH", args, false)
+		if err != nil {
+			t.Fatalf("Unexpected parse error: %v", err)
+		}
+		return query
+	}
+	members := func(points []GeoPoint) []string {
+		names := []string{}
+		for _, point := range points {
+			names = append(names, point.Member)
+		}
+		return names
+	}
+
+	points, _ := s.GeoSearch("Sicily", parse("FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"))
+	if !reflect.DeepEqual(members(points), []string{"Catania", "Palermo"}) {
+		t.Errorf("Unexpected radius search result %v", members(points))
+	}
+
+	query := parse("FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHCOORD", "WITHDIST")
+	points, _ = s.GeoSearch("Sicily", query)
+	expected := &RESPValue{Type: Array, Array: []RESPValue{}}
+	for _, item := range [][4]string{
+		{"Catania", "56.4413", "15.08726745843887329", "37.50266842333162032"},
+		{"Palermo", "190.4424", "13.36138933897018433", "38.11555639549629859"},
+		{"edge2", "279.7403", "17.24151045083999634", "38.78813451624225195"},
+		{"edge1", "279.7405", "12.7584877610206604", "38.78813451624225195"},
+	} {
+		expected.Array = append(expected.Array, RESPValue{Type: Array, Array: []RESPValue{
+			{Type: BulkString, Str: item[0]},
+			{Type: BulkString, Str: item[1]},
+			{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: item[2]},
+				{Type: BulkString, Str: item[3]},
+			}},
+		}})
+	}
+	if got := GeoSearchReply(points, query); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Unexpected box search reply %v", got)
+	}
+
+	points, _ = s.GeoSearch("Sicily", parse("FROMMEMBER", "Palermo", "BYRADIUS", "500", "km", "DESC", "COUNT", "2"))
+	if !reflect.DeepEqual(members(points), []string{"edge2", "Catania"}) {
+		t.Errorf("Unexpected COUNT search result %v", members(points))
+	}
+
+	points, _ = s.GeoSearch("Sicily", parse("FROMMEMBER", "Palermo", "BYRADIUS", "500", "km", "COUNT", "1", "ANY"))
+	if len(points) != 1 {
+		t.Errorf("Expected 1 result with COUNT ANY, got %d", len(points))
+	}
+
+	if _, err := s.GeoSearch("Sicily", parse("FROMMEMBER", "missing", "BYRADIUS", "1", "km")); err != ErrGeoMemberNotFound {
+		t.Errorf("Expected ErrGeoMemberNotFound, got %v", err)
+	}
+	if _, err := s.GeoSearch("Sicily", parse("FROMMEMBER", "", "BYRADIUS", "5000", "km")); err != ErrGeoMemberNotFound {
+		t.Errorf("Expected ErrGeoMemberNotFound for an empty member name, got %v", err)
+	}
+
+	points, err := s.GeoSearch("missing", parse("FROMLONLAT", "15", "37", "BYRADIUS", "200", "km"))
+	if err != nil || len(points) != 0 {
+		t.Errorf("Expected no results for a missing key, got %v, %v", points, err)
+	}
+}
+
+func TestGeoSearchStore(t *testing.T) {
+	s := newSicily(t)
+
+	query, _ := ParseGeoSearch("GEOSEARCHSTORE", []string{"FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "STOREDIST"}, true)
+	stored, err := s.GeoSearchStore("distances", "Sicily", query)
+	if err != nil || stored != 2 {
+		t.Fatalf("Expected 2 stored members, got %d, %v", stored, err)
+	}
+	distance, _ := lookupValue(s, "distances").ZSet.Score("Catania")
+	if FormatGeoDistance(distance) != "56.4413" {
+		t.Errorf("Expected distance 56.4413 as score, got %f", distance)
+	}
+
+	// An empty result removes the destination
+	query, _ = ParseGeoSearch("GEOSEARCHSTORE", []string{"FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"}, true)
+	stored, _ = s.GeoSearchStore("distances", "Sicily", query)
+	if stored != 0 || s.Exists("distances") != 0 {
+		t.Error("Expected the destination to be deleted")
+	}
+}
+
+func TestParseGeoSearchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		store    bool
+		expected string
+	}{
+		{"missing FROM", []string{"BYRADIUS", "1", "km"}, false,
+			"ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH"},
+		{"missing BY", []string{"FROMMEMBER", "a"}, false,
+			"ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"},
+		{"both FROM", []string{"FROMMEMBER", "a", "FROMLONLAT", "1", "2", "BYRADIUS", "1", "km"}, false,
+			"ERR syntax error"},
+		{"ANY without COUNT", []string{"FROMMEMBER", "a", "BYRADIUS", "1", "km", "ANY"}, false,
+			"ERR the ANY argument requires COUNT argument"},
+		{"zero COUNT", []string{"FROMMEMBER", "a", "BYRADIUS", "1", "km", "COUNT", "0"}, false,
+			"ERR COUNT must be > 0"},
+		{"bad unit", []string{"FROMMEMBER", "a", "BYRADIUS", "1", "yd"}, false,
+			"ERR unsupported unit provided. please use M, KM, FT, MI"},
+		{"negative radius", []string{"FROMMEMBER", "a", "BYRADIUS", "-1", "km"}, false,
+			"ERR radius cannot be negative"},
+		{"invalid coordinates", []string{"FROMLONLAT", "200", "0", "BYRADIUS", "1", "km"}, false,
+			"ERR invalid longitude,latitude pair 200.000000,0.000000"},
+		{"WITHDIST when storing", []string{"FROMMEMBER", "a", "BYRADIUS", "1", "km", "WITHDIST"}, true,
+			"ERR syntax error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseGeoSearch("GEOSEARCH", tt.args, tt.store)
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Expected error %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
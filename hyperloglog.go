@@ -0,0 +1,672 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The HyperLogLog implementation mirrors the one in Redis (hyperloglog.c) byte
+// for byte: values are plain strings holding a 16 byte header followed by either
+// the sparse or the dense register representation, so blobs can be moved
+// between redis-lite and Redis with GET/SET or DUMP/RESTORE.
+const (
+	HLL_P              = 14         // Number of index bits
+	HLL_Q              = 64 - HLL_P // Number of bits used for the run length
+	HLL_REGISTERS      = 1 << HLL_P // 16384 registers
+	HLL_P_MASK         = HLL_REGISTERS - 1
+	HLL_BITS           = 6 // Bits per dense register
+	HLL_REGISTER_MAX   = (1 << HLL_BITS) - 1
+	HLL_HDR_SIZE       = 16
+	HLL_DENSE_SIZE     = HLL_HDR_SIZE + (HLL_REGISTERS*HLL_BITS+7)/8
+	HLL_DENSE          = 0 // Dense encoding
+	HLL_SPARSE         = 1 // Sparse encoding
+	HLL_MAX_ENCODING   = 1
+	HLL_SPARSE_MAX_LEN = 3000 // Same as the hll-sparse-max-bytes default
+	HLL_ALPHA_INF      = 0.721347520444481703680
+
+	// Sparse representation opcodes
+	HLL_SPARSE_XZERO_BIT             = 0x40
+	HLL_SPARSE_VAL_BIT               = 0x80
+	HLL_SPARSE_VAL_MAX_VALUE         = 32
+	HLL_SPARSE_VAL_MAX_LEN           = 4
+	HLL_SPARSE_ZERO_MAX_LEN          = 64
+	HLL_SPARSE_XZERO_MAX_LEN         = 16384
+	hllMagic                         = "HYLL"
+	hllCardinalityInvalidFlag        = 1 << 7
+	murmurHashSeed            uint32 = 0xadc83b19
+)
+
+var (
+	ErrNotHLL     = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrInvalidHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// MurmurHash64A is the 64 bit MurmurHash2 variant Redis uses to hash HLL
+// elements. Blocks are always read as little endian, like Redis does on every
+// platform, so the hashes are portable.
+func MurmurHash64A(key []byte, seed uint32) uint64 {
+	const m uint64 = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := uint64(seed) ^ (uint64(len(key)) * m)
+
+	data := key
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+
+	// Mix in the remaining bytes, falling through like the C switch
+	switch len(data) {
+	case 7:
+		h ^= uint64(data[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(data[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(data[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(data[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(data[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(data[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(data[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hllPatLen returns the register index for an element and the length of the
+// 000..1 pattern in the remaining hash bits, which is the register candidate value
+func hllPatLen(element []byte) (int, uint8) {
+	hash := MurmurHash64A(element, murmurHashSeed)
+	index := int(hash & HLL_P_MASK)
+	hash >>= HLL_P
+	hash |= 1 << HLL_Q // Make sure the loop terminates
+
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// newHLL returns an empty sparse HyperLogLog covering all registers with XZERO opcodes
+func newHLL() []byte {
+	hll := make([]byte, HLL_HDR_SIZE, HLL_HDR_SIZE+2)
+	copy(hll, hllMagic)
+	hll[4] = HLL_SPARSE
+	for remaining := HLL_REGISTERS; remaining > 0; {
+		n := min(remaining, HLL_SPARSE_XZERO_MAX_LEN)
+		hll = append(hll, hllSparseXZero(n)...)
+		remaining -= n
+	}
+	return hll
+}
+
+// isHLL checks that a string value holds a well formed HyperLogLog header
+func isHLL(value []byte) bool {
+	if len(value) < HLL_HDR_SIZE || string(value[:4]) != hllMagic {
+		return false
+	}
+	if value[4] > HLL_MAX_ENCODING {
+		return false
+	}
+	if value[4] == HLL_DENSE && len(value) != HLL_DENSE_SIZE {
+		return false
+	}
+	return true
+}
+
+func hllInvalidateCache(hll []byte) {
+	hll[15] |= hllCardinalityInvalidFlag
+}
+
+func hllValidCache(hll []byte) bool {
+	return hll[15]&hllCardinalityInvalidFlag == 0
+}
+
+// Dense registers are 6 bit values packed starting from the least significant bit
+
+func hllDenseGetRegister(registers []byte, index int) uint8 {
+	byteIndex := index * HLL_BITS / 8
+	fb := uint(index * HLL_BITS & 7)
+	value := uint(registers[byteIndex]) >> fb
+	if byteIndex+1 < len(registers) {
+		value |= uint(registers[byteIndex+1]) << (8 - fb)
+	}
+	return uint8(value & HLL_REGISTER_MAX)
+}
+
+func hllDenseSetRegister(registers []byte, index int, value uint8) {
+	byteIndex := index * HLL_BITS / 8
+	fb := uint(index * HLL_BITS & 7)
+	v := uint(value)
+	registers[byteIndex] &^= byte(HLL_REGISTER_MAX << fb)
+	registers[byteIndex] |= byte(v << fb)
+	if byteIndex+1 < len(registers) {
+		registers[byteIndex+1] &^= byte(HLL_REGISTER_MAX >> (8 - fb))
+		registers[byteIndex+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// hllDenseSet updates a register if the new value is greater and reports whether it changed
+func hllDenseSet(registers []byte, index int, count uint8) bool {
+	if count > hllDenseGetRegister(registers, index) {
+		hllDenseSetRegister(registers, index, count)
+		return true
+	}
+	return false
+}
+
+// Sparse opcodes:
+//   ZERO:  00xxxxxx           - run of 1-64 zero registers
+//   XZERO: 01xxxxxx yyyyyyyy  - run of 1-16384 zero registers
+//   VAL:   1vvvvvxx           - run of 1-4 registers set to value 1-32
+
+func hllSparseIsZero(op byte) bool  { return op&0xc0 == 0 }
+func hllSparseIsXZero(op byte) bool { return op&0xc0 == HLL_SPARSE_XZERO_BIT }
+func hllSparseIsVal(op byte) bool   { return op&HLL_SPARSE_VAL_BIT != 0 }
+func hllSparseZeroLen(op byte) int  { return int(op&0x3f) + 1 }
+func hllSparseXZeroLen(op, next byte) int {
+	return (int(op&0x3f)<<8 | int(next)) + 1
+}
+func hllSparseValValue(op byte) uint8 { return (op>>2)&0x1f + 1 }
+func hllSparseValLen(op byte) int     { return int(op&0x3) + 1 }
+
+func hllSparseZero(length int) byte { return byte(length - 1) }
+func hllSparseXZero(length int) []byte {
+	l := length - 1
+	return []byte{byte(l>>8) | HLL_SPARSE_XZERO_BIT, byte(l & 0xff)}
+}
+func hllSparseVal(value uint8, length int) byte {
+	return byte(int(value-1)<<2|(length-1)) | HLL_SPARSE_VAL_BIT
+}
+
+// hllSparseRuns walks the opcodes of a sparse representation calling fn with
+// the first register index, run length and register value of each opcode.
+// It returns false if the opcodes are truncated or don't cover every register.
+func hllSparseRuns(sparse []byte, fn func(first, length int, value uint8)) bool {
+	index := 0
+	for p := 0; p < len(sparse); {
+		op := sparse[p]
+		var length int
+		var value uint8
+		switch {
+		case hllSparseIsZero(op):
+			length = hllSparseZeroLen(op)
+			p++
+		case hllSparseIsXZero(op):
+			if p+1 >= len(sparse) {
+				return false
+			}
+			length = hllSparseXZeroLen(op, sparse[p+1])
+			p += 2
+		default:
+			length, value = hllSparseValLen(op), hllSparseValValue(op)
+			p++
+		}
+		if index+length > HLL_REGISTERS {
+			return false
+		}
+		fn(index, length, value)
+		index += length
+	}
+	return index == HLL_REGISTERS
+}
+
+// hllSparseToDense converts a sparse HyperLogLog to the dense representation
+func hllSparseToDense(hll []byte) ([]byte, error) {
+	if hll[4] == HLL_DENSE {
+		return hll, nil
+	}
+	dense := make([]byte, HLL_DENSE_SIZE)
+	copy(dense, hll[:HLL_HDR_SIZE])
+	dense[4] = HLL_DENSE
+	registers := dense[HLL_HDR_SIZE:]
+	ok := hllSparseRuns(hll[HLL_HDR_SIZE:], func(first, length int, value uint8) {
+		if value == 0 {
+			return
+		}
+		for i := first; i < first+length; i++ {
+			hllDenseSetRegister(registers, i, value)
+		}
+	})
+	if !ok {
+		return nil, ErrInvalidHLL
+	}
+	return dense, nil
+}
+
+// hllSparseSet sets register index to count if count is greater than the
+// current value, following the exact opcode splitting and merging of Redis so
+// the resulting bytes are identical. It returns the possibly reallocated or
+// promoted HyperLogLog and whether the register was updated.
+func hllSparseSet(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	// Values too big for a VAL opcode need the dense representation
+	if count > HLL_SPARSE_VAL_MAX_VALUE {
+		return hllPromoteAndSet(hll, index, count)
+	}
+
+	// Step 1: locate the opcode covering the register
+	sparse := hll[HLL_HDR_SIZE:]
+	end := len(sparse)
+	p, first, span := 0, 0, 0
+	prev := -1
+	for p < end {
+		oplen := 1
+		switch {
+		case hllSparseIsZero(sparse[p]):
+			span = hllSparseZeroLen(sparse[p])
+		case hllSparseIsVal(sparse[p]):
+			span = hllSparseValLen(sparse[p])
+		default:
+			if p+1 >= end {
+				return nil, false, ErrInvalidHLL
+			}
+			span = hllSparseXZeroLen(sparse[p], sparse[p+1])
+			oplen = 2
+		}
+		if index <= first+span-1 {
+			break
+		}
+		prev = p
+		p += oplen
+		first += span
+	}
+	if span == 0 || p >= end {
+		return nil, false, ErrInvalidHLL
+	}
+
+	op := sparse[p]
+	isZero, isXZero, isVal := hllSparseIsZero(op), hllSparseIsXZero(op), hllSparseIsVal(op)
+
+	// Step 2: handle the trivial in place updates
+	if isVal {
+		if hllSparseValValue(op) >= count {
+			return hll, false, nil
+		}
+		if hllSparseValLen(op) == 1 {
+			sparse[p] = hllSparseVal(count, 1)
+			return hllSparseMerge(hll, prev), true, nil
+		}
+	}
+	if isZero && hllSparseZeroLen(op) == 1 {
+		sparse[p] = hllSparseVal(count, 1)
+		return hllSparseMerge(hll, prev), true, nil
+	}
+
+	// General case: split the opcode in up to three new ones
+	last := first + span - 1
+	seq := make([]byte, 0, 5)
+	if isZero || isXZero {
+		if index != first {
+			seq = appendSparseZeroRun(seq, index-first)
+		}
+		seq = append(seq, hllSparseVal(count, 1))
+		if index != last {
+			seq = appendSparseZeroRun(seq, last-index)
+		}
+	} else {
+		current := hllSparseValValue(op)
+		if index != first {
+			seq = append(seq, hllSparseVal(current, index-first))
+		}
+		seq = append(seq, hllSparseVal(count, 1))
+		if index != last {
+			seq = append(seq, hllSparseVal(current, last-index))
+		}
+	}
+
+	// Step 3: substitute the old opcode with the new sequence
+	oldlen := 1
+	if isXZero {
+		oldlen = 2
+	}
+	if len(seq)-oldlen > 0 && len(hll)+len(seq)-oldlen > HLL_SPARSE_MAX_LEN {
+		return hllPromoteAndSet(hll, index, count)
+	}
+	updated := make([]byte, 0, len(hll)+len(seq)-oldlen)
+	updated = append(updated, hll[:HLL_HDR_SIZE+p]...)
+	updated = append(updated, seq...)
+	updated = append(updated, hll[HLL_HDR_SIZE+p+oldlen:]...)
+
+	return hllSparseMerge(updated, prev), true, nil
+}
+
+func appendSparseZeroRun(seq []byte, length int) []byte {
+	if length > HLL_SPARSE_ZERO_MAX_LEN {
+		return append(seq, hllSparseXZero(length)...)
+	}
+	return append(seq, hllSparseZero(length))
+}
+
+// hllSparseMerge merges adjacent VAL opcodes with the same value, scanning up
+// to five opcodes starting from the one preceding the updated register
+func hllSparseMerge(hll []byte, prev int) []byte {
+	sparse := hll[HLL_HDR_SIZE:]
+	end := len(sparse)
+	p := max(prev, 0)
+	for scan := 5; p < end && scan > 0; scan-- {
+		if hllSparseIsXZero(sparse[p]) {
+			p += 2
+			continue
+		} else if hllSparseIsZero(sparse[p]) {
+			p++
+			continue
+		}
+		if p+1 < end && hllSparseIsVal(sparse[p+1]) {
+			v1 := hllSparseValValue(sparse[p])
+			v2 := hllSparseValValue(sparse[p+1])
+			if v1 == v2 {
+				length := hllSparseValLen(sparse[p]) + hllSparseValLen(sparse[p+1])
+				if length <= HLL_SPARSE_VAL_MAX_LEN {
+					sparse[p+1] = hllSparseVal(v1, length)
+					copy(sparse[p:], sparse[p+1:end])
+					end--
+					// Try to merge the result with the opcode on its right
+					continue
+				}
+			}
+		}
+		p++
+	}
+	return hll[:HLL_HDR_SIZE+end]
+}
+
+func hllPromoteAndSet(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	dense, err := hllSparseToDense(hll)
+	if err != nil {
+		return nil, false, err
+	}
+	return dense, hllDenseSet(dense[HLL_HDR_SIZE:], index, count), nil
+}
+
+// hllSet dispatches a register update to the representation in use
+func hllSet(hll []byte, index int, count uint8) ([]byte, bool, error) {
+	if hll[4] == HLL_DENSE {
+		return hll, hllDenseSet(hll[HLL_HDR_SIZE:], index, count), nil
+	}
+	return hllSparseSet(hll, index, count)
+}
+
+// hllAdd adds an element and reports whether any register was updated
+func hllAdd(hll []byte, element []byte) ([]byte, bool, error) {
+	index, count := hllPatLen(element)
+	return hllSet(hll, index, count)
+}
+
+// hllMergeRegisters sets max[i] to the maximum of max[i] and the HLL registers
+func hllMergeRegisters(max []uint8, hll []byte) error {
+	if hll[4] == HLL_DENSE {
+		registers := hll[HLL_HDR_SIZE:]
+		for i := 0; i < HLL_REGISTERS; i++ {
+			if v := hllDenseGetRegister(registers, i); v > max[i] {
+				max[i] = v
+			}
+		}
+		return nil
+	}
+	ok := hllSparseRuns(hll[HLL_HDR_SIZE:], func(first, length int, value uint8) {
+		for i := first; i < first+length; i++ {
+			if value > max[i] {
+				max[i] = value
+			}
+		}
+	})
+	if !ok {
+		return ErrInvalidHLL
+	}
+	return nil
+}
+
+// hllRegisterHistogram counts how many registers hold each value
+func hllRegisterHistogram(hll []byte) ([HLL_Q + 2]int, error) {
+	var histogram [HLL_Q + 2]int
+	if hll[4] == HLL_DENSE {
+		registers := hll[HLL_HDR_SIZE:]
+		for i := 0; i < HLL_REGISTERS; i++ {
+			histogram[hllDenseGetRegister(registers, i)]++
+		}
+		return histogram, nil
+	}
+	ok := hllSparseRuns(hll[HLL_HDR_SIZE:], func(first, length int, value uint8) {
+		histogram[value] += length
+	})
+	if !ok {
+		return histogram, ErrInvalidHLL
+	}
+	return histogram, nil
+}
+
+// hllEstimate estimates the cardinality from a register histogram using the
+// improved estimator from "New cardinality estimation algorithms for
+// HyperLogLog sketches" by Otmar Ertl, as Redis does since 5.0
+func hllEstimate(histogram [HLL_Q + 2]int) uint64 {
+	m := float64(HLL_REGISTERS)
+	z := m * hllTau((m-float64(histogram[HLL_Q+1]))/m)
+	for j := HLL_Q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	return uint64(math.Round(HLL_ALPHA_INF * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+// hllCount returns the cardinality of a single HyperLogLog, using and
+// refreshing the cached value in the header
+func hllCount(hll []byte) (uint64, bool, error) {
+	if hllValidCache(hll) {
+		return binary.LittleEndian.Uint64(hll[8:16]), false, nil
+	}
+	histogram, err := hllRegisterHistogram(hll)
+	if err != nil {
+		return 0, false, err
+	}
+	count := hllEstimate(histogram)
+	binary.LittleEndian.PutUint64(hll[8:16], count)
+	return count, true, nil
+}
+
+// hllRawCount estimates the cardinality of merged raw registers
+func hllRawCount(registers []uint8) uint64 {
+	var histogram [HLL_Q + 2]int
+	for _, v := range registers {
+		histogram[v]++
+	}
+	return hllEstimate(histogram)
+}
+
+// hllValue returns a copy of the HyperLogLog stored at key that can be
+// modified in place. The caller must hold the lock.
+func (s *Storage) hllValue(key string) ([]byte, bool, error) {
+	value, exists, err := s.stringValue(key)
+	if err != nil || !exists {
+		return nil, false, err
+	}
+	hll := []byte(value)
+	if !isHLL(hll) {
+		return nil, false, ErrNotHLL
+	}
+	return hll, true, nil
+}
+
+// PFAdd adds elements to the HyperLogLog stored at key, creating it if needed.
+// It returns 1 if the key was created or an estimated register changed.
+func (s *Storage) PFAdd(key string, elements ...string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, exists, err := s.hllValue(key)
+	if err != nil {
+		return 0, err
+	}
+	updated := false
+	if !exists {
+		hll = newHLL()
+		updated = true
+	}
+
+	for _, element := range elements {
+		var changed bool
+		hll, changed, err = hllAdd(hll, []byte(element))
+		if err != nil {
+			return 0, err
+		}
+		updated = updated || changed
+	}
+
+	if !updated {
+		return 0, nil
+	}
+	hllInvalidateCache(hll)
+	s.setValue(key, &Value{Type: StringValue, Str: string(hll)})
+	return 1, nil
+}
+
+// PFCount returns the approximated cardinality of the union of the
+// HyperLogLogs stored at keys. Missing keys count as empty sets.
+func (s *Storage) PFCount(keys ...string) (int64, error) {
+	if len(keys) == 1 {
+		return s.pfCountSingle(keys[0])
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	registers := make([]uint8, HLL_REGISTERS)
+	for _, key := range keys {
+		hll, exists, err := s.hllValue(key)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			continue
+		}
+		if err := hllMergeRegisters(registers, hll); err != nil {
+			return 0, err
+		}
+	}
+	return int64(hllRawCount(registers)), nil
+}
+
+// pfCountSingle counts a single key and stores the cached cardinality back
+func (s *Storage) pfCountSingle(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hll, exists, err := s.hllValue(key)
+	if err != nil || !exists {
+		return 0, err
+	}
+	count, refreshed, err := hllCount(hll)
+	if err != nil {
+		return 0, err
+	}
+	if refreshed {
+		value, _ := s.data.Get(key)
+		value.Str = string(hll)
+	}
+	return int64(count), nil
+}
+
+// PFMerge merges the source HyperLogLogs, and the destination itself if it
+// exists, into the destination key. The result is dense if any input was dense.
+func (s *Storage) PFMerge(destination string, sources ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	registers := make([]uint8, HLL_REGISTERS)
+	useDense := false
+	for _, key := range append([]string{destination}, sources...) {
+		hll, exists, err := s.hllValue(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if hll[4] == HLL_DENSE {
+			useDense = true
+		}
+		if err := hllMergeRegisters(registers, hll); err != nil {
+			return err
+		}
+	}
+
+	hll, exists, _ := s.hllValue(destination)
+	if !exists {
+		hll = newHLL()
+	}
+	if useDense {
+		var err error
+		if hll, err = hllSparseToDense(hll); err != nil {
+			return err
+		}
+	}
+
+	for i, value := range registers {
+		if value == 0 {
+			continue
+		}
+		var err error
+		if hll, _, err = hllSet(hll, i, value); err != nil {
+			return err
+		}
+	}
+	hllInvalidateCache(hll)
+	s.setValue(destination, &Value{Type: StringValue, Str: string(hll)})
+	return nil
+}
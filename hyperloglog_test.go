@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMurmurHash64A(t *testing.T) {
+	got := MurmurHash64A([]byte("hello world!x"), murmurHashSeed)
+	if got != 0x1a803d129b13c5ff {
+		t.Errorf("Expected 0x1a803d129b13c5ff, got %#x", got)
+	}
+}
+
+func TestHyperLogLogEncoding(t *testing.T) {
+	s := NewStorage()
+
+	// An empty HLL is a sparse header followed by a single XZERO opcode
+	updated, err := s.PFAdd("hll")
+	if err != nil || updated != 1 {
+		t.Fatalf("Expected PFADD to create the key, got %d, %v", updated, err)
+	}
+	expected := "48594c4c010000000000000000000080" + "7fff"
+	if got := hex.EncodeToString([]byte(lookupValue(s, "hll").Str)); got != expected {
+		t.Errorf("Expected empty HLL %s, got %s", expected, got)
+	}
+
+	// The sparse representation must match the bytes produced by Redis
+	for i := 0; i < 5; i++ {
+		s.PFAdd("hll", fmt.Sprintf("elem-%d", i))
+	}
+	expected = "48594c4c0100000000000000000000804aef8c40c380502e944cde8048e5884e52"
+	if got := hex.EncodeToString([]byte(lookupValue(s, "hll").Str)); got != expected {
+		t.Errorf("Expected sparse HLL %s, got %s", expected, got)
+	}
+
+	// Adding an already counted element does not change any register
+	updated, _ = s.PFAdd("hll", "elem-0")
+	if updated != 0 {
+		t.Errorf("Expected 0 for an existing element, got %d", updated)
+	}
+
+	// The sparse representation is promoted to dense once it grows too big
+	for i := 5; i < 3000; i++ {
+		s.PFAdd("hll", fmt.Sprintf("elem-%d", i))
+	}
+	if lookupValue(s, "hll").Str[4] != HLL_DENSE || len(lookupValue(s, "hll").Str) != HLL_DENSE_SIZE {
+		t.Errorf("Expected dense encoding of %d bytes, got encoding %d with %d bytes",
+			HLL_DENSE_SIZE, lookupValue(s, "hll").Str[4], len(lookupValue(s, "hll").Str))
+	}
+}
+
+func TestHyperLogLogSparseToDense(t *testing.T) {
+	hll := newHLL()
+	for i := 0; i < 500; i++ {
+		var err error
+		hll, _, err = hllAdd(hll, []byte(fmt.Sprintf("element:%d", i)))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if hll[4] != HLL_SPARSE {
+		t.Fatal("Expected sparse encoding")
+	}
+
+	dense, err := hllSparseToDense(hll)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sparseRegisters := make([]uint8, HLL_REGISTERS)
+	denseRegisters := make([]uint8, HLL_REGISTERS)
+	hllMergeRegisters(sparseRegisters, hll)
+	hllMergeRegisters(denseRegisters, dense)
+	for i := range sparseRegisters {
+		if sparseRegisters[i] != denseRegisters[i] {
+			t.Fatalf("Register %d differs: sparse %d, dense %d", i, sparseRegisters[i], denseRegisters[i])
+		}
+	}
+}
+
+func TestHyperLogLogCount(t *testing.T) {
+	s := NewStorage()
+
+	count, err := s.PFCount("missing")
+	if err != nil || count != 0 {
+		t.Errorf("Expected 0 for a missing key, got %d, %v", count, err)
+	}
+
+	for _, n := range []int{10, 1000, 100000} {
+		key := fmt.Sprintf("hll%d", n)
+		for i := 0; i < n; i++ {
+			s.PFAdd(key, fmt.Sprintf("element:%d", i))
+		}
+		count, err := s.PFCount(key)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if math.Abs(float64(count)-float64(n))/float64(n) > 0.02 {
+			t.Errorf("Expected approximately %d, got %d", n, count)
+		}
+
+		// The cardinality is cached in the header after counting
+		if !hllValidCache([]byte(lookupValue(s, key).Str)) {
+			t.Errorf("Expected a valid cached cardinality for %s", key)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	s := NewStorage()
+
+	for i := 0; i < 100; i++ {
+		s.PFAdd("a", fmt.Sprintf("element:%d", i))
+		s.PFAdd("b", fmt.Sprintf("element:%d", i+50))
+	}
+
+	union, _ := s.PFCount("a", "b")
+	if union < 145 || union > 155 {
+		t.Errorf("Expected approximately 150, got %d", union)
+	}
+
+	if err := s.PFMerge("merged", "a", "b", "missing"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	merged, _ := s.PFCount("merged")
+	if merged != union {
+		t.Errorf("Expected merged count %d, got %d", union, merged)
+	}
+	if lookupValue(s, "merged").Str[4] != HLL_SPARSE {
+		t.Error("Expected merging sparse HLLs to keep the sparse encoding")
+	}
+
+	// Merging a dense HLL makes the destination dense
+	for i := 0; i < 5000; i++ {
+		s.PFAdd("dense", fmt.Sprintf("element:%d", i))
+	}
+	if err := s.PFMerge("merged", "dense"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if lookupValue(s, "merged").Str[4] != HLL_DENSE {
+		t.Error("Expected the destination to be dense")
+	}
+}
+
+func TestHyperLogLogWrongType(t *testing.T) {
+	s := NewStorage()
+	s.Set("string", "not an hll")
+
+	if _, err := s.PFAdd("string", "a"); err != ErrNotHLL {
+		t.Errorf("Expected ErrNotHLL from PFADD, got %v", err)
+	}
+	if _, err := s.PFCount("string"); err != ErrNotHLL {
+		t.Errorf("Expected ErrNotHLL from PFCOUNT, got %v", err)
+	}
+	if err := s.PFMerge("dest", "string"); err != ErrNotHLL {
+		t.Errorf("Expected ErrNotHLL from PFMERGE, got %v", err)
+	}
+
+	// A truncated sparse representation is detected as corrupted
+	corrupted := newHLL()
+	hllInvalidateCache(corrupted)
+	s.Set("corrupted", string(corrupted[:HLL_HDR_SIZE+1]))
+	if _, err := s.PFCount("corrupted"); err != ErrInvalidHLL {
+		t.Errorf("Expected ErrInvalidHLL, got %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	REDIS_VERSION       = "7.2.0" // Version reported to clients, which check it for feature support
+	STATS_SAMPLES       = 16      // Samples averaged for the instantaneous metrics
+	STATS_SAMPLE_PERIOD = 100 * time.Millisecond
+
+	LATENCY_HISTOGRAM_BUCKETS = 25 // Power of two microsecond buckets, up to about 16 seconds
+)
+
+// ServerStats holds the counters reported by INFO
+type ServerStats struct {
+	startTime        time.Time
+	runID            string // Identifies this run of the server
+	connectedClients atomic.Int64
+	totalConnections atomic.Int64
+	totalCommands    atomic.Int64
+	keyspaceHits     atomic.Int64
+	keyspaceMisses   atomic.Int64
+	netInputBytes    atomic.Int64
+	netOutputBytes   atomic.Int64
+	peakMemory       atomic.Uint64
+	instantaneousOps atomic.Int64
+	rdbSaves         atomic.Int64
+	lastSaveTime     atomic.Int64 // Unix time of the last successful save
+	evictedKeys      atomic.Int64
+	startupMemory    atomic.Uint64 // Memory used before loading the dataset
+}
+
+var stats = newServerStats()
+
+// commandStats holds the INFO commandstats counters of a command and the
+// distribution of its run times
+type commandStats struct {
+	calls         atomic.Int64
+	usec          atomic.Int64
+	rejectedCalls atomic.Int64 // Refused before running, like on arity or ACL errors
+	failedCalls   atomic.Int64 // Ran and replied with an error
+
+	// Bucket i counts the calls that took up to 2^i microseconds, the last
+	// one also counts the slower calls
+	latency [LATENCY_HISTOGRAM_BUCKETS]atomic.Int64
+}
+
+// record counts a call that ran for duration
+func (s *commandStats) record(duration time.Duration, failed bool) {
+	usec := duration.Microseconds()
+	s.calls.Add(1)
+	s.usec.Add(usec)
+	if failed {
+		s.failedCalls.Add(1)
+	}
+	bucket := 0
+	if usec > 1 {
+		bucket = min(bits.Len64(uint64(usec-1)), LATENCY_HISTOGRAM_BUCKETS-1)
+	}
+	s.latency[bucket].Add(1)
+}
+
+func (s *commandStats) reset() {
+	s.calls.Store(0)
+	s.usec.Store(0)
+	s.rejectedCalls.Store(0)
+	s.failedCalls.Store(0)
+	for i := range s.latency {
+		s.latency[i].Store(0)
+	}
+}
+
+// used reports whether the command ran or was refused since the stats were reset
+func (s *commandStats) used() bool {
+	return s.calls.Load() > 0 || s.rejectedCalls.Load() > 0
+}
+
+// forEachCommand calls fn for every command and subcommand in the command table
+func forEachCommand(fn func(spec *CommandSpec)) {
+	for _, spec := range commandTable {
+		fn(spec)
+		for _, subcommand := range spec.Subcommands {
+			fn(subcommand)
+		}
+	}
+}
+
+func newServerStats() *ServerStats {
+	s := &ServerStats{
+		startTime: time.Now(),
+		runID:     randomHexID(),
+	}
+	s.lastSaveTime.Store(s.startTime.Unix())
+	return s
+}
+
+// randomHexID returns a random 40 character identifier like Redis run ids
+func randomHexID() string {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// reset clears the counters for CONFIG RESETSTAT. Gauges like the number of
+// connected clients are kept.
+func (s *ServerStats) reset() {
+	s.totalConnections.Store(0)
+	s.totalCommands.Store(0)
+	s.keyspaceHits.Store(0)
+	s.keyspaceMisses.Store(0)
+	s.netInputBytes.Store(0)
+	s.netOutputBytes.Store(0)
+	s.rdbSaves.Store(0)
+	s.evictedKeys.Store(0)
+	s.peakMemory.Store(usedMemory())
+}
+
+// ResetStats clears the statistics counters, for CONFIG RESETSTAT
+func ResetStats() {
+	stats.reset()
+	forEachCommand(func(spec *CommandSpec) { spec.stats.reset() })
+	lazyFreedTotal.Store(0)
+}
+
+var statsCronOnce sync.Once
+
+// startStatsCron samples the command counter and memory usage periodically,
+// like the Redis serverCron, to compute instantaneous_ops_per_sec and the
+// memory peak
+func startStatsCron() {
+	statsCronOnce.Do(func() {
+		go func() {
+			var samples [STATS_SAMPLES]int64
+			index := 0
+			last := stats.totalCommands.Load()
+			ticker := time.NewTicker(STATS_SAMPLE_PERIOD)
+			defer ticker.Stop()
+			for range ticker.C {
+				current := stats.totalCommands.Load()
+				samples[index%STATS_SAMPLES] = current - last
+				last = current
+				index++
+
+				var sum int64
+				for _, sample := range samples {
+					sum += sample
+				}
+				stats.instantaneousOps.Store(sum * int64(time.Second/STATS_SAMPLE_PERIOD) / STATS_SAMPLES)
+				stats.trackPeakMemory()
+			}
+		}()
+	})
+}
+
+func (s *ServerStats) trackPeakMemory() uint64 {
+	used := usedMemory()
+	for {
+		peak := s.peakMemory.Load()
+		if used <= peak || s.peakMemory.CompareAndSwap(peak, used) {
+			return used
+		}
+	}
+}
+
+// usedMemory returns the bytes of heap memory in use by live objects. Unlike
+// runtime.ReadMemStats it doesn't stop the world.
+func usedMemory() uint64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample[0].Value.Uint64()
+}
+
+// statsReader counts the bytes read from clients
+type statsReader struct {
+	io.Reader
+}
+
+func (r statsReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	stats.netInputBytes.Add(int64(n))
+	return n, err
+}
+
+// infoSections lists the INFO sections in the order they are rendered.
+// Extra sections are only rendered with "all", "everything" or by name.
+var infoSections = []struct {
+	name   string
+	render func(b *strings.Builder)
+	extra  bool
+}{
+	{"server", infoServer, false},
+	{"clients", infoClients, false},
+	{"memory", infoMemory, false},
+	{"persistence", infoPersistence, false},
+	{"stats", infoStats, false},
+	{"replication", infoReplication, false},
+	{"commandstats", infoCommandStats, true},
+	{"keyspace", infoKeyspace, false},
+}
+
+// GenerateInfo renders the requested INFO sections. No sections and
+// "default" select the default sections, "all" and "everything" every
+// section; unknown names are ignored.
+func GenerateInfo(sections ...string) string {
+	selected := make(map[string]bool)
+	defaults := len(sections) == 0
+	all := false
+	for _, section := range sections {
+		switch section = strings.ToLower(section); section {
+		case "default":
+			defaults = true
+		case "all", "everything":
+			all = true
+		default:
+			selected[section] = true
+		}
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !selected[section.name] && !(defaults && !section.extra) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.ToUpper(section.name[:1])+section.name[1:])
+		section.render(&b)
+	}
+	return b.String()
+}
+
+func infoServer(b *strings.Builder) {
+	c := currentConfig()
+	uptime := time.Since(stats.startTime)
+	executable, _ := os.Executable()
+	fmt.Fprintf(b, "redis_version:%s\r\n", REDIS_VERSION)
+	fmt.Fprintf(b, "redis_mode:standalone\r\n")
+	fmt.Fprintf(b, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(b, "arch_bits:%d\r\n", 32<<(^uint(0)>>63))
+	fmt.Fprintf(b, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(b, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(b, "run_id:%s\r\n", stats.runID)
+	fmt.Fprintf(b, "tcp_port:%d\r\n", c.Port)
+	fmt.Fprintf(b, "server_time_usec:%d\r\n", time.Now().UnixMicro())
+	fmt.Fprintf(b, "uptime_in_seconds:%d\r\n", int64(uptime.Seconds()))
+	fmt.Fprintf(b, "uptime_in_days:%d\r\n", int64(uptime.Hours()/24))
+	fmt.Fprintf(b, "executable:%s\r\n", executable)
+	fmt.Fprintf(b, "config_file:%s\r\n", c.File)
+}
+
+func infoClients(b *strings.Builder) {
+	fmt.Fprintf(b, "connected_clients:%d\r\n", stats.connectedClients.Load())
+	fmt.Fprintf(b, "blocked_clients:0\r\n")
+}
+
+func infoMemory(b *strings.Builder) {
+	c := currentConfig()
+	used := stats.trackPeakMemory()
+	peak := stats.peakMemory.Load()
+	fmt.Fprintf(b, "used_memory:%d\r\n", used)
+	fmt.Fprintf(b, "used_memory_human:%s\r\n", BytesToHuman(used))
+	fmt.Fprintf(b, "used_memory_peak:%d\r\n", peak)
+	fmt.Fprintf(b, "used_memory_peak_human:%s\r\n", BytesToHuman(peak))
+	fmt.Fprintf(b, "used_memory_dataset:%d\r\n", datasetMemory())
+	fmt.Fprintf(b, "maxmemory:%d\r\n", c.MaxMemory)
+	fmt.Fprintf(b, "maxmemory_human:%s\r\n", BytesToHuman(uint64(c.MaxMemory)))
+	fmt.Fprintf(b, "maxmemory_policy:%s\r\n", c.MaxMemoryPolicy)
+	fmt.Fprintf(b, "lazyfree_pending_objects:%d\r\n", lazyFreePending.Load())
+	fmt.Fprintf(b, "lazyfreed_objects:%d\r\n", lazyFreedTotal.Load())
+}
+
+func infoPersistence(b *strings.Builder) {
+	fmt.Fprintf(b, "loading:0\r\n")
+	fmt.Fprintf(b, "rdb_bgsave_in_progress:0\r\n")
+	fmt.Fprintf(b, "rdb_last_save_time:%d\r\n", stats.lastSaveTime.Load())
+	fmt.Fprintf(b, "rdb_saves:%d\r\n", stats.rdbSaves.Load())
+	fmt.Fprintf(b, "aof_enabled:0\r\n")
+	fmt.Fprintf(b, "aof_rewrite_in_progress:0\r\n")
+}
+
+func infoStats(b *strings.Builder) {
+	fmt.Fprintf(b, "total_connections_received:%d\r\n", stats.totalConnections.Load())
+	fmt.Fprintf(b, "total_commands_processed:%d\r\n", stats.totalCommands.Load())
+	fmt.Fprintf(b, "instantaneous_ops_per_sec:%d\r\n", stats.instantaneousOps.Load())
+	fmt.Fprintf(b, "total_net_input_bytes:%d\r\n", stats.netInputBytes.Load())
+	fmt.Fprintf(b, "total_net_output_bytes:%d\r\n", stats.netOutputBytes.Load())
+	fmt.Fprintf(b, "keyspace_hits:%d\r\n", stats.keyspaceHits.Load())
+	fmt.Fprintf(b, "keyspace_misses:%d\r\n", stats.keyspaceMisses.Load())
+	fmt.Fprintf(b, "evicted_keys:%d\r\n", stats.evictedKeys.Load())
+}
+
+func infoReplication(b *strings.Builder) {
+	status := replication.Status()
+	fmt.Fprintf(b, "role:%s\r\n", status.role)
+	if status.role == "slave" {
+		linkStatus, syncing := "down", 0
+		if status.linkState == "connected" {
+			linkStatus = "up"
+		} else if status.linkState == "sync" {
+			syncing = 1
+		}
+		fmt.Fprintf(b, "master_host:%s\r\n", status.masterHost)
+		fmt.Fprintf(b, "master_port:%d\r\n", status.masterPort)
+		fmt.Fprintf(b, "master_link_status:%s\r\n", linkStatus)
+		fmt.Fprintf(b, "master_last_io_seconds_ago:%d\r\n", status.lastIOSeconds)
+		fmt.Fprintf(b, "master_sync_in_progress:%d\r\n", syncing)
+		fmt.Fprintf(b, "slave_repl_offset:%d\r\n", status.offset)
+		if status.linkDownSeconds >= 0 {
+			fmt.Fprintf(b, "master_link_down_since_seconds:%d\r\n", status.linkDownSeconds)
+		}
+		fmt.Fprintf(b, "slave_read_only:%d\r\n", boolToInt(currentConfig().ReplicaReadOnly))
+	}
+	fmt.Fprintf(b, "connected_slaves:%d\r\n", len(status.replicas))
+	for i, rep := range status.replicas {
+		fmt.Fprintf(b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n",
+			i, rep.ip, rep.port, rep.state, rep.offset, rep.lag)
+	}
+	fmt.Fprintf(b, "master_replid:%s\r\n", status.replID)
+	fmt.Fprintf(b, "master_replid2:%s\r\n", status.replID2)
+	fmt.Fprintf(b, "master_repl_offset:%d\r\n", status.offset)
+	fmt.Fprintf(b, "second_repl_offset:%d\r\n", status.secondOffset)
+	fmt.Fprintf(b, "repl_backlog_active:%d\r\n", boolToInt(status.backlogActive))
+	fmt.Fprintf(b, "repl_backlog_size:%d\r\n", currentConfig().ReplBacklogSize)
+	fmt.Fprintf(b, "repl_backlog_first_byte_offset:%d\r\n", status.backlogFirstByte)
+	fmt.Fprintf(b, "repl_backlog_histlen:%d\r\n", status.backlogHistlen)
+}
+
+func infoCommandStats(b *strings.Builder) {
+	forEachCommand(func(spec *CommandSpec) {
+		if !spec.stats.used() {
+			return
+		}
+		calls, usec := spec.stats.calls.Load(), spec.stats.usec.Load()
+		perCall := 0.0
+		if calls > 0 {
+			perCall = float64(usec) / float64(calls)
+		}
+		fmt.Fprintf(b, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d\r\n",
+			spec.Name, calls, usec, perCall, spec.stats.rejectedCalls.Load(), spec.stats.failedCalls.Load())
+	})
+}
+
+func infoKeyspace(b *strings.Builder) {
+	for i, db := range databases {
+		if keys := db.Len(); keys > 0 {
+			fmt.Fprintf(b, "db%d:keys=%d,expires=0,avg_ttl=0\r\n", i, keys)
+		}
+	}
+}
+
+// BytesToHuman formats a byte count like Redis, e.g. 1.50M
+func BytesToHuman(n uint64) string {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"P", 1 << 50}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	}
+	for _, unit := range units {
+		if float64(n) >= unit.size {
+			return fmt.Sprintf("%.2f%s", float64(n)/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// handleInfo runs INFO [section [section ...]]
+func handleInfo(args []RESPValue) *RESPValue {
+	sections := make([]string, len(args))
+	for i, arg := range args {
+		sections[i] = arg.Str
+	}
+	return &RESPValue{Type: BulkString, Str: GenerateInfo(sections...)}
+}
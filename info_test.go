@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateInfoSections(t *testing.T) {
+	info := GenerateInfo()
+	for _, header := range []string{"# Server\r\n", "# Clients\r\n", "# Memory\r\n", "# Persistence\r\n",
+		"# Stats\r\n", "# Replication\r\n", "# Keyspace\r\n"} {
+		if !strings.Contains(info, header) {
+			t.Errorf("Expected section %q in INFO", header)
+		}
+	}
+	if strings.Contains(info, "# Commandstats") {
+		t.Error("Expected commandstats to be left out of the default sections")
+	}
+	if strings.Count(GenerateInfo("everything"), "# ") != 8 {
+		t.Error("Expected everything to render every section")
+	}
+
+	stats := GenerateInfo("STATS", "clients")
+	if !strings.HasPrefix(stats, "# Clients\r\nconnected_clients:") || !strings.Contains(stats, "\r\n\r\n# Stats\r\n") {
+		t.Errorf("Unexpected filtered INFO %q", stats)
+	}
+	if strings.Contains(stats, "# Server") {
+		t.Error("Expected the server section to be filtered out")
+	}
+	if GenerateInfo("nosuchsection") != "" {
+		t.Error("Expected an unknown section to render nothing")
+	}
+}
+
+func TestCommandStats(t *testing.T) {
+	spec := lookupCommand("config|get")
+	spec.stats.reset()
+	defer spec.stats.reset()
+	spec.stats.record(3*time.Microsecond, false)
+	spec.stats.record(5*time.Microsecond, true)
+	spec.stats.rejectedCalls.Add(1)
+
+	expected := "cmdstat_config|get:calls=2,usec=8,usec_per_call=4.00,rejected_calls=1,failed_calls=1\r\n"
+	if info := GenerateInfo("commandstats"); !strings.Contains(info, expected) {
+		t.Errorf("Expected %q in %q", expected, info)
+	}
+	for bucket, count := range map[int]int64{0: 0, 2: 1, 3: 1} {
+		if got := spec.stats.latency[bucket].Load(); got != count {
+			t.Errorf("Expected %d calls in latency bucket %d, got %d", count, bucket, got)
+		}
+	}
+
+	ResetStats()
+	if spec.stats.used() {
+		t.Error("Expected CONFIG RESETSTAT to clear the command stats")
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	stats.keyspaceHits.Add(5)
+	stats.totalCommands.Add(5)
+	ResetStats()
+	if stats.keyspaceHits.Load() != 0 || stats.totalCommands.Load() != 0 {
+		t.Error("Expected counters to be reset")
+	}
+	if !strings.Contains(GenerateInfo("stats"), "keyspace_hits:0\r\n") {
+		t.Error("Expected keyspace_hits:0 after a reset")
+	}
+}
+
+func TestBytesToHuman(t *testing.T) {
+	tests := map[uint64]string{
+		0:           "0B",
+		1023:        "1023B",
+		1024:        "1.00K",
+		1536 * 1024: "1.50M",
+		5 << 30:     "5.00G",
+		3 << 40:     "3.00T",
+	}
+	for n, expected := range tests {
+		if got := BytesToHuman(n); got != expected {
+			t.Errorf("BytesToHuman(%d) = %s, expected %s", n, got, expected)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const LATENCY_TS_LEN = 160 // Samples kept per event, like in Redis
+
+// latencySample is the worst latency of an event within one second
+type latencySample struct {
+	time    int64 // Unix seconds, 0 for an unused slot
+	latency int64 // Milliseconds
+}
+
+// latencyTimeSeries is a ring buffer of the latest samples of an event
+type latencyTimeSeries struct {
+	idx     int // Slot of the next sample
+	max     int64
+	samples [LATENCY_TS_LEN]latencySample
+}
+
+// LatencyMonitor records the events, like slow commands or eviction cycles,
+// that took at least latency-monitor-threshold milliseconds
+type LatencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencyTimeSeries
+}
+
+var latencyMonitor = &LatencyMonitor{events: make(map[string]*latencyTimeSeries)}
+
+// AddSampleIfNeeded records an event if the latency monitor is enabled and
+// the event took at least the threshold
+func (l *LatencyMonitor) AddSampleIfNeeded(event string, duration time.Duration) {
+	if duration < time.Millisecond {
+		// Below any threshold, skip reading the settings
+		return
+	}
+	threshold := int64(currentConfig().LatencyMonitorThreshold)
+	if threshold == 0 || duration.Milliseconds() < threshold {
+		return
+	}
+	l.AddSample(event, time.Now(), duration.Milliseconds())
+}
+
+// AddSample records the latency of an event. Samples within the same second
+// are merged, keeping the worst latency.
+func (l *LatencyMonitor) AddSample(event string, now time.Time, latency int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ts := l.events[event]
+	if ts == nil {
+		ts = &latencyTimeSeries{}
+		l.events[event] = ts
+	}
+	ts.max = max(ts.max, latency)
+
+	prev := &ts.samples[(ts.idx+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+	if prev.time == now.Unix() {
+		prev.latency = max(prev.latency, latency)
+		return
+	}
+	ts.samples[ts.idx] = latencySample{time: now.Unix(), latency: latency}
+	ts.idx = (ts.idx + 1) % LATENCY_TS_LEN
+}
+
+// history returns the samples of a time series, oldest first
+func (ts *latencyTimeSeries) history() []latencySample {
+	var samples []latencySample
+	for j := range LATENCY_TS_LEN {
+		if sample := ts.samples[(ts.idx+j)%LATENCY_TS_LEN]; sample.time != 0 {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// Reset clears the given events, or every event if none is given, and
+// returns the number of time series cleared
+func (l *LatencyMonitor) Reset(events ...string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(events) == 0 {
+		cleared := len(l.events)
+		clear(l.events)
+		return cleared
+	}
+	cleared := 0
+	for _, event := range events {
+		if _, exists := l.events[event]; exists {
+			delete(l.events, event)
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// eventNames returns the recorded events in alphabetical order. The caller
+// must hold the lock.
+func (l *LatencyMonitor) eventNames() []string {
+	names := make([]string, 0, len(l.events))
+	for name := range l.events {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// latestReply renders LATENCY LATEST: the time and latency of the latest
+// sample and the all time maximum of every event
+func (l *LatencyMonitor) latestReply() *RESPValue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+	for _, name := range l.eventNames() {
+		ts := l.events[name]
+		latest := ts.samples[(ts.idx+LATENCY_TS_LEN-1)%LATENCY_TS_LEN]
+		reply.Array = append(reply.Array, RESPValue{Type: Array, Array: []RESPValue{
+			{Type: BulkString, Str: name},
+			{Type: Integer, Int: latest.time},
+			{Type: Integer, Int: latest.latency},
+			{Type: Integer, Int: ts.max},
+		}})
+	}
+	return reply
+}
+
+// historyReply renders LATENCY HISTORY: the samples of an event, oldest first
+func (l *LatencyMonitor) historyReply(event string) *RESPValue {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+	if ts := l.events[event]; ts != nil {
+		for _, sample := range ts.history() {
+			reply.Array = append(reply.Array, RESPValue{Type: Array, Array: []RESPValue{
+				{Type: Integer, Int: sample.time},
+				{Type: Integer, Int: sample.latency},
+			}})
+		}
+	}
+	return reply
+}
+
+// Doctor returns a human readable analysis of the recorded events, in the
+// voice of the Redis LATENCY DOCTOR
+func (l *LatencyMonitor) Doctor(c *Config, now time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) == 0 && c.LatencyMonitorThreshold == 0 {
+		return "I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. " +
+			"You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n"
+	}
+	if len(l.events) == 0 {
+		return "Dave, no latency spike was observed during the lifetime of this Redis instance, " +
+			"not in the slightest bit. I honestly think you ought to sleep tonight.\n"
+	}
+
+	var report strings.Builder
+	report.WriteString("Dave, I have observed latency spikes in this Redis instance. " +
+		"You don't mind talking about it, do you Dave?\n\n")
+	advices := map[string]bool{}
+	for i, name := range l.eventNames() {
+		ts := l.events[name]
+		samples := ts.history()
+		var sum int64
+		for _, sample := range samples {
+			sum += sample.latency
+		}
+		avg := float64(sum) / float64(len(samples))
+		var deviation float64
+		for _, sample := range samples {
+			deviation += math.Abs(float64(sample.latency) - avg)
+		}
+		period := float64(now.Unix()-samples[0].time) / float64(len(samples))
+		fmt.Fprintf(&report, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %.2f sec). Worst all time event %dms.\n",
+			i+1, name, len(samples), int64(avg), int64(deviation/float64(len(samples))), period, ts.max)
+
+		switch name {
+		case "command":
+			advices["slowlog"] = true
+		case "fast-command":
+			advices["cpu"] = true
+		case "eviction-cycle", "eviction-del":
+			advices["eviction"] = true
+		}
+	}
+
+	var advice []string
+	if advices["slowlog"] {
+		if c.SlowlogLogSlowerThan < 0 {
+			advice = append(advice, "- The slow log is disabled, enable it with CONFIG SET slowlog-log-slower-than "+
+				"to find out which commands are slow.\n")
+		} else {
+			advice = append(advice, "- Check your slowlog to understand what are the commands you are running which are too slow to execute. "+
+				"Commands running on large sorted sets or KEYS on a large keyspace are the usual suspects.\n")
+		}
+	}
+	if advices["cpu"] {
+		advice = append(advice, "- Commands that should be fast are slow, which means the server doesn't get enough CPU time. "+
+			"Check for other busy processes on the host and for long garbage collection pauses.\n")
+	}
+	if advices["eviction"] {
+		advice = append(advice, "- Evicting keys is slow. Lower maxmemory-samples, or leave more room between the dataset size and maxmemory "+
+			"so fewer keys are evicted at once.\n")
+	}
+	if len(advice) == 0 {
+		report.WriteString("\nWhile there are latency events logged, I'm not able to suggest any easy fix. " +
+			"Please use the Redis community to get some help, providing this report in your help request.\n")
+	} else {
+		report.WriteString("\nI have a few advices for you:\n\n" + strings.Join(advice, ""))
+	}
+	return report.String()
+}
+
+// histogramReply renders LATENCY HISTOGRAM for the given commands, or every
+// command that ran if none is given. Each histogram lists the cumulative
+// number of calls up to each power of two microseconds where it grows.
+func histogramReply(names []string) *RESPValue {
+	var specs []*CommandSpec
+	if len(names) == 0 {
+		forEachCommand(func(spec *CommandSpec) { specs = append(specs, spec) })
+	}
+	for _, name := range names {
+		if spec := lookupCommand(name); spec != nil && !slices.Contains(specs, spec) {
+			specs = append(specs, spec)
+		}
+	}
+
+	reply := &RESPValue{Type: Array, Array: []RESPValue{}}
+	for _, spec := range specs {
+		calls := spec.stats.calls.Load()
+		if calls == 0 {
+			continue
+		}
+		histogram := RESPValue{Type: Array, Array: []RESPValue{}}
+		var cumulative int64
+		for i := range LATENCY_HISTOGRAM_BUCKETS {
+			count := spec.stats.latency[i].Load()
+			if count == 0 {
+				continue
+			}
+			cumulative += count
+			histogram.Array = append(histogram.Array,
+				RESPValue{Type: Integer, Int: int64(1) << i},
+				RESPValue{Type: Integer, Int: cumulative})
+		}
+		reply.Array = append(reply.Array,
+			RESPValue{Type: BulkString, Str: spec.Name},
+			RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "calls"},
+				{Type: Integer, Int: calls},
+				{Type: BulkString, Str: "histogram_usec"},
+				histogram,
+			}})
+	}
+	return reply
+}
+
+var latencyHelp = []string{
+	"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return a human readable latency analysis report.",
+	"HISTOGRAM [COMMAND ...]",
+	"    Return a cumulative distribution of latencies in the format of a histogram for the specified command names.",
+	"    If no commands are specified then all histograms are replied.",
+	"HISTORY <event>",
+	"    Return time-latency samples for the <event> class.",
+	"LATEST",
+	"    Return the latest latency samples for all events.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more <event> classes.",
+	"    (default: reset all data for all event classes)",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleLatency runs LATENCY LATEST, HISTORY, RESET, HISTOGRAM, DOCTOR and HELP
+func handleLatency(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("latency|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'LATENCY|%s' command", subcommand),
+		}
+	}
+
+	switch subcommand {
+	case "LATEST":
+		return latencyMonitor.latestReply()
+	case "HISTORY":
+		return latencyMonitor.historyReply(args[1].Str)
+	case "RESET":
+		return &RESPValue{Type: Integer, Int: int64(latencyMonitor.Reset(argStrings(args[1:])...))}
+	case "HISTOGRAM":
+		return histogramReply(argStrings(args[1:]))
+	case "DOCTOR":
+		config := currentConfig()
+		return &RESPValue{Type: BulkString, Str: latencyMonitor.Doctor(&config, time.Now())}
+	case "HELP":
+		return simpleStringArrayReply(latencyHelp)
+	default:
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try LATENCY HELP.", args[0].Str),
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyTimeSeries(t *testing.T) {
+	l := &LatencyMonitor{events: make(map[string]*latencyTimeSeries)}
+	start := time.Unix(1700000000, 0)
+	l.AddSample("command", start, 10)
+	l.AddSample("command", start.Add(500*time.Millisecond), 30) // Same second, the worst is kept
+	l.AddSample("command", start.Add(time.Second), 20)
+
+	history := l.events["command"].history()
+	if len(history) != 2 || history[0] != (latencySample{start.Unix(), 30}) || history[1] != (latencySample{start.Unix() + 1, 20}) {
+		t.Errorf("Unexpected history %v", history)
+	}
+	latest := l.latestReply().Array[0].Array
+	if latest[0].Str != "command" || latest[1].Int != start.Unix()+1 || latest[2].Int != 20 || latest[3].Int != 30 {
+		t.Errorf("Unexpected LATENCY LATEST entry %v", latest)
+	}
+
+	// The ring keeps the newest LATENCY_TS_LEN samples
+	for i := range LATENCY_TS_LEN + 5 {
+		l.AddSample("eviction-del", start.Add(time.Duration(i)*time.Second), int64(i))
+	}
+	history = l.events["eviction-del"].history()
+	if len(history) != LATENCY_TS_LEN || history[0].latency != 5 || history[len(history)-1].latency != LATENCY_TS_LEN+4 {
+		t.Errorf("Expected samples 5 to %d, got %d samples from %v", LATENCY_TS_LEN+4, len(history), history[0])
+	}
+
+	if cleared := l.Reset("command", "nosuchevent"); cleared != 1 {
+		t.Errorf("Expected 1 event reset, got %d", cleared)
+	}
+	if cleared := l.Reset(); cleared != 1 || len(l.events) != 0 {
+		t.Errorf("Expected the remaining event reset, got %d", cleared)
+	}
+}
+
+func TestLatencyDoctor(t *testing.T) {
+	l := &LatencyMonitor{events: make(map[string]*latencyTimeSeries)}
+	c := DefaultConfig()
+	now := time.Unix(1700000100, 0)
+	if report := l.Doctor(c, now); !strings.HasPrefix(report, "I'm sorry, Dave, I can't do that.") {
+		t.Errorf("Expected the monitor to be reported disabled, got %q", report)
+	}
+	c.LatencyMonitorThreshold = 100
+	if report := l.Doctor(c, now); !strings.HasPrefix(report, "Dave, no latency spike was observed") {
+		t.Errorf("Expected no spikes to be reported, got %q", report)
+	}
+
+	l.AddSample("command", time.Unix(1700000000, 0), 100)
+	l.AddSample("command", time.Unix(1700000050, 0), 300)
+	report := l.Doctor(c, now)
+	for _, expected := range []string{
+		"Dave, I have observed latency spikes",
+		"1. command: 2 latency spikes (average 200ms, mean deviation 100ms, period 50.00 sec). Worst all time event 300ms.",
+		"- Check your slowlog",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected %q in %q", expected, report)
+		}
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	spec := lookupCommand("echo")
+	spec.stats.reset()
+	defer spec.stats.reset()
+	spec.stats.record(time.Microsecond, false)
+	spec.stats.record(3*time.Microsecond, false)
+	spec.stats.record(4*time.Microsecond, false)
+
+	reply := histogramReply([]string{"ECHO", "echo", "nosuchcommand"})
+	if len(reply.Array) != 2 || reply.Array[0].Str != "echo" {
+		t.Fatalf("Expected a single echo histogram, got %v", reply)
+	}
+	details := reply.Array[1].Array
+	if details[0].Str != "calls" || details[1].Int != 3 || details[2].Str != "histogram_usec" {
+		t.Errorf("Unexpected histogram details %v", details)
+	}
+	var buckets []int64
+	for _, value := range details[3].Array {
+		buckets = append(buckets, value.Int)
+	}
+	if len(buckets) != 4 || buckets[0] != 1 || buckets[1] != 1 || buckets[2] != 4 || buckets[3] != 3 {
+		t.Errorf("Expected 1 call up to 1us and 3 up to 4us, got %v", buckets)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	LAZYFREE_THRESHOLD = 64   // Values with more elements are freed in the background
+	LAZYFREE_QUEUE     = 1024 // Pending jobs before freeing falls back to the caller
+)
+
+var (
+	lazyFreeOnce    sync.Once
+	lazyFreeQueue   chan func()
+	lazyFreePending atomic.Int64
+	lazyFreedTotal  atomic.Int64
+)
+
+// freeEffort estimates the work needed to release a value, in elements
+func (v *Value) freeEffort() int {
+	if v.Type == SortedSetValue {
+		return v.ZSet.Len()
+	}
+	return 1
+}
+
+// free releases the internal structures of a value
+func (v *Value) free() {
+	if v.Type == SortedSetValue {
+		v.ZSet.clear()
+	}
+	v.Str = ""
+}
+
+// freeValueAsync releases large values in a background goroutine so deleting
+// a huge collection doesn't hold up the caller. Small values are simply left
+// to the garbage collector.
+func freeValueAsync(value *Value) {
+	if value.freeEffort() <= LAZYFREE_THRESHOLD {
+		return
+	}
+	lazyFree(value.free)
+}
+
+// freeDictAsync releases a whole keyspace in the background, as dropped by
+// FLUSHDB ASYNC and FLUSHALL ASYNC
+func freeDictAsync(d *Dict[*Value]) {
+	if d.Len() == 0 {
+		return
+	}
+	lazyFree(func() {
+		d.Range(func(key string, value *Value) bool {
+			value.free()
+			return true
+		})
+		d.Clear()
+	})
+}
+
+// lazyFree queues a job for the background worker
+func lazyFree(job func()) {
+	lazyFreeOnce.Do(func() {
+		lazyFreeQueue = make(chan func(), LAZYFREE_QUEUE)
+		go lazyFreeWorker()
+	})
+
+	lazyFreePending.Add(1)
+	select {
+	case lazyFreeQueue <- job:
+	default:
+		// The background worker is saturated, free it here instead
+		job()
+		lazyFreePending.Add(-1)
+		lazyFreedTotal.Add(1)
+	}
+}
+
+func lazyFreeWorker() {
+	for job := range lazyFreeQueue {
+		job()
+		lazyFreePending.Add(-1)
+		lazyFreedTotal.Add(1)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Levels of the loglevel setting. verbose sits between debug and notice like
+// in Redis, and nothing is above every level so it disables logging.
+const (
+	LevelDebug   = slog.LevelDebug
+	LevelVerbose = slog.Level(-2)
+	LevelNotice  = slog.LevelInfo
+	LevelWarning = slog.LevelWarn
+	LevelNothing = slog.Level(1 << 10)
+)
+
+var logLevels = map[string]slog.Level{
+	"debug":   LevelDebug,
+	"verbose": LevelVerbose,
+	"notice":  LevelNotice,
+	"warning": LevelWarning,
+	"nothing": LevelNothing,
+}
+
+var logLevelNames = map[slog.Level]string{
+	LevelDebug:   "DEBUG",
+	LevelVerbose: "VERBOSE",
+	LevelNotice:  "NOTICE",
+	LevelWarning: "WARNING",
+}
+
+var logLevel = new(slog.LevelVar) // The zero value is notice, the default
+
+// logger is replaced by setupLogging once the settings are loaded
+var logger = func() *atomic.Pointer[slog.Logger] {
+	logger := new(atomic.Pointer[slog.Logger])
+	logger.Store(newLogger(os.Stderr, "text"))
+	return logger
+}()
+
+// newLogger returns a logger writing text or JSON lines to w, filtered by
+// the loglevel setting
+func newLogger(w io.Writer, format string) *slog.Logger {
+	options := &slog.HandlerOptions{
+		Level: logLevel,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if level, ok := attr.Value.Any().(slog.Level); ok && attr.Key == slog.LevelKey && len(groups) == 0 {
+				attr.Value = slog.StringValue(logLevelNames[level])
+			}
+			return attr
+		},
+	}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, options))
+	}
+	return slog.New(slog.NewTextHandler(w, options))
+}
+
+// setupLogging applies the loglevel, logfile and log-format settings at startup
+func setupLogging(c *Config) error {
+	logLevel.Set(logLevels[c.LogLevel])
+	w := io.Writer(os.Stderr)
+	if c.LogFile != "" {
+		file, err := os.OpenFile(c.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = file
+	}
+	logger.Store(newLogger(w, c.LogFormat))
+	return nil
+}
+
+// logEnabled reports whether messages of a level are logged, so callers can
+// skip preparing expensive attributes
+func logEnabled(level slog.Level) bool {
+	return logger.Load().Enabled(context.Background(), level)
+}
+
+// logDebug, logVerbose, logNotice and logWarning log a message with
+// key-value attributes, like slog.Info
+func logDebug(msg string, args ...any) {
+	logger.Load().Log(context.Background(), LevelDebug, msg, args...)
+}
+
+func logVerbose(msg string, args ...any) {
+	logger.Load().Log(context.Background(), LevelVerbose, msg, args...)
+}
+
+func logNotice(msg string, args ...any) {
+	logger.Load().Log(context.Background(), LevelNotice, msg, args...)
+}
+
+func logWarning(msg string, args ...any) {
+	logger.Load().Log(context.Background(), LevelWarning, msg, args...)
+}
+
+// logFatal logs a message whatever the loglevel setting and exits
+func logFatal(msg string, args ...any) {
+	record := slog.NewRecord(time.Now(), LevelWarning, msg, 0)
+	record.Add(args...)
+	logger.Load().Handler().Handle(context.Background(), record)
+	os.Exit(1)
+}
+
+// newLogLogger returns a log.Logger writing to the current logger at level,
+// for standard library servers
+func newLogLogger(level slog.Level) *log.Logger {
+	return slog.NewLogLogger(logger.Load().Handler(), level)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	saved := logLevel.Level()
+	defer logLevel.Set(saved)
+	logLevel.Set(LevelVerbose)
+
+	var buf bytes.Buffer
+	text := newLogger(&buf, "text")
+	text.Log(context.Background(), LevelDebug, "hidden")
+	text.Log(context.Background(), LevelVerbose, "New connection", "address", "127.0.0.1:5000")
+	if line := buf.String(); strings.Contains(line, "hidden") ||
+		!strings.Contains(line, `level=VERBOSE msg="New connection" address=127.0.0.1:5000`) {
+		t.Errorf("Unexpected text log %q", line)
+	}
+
+	buf.Reset()
+	newLogger(&buf, "json").Log(context.Background(), LevelWarning, "Shutdown manually aborted.", "signal", "SIGINT")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON line, got %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "WARNING" || entry["msg"] != "Shutdown manually aborted." || entry["signal"] != "SIGINT" {
+		t.Errorf("Unexpected JSON log %v", entry)
+	}
+
+	// CONFIG SET loglevel takes effect right away
+	withConfig(t, DefaultConfig(), func() {
+		if err := ConfigSet([]string{"loglevel", "nothing"}); err != nil {
+			t.Fatal(err)
+		}
+		if logEnabled(LevelWarning) {
+			t.Error("Expected nothing to be logged with loglevel nothing")
+		}
+		ConfigSet([]string{"loglevel", "debug"})
+		if !logEnabled(LevelDebug) {
+			t.Error("Expected debug messages to be logged with loglevel debug")
+		}
+	})
+}
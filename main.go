@@ -2,10 +2,16 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const (
@@ -13,23 +19,153 @@ const (
 	PROTOCOL     = "tcp"
 )
 
-var storage *Storage
-
 func main() {
-	storage = NewStorage()
+	loaded, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		logFatal("*** FATAL CONFIG FILE ERROR ***", "error", err)
+	}
+	configMu.Lock()
+	config = loaded
+	configMu.Unlock()
+
+	if err := setupLogging(config); err != nil {
+		logFatal("Can't open the log file", "error", err)
+	}
+	if err := os.Chdir(config.Dir); err != nil {
+		logFatal("Can't chdir to the working directory", "dir", config.Dir, "error", err)
+	}
+
+	acl.SetRequirePass(config.RequirePass)
+	lfuSettings.set(config)
+	if config.ACLFile != "" {
+		if err := acl.LoadFile(config.ACLFile); err != nil {
+			logFatal("Error loading the ACL file", "error", err)
+		}
+	}
+
+	stats.startupMemory.Store(usedMemory())
+	databases = NewDatabases(config.Databases)
+	if err := LoadRDB(config.DBFilename); err != nil {
+		logFatal("Fatal error loading the DB. Exiting.", "error", err)
+	}
+	startStatsCron()
+	if config.MasterHost != "" {
+		replication.SetMaster(config.MasterHost, config.MasterPort)
+	}
+	startReplicationCron()
+
+	tlsConfig, err := loadTLSConfig(config)
+	if err != nil {
+		logFatal("Failed to configure TLS", "error", err)
+	}
+	var listeners []net.Listener
+	if config.Port != 0 {
+		listeners = append(listeners, listen(listenAddresses(config, config.Port), "server")...)
+	}
+	if tlsConfig != nil {
+		for _, listener := range listen(listenAddresses(config, config.TLSPort), "TLS server") {
+			listeners = append(listeners, tls.NewListener(listener, tlsConfig))
+		}
+	}
+	if config.UnixSocket != "" {
+		listener, err := listenUnix(config.UnixSocket, config.UnixSocketPerm)
+		if err != nil {
+			logFatal("Failed opening Unix socket", "error", err)
+		}
+		shutdown.AddListener(listener)
+		listeners = append(listeners, listener)
+		logNotice("Redis-lite server listening on unix socket", "path", config.UnixSocket)
+	}
+	if config.MetricsPort != 0 {
+		serveMetrics(listen(listenAddresses(config, config.MetricsPort), "metrics server"))
+	}
+	if len(listeners) == 0 {
+		logFatal("Failed to start server: no address to listen on")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	for _, listener := range listeners {
+		go acceptConnections(listener)
+	}
+	handleSignals(signals)
+}
+
+// listen opens a listener on every address, closed at shutdown. kind names
+// the listener in the log. Optional addresses that are unavailable are skipped.
+func listen(addresses []string, kind string) []net.Listener {
+	var listeners []net.Listener
+	for _, address := range addresses {
+		optional := strings.HasPrefix(address, "-")
+		address = strings.TrimPrefix(address, "-")
+		listener, err := net.Listen(PROTOCOL, address)
+		if err != nil {
+			if optional {
+				logWarning("Skipping optional address", "address", address, "error", err)
+				continue
+			}
+			logFatal("Failed to start server", "error", err)
+		}
+		shutdown.AddListener(listener)
+		listeners = append(listeners, listener)
+		logNotice("Redis-lite "+kind+" listening", "address", listener.Addr().String())
+	}
+	return listeners
+}
 
-	listener, err := net.Listen(PROTOCOL, fmt.Sprintf(":%d", DEFAULT_PORT))
+// listenUnix listens on a unix socket, replacing a stale socket left by a
+// previous run. perm sets the socket permissions unless it is 0. The socket
+// file is removed when the listener is closed.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return nil, err
+		}
 	}
-	defer listener.Close()
+	return listener, nil
+}
 
-	log.Printf("Redis-lite server listening on port %d", DEFAULT_PORT)
+// listenAddresses returns the host:port addresses to listen on for the bind
+// setting, where * means every IPv4 interface, ::* every IPv6 interface and
+// a leading - marks an address that may be unavailable
+func listenAddresses(c *Config, portNumber int) []string {
+	port := strconv.Itoa(portNumber)
+	if len(c.Bind) == 0 {
+		return []string{":" + port}
+	}
 
+	addresses := make([]string, len(c.Bind))
+	for i, bind := range c.Bind {
+		prefix := ""
+		if strings.HasPrefix(bind, "-") {
+			prefix, bind = "-", bind[1:]
+		}
+		switch bind {
+		case "*":
+			bind = "0.0.0.0"
+		case "::*":
+			bind = "::"
+		}
+		addresses[i] = prefix + net.JoinHostPort(bind, port)
+	}
+	return addresses
+}
+
+func acceptConnections(listener net.Listener) {
 	for {
 		conn, err := listener.Accept()
-		if err != nil {
-			log.Printf("Failed to accept connection: %v", err)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			logWarning("Failed to accept connection", "error", err)
 			continue
 		}
 
@@ -40,15 +176,31 @@ func main() {
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	log.Printf("New connection from %s", conn.RemoteAddr())
+	logVerbose("New connection", "address", conn.RemoteAddr().String())
+	stats.totalConnections.Add(1)
+	stats.connectedClients.Add(1)
+	defer stats.connectedClients.Add(-1)
 
-	reader := bufio.NewReader(conn)
+	reader := bufio.NewReader(statsReader{conn})
+	client := clients.Add(conn, reader)
+	defer clients.Remove(client)
+	defer monitors.Remove(client)
+	defer replication.RemoveReplica(client)
+	if err := client.tlsHandshake(conn); err != nil {
+		logVerbose("Error accepting a TLS connection", "client", client.id, "error", err)
+		return
+	}
 
 	for {
+		// Close clients idle for longer than the timeout setting
+		if timeout := currentConfig().Timeout; timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+		}
+
 		// Parse RESP message
 		value, err := ParseRESP(reader)
 		if err != nil {
-			log.Printf("Error reading from connection: %v", err)
+			logVerbose("Error reading from connection", "client", client.id, "error", err)
 			return
 		}
 
@@ -56,103 +208,633 @@ func handleConnection(conn net.Conn) {
 		if value.Type == Array && len(value.Array) > 0 {
 			command := strings.ToUpper(value.Array[0].Str)
 			args := value.Array[1:]
-			log.Printf("Received command: %s, args: %v", command, args)
-
-			var response *RESPValue
+			if logEnabled(LevelDebug) {
+				logDebug("Received command", "client", client.id, "args", redactArgs(argStrings(value.Array)))
+			}
+			stats.totalCommands.Add(1)
 
-			switch command {
-			case "PING":
-				if len(args) == 0 {
-					response = &RESPValue{
-						Type: SimpleString,
-						Str:  "PONG",
-					}
-				} else {
-					// Echo back the first argument
-					response = &RESPValue{
-						Type: SimpleString,
-						Str:  args[0].Str,
-					}
-				}
-			case "ECHO":
-				if len(args) != 1 {
-					response = &RESPValue{
-						Type: Error,
-						Str:  "ERR wrong number of arguments for 'ECHO' command",
-					}
+			// Count the command until its reply is written, so SHUTDOWN can wait for it
+			shutdown.inFlight.Add(1)
+			start := time.Now()
+			response := client.run(command, args)
+			if client.called != nil {
+				duration := time.Since(start)
+				client.called.stats.record(duration, client.failed)
+				slowlog.Add(client, value.Array, duration)
+				if client.called.hasFlag("fast") {
+					latencyMonitor.AddSampleIfNeeded("fast-command", duration)
 				} else {
-					response = &RESPValue{
-						Type: BulkString,
-						Str:  args[0].Str,
-					}
+					latencyMonitor.AddSampleIfNeeded("command", duration)
 				}
-			case "SET":
-				if len(args) != 2 {
-					response = &RESPValue{
-						Type: Error,
-						Str:  "ERR wrong number of arguments for 'SET' command",
-					}
-				} else {
-					storage.Set(args[0].Str, args[1].Str)
-					response = &RESPValue{
-						Type: SimpleString,
-						Str:  "OK",
-					}
+				if !client.called.hasFlag("admin") {
+					monitors.Feed(client, value.Array)
 				}
-			case "GET":
-				if len(args) != 1 {
-					response = &RESPValue{
-						Type: Error,
-						Str:  "ERR wrong number of arguments for 'GET' command",
-					}
-				} else {
-					if value, exists := storage.Get(args[0].Str); exists {
-						log.Printf("GET %s: found value %s", args[0].Str, value)
-						response = &RESPValue{
-							Type: BulkString,
-							Str:  value,
-						}
-					} else {
-						log.Printf("GET %s: key not found", args[0].Str)
-						response = &RESPValue{
-							Type:   BulkString,
-							IsNull: true,
-						}
-					}
+			} else if spec := resolveCommand(command, args); spec != nil {
+				spec.stats.rejectedCalls.Add(1)
+			}
+
+			if response != nil {
+				if logEnabled(LevelDebug) {
+					logDebug("Sending response", "client", client.id, "response", response.String())
 				}
-			case "DEL":
-				if len(args) < 1 {
-					response = &RESPValue{
-						Type: Error,
-						Str:  "ERR wrong number of arguments for 'DEL' command",
-					}
-				} else {
-					// Extract keys from arguments
-					keys := make([]string, len(args))
-					for i, arg := range args {
-						keys[i] = arg.Str
-					}
-
-					// Delete keys and get count of deleted keys
-					deleted := storage.Del(keys...)
-					response = &RESPValue{
-						Type: Integer,
-						Int:  deleted,
-					}
+				_, err = client.write(response.Serialize())
+			}
+			shutdown.inFlight.Add(-1)
+			if err != nil {
+				logVerbose("Error writing response", "client", client.id, "error", err)
+				return
+			}
+			if client.monitor {
+				// The feed starts once the reply to MONITOR is out
+				monitors.Add(client)
+			}
+			if client.replica != nil {
+				// Likewise the snapshot follows the reply to PSYNC
+				replication.Attach(client)
+			}
+			if client.closeAfterReply {
+				return
+			}
+		}
+	}
+}
+
+// execute checks a command against the command table and runs it
+func (c *Client) execute(command string, args []RESPValue) *RESPValue {
+	c.called = nil
+	spec := lookupCommand(command)
+	if spec == nil {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown command '%s'", command),
+		}
+	}
+	if !spec.CheckArity(len(args) + 1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for '%s' command", command),
+		}
+	}
+	if c.authRequired() && !spec.hasFlag("no_auth") {
+		return &RESPValue{Type: Error, Str: ErrNoAuth.Error()}
+	}
+
+	// Container commands like CONFIG are described by their subcommand.
+	// The master of a replica may run anything.
+	spec = resolveCommand(command, args)
+	if !c.master {
+		if denied := c.checkPermissions(spec, command, args); denied != nil {
+			return denied
+		}
+	}
+	c.touch(spec.Name)
+	if !c.master {
+		// The stream of the master waits for a pause before holding writeMu
+		pause.Wait(spec)
+	}
+
+	// Evict keys before running any command, but only refuse the commands
+	// that may grow the dataset if it still doesn't fit. Replicas leave
+	// eviction to their master, which streams the deletes.
+	replica := replication.IsReplica()
+	if !c.master && !replica {
+		if err := performEvictions(); err != nil && spec.hasFlag("denyoom") {
+			return &RESPValue{Type: Error, Str: err.Error()}
+		}
+	}
+	if spec.hasFlag("write") && !c.master && replica && currentConfig().ReplicaReadOnly {
+		return &RESPValue{Type: Error, Str: ErrReadOnly.Error()}
+	}
+
+	c.called = spec
+	if spec.hasFlag("write") && !c.master {
+		// Writes fed to the stream run one at a time, so the replicas
+		// apply them in the same order
+		propagate := replication.beginWrite()
+		defer replication.endWrite(propagate)
+		if propagate {
+			db := c.storage.index
+			response := c.call(command, args)
+			if response == nil || response.Type != Error {
+				replication.Propagate(db, append([]string{command}, argStrings(args)...))
+			}
+			return response
+		}
+	}
+	return c.call(command, args)
+}
+
+// call runs a command that passed every check
+func (c *Client) call(command string, args []RESPValue) *RESPValue {
+	storage := c.storage
+	var response *RESPValue
+	switch command {
+	case "PING":
+		if len(args) == 0 {
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  "PONG",
+			}
+		} else {
+			// Echo back the first argument
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  args[0].Str,
+			}
+		}
+	case "ECHO":
+		response = &RESPValue{
+			Type: BulkString,
+			Str:  args[0].Str,
+		}
+	case "SET":
+		storage.Set(args[0].Str, args[1].Str)
+		response = &RESPValue{
+			Type: SimpleString,
+			Str:  "OK",
+		}
+	case "GET":
+		if valueType, exists := storage.Type(args[0].Str); exists && valueType != StringValue {
+			response = &RESPValue{
+				Type: Error,
+				Str:  ErrWrongType.Error(),
+			}
+		} else if value, exists := storage.Get(args[0].Str); exists {
+			response = &RESPValue{
+				Type: BulkString,
+				Str:  value,
+			}
+		} else {
+			response = &RESPValue{
+				Type:   BulkString,
+				IsNull: true,
+			}
+		}
+	case "DEL":
+		// Extract keys from arguments
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = arg.Str
+		}
+
+		// Delete keys and get count of deleted keys
+		deleted := storage.Del(keys...)
+		response = &RESPValue{
+			Type: Integer,
+			Int:  deleted,
+		}
+	case "UNLINK":
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = arg.Str
+		}
+
+		// Large values are freed in the background
+		unlinked := storage.Unlink(keys...)
+		response = &RESPValue{
+			Type: Integer,
+			Int:  unlinked,
+		}
+	case "EXISTS", "TOUCH":
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = arg.Str
+		}
+
+		var count int64
+		if command == "EXISTS" {
+			count = storage.Exists(keys...)
+		} else {
+			count = storage.Touch(keys...)
+		}
+		response = &RESPValue{
+			Type: Integer,
+			Int:  count,
+		}
+	case "TYPE":
+		typeName := "none"
+		if valueType, exists := storage.Type(args[0].Str); exists {
+			typeName = valueType.String()
+		}
+		response = &RESPValue{
+			Type: SimpleString,
+			Str:  typeName,
+		}
+	case "RENAME", "RENAMENX":
+		nx := command == "RENAMENX"
+		renamed, err := storage.Rename(args[0].Str, args[1].Str, nx)
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else if !nx {
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  "OK",
+			}
+		} else {
+			response = &RESPValue{
+				Type: Integer,
+				Int:  boolToInt(renamed),
+			}
+		}
+	case "COPY":
+		replace := false
+		dst := storage
+		var err error
+		for i := 2; i < len(args) && err == nil; i++ {
+			switch option := strings.ToUpper(args[i].Str); {
+			case option == "REPLACE":
+				replace = true
+			case option == "DB" && i+1 < len(args):
+				var index int
+				if index, err = ParseDBIndex(args[i+1].Str); err == nil {
+					dst = databases[index]
 				}
+				i++
 			default:
-				response = &RESPValue{
-					Type: Error,
-					Str:  fmt.Sprintf("ERR unknown command '%s'", command),
+				err = ErrSyntax
+			}
+		}
+
+		var copied bool
+		if err == nil {
+			copied, err = storage.CopyTo(args[0].Str, dst, args[1].Str, replace)
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{
+				Type: Integer,
+				Int:  boolToInt(copied),
+			}
+		}
+	case "RANDOMKEY":
+		if key, exists := storage.RandomKey(); exists {
+			response = &RESPValue{
+				Type: BulkString,
+				Str:  key,
+			}
+		} else {
+			response = &RESPValue{
+				Type:   BulkString,
+				IsNull: true,
+			}
+		}
+	case "KEYS":
+		keys := storage.Keys(args[0].Str)
+		response = &RESPValue{Type: Array, Array: make([]RESPValue, len(keys))}
+		for i, key := range keys {
+			response.Array[i] = RESPValue{Type: BulkString, Str: key}
+		}
+	case "SCAN":
+		options := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			options[i] = arg.Str
+		}
+
+		cursor, err := ParseScanCursor(args[0].Str)
+		var scanOptions *ScanOptions
+		if err == nil {
+			scanOptions, err = ParseScanOptions(options, true)
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = ScanReply(storage.Scan(cursor, scanOptions))
+		}
+	case "ZSCAN", "HSCAN", "SSCAN":
+		options := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			options[i] = arg.Str
+		}
+
+		cursor, err := ParseScanCursor(args[1].Str)
+		var scanOptions *ScanOptions
+		if err == nil {
+			scanOptions, err = ParseScanOptions(options, false)
+		}
+
+		var elements []string
+		if err == nil {
+			if command == "ZSCAN" {
+				cursor, elements, err = storage.ZScan(args[0].Str, cursor, scanOptions)
+			} else {
+				cursor, elements, err = storage.ScanMissingType(args[0].Str)
+			}
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = ScanReply(cursor, elements)
+		}
+	case "AUTH":
+		response = c.handleAuth(args)
+	case "HELLO":
+		response = c.handleHello(args)
+	case "QUIT":
+		c.closeAfterReply = true
+		response = okReply()
+	case "SELECT":
+		if index, err := ParseDBIndex(args[0].Str); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			c.selectDatabase(databases[index])
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  "OK",
+			}
+		}
+	case "MOVE":
+		index, err := ParseDBIndex(args[1].Str)
+		var moved bool
+		if err == nil {
+			moved, err = storage.Move(args[0].Str, databases[index])
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{
+				Type: Integer,
+				Int:  boolToInt(moved),
+			}
+		}
+	case "SWAPDB":
+		first, err := ParseDBIndex(args[0].Str)
+		var second int
+		if err == nil {
+			second, err = ParseDBIndex(args[1].Str)
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			databases[first].Swap(databases[second])
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  "OK",
+			}
+		}
+	case "FLUSHDB", "FLUSHALL":
+		if async, err := parseFlushMode(args); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			if command == "FLUSHDB" {
+				storage.Flush(async)
+			} else {
+				FlushAll(async)
+			}
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  "OK",
+			}
+		}
+	case "DBSIZE":
+		response = &RESPValue{
+			Type: Integer,
+			Int:  int64(storage.Len()),
+		}
+	case "CONFIG":
+		response = handleConfig(args)
+	case "INFO":
+		response = handleInfo(args)
+	case "COMMAND":
+		response = handleCommand(args)
+	case "CLIENT":
+		response = c.handleClient(args)
+	case "ACL":
+		response = c.handleACL(args)
+	case "MEMORY":
+		response = c.handleMemory(args)
+	case "OBJECT":
+		response = c.handleObject(args)
+	case "SLOWLOG":
+		response = handleSlowlog(args)
+	case "LATENCY":
+		response = handleLatency(args)
+	case "MONITOR":
+		response = c.handleMonitor()
+	case "REPLICAOF", "SLAVEOF":
+		response = handleReplicaof(args)
+	case "ROLE":
+		response = handleRole()
+	case "REPLCONF":
+		response = c.handleReplconf(args)
+	case "PSYNC", "SYNC":
+		response = c.handlePsync(command, args)
+	case "SHUTDOWN":
+		response = handleShutdown(args)
+	case "PFADD":
+		elements := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			elements[i] = arg.Str
+		}
+
+		if updated, err := storage.PFAdd(args[0].Str, elements...); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{
+				Type: Integer,
+				Int:  updated,
+			}
+		}
+	case "PFCOUNT":
+		keys := make([]string, len(args))
+		for i, arg := range args {
+			keys[i] = arg.Str
+		}
+
+		if count, err := storage.PFCount(keys...); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{
+				Type: Integer,
+				Int:  count,
+			}
+		}
+	case "PFMERGE":
+		sources := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			sources[i] = arg.Str
+		}
+
+		if err := storage.PFMerge(args[0].Str, sources...); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{
+				Type: SimpleString,
+				Str:  "OK",
+			}
+		}
+	case "GEOADD":
+		response = handleGeoAdd(storage, args)
+	case "GEOPOS":
+		members := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			members[i] = arg.Str
+		}
+
+		if positions, err := storage.GeoPos(args[0].Str, members...); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{Type: Array, Array: []RESPValue{}}
+			for _, position := range positions {
+				if position == nil {
+					response.Array = append(response.Array, RESPValue{Type: Array, IsNull: true})
+					continue
 				}
+				response.Array = append(response.Array, RESPValue{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: FormatGeoCoordinate(position[0])},
+					{Type: BulkString, Str: FormatGeoCoordinate(position[1])},
+				}})
 			}
+		}
+	case "GEODIST":
+		conversion := 1.0
+		var err error
+		if len(args) > 4 {
+			err = ErrSyntax
+		} else if len(args) == 4 {
+			conversion, err = geoUnitConversion(args[3].Str)
+		}
 
-			log.Printf("Sending response: %v", response)
-			_, err = conn.Write(response.Serialize())
-			if err != nil {
-				log.Printf("Error writing response: %v", err)
-				return
+		var distance float64
+		var exists bool
+		if err == nil {
+			distance, exists, err = storage.GeoDist(args[0].Str, args[1].Str, args[2].Str)
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
 			}
+		} else if !exists {
+			response = &RESPValue{
+				Type:   BulkString,
+				IsNull: true,
+			}
+		} else {
+			response = &RESPValue{
+				Type: BulkString,
+				Str:  FormatGeoDistance(distance / conversion),
+			}
+		}
+	case "GEOHASH":
+		members := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			members[i] = arg.Str
+		}
+
+		if hashes, err := storage.GeoHash(args[0].Str, members...); err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{Type: Array, Array: []RESPValue{}}
+			for _, hash := range hashes {
+				response.Array = append(response.Array, RESPValue{
+					Type:   BulkString,
+					Str:    hash,
+					IsNull: hash == "",
+				})
+			}
+		}
+	case "GEOSEARCH":
+		options := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			options[i] = arg.Str
+		}
+
+		query, err := ParseGeoSearch(command, options, false)
+		var points []GeoPoint
+		if err == nil {
+			points, err = storage.GeoSearch(args[0].Str, query)
+		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = GeoSearchReply(points, query)
+		}
+	case "GEOSEARCHSTORE":
+		options := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			options[i] = arg.Str
+		}
+
+		query, err := ParseGeoSearch(command, options, true)
+		var stored int64
+		if err == nil {
+			stored, err = storage.GeoSearchStore(args[0].Str, args[1].Str, query)
 		}
+
+		if err != nil {
+			response = &RESPValue{
+				Type: Error,
+				Str:  err.Error(),
+			}
+		} else {
+			response = &RESPValue{
+				Type: Integer,
+				Int:  stored,
+			}
+		}
+	default:
+		response = &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown command '%s'", command),
+		}
+	}
+	return response
+}
+
+// boolToInt converts a boolean reply to the 0/1 integer Redis uses
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
 	}
+	return 0
 }
@@ -4,16 +4,70 @@ import (
 	"bufio"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-func TestServerStartup(t *testing.T) {
-	// Start server in a goroutine
-	go main()
+var serverOnce sync.Once
+
+// startTestServer starts the server once for all tests that need it
+func startTestServer() {
+	serverOnce.Do(func() {
+		// Start server in a goroutine
+		go main()
+
+		// Give the server a moment to start
+		time.Sleep(100 * time.Millisecond)
+	})
+}
+
+// sendCommand sends a command as a RESP array of bulk strings on a new
+// connection and returns the parsed response
+func sendCommand(t *testing.T, args ...string) *RESPValue {
+	t.Helper()
+	return sendCommands(t, args)[0]
+}
+
+// sendCommands sends several commands in order on a single connection, for
+// commands with per connection state like SELECT, and returns the responses
+func sendCommands(t *testing.T, commands ...[]string) []*RESPValue {
+	t.Helper()
+	startTestServer()
+
+	conn, err := net.Dial(PROTOCOL, fmt.Sprintf(":%d", DEFAULT_PORT))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	responses := make([]*RESPValue, len(commands))
+	for i, args := range commands {
+		command := RESPValue{Type: Array}
+		for _, arg := range args {
+			command.Array = append(command.Array, RESPValue{Type: BulkString, Str: arg})
+		}
+		if _, err := conn.Write(command.Serialize()); err != nil {
+			t.Fatalf("Failed to send command: %v", err)
+		}
+
+		responses[i], err = ParseRESP(reader)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+	}
+	return responses
+}
 
-	// Give the server a moment to start
-	time.Sleep(100 * time.Millisecond)
+func TestServerStartup(t *testing.T) {
+	startTestServer()
 
 	tests := []struct {
 		name         string
@@ -174,3 +228,1175 @@ func TestServerStartup(t *testing.T) {
 		})
 	}
 }
+
+func TestHyperLogLogCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected RESPValue
+	}{
+		{
+			name:     "PFADD without arguments",
+			args:     []string{"PFADD"},
+			expected: RESPValue{Type: Error, Str: "ERR wrong number of arguments for 'PFADD' command"},
+		},
+		{
+			name:     "PFADD new key",
+			args:     []string{"PFADD", "hll1", "a", "b", "c"},
+			expected: RESPValue{Type: Integer, Int: 1},
+		},
+		{
+			name:     "PFADD existing elements",
+			args:     []string{"PFADD", "hll1", "a", "b"},
+			expected: RESPValue{Type: Integer, Int: 0},
+		},
+		{
+			name:     "PFADD second key",
+			args:     []string{"PFADD", "hll2", "c", "d"},
+			expected: RESPValue{Type: Integer, Int: 1},
+		},
+		{
+			name:     "PFCOUNT single key",
+			args:     []string{"PFCOUNT", "hll1"},
+			expected: RESPValue{Type: Integer, Int: 3},
+		},
+		{
+			name:     "PFCOUNT union",
+			args:     []string{"PFCOUNT", "hll1", "hll2"},
+			expected: RESPValue{Type: Integer, Int: 4},
+		},
+		{
+			name:     "PFMERGE",
+			args:     []string{"PFMERGE", "hll3", "hll1", "hll2"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name:     "PFCOUNT merged key",
+			args:     []string{"PFCOUNT", "hll3"},
+			expected: RESPValue{Type: Integer, Int: 4},
+		},
+		{
+			name:     "SET plain string",
+			args:     []string{"SET", "hllstring", "value"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name:     "PFADD on a plain string",
+			args:     []string{"PFADD", "hllstring", "a"},
+			expected: RESPValue{Type: Error, Str: "WRONGTYPE Key is not a valid HyperLogLog string value."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendCommand(t, tt.args...)
+			if !reflect.DeepEqual(*resp, tt.expected) {
+				t.Errorf("Expected %v, got %v", &tt.expected, resp)
+			}
+		})
+	}
+}
+
+func TestGeoCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected RESPValue
+	}{
+		{
+			name:     "GEOADD",
+			args:     []string{"GEOADD", "geo", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"},
+			expected: RESPValue{Type: Integer, Int: 2},
+		},
+		{
+			name:     "GEOADD invalid coordinates",
+			args:     []string{"GEOADD", "geo", "13.361389", "90", "North"},
+			expected: RESPValue{Type: Error, Str: "ERR invalid longitude,latitude pair 13.361389,90.000000"},
+		},
+		{
+			name:     "GEODIST in km",
+			args:     []string{"GEODIST", "geo", "Palermo", "Catania", "km"},
+			expected: RESPValue{Type: BulkString, Str: "166.2742"},
+		},
+		{
+			name:     "GEODIST missing member",
+			args:     []string{"GEODIST", "geo", "Palermo", "missing"},
+			expected: RESPValue{Type: BulkString, IsNull: true},
+		},
+		{
+			name:     "GEODIST extra argument",
+			args:     []string{"GEODIST", "geo", "Palermo", "Catania", "km", "km"},
+			expected: RESPValue{Type: Error, Str: "ERR syntax error"},
+		},
+		{
+			name: "GEOPOS",
+			args: []string{"GEOPOS", "geo", "Palermo", "missing"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: "13.36138933897018433"},
+					{Type: BulkString, Str: "38.11555639549629859"},
+				}},
+				{Type: Array, IsNull: true},
+			}},
+		},
+		{
+			name: "GEOHASH",
+			args: []string{"GEOHASH", "geo", "Palermo", "Catania"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "sqc8b49rny0"},
+				{Type: BulkString, Str: "sqdtr74hyu0"},
+			}},
+		},
+		{
+			name: "GEOSEARCH WITHDIST",
+			args: []string{"GEOSEARCH", "geo", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km", "WITHDIST"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: "Catania"},
+					{Type: BulkString, Str: "56.4413"},
+				}},
+			}},
+		},
+		{
+			name:     "GEOSEARCHSTORE",
+			args:     []string{"GEOSEARCHSTORE", "geo2", "geo", "FROMMEMBER", "Palermo", "BYBOX", "400", "400", "km"},
+			expected: RESPValue{Type: Integer, Int: 2},
+		},
+		{
+			name:     "GET on a geo index",
+			args:     []string{"GET", "geo"},
+			expected: RESPValue{Type: Error, Str: "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendCommand(t, tt.args...)
+			if !reflect.DeepEqual(*resp, tt.expected) {
+				t.Errorf("Expected %v, got %v", &tt.expected, resp)
+			}
+		})
+	}
+}
+
+func TestKeyspaceCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected RESPValue
+	}{
+		{
+			name:     "SET ks1",
+			args:     []string{"SET", "ks1", "value1"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name:     "EXISTS",
+			args:     []string{"EXISTS", "ks1", "ks1", "ksmissing"},
+			expected: RESPValue{Type: Integer, Int: 2},
+		},
+		{
+			name:     "TYPE string",
+			args:     []string{"TYPE", "ks1"},
+			expected: RESPValue{Type: SimpleString, Str: "string"},
+		},
+		{
+			name:     "TYPE missing",
+			args:     []string{"TYPE", "ksmissing"},
+			expected: RESPValue{Type: SimpleString, Str: "none"},
+		},
+		{
+			name:     "RENAME",
+			args:     []string{"RENAME", "ks1", "ks2"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name:     "RENAME missing key",
+			args:     []string{"RENAME", "ksmissing", "ks3"},
+			expected: RESPValue{Type: Error, Str: "ERR no such key"},
+		},
+		{
+			name:     "COPY",
+			args:     []string{"COPY", "ks2", "ks3"},
+			expected: RESPValue{Type: Integer, Int: 1},
+		},
+		{
+			name:     "COPY existing destination",
+			args:     []string{"COPY", "ks2", "ks3"},
+			expected: RESPValue{Type: Integer, Int: 0},
+		},
+		{
+			name:     "COPY to a database out of range",
+			args:     []string{"COPY", "ks2", "ks3", "DB", "16"},
+			expected: RESPValue{Type: Error, Str: "ERR DB index is out of range"},
+		},
+		{
+			name:     "RENAMENX existing destination",
+			args:     []string{"RENAMENX", "ks2", "ks3"},
+			expected: RESPValue{Type: Integer, Int: 0},
+		},
+		{
+			name:     "TOUCH",
+			args:     []string{"TOUCH", "ks2", "ks3", "ksmissing"},
+			expected: RESPValue{Type: Integer, Int: 2},
+		},
+		{
+			name:     "UNLINK",
+			args:     []string{"UNLINK", "ks2", "ks3", "ksmissing"},
+			expected: RESPValue{Type: Integer, Int: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendCommand(t, tt.args...)
+			if !reflect.DeepEqual(*resp, tt.expected) {
+				t.Errorf("Expected %v, got %v", &tt.expected, resp)
+			}
+		})
+	}
+}
+
+func TestScanCommands(t *testing.T) {
+	sendCommand(t, "SET", "scan:key", "value")
+	sendCommand(t, "GEOADD", "scan:zset", "1", "1", "member")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected RESPValue
+	}{
+		{
+			name: "KEYS",
+			args: []string{"KEYS", "scan:k*"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "scan:key"},
+			}},
+		},
+		{
+			name:     "SCAN invalid cursor",
+			args:     []string{"SCAN", "abc"},
+			expected: RESPValue{Type: Error, Str: "ERR invalid cursor"},
+		},
+		{
+			name:     "SCAN unknown type",
+			args:     []string{"SCAN", "0", "TYPE", "foo"},
+			expected: RESPValue{Type: Error, Str: "ERR unknown type name 'foo'"},
+		},
+		{
+			name: "ZSCAN",
+			args: []string{"ZSCAN", "scan:zset", "0"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "0"},
+				{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: "member"},
+					{Type: BulkString, Str: "3377822707026402"},
+				}},
+			}},
+		},
+		{
+			name:     "HSCAN on a string",
+			args:     []string{"HSCAN", "scan:key", "0"},
+			expected: RESPValue{Type: Error, Str: "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		},
+		{
+			name: "SSCAN missing key",
+			args: []string{"SSCAN", "scan:missing", "0"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "0"},
+				{Type: Array, Array: []RESPValue{}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendCommand(t, tt.args...)
+			if !reflect.DeepEqual(*resp, tt.expected) {
+				t.Errorf("Expected %v, got %v", &tt.expected, resp)
+			}
+		})
+	}
+}
+
+func TestDatabaseCommands(t *testing.T) {
+	ok := RESPValue{Type: SimpleString, Str: "OK"}
+	steps := []struct {
+		args     []string
+		expected RESPValue
+	}{
+		{[]string{"SELECT", "8"}, ok},
+		{[]string{"FLUSHDB"}, ok},
+		{[]string{"SET", "db:key", "eight"}, ok},
+		{[]string{"DBSIZE"}, RESPValue{Type: Integer, Int: 1}},
+		{[]string{"MOVE", "db:key", "9"}, RESPValue{Type: Integer, Int: 1}},
+		{[]string{"MOVE", "db:key", "9"}, RESPValue{Type: Integer, Int: 0}},
+		{[]string{"MOVE", "db:key", "8"}, RESPValue{Type: Error, Str: "ERR source and destination objects are the same"}},
+		{[]string{"DBSIZE"}, RESPValue{Type: Integer, Int: 0}},
+		{[]string{"SELECT", "9"}, ok},
+		{[]string{"GET", "db:key"}, RESPValue{Type: BulkString, Str: "eight"}},
+		{[]string{"COPY", "db:key", "db:copy", "DB", "8"}, RESPValue{Type: Integer, Int: 1}},
+		{[]string{"SWAPDB", "8", "9"}, ok},
+		{[]string{"EXISTS", "db:key", "db:copy"}, RESPValue{Type: Integer, Int: 1}},
+		{[]string{"GET", "db:copy"}, RESPValue{Type: BulkString, Str: "eight"}},
+		{[]string{"FLUSHDB", "ASYNC"}, ok},
+		{[]string{"DBSIZE"}, RESPValue{Type: Integer, Int: 0}},
+		{[]string{"FLUSHDB", "LATER"}, RESPValue{Type: Error, Str: "ERR syntax error"}},
+		{[]string{"FLUSHDB", "ASYNC", "SYNC"}, RESPValue{Type: Error, Str: "ERR syntax error"}},
+		{[]string{"SELECT", "16"}, RESPValue{Type: Error, Str: "ERR DB index is out of range"}},
+		{[]string{"SELECT", "one"}, RESPValue{Type: Error, Str: "ERR value is not an integer or out of range"}},
+		{[]string{"SWAPDB", "8", "-1"}, RESPValue{Type: Error, Str: "ERR DB index is out of range"}},
+		{[]string{"SELECT", "8"}, ok},
+		{[]string{"FLUSHALL", "SYNC"}, ok},
+		{[]string{"DBSIZE"}, RESPValue{Type: Integer, Int: 0}},
+	}
+
+	commands := make([][]string, len(steps))
+	for i, step := range steps {
+		commands[i] = step.args
+	}
+	responses := sendCommands(t, commands...)
+	for i, step := range steps {
+		if !reflect.DeepEqual(*responses[i], step.expected) {
+			t.Errorf("%v: expected %v, got %v", step.args, &step.expected, responses[i])
+		}
+	}
+
+	// A new connection starts on database 0
+	if resp := sendCommand(t, "GET", "db:key"); !resp.IsNull {
+		t.Errorf("Expected db:key to be missing from database 0, got %v", resp)
+	}
+}
+
+func TestConfigCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected RESPValue
+	}{
+		{
+			name:     "CONFIG SET",
+			args:     []string{"CONFIG", "SET", "timeout", "0", "maxmemory-policy", "allkeys-lru"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name: "CONFIG GET pattern",
+			args: []string{"CONFIG", "GET", "maxmemory-*", "timeout"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "maxmemory-policy"},
+				{Type: BulkString, Str: "allkeys-lru"},
+				{Type: BulkString, Str: "maxmemory-samples"},
+				{Type: BulkString, Str: "5"},
+				{Type: BulkString, Str: "timeout"},
+				{Type: BulkString, Str: "0"},
+			}},
+		},
+		{
+			name:     "CONFIG SET invalid value",
+			args:     []string{"CONFIG", "SET", "maxmemory-policy", "sometimes"},
+			expected: RESPValue{Type: Error, Str: "ERR CONFIG SET failed (possibly related to argument 'maxmemory-policy') - argument(s) must be one of the following: volatile-lru, volatile-lfu, volatile-random, volatile-ttl, allkeys-lru, allkeys-lfu, allkeys-random, noeviction"},
+		},
+		{
+			name:     "CONFIG SET restore",
+			args:     []string{"CONFIG", "SET", "maxmemory-policy", "noeviction"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name:     "CONFIG SET odd arguments",
+			args:     []string{"CONFIG", "SET", "timeout"},
+			expected: RESPValue{Type: Error, Str: "ERR wrong number of arguments for 'CONFIG|SET' command"},
+		},
+		{
+			name:     "CONFIG RESETSTAT",
+			args:     []string{"CONFIG", "RESETSTAT"},
+			expected: RESPValue{Type: SimpleString, Str: "OK"},
+		},
+		{
+			name:     "CONFIG REWRITE without a config file",
+			args:     []string{"CONFIG", "REWRITE"},
+			expected: RESPValue{Type: Error, Str: "ERR The server is running without a config file"},
+		},
+		{
+			name:     "CONFIG unknown subcommand",
+			args:     []string{"CONFIG", "FETCH"},
+			expected: RESPValue{Type: Error, Str: "ERR unknown subcommand 'FETCH'. Try CONFIG HELP."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendCommand(t, tt.args...)
+			if !reflect.DeepEqual(*resp, tt.expected) {
+				t.Errorf("Expected %v, got %v", &tt.expected, resp)
+			}
+		})
+	}
+}
+
+// infoField returns the value of a field in an INFO reply
+func infoField(t *testing.T, info *RESPValue, field string) string {
+	t.Helper()
+	for _, line := range strings.Split(info.Str, "\r\n") {
+		if value, found := strings.CutPrefix(line, field+":"); found {
+			return value
+		}
+	}
+	t.Fatalf("Field %s not found in INFO", field)
+	return ""
+}
+
+func TestInfoCommand(t *testing.T) {
+	sendCommand(t, "SET", "info:key", "value")
+
+	before := sendCommand(t, "INFO", "stats")
+	sendCommand(t, "GET", "info:key")
+	sendCommand(t, "GET", "info:missing")
+	after := sendCommand(t, "INFO", "stats")
+
+	for _, field := range []string{"keyspace_hits", "keyspace_misses"} {
+		previous, _ := strconv.Atoi(infoField(t, before, field))
+		current, _ := strconv.Atoi(infoField(t, after, field))
+		if current != previous+1 {
+			t.Errorf("Expected %s to go from %d to %d, got %d", field, previous, previous+1, current)
+		}
+	}
+	if commands, _ := strconv.Atoi(infoField(t, after, "total_commands_processed")); commands < 4 {
+		t.Errorf("Expected at least 4 commands processed, got %d", commands)
+	}
+	if strings.Contains(after.Str, "# Server") {
+		t.Error("Expected only the stats section")
+	}
+
+	// The INFO connection itself is connected
+	clients := sendCommand(t, "INFO", "clients")
+	if connected, _ := strconv.Atoi(infoField(t, clients, "connected_clients")); connected < 1 {
+		t.Errorf("Expected at least 1 connected client, got %d", connected)
+	}
+
+	keyspace := sendCommand(t, "INFO", "keyspace")
+	if !strings.HasPrefix(keyspace.Str, "# Keyspace\r\ndb0:keys=") {
+		t.Errorf("Unexpected keyspace section %q", keyspace.Str)
+	}
+}
+
+func TestCommandCommand(t *testing.T) {
+	count := sendCommand(t, "COMMAND", "COUNT")
+	if count.Type != Integer || count.Int != int64(len(commandTable)) {
+		t.Errorf("Expected COMMAND COUNT %d, got %v", len(commandTable), count)
+	}
+
+	info := sendCommand(t, "COMMAND", "INFO", "get", "nosuch")
+	expected := RESPValue{Type: Array, Array: []RESPValue{
+		{Type: Array, Array: []RESPValue{
+			{Type: BulkString, Str: "get"},
+			{Type: Integer, Int: 2},
+			{Type: Array, Array: []RESPValue{{Type: SimpleString, Str: "readonly"}, {Type: SimpleString, Str: "fast"}}},
+			{Type: Integer, Int: 1},
+			{Type: Integer, Int: 1},
+			{Type: Integer, Int: 1},
+			{Type: Array, Array: []RESPValue{{Type: SimpleString, Str: "@read"}, {Type: SimpleString, Str: "@fast"}, {Type: SimpleString, Str: "@string"}}},
+			{Type: Array, Array: []RESPValue{}},
+			{Type: Array, Array: []RESPValue{
+				{Type: Array, Array: []RESPValue{
+					{Type: BulkString, Str: "flags"},
+					{Type: Array, Array: []RESPValue{{Type: SimpleString, Str: "RO"}, {Type: SimpleString, Str: "ACCESS"}}},
+					{Type: BulkString, Str: "begin_search"},
+					{Type: Array, Array: []RESPValue{
+						{Type: BulkString, Str: "type"},
+						{Type: BulkString, Str: "index"},
+						{Type: BulkString, Str: "spec"},
+						{Type: Array, Array: []RESPValue{{Type: BulkString, Str: "index"}, {Type: Integer, Int: 1}}},
+					}},
+					{Type: BulkString, Str: "find_keys"},
+					{Type: Array, Array: []RESPValue{
+						{Type: BulkString, Str: "type"},
+						{Type: BulkString, Str: "range"},
+						{Type: BulkString, Str: "spec"},
+						{Type: Array, Array: []RESPValue{
+							{Type: BulkString, Str: "lastkey"},
+							{Type: Integer, Int: 0},
+							{Type: BulkString, Str: "keystep"},
+							{Type: Integer, Int: 1},
+							{Type: BulkString, Str: "limit"},
+							{Type: Integer, Int: 0},
+						}},
+					}},
+				}},
+			}},
+			{Type: Array, Array: []RESPValue{}},
+		}},
+		{Type: Array, IsNull: true},
+	}}
+	if !reflect.DeepEqual(*info, expected) {
+		t.Errorf("Unexpected COMMAND INFO reply %v", info)
+	}
+
+	docs := sendCommand(t, "COMMAND", "DOCS", "echo")
+	expected = RESPValue{Type: Array, Array: []RESPValue{
+		{Type: BulkString, Str: "echo"},
+		{Type: Array, Array: []RESPValue{
+			{Type: BulkString, Str: "summary"},
+			{Type: BulkString, Str: "Returns the given string."},
+			{Type: BulkString, Str: "since"},
+			{Type: BulkString, Str: "1.0.0"},
+			{Type: BulkString, Str: "group"},
+			{Type: BulkString, Str: "connection"},
+			{Type: BulkString, Str: "complexity"},
+			{Type: BulkString, Str: "O(1)"},
+		}},
+	}}
+	if !reflect.DeepEqual(*docs, expected) {
+		t.Errorf("Unexpected COMMAND DOCS reply %v", docs)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected RESPValue
+	}{
+		{
+			name: "COMMAND GETKEYS",
+			args: []string{"COMMAND", "GETKEYS", "RENAME", "a", "b"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "a"},
+				{Type: BulkString, Str: "b"},
+			}},
+		},
+		{
+			name:     "COMMAND GETKEYS without keys",
+			args:     []string{"COMMAND", "GETKEYS", "PING"},
+			expected: RESPValue{Type: Error, Str: "ERR The command has no key arguments"},
+		},
+		{
+			name:     "COMMAND GETKEYS unknown command",
+			args:     []string{"COMMAND", "GETKEYS", "NOSUCH", "a"},
+			expected: RESPValue{Type: Error, Str: "ERR Invalid command specified"},
+		},
+		{
+			name: "COMMAND LIST FILTERBY PATTERN",
+			args: []string{"COMMAND", "LIST", "FILTERBY", "PATTERN", "geo*store"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "geosearchstore"},
+			}},
+		},
+		{
+			name: "COMMAND LIST FILTERBY ACLCAT",
+			args: []string{"COMMAND", "LIST", "FILTERBY", "ACLCAT", "hyperloglog"},
+			expected: RESPValue{Type: Array, Array: []RESPValue{
+				{Type: BulkString, Str: "pfadd"},
+				{Type: BulkString, Str: "pfcount"},
+				{Type: BulkString, Str: "pfmerge"},
+			}},
+		},
+		{
+			name:     "COMMAND GETKEYS arity",
+			args:     []string{"COMMAND", "GETKEYS"},
+			expected: RESPValue{Type: Error, Str: "ERR wrong number of arguments for 'COMMAND|GETKEYS' command"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := sendCommand(t, tt.args...)
+			if !reflect.DeepEqual(*resp, tt.expected) {
+				t.Errorf("Expected %v, got %v", &tt.expected, resp)
+			}
+		})
+	}
+}
+
+// dialTestServer opens a raw connection for tests that don't expect a reply
+// to every command
+func dialTestServer(t *testing.T) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	startTestServer()
+	conn, err := net.Dial(PROTOCOL, fmt.Sprintf(":%d", DEFAULT_PORT))
+	if err != nil {
+		t.Fatalf("Failed to connect to server: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, bufio.NewReader(conn)
+}
+
+// writeCommand sends a command as a RESP array of bulk strings
+func writeCommand(t *testing.T, conn net.Conn, args ...string) {
+	t.Helper()
+	command := RESPValue{Type: Array}
+	for _, arg := range args {
+		command.Array = append(command.Array, RESPValue{Type: BulkString, Str: arg})
+	}
+	if _, err := conn.Write(command.Serialize()); err != nil {
+		t.Fatalf("Failed to send command: %v", err)
+	}
+}
+
+func TestClientCommands(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"CLIENT", "GETNAME"},
+		[]string{"CLIENT", "SETNAME", "worker"},
+		[]string{"CLIENT", "GETNAME"},
+		[]string{"CLIENT", "SETNAME", "two words"},
+		[]string{"SELECT", "3"},
+		[]string{"CLIENT", "INFO"},
+		[]string{"CLIENT", "ID"},
+		[]string{"CLIENT", "LIST", "TYPE", "pubsub"},
+		[]string{"CLIENT", "NO-EVICT", "on"},
+		[]string{"CLIENT", "INFO"},
+		[]string{"CLIENT", "KILL", "1.2.3.4:5"},
+		[]string{"CLIENT", "PAUSE", "soon"},
+		[]string{"CLIENT", "NOSUCH"},
+	)
+	expected := []RESPValue{
+		{Type: BulkString, IsNull: true},
+		{Type: SimpleString, Str: "OK"},
+		{Type: BulkString, Str: "worker"},
+		{Type: Error, Str: "ERR Client names cannot contain spaces, newlines or special characters."},
+		{Type: SimpleString, Str: "OK"},
+	}
+	for i, want := range expected {
+		if !reflect.DeepEqual(*responses[i], want) {
+			t.Errorf("Response %d: expected %v, got %v", i, &want, responses[i])
+		}
+	}
+
+	info := responses[5].Str
+	id := responses[6].Int
+	for _, field := range []string{fmt.Sprintf("id=%d ", id), " name=worker ", " db=3 ", " flags=N ", " cmd=client|info "} {
+		if !strings.Contains(info, field) {
+			t.Errorf("Expected %q in CLIENT INFO %q", field, info)
+		}
+	}
+	if responses[7].Str != "" {
+		t.Errorf("Expected no pubsub clients, got %q", responses[7].Str)
+	}
+	if !strings.Contains(responses[9].Str, " flags=e ") {
+		t.Errorf("Expected the no-evict flag in %q", responses[9].Str)
+	}
+	for i, want := range []string{
+		"ERR No such client",
+		"ERR timeout is not an integer or out of range",
+		"ERR unknown subcommand 'NOSUCH'. Try CLIENT HELP.",
+	} {
+		if responses[10+i].Str != want {
+			t.Errorf("Expected %q, got %v", want, responses[10+i])
+		}
+	}
+}
+
+func TestClientListAndKill(t *testing.T) {
+	victim, victimReader := dialTestServer(t)
+	writeCommand(t, victim, "CLIENT", "ID")
+	idReply, err := ParseRESP(victimReader)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	id := strconv.FormatInt(idReply.Int, 10)
+
+	list := sendCommand(t, "CLIENT", "LIST", "ID", id)
+	if !strings.HasPrefix(list.Str, "id="+id+" ") || strings.Count(list.Str, "\n") != 1 {
+		t.Errorf("Expected only client %s in CLIENT LIST, got %q", id, list.Str)
+	}
+
+	if resp := sendCommand(t, "CLIENT", "KILL", "ID", id); resp.Type != Integer || resp.Int != 1 {
+		t.Errorf("Expected 1 client killed, got %v", resp)
+	}
+	victim.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ParseRESP(victimReader); err == nil {
+		t.Error("Expected the killed connection to be closed")
+	}
+
+	// Killing yourself closes the connection after the reply
+	responses := sendCommands(t, []string{"CLIENT", "KILL", "SKIPME", "no", "MAXAGE", "3600"})
+	if responses[0].Type != Integer {
+		t.Errorf("Expected an integer reply, got %v", responses[0])
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	conn, reader := dialTestServer(t)
+	writeCommand(t, conn, "CLIENT", "REPLY", "OFF")
+	writeCommand(t, conn, "PING", "silent")
+	writeCommand(t, conn, "CLIENT", "REPLY", "ON")
+	writeCommand(t, conn, "CLIENT", "REPLY", "SKIP")
+	writeCommand(t, conn, "PING", "skipped")
+	writeCommand(t, conn, "PING", "heard")
+
+	for _, expected := range []string{"OK", "heard"} {
+		resp, err := ParseRESP(reader)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		if resp.Str != expected {
+			t.Errorf("Expected %q, got %v", expected, resp)
+		}
+	}
+}
+
+func TestClientPauseWrite(t *testing.T) {
+	if resp := sendCommand(t, "CLIENT", "PAUSE", "10000", "WRITE"); resp.Str != "OK" {
+		t.Fatalf("Expected OK, got %v", resp)
+	}
+
+	start := time.Now()
+	sendCommand(t, "GET", "pause:key")
+	if time.Since(start) > time.Second {
+		t.Error("Expected reads to run during a write pause")
+	}
+
+	done := make(chan *RESPValue)
+	go func() {
+		done <- sendCommand(t, "SET", "pause:key", "value")
+	}()
+	select {
+	case resp := <-done:
+		t.Fatalf("Expected SET to wait for the pause, got %v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	sendCommand(t, "CLIENT", "UNPAUSE")
+	select {
+	case resp := <-done:
+		if resp.Str != "OK" {
+			t.Errorf("Expected OK, got %v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected SET to run after CLIENT UNPAUSE")
+	}
+}
+
+func TestAuthCommands(t *testing.T) {
+	responses := sendCommands(t, []string{"CONFIG", "SET", "requirepass", "secret"})
+	if responses[0].Str != "OK" {
+		t.Fatalf("Expected OK, got %v", responses[0])
+	}
+
+	// The connection above was authenticated before the password was set, new
+	// ones must authenticate
+	responses = sendCommands(t,
+		[]string{"PING"},
+		[]string{"AUTH", "wrong"},
+		[]string{"AUTH", "secret"},
+		[]string{"PING"},
+		[]string{"CONFIG", "SET", "requirepass", ""},
+	)
+	for i, want := range []string{ErrNoAuth.Error(), ErrWrongPass.Error(), "OK", "PONG", "OK"} {
+		if responses[i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", i, want, responses[i])
+		}
+	}
+
+	conn, reader := dialTestServer(t)
+	writeCommand(t, conn, "QUIT")
+	if resp, err := ParseRESP(reader); err != nil || resp.Str != "OK" {
+		t.Fatalf("Expected OK, got %v, %v", resp, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ParseRESP(reader); err == nil {
+		t.Error("Expected QUIT to close the connection")
+	}
+}
+
+func TestACLCommands(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"ACL", "SETUSER", "tenant", "on", ">pw", "~tenant:*", "+@read", "+set", "+acl|whoami"},
+		[]string{"ACL", "GETUSER", "tenant"},
+		[]string{"ACL", "DRYRUN", "tenant", "GET", "other"},
+		[]string{"ACL", "DRYRUN", "tenant", "SET", "tenant:1", "v"},
+		[]string{"ACL", "LOG", "RESET"},
+		[]string{"ACL", "WHOAMI"},
+		[]string{"ACL", "SETUSER", "tenant", "+nosuch"},
+	)
+	if responses[0].Str != "OK" {
+		t.Fatalf("Expected OK, got %v", responses[0])
+	}
+	defer sendCommand(t, "ACL", "DELUSER", "tenant")
+
+	getUser := responses[1].Array
+	if len(getUser) != 12 || getUser[5].Str != "-@all +@read +set +acl|whoami" || getUser[7].Str != "~tenant:*" {
+		t.Errorf("Unexpected ACL GETUSER reply %v", responses[1])
+	}
+	for i, want := range []string{
+		"No permissions to access the 'other' key",
+		"OK",
+		"OK",
+		"default",
+		"ERR Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL",
+	} {
+		if responses[2+i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", 2+i, want, responses[2+i])
+		}
+	}
+
+	conn, reader := dialTestServer(t)
+	for _, command := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"AUTH", "tenant", "pw"}, "OK"},
+		{[]string{"ACL", "WHOAMI"}, "tenant"},
+		{[]string{"SET", "tenant:1", "v"}, "OK"},
+		{[]string{"GET", "other"}, "NOPERM No permissions to access a key"},
+		{[]string{"DEL", "tenant:1"}, "NOPERM User tenant has no permissions to run the 'del' command"},
+	} {
+		writeCommand(t, conn, command.args...)
+		resp, err := ParseRESP(reader)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		if resp.Str != command.expected {
+			t.Errorf("%v: expected %q, got %v", command.args, command.expected, resp)
+		}
+	}
+
+	responses = sendCommands(t,
+		[]string{"ACL", "LOG"},
+		[]string{"CLIENT", "LIST"},
+		[]string{"ACL", "DELUSER", "tenant"},
+	)
+	log := responses[0].Array
+	if len(log) != 2 || log[0].Array[3].Str != "command" || log[0].Array[7].Str != "del" || log[1].Array[7].Str != "other" {
+		t.Errorf("Unexpected ACL LOG reply %v", responses[0])
+	}
+	if !strings.Contains(responses[1].Str, " user=tenant ") {
+		t.Errorf("Expected the tenant connection in CLIENT LIST, got %q", responses[1].Str)
+	}
+	if responses[2].Int != 1 {
+		t.Errorf("Expected 1 deleted user, got %v", responses[2])
+	}
+
+	// Deleting a user disconnects its clients
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := ParseRESP(reader); err == nil {
+		t.Error("Expected the tenant connection to be closed")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	startTestServer()
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(path, 0); err == nil {
+		t.Fatal("Expected a regular file not to be replaced")
+	}
+	os.Remove(path)
+
+	listener, err := listenUnix(path, 0700)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Expected the socket to have permissions 0700, got %v", info.Mode())
+	}
+	go acceptConnections(listener)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	writeCommand(t, conn, "CLIENT", "INFO")
+	resp, err := ParseRESP(reader)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if !strings.Contains(resp.Str, " addr="+path+":0 laddr="+path+":0 ") || !strings.Contains(resp.Str, " flags=U ") {
+		t.Errorf("Unexpected CLIENT INFO %q", resp.Str)
+	}
+
+	// Closing the listener removes the socket
+	listener.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"SET", "maxmemory:key", "value"},
+		[]string{"CONFIG", "SET", "maxmemory", "1", "maxmemory-policy", "noeviction"},
+		[]string{"SET", "maxmemory:key", "other"},
+		[]string{"GET", "maxmemory:key"},
+		[]string{"CONFIG", "SET", "maxmemory", "0"},
+		[]string{"SET", "maxmemory:key", "other"},
+		[]string{"INFO", "stats"},
+	)
+	expected := []string{"OK", "OK", "OOM command not allowed when used memory > 'maxmemory'.", "value", "OK", "OK"}
+	for i, want := range expected {
+		if responses[i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", i, want, responses[i])
+		}
+	}
+	if !strings.Contains(responses[6].Str, "evicted_keys:") {
+		t.Errorf("Expected evicted_keys in INFO stats, got %q", responses[6].Str)
+	}
+	sendCommand(t, "DEL", "maxmemory:key")
+}
+
+func TestMemoryCommands(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"SET", "memory:key", "value"},
+		[]string{"MEMORY", "USAGE", "memory:key"},
+		[]string{"MEMORY", "USAGE", "memory:missing"},
+		[]string{"MEMORY", "USAGE", "memory:key", "SAMPLES", "-1"},
+		[]string{"MEMORY", "USAGE", "memory:key", "COUNT", "1"},
+		[]string{"MEMORY", "STATS"},
+		[]string{"MEMORY", "DOCTOR"},
+		[]string{"MEMORY", "PURGE"},
+		[]string{"MEMORY", "STATS", "extra"},
+		[]string{"MEMORY", "FREE"},
+		[]string{"DEL", "memory:key"},
+	)
+	if responses[1].Type != Integer || responses[1].Int != keyOverhead+int64(len("memory:key")+len("value")) {
+		t.Errorf("Unexpected MEMORY USAGE reply %v", responses[1])
+	}
+	if !responses[2].IsNull {
+		t.Errorf("Expected nil for a missing key, got %v", responses[2])
+	}
+	for i, want := range map[int]string{
+		3: "ERR value is out of range, must be positive",
+		4: "ERR syntax error",
+		7: "OK",
+		8: "ERR wrong number of arguments for 'MEMORY|STATS' command",
+		9: "ERR unknown subcommand 'FREE'. Try MEMORY HELP.",
+	} {
+		if responses[i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", i, want, responses[i])
+		}
+	}
+
+	fields := map[string]RESPValue{}
+	for i := 0; i+1 < len(responses[5].Array); i += 2 {
+		fields[responses[5].Array[i].Str] = responses[5].Array[i+1]
+	}
+	for _, name := range []string{"peak.allocated", "total.allocated", "startup.allocated", "clients.normal", "overhead.total", "dataset.bytes", "fragmentation"} {
+		if _, exists := fields[name]; !exists {
+			t.Errorf("Expected %s in MEMORY STATS", name)
+		}
+	}
+	if fields["keys.count"].Int < 1 || fields["clients.normal"].Int <= 0 || len(fields["db.0"].Array) != 4 {
+		t.Errorf("Unexpected MEMORY STATS reply %v", responses[5])
+	}
+	if !strings.HasPrefix(responses[6].Str, "Hi Sam") && !strings.HasPrefix(responses[6].Str, "Sam, I detected") {
+		t.Errorf("Unexpected MEMORY DOCTOR reply %q", responses[6].Str)
+	}
+}
+
+func TestObjectCommands(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"SET", "object:int", "12345"},
+		[]string{"SET", "object:str", "hello"},
+		[]string{"GEOADD", "object:zset", "13.361389", "38.115556", "Palermo"},
+		[]string{"OBJECT", "ENCODING", "object:int"},
+		[]string{"OBJECT", "ENCODING", "object:str"},
+		[]string{"OBJECT", "ENCODING", "object:zset"},
+		[]string{"OBJECT", "ENCODING", "object:missing"},
+		[]string{"OBJECT", "IDLETIME", "object:str"},
+		[]string{"OBJECT", "REFCOUNT", "object:str"},
+		[]string{"OBJECT", "FREQ", "object:str"},
+		[]string{"CONFIG", "SET", "maxmemory-policy", "allkeys-lfu"},
+		[]string{"OBJECT", "FREQ", "object:str"},
+		[]string{"OBJECT", "IDLETIME", "object:str"},
+		[]string{"CONFIG", "SET", "maxmemory-policy", "noeviction"},
+		[]string{"OBJECT", "ENCODING"},
+		[]string{"OBJECT", "SIZE", "object:str"},
+		[]string{"DEL", "object:int", "object:str", "object:zset"},
+	)
+	for i, want := range map[int]string{
+		3:  "int",
+		4:  "embstr",
+		5:  "listpack",
+		9:  ErrObjectNotLFUPolicy.Error(),
+		12: ErrObjectLFUPolicy.Error(),
+		14: "ERR wrong number of arguments for 'OBJECT|ENCODING' command",
+		15: "ERR unknown subcommand 'SIZE'. Try OBJECT HELP.",
+	} {
+		if responses[i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", i, want, responses[i])
+		}
+	}
+	if !responses[6].IsNull {
+		t.Errorf("Expected nil for a missing key, got %v", responses[6])
+	}
+	if responses[7].Type != Integer || responses[7].Int != 0 {
+		t.Errorf("Expected an idle time of 0, got %v", responses[7])
+	}
+	if responses[8].Int != 1 {
+		t.Errorf("Expected a refcount of 1, got %v", responses[8])
+	}
+	if responses[11].Type != Integer || responses[11].Int < LFU_INIT_VAL {
+		t.Errorf("Expected an LFU counter of at least %d, got %v", LFU_INIT_VAL, responses[11])
+	}
+}
+
+func TestObjectEncodingDuringWrites(t *testing.T) {
+	writer, reader := newTestClient(t), newTestClient(t)
+	reader.storage = writer.storage
+	writer.execute("GEOADD", bulkStringArray([]string{"object:geo", "13.361389", "38.115556", "member"}).Array)
+
+	// GEOADD grows the sorted set in place while OBJECT ENCODING walks it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 100 {
+			writer.execute("GEOADD", bulkStringArray([]string{"object:geo", "13.361389", "38.115556", "member" + strconv.Itoa(i)}).Array)
+		}
+	}()
+	for range 100 {
+		if response := reader.execute("OBJECT", bulkStringArray([]string{"ENCODING", "object:geo"}).Array); response.Str != "listpack" {
+			t.Fatalf("Expected listpack, got %v", response)
+		}
+	}
+	<-done
+}
+
+func TestSlowlogCommands(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"SLOWLOG", "RESET"},
+		[]string{"CONFIG", "SET", "slowlog-log-slower-than", "0"},
+		[]string{"AUTH", "secret"},
+		[]string{"SET", "slowlog:key", "value"},
+		[]string{"CONFIG", "SET", "slowlog-log-slower-than", "-1"},
+		[]string{"SLOWLOG", "LEN"},
+		[]string{"SLOWLOG", "GET", "2"},
+		[]string{"SLOWLOG", "GET", "-2"},
+		[]string{"SLOWLOG", "LEN", "extra"},
+		[]string{"SLOWLOG", "RESET"},
+		[]string{"SLOWLOG", "LEN"},
+		[]string{"CONFIG", "SET", "slowlog-log-slower-than", "10000"},
+		[]string{"DEL", "slowlog:key"},
+	)
+	if responses[5].Int != 3 {
+		t.Errorf("Expected 3 slow log entries, got %v", responses[5])
+	}
+	entries := responses[6].Array
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %v", responses[6])
+	}
+	if args := entries[0].Array[3].Array; len(args) != 3 || args[0].Str != "SET" || args[2].Str != "value" {
+		t.Errorf("Expected the SET command first, got %v", entries[0])
+	}
+	if args := entries[1].Array[3].Array; args[1].Str != "(redacted)" {
+		t.Errorf("Expected the AUTH password to be redacted, got %v", entries[1])
+	}
+	if entries[0].Array[0].Int != entries[1].Array[0].Int+1 || entries[0].Array[4].Str == "" {
+		t.Errorf("Unexpected entry ids or client address %v", responses[6])
+	}
+	for i, want := range map[int]string{
+		7: "ERR count should be greater than or equal to -1",
+		8: "ERR wrong number of arguments for 'SLOWLOG|LEN' command",
+		9: "OK",
+	} {
+		if responses[i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", i, want, responses[i])
+		}
+	}
+	if responses[10].Int != 0 {
+		t.Errorf("Expected an empty slow log after reset, got %v", responses[10])
+	}
+}
+
+func TestMonitorCommand(t *testing.T) {
+	monitor, monitorReader := dialTestServer(t)
+	writeCommand(t, monitor, "MONITOR")
+	if resp, err := ParseRESP(monitorReader); err != nil || resp.Str != "OK" {
+		t.Fatalf("Expected OK, got %v, %v", resp, err)
+	}
+
+	conn, reader := dialTestServer(t)
+	for _, args := range [][]string{
+		{"SELECT", "1"},
+		{"set", "monitor:key", "a b"},
+		{"CONFIG", "GET", "port"},
+		{"DEL", "monitor:key"},
+	} {
+		writeCommand(t, conn, args...)
+		if _, err := ParseRESP(reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCommand(t, conn, "CLIENT", "LIST")
+	if resp, err := ParseRESP(reader); err != nil || !strings.Contains(resp.Str, "flags=O ") {
+		t.Errorf("Expected the monitor to have the O flag, got %v, %v", resp, err)
+	}
+
+	// CONFIG is an admin command and isn't shown
+	monitor.SetReadDeadline(time.Now().Add(time.Second))
+	for _, pattern := range []string{
+		`^\d+\.\d{6} \[1 127\.0\.0\.1:\d+\] "SELECT" "1"$`,
+		`^\d+\.\d{6} \[1 127\.0\.0\.1:\d+\] "set" "monitor:key" "a b"$`,
+		`^\d+\.\d{6} \[1 127\.0\.0\.1:\d+\] "DEL" "monitor:key"$`,
+	} {
+		resp, err := ParseRESP(monitorReader)
+		if err != nil {
+			t.Fatalf("Failed to read the feed: %v", err)
+		}
+		if resp.Type != SimpleString || !regexp.MustCompile(pattern).MatchString(resp.Str) {
+			t.Errorf("Expected a line matching %s, got %v", pattern, resp)
+		}
+	}
+}
+
+func TestInfoCommandStats(t *testing.T) {
+	sendCommand(t, "CONFIG", "RESETSTAT")
+	responses := sendCommands(t,
+		[]string{"ECHO", "hello"},
+		[]string{"ECHO"},
+		[]string{"CLIENT", "KILL", "ID", "0"},
+		[]string{"INFO", "commandstats"},
+	)
+	info := responses[3].Str
+	for _, pattern := range []string{
+		`cmdstat_echo:calls=1,usec=\d+,usec_per_call=\d+\.\d\d,rejected_calls=1,failed_calls=0\r\n`,
+		`cmdstat_client\|kill:calls=1,usec=\d+,usec_per_call=\d+\.\d\d,rejected_calls=0,failed_calls=1\r\n`,
+	} {
+		if !regexp.MustCompile(pattern).MatchString(info) {
+			t.Errorf("Expected a line matching %s in %q", pattern, info)
+		}
+	}
+}
+
+func TestLatencyCommands(t *testing.T) {
+	latencyMonitor.Reset()
+	defer latencyMonitor.Reset()
+	latencyMonitor.AddSample("command", time.Now(), 42)
+
+	responses := sendCommands(t,
+		[]string{"LATENCY", "LATEST"},
+		[]string{"LATENCY", "HISTORY", "command"},
+		[]string{"LATENCY", "HISTORY", "nosuchevent"},
+		[]string{"ECHO", "hello"},
+		[]string{"LATENCY", "HISTOGRAM", "echo"},
+		[]string{"LATENCY", "DOCTOR"},
+		[]string{"LATENCY", "RESET", "command"},
+		[]string{"LATENCY", "HISTORY"},
+		[]string{"LATENCY", "GRAPH", "command"},
+	)
+	if latest := responses[0].Array; len(latest) != 1 || latest[0].Array[0].Str != "command" || latest[0].Array[2].Int != 42 {
+		t.Errorf("Unexpected LATENCY LATEST reply %v", responses[0])
+	}
+	if history := responses[1].Array; len(history) != 1 || history[0].Array[1].Int != 42 {
+		t.Errorf("Unexpected LATENCY HISTORY reply %v", responses[1])
+	}
+	if len(responses[2].Array) != 0 {
+		t.Errorf("Expected no history for an unknown event, got %v", responses[2])
+	}
+	if histogram := responses[4].Array; len(histogram) != 2 || histogram[0].Str != "echo" || histogram[1].Array[1].Int < 1 {
+		t.Errorf("Unexpected LATENCY HISTOGRAM reply %v", responses[4])
+	}
+	if !strings.Contains(responses[5].Str, "1. command: 1 latency spikes") {
+		t.Errorf("Unexpected LATENCY DOCTOR reply %q", responses[5].Str)
+	}
+	if responses[6].Int != 1 {
+		t.Errorf("Expected 1 event reset, got %v", responses[6])
+	}
+	for i, want := range map[int]string{
+		7: "ERR wrong number of arguments for 'LATENCY|HISTORY' command",
+		8: "ERR unknown subcommand 'GRAPH'. Try LATENCY HELP.",
+	} {
+		if responses[i].Str != want {
+			t.Errorf("Response %d: expected %q, got %v", i, want, responses[i])
+		}
+	}
+}
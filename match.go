@@ -0,0 +1,119 @@
+package main
+
+import (
+	"unicode"
+)
+
+// StringMatch reports whether str matches a Redis glob style pattern, where
+// `*` matches any sequence of characters, `?` any single character, `[abc]`
+// one of the listed characters, `[^abc]` any other character, `[a-z]` a
+// character in the range and `\x` the character x literally.
+//
+// It is a port of stringmatchlen from Redis, including its protection
+// against patterns with many stars backtracking exponentially.
+func StringMatch(pattern, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return stringMatch([]byte(pattern), []byte(str), nocase, &skipLongerMatches, 0)
+}
+
+func foldByte(b byte, nocase bool) byte {
+	if nocase {
+		return byte(unicode.ToLower(rune(b)))
+	}
+	return b
+}
+
+func stringMatch(pattern, str []byte, nocase bool, skipLongerMatches *bool, nesting int) bool {
+	// Protection against abusive patterns
+	if nesting > 1000 {
+		return false
+	}
+
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if stringMatch(pattern[1:], str, nocase, skipLongerMatches, nesting+1) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				str = str[1:]
+			}
+			// The rest of the pattern doesn't match anywhere in the rest of
+			// the string, so trying longer matches for earlier stars can't
+			// succeed either
+			*skipLongerMatches = true
+			return false
+		case '?':
+			str = str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for {
+				if len(pattern) == 0 {
+					// Unterminated class, step back so the loop below ends
+					pattern = []byte{']'}
+					break
+				} else if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if pattern[0] == ']' {
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					c := foldByte(str[0], nocase)
+					if c >= foldByte(start, nocase) && c <= foldByte(end, nocase) {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if foldByte(pattern[0], nocase) == foldByte(str[0], nocase) {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if foldByte(pattern[0], nocase) != foldByte(str[0], nocase) {
+				return false
+			}
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+		if len(str) == 0 {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			break
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStringMatch(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		str      string
+		expected bool
+	}{
+		{"*", "anything", true},
+		{"*", "", false}, // Like Redis, callers special case a lone star
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hllo", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"user:*:name", "user:1000:name", true},
+		{"user:*:name", "user:1000:email", false},
+		{"a*b*c", "abc", true},
+		{"a*", "b", false},
+		{"abc*", "abc", true},
+		{"h[a", "ha", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+
+	for _, tt := range tests {
+		if got := StringMatch(tt.pattern, tt.str, false); got != tt.expected {
+			t.Errorf("StringMatch(%q, %q) = %v, want %v", tt.pattern, tt.str, got, tt.expected)
+		}
+	}
+
+	if !StringMatch("HELLO", "hello", true) || StringMatch("HELLO", "hello", false) {
+		t.Error("Expected only case insensitive matching to ignore case")
+	}
+}
+
+func TestStringMatchAbusivePattern(t *testing.T) {
+	// Without skipping longer matches this takes exponential time
+	pattern := strings.Repeat("a*", 30) + "b"
+	if StringMatch(pattern, strings.Repeat("a", 60), false) {
+		t.Error("Expected no match")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+const (
+	MEMORY_USAGE_SAMPLES   = 5               // Elements sampled by MEMORY USAGE by default
+	MEMORY_DOCTOR_MIN_USED = 5 * 1024 * 1024 // Below this MEMORY DOCTOR has nothing to say
+)
+
+var ErrMemorySamples = errors.New("ERR value is out of range, must be positive")
+
+// Approximate sizes of the structures holding the dataset. They follow the Go
+// layout of the dictionaries and skiplists but ignore allocator overhead, so
+// the accounting is an estimate like the Redis per-key MEMORY USAGE.
+var (
+	pointerSize = int64(unsafe.Sizeof(uintptr(0)))
+
+	// A key costs its dictionary entry, its bucket pointer and the Value
+	keyOverhead = int64(unsafe.Sizeof(dictEntry[*Value]{})+unsafe.Sizeof(Value{})) + pointerSize
+
+	// An empty sorted set has its dictionary and a skiplist header with a
+	// full tower of pointers
+	sortedSetOverhead = int64(unsafe.Sizeof(SortedSet{})+unsafe.Sizeof(Dict[float64]{})+
+		unsafe.Sizeof(skiplist{})+unsafe.Sizeof(skiplistNode{})) + SKIPLIST_MAXLEVEL*pointerSize
+
+	// A member costs a dictionary entry and bucket pointer, plus a skiplist
+	// node with on average 1/(1-p) levels
+	sortedSetEntryOverhead = int64(unsafe.Sizeof(dictEntry[float64]{})+unsafe.Sizeof(skiplistNode{})) +
+		pointerSize + int64(float64(pointerSize)/(1-SKIPLIST_P))
+)
+
+// memoryUsage returns the approximate bytes used by the value
+func (v *Value) memoryUsage() int64 {
+	if v.Type == SortedSetValue {
+		return v.ZSet.memoryUsage()
+	}
+	return int64(len(v.Str))
+}
+
+// memoryUsage returns the approximate bytes used by the sorted set
+func (z *SortedSet) memoryUsage() int64 {
+	return sortedSetOverhead + int64(z.Len())*sortedSetEntryOverhead + z.memberBytes
+}
+
+// sampledMemoryUsage estimates the bytes used by the value from the size of
+// up to samples elements, or every element if samples is 0, like MEMORY USAGE
+func (v *Value) sampledMemoryUsage(samples int) int64 {
+	if v.Type != SortedSetValue {
+		return v.memoryUsage()
+	}
+	z := v.ZSet
+	if samples == 0 || samples >= z.Len() {
+		return z.memoryUsage()
+	}
+	var sampled, memberBytes int64
+	z.Range(func(member string, score float64) bool {
+		memberBytes += int64(len(member))
+		sampled++
+		return sampled < int64(samples)
+	})
+	return sortedSetOverhead + int64(z.Len())*(sortedSetEntryOverhead+memberBytes/sampled)
+}
+
+// entryMemoryUsage returns the approximate bytes used by a key and its value
+func entryMemoryUsage(key string, value *Value) int64 {
+	return keyOverhead + int64(len(key)) + value.memoryUsage()
+}
+
+// datasetMemory returns the approximate bytes used by the keys and values of
+// every database, the memory that maxmemory limits
+func datasetMemory() int64 {
+	var used int64
+	for _, db := range databases {
+		used += db.MemoryUsage()
+	}
+	return used
+}
+
+// KeyMemoryUsage estimates the bytes used by a key and its value, sampling up
+// to samples elements of collections, or all of them if samples is 0
+func (s *Storage) KeyMemoryUsage(key string, samples int) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, exists := s.data.Get(key)
+	if !exists {
+		return 0, false
+	}
+	return keyOverhead + int64(len(key)) + value.sampledMemoryUsage(samples), true
+}
+
+// hashTableOverhead returns the bytes used by the keyspace dictionary itself:
+// its buckets and entries, without the keys and values
+func (s *Storage) hashTableOverhead() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(s.data.Buckets())*pointerSize + int64(s.data.Len())*int64(unsafe.Sizeof(dictEntry[*Value]{}))
+}
+
+// memoryUsage returns the bytes used by the client and its query buffer
+func (c *Client) memoryUsage() int64 {
+	return int64(unsafe.Sizeof(Client{})) + int64(c.reader.Size())
+}
+
+// dbOverhead is the hash table overhead of a database for MEMORY STATS
+type dbOverhead struct {
+	index int
+	main  int64
+}
+
+// MemoryStats breaks the memory use down like MEMORY STATS
+type MemoryStats struct {
+	PeakAllocated    uint64
+	TotalAllocated   uint64
+	StartupAllocated uint64
+	ReplBacklog      int64
+	ClientsNormal    int64
+	DBs              []dbOverhead
+	OverheadTotal    int64
+	Keys             int64
+	DatasetBytes     int64
+
+	AllocatorAllocated uint64
+	AllocatorActive    uint64
+	AllocatorResident  uint64
+}
+
+// GetMemoryStats measures the memory used by the server. The allocator
+// figures come from the Go runtime: resident is the memory mapped for the
+// heap and runtime that wasn't released to the OS.
+func GetMemoryStats() *MemoryStats {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/heap/objects:bytes"},
+		{Name: "/memory/classes/heap/unused:bytes"},
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	values := make([]uint64, len(samples))
+	for i, sample := range samples {
+		if sample.Value.Kind() == metrics.KindUint64 {
+			values[i] = sample.Value.Uint64()
+		}
+	}
+
+	m := &MemoryStats{
+		TotalAllocated:     stats.trackPeakMemory(),
+		StartupAllocated:   stats.startupMemory.Load(),
+		AllocatorAllocated: values[0],
+		AllocatorActive:    values[0] + values[1],
+		AllocatorResident:  values[2] - values[3],
+	}
+	m.PeakAllocated = stats.peakMemory.Load()
+	for _, client := range clients.List() {
+		m.ClientsNormal += client.memoryUsage()
+	}
+	m.ReplBacklog = replication.BacklogSize()
+	m.OverheadTotal = int64(m.StartupAllocated) + m.ReplBacklog + m.ClientsNormal
+
+	// The dataset is the keys and values without the dictionary entries
+	// counted as overhead
+	entryOverhead := int64(unsafe.Sizeof(dictEntry[*Value]{})) + pointerSize
+	for _, db := range databases {
+		keys := int64(db.Len())
+		if keys == 0 {
+			continue
+		}
+		overhead := db.hashTableOverhead()
+		m.DBs = append(m.DBs, dbOverhead{index: db.index, main: overhead})
+		m.OverheadTotal += overhead
+		m.Keys += keys
+		m.DatasetBytes += db.MemoryUsage() - keys*entryOverhead
+	}
+	return m
+}
+
+// percentage returns part as a percentage of total, or 0 if total is 0
+func percentage(part, total float64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return part * 100 / total
+}
+
+// fragmentation returns the ratio of resident to allocated memory
+func (m *MemoryStats) fragmentation() float64 {
+	if m.AllocatorAllocated == 0 {
+		return 0
+	}
+	return float64(m.AllocatorResident) / float64(m.AllocatorAllocated)
+}
+
+// Reply renders the statistics as the flat map MEMORY STATS returns
+func (m *MemoryStats) Reply() *RESPValue {
+	reply := &RESPValue{Type: Array}
+	add := func(name string, value RESPValue) {
+		reply.Array = append(reply.Array, RESPValue{Type: BulkString, Str: name}, value)
+	}
+	integer := func(n int64) RESPValue { return RESPValue{Type: Integer, Int: n} }
+	double := func(f float64) RESPValue {
+		return RESPValue{Type: BulkString, Str: strconv.FormatFloat(f, 'f', -1, 64)}
+	}
+
+	sinceStartup := float64(m.TotalAllocated) - float64(m.StartupAllocated)
+	add("peak.allocated", integer(int64(m.PeakAllocated)))
+	add("total.allocated", integer(int64(m.TotalAllocated)))
+	add("startup.allocated", integer(int64(m.StartupAllocated)))
+	add("replication.backlog", integer(m.ReplBacklog))
+	add("clients.slaves", integer(0))
+	add("clients.normal", integer(m.ClientsNormal))
+	add("cluster.links", integer(0))
+	add("aof.buffer", integer(0))
+	add("lua.caches", integer(0))
+	add("functions.caches", integer(0))
+	for _, db := range m.DBs {
+		add(fmt.Sprintf("db.%d", db.index), RESPValue{Type: Array, Array: []RESPValue{
+			{Type: BulkString, Str: "overhead.hashtable.main"}, integer(db.main),
+			{Type: BulkString, Str: "overhead.hashtable.expires"}, integer(0),
+		}})
+	}
+	add("overhead.total", integer(m.OverheadTotal))
+	add("keys.count", integer(m.Keys))
+	bytesPerKey := int64(0)
+	if m.Keys > 0 {
+		bytesPerKey = int64(sinceStartup) / m.Keys
+	}
+	add("keys.bytes-per-key", integer(bytesPerKey))
+	add("dataset.bytes", integer(m.DatasetBytes))
+	add("dataset.percentage", double(percentage(float64(m.DatasetBytes), sinceStartup)))
+	add("peak.percentage", double(percentage(float64(m.TotalAllocated), float64(m.PeakAllocated))))
+	add("allocator.allocated", integer(int64(m.AllocatorAllocated)))
+	add("allocator.active", integer(int64(m.AllocatorActive)))
+	add("allocator.resident", integer(int64(m.AllocatorResident)))
+	add("fragmentation", double(m.fragmentation()))
+	add("fragmentation.bytes", integer(int64(m.AllocatorResident)-int64(m.AllocatorAllocated)))
+	return reply
+}
+
+// Doctor reports likely memory problems, in the words of MEMORY DOCTOR
+func (m *MemoryStats) Doctor() string {
+	if m.TotalAllocated < MEMORY_DOCTOR_MIN_USED {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. " +
+			"The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	var issues []string
+	if float64(m.PeakAllocated) > float64(m.TotalAllocated)*1.5 {
+		issues = append(issues, " * Peak memory: In the past this instance used more than 150% the memory that is currently using. "+
+			"The allocator is normally not able to release memory after a peak, so you can expect to see a big fragmentation ratio, "+
+			"however this is actually harmless and is only due to the memory peak. "+
+			"If the memory peak was only occasional and you want to try to reclaim memory, please try the MEMORY PURGE command, "+
+			"otherwise the only other option is to shutdown and restart the instance.")
+	}
+	if m.fragmentation() > 1.4 {
+		issues = append(issues, fmt.Sprintf(" * High total RSS: This instance has a memory fragmentation and RSS overhead greater than 1.4 "+
+			"(this means that the Resident Set Size of the process is much larger than the sum of the logical allocations it performed, %.2f here). "+
+			"This problem is usually due either to a large peak memory (check if there is a peak memory entry above in the report) "+
+			"or may result from a workload that causes the allocator to fragment memory a lot.", m.fragmentation()))
+	}
+	if clients := len(clients.List()); clients > 0 && m.ClientsNormal/int64(clients) > 200*1024 {
+		issues = append(issues, " * Big client buffers: The clients buffers are in general larger than 200K per client. "+
+			"This may result from clients sending large commands or pipelining many of them.")
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this Redis instance memory implants:\n\n" +
+		strings.Join(issues, "\n\n") + "\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
+
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"MALLOC-STATS",
+	"    Return internal statistics report from the memory allocator.",
+	"PURGE",
+	"    Attempt to purge dirty pages for reclamation by the allocator.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleMemory runs MEMORY USAGE, STATS, DOCTOR, MALLOC-STATS, PURGE and HELP
+func (c *Client) handleMemory(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("memory|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'MEMORY|%s' command", subcommand),
+		}
+	}
+
+	switch subcommand {
+	case "USAGE":
+		samples := MEMORY_USAGE_SAMPLES
+		for i := 2; i < len(args); i++ {
+			if !strings.EqualFold(args[i].Str, "SAMPLES") || i+1 >= len(args) {
+				return &RESPValue{Type: Error, Str: ErrSyntax.Error()}
+			}
+			count, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return &RESPValue{Type: Error, Str: ErrNotInteger.Error()}
+			}
+			if count < 0 {
+				return &RESPValue{Type: Error, Str: ErrMemorySamples.Error()}
+			}
+			samples = int(min(count, 1<<31-1))
+			i++
+		}
+		usage, exists := c.storage.KeyMemoryUsage(args[1].Str, samples)
+		if !exists {
+			return &RESPValue{Type: BulkString, IsNull: true}
+		}
+		return &RESPValue{Type: Integer, Int: usage}
+	case "STATS":
+		return GetMemoryStats().Reply()
+	case "DOCTOR":
+		return &RESPValue{Type: BulkString, Str: GetMemoryStats().Doctor()}
+	case "MALLOC-STATS":
+		return &RESPValue{Type: BulkString, Str: "Stats not supported for the current allocator"}
+	case "PURGE":
+		debug.FreeOSMemory()
+		return okReply()
+	case "HELP":
+		return simpleStringArrayReply(memoryHelp)
+	default:
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try MEMORY HELP.", args[0].Str),
+		}
+	}
+}
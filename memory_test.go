@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// countMemory adds up the memory used by every key of s from scratch
+func countMemory(s *Storage) int64 {
+	var used int64
+	s.data.Range(func(key string, value *Value) bool {
+		used += entryMemoryUsage(key, value)
+		return true
+	})
+	return used
+}
+
+func TestStorageMemoryAccounting(t *testing.T) {
+	s := NewStorage()
+	other := NewStorage()
+	other.index = 1
+	check := func(step string) {
+		t.Helper()
+		for _, db := range []*Storage{s, other} {
+			if db.MemoryUsage() != countMemory(db) {
+				t.Errorf("%s: db %d accounts %d bytes, keys use %d", step, db.index, db.MemoryUsage(), countMemory(db))
+			}
+		}
+	}
+
+	s.Set("key", "value")
+	s.Set("key", "a longer value")
+	s.Set("other", "x")
+	if expected := 2*keyOverhead + int64(len("key")+len("a longer value")+len("other")+len("x")); s.MemoryUsage() != expected {
+		t.Errorf("Expected %d bytes, got %d", expected, s.MemoryUsage())
+	}
+	s.GeoAdd("places", false, false, false, []float64{13.36, 15.08}, []float64{38.11, 37.50}, []string{"Palermo", "Catania"})
+	check("GEOADD")
+	s.GeoAdd("places", false, false, false, []float64{13.37}, []float64{38.12}, []string{"Palermo"})
+	check("GEOADD update")
+	s.Rename("key", "other", false)
+	check("RENAME")
+	s.CopyTo("places", other, "copy", false)
+	check("COPY")
+	s.Move("other", other)
+	check("MOVE")
+	s.PFAdd("hll", "a", "b")
+	check("PFADD")
+	s.Swap(other)
+	check("SWAPDB")
+	s.Del("copy")
+	other.Unlink("places")
+	check("DEL")
+	s.Flush(false)
+	if s.MemoryUsage() != 0 {
+		t.Errorf("Expected an empty database to use 0 bytes, got %d", s.MemoryUsage())
+	}
+}
+
+func TestKeyMemoryUsage(t *testing.T) {
+	s := NewStorage()
+	s.Set("key", "value")
+	if usage, exists := s.KeyMemoryUsage("key", 5); !exists || usage != keyOverhead+int64(len("key")+len("value")) {
+		t.Errorf("Unexpected usage %d", usage)
+	}
+	if _, exists := s.KeyMemoryUsage("missing", 5); exists {
+		t.Error("Expected a missing key to have no usage")
+	}
+
+	// Members of the same length sample exactly, others are extrapolated
+	// from the first members in score order
+	s.GeoAdd("places", false, false, false, []float64{13.36, 15.08, 13.5}, []float64{38.11, 37.50, 38.0}, []string{"aa", "bb", "a much longer member"})
+	exact, _ := s.KeyMemoryUsage("places", 0)
+	value, _ := s.data.Get("places")
+	if exact != entryMemoryUsage("places", value) {
+		t.Errorf("Expected SAMPLES 0 to count every member, got %d", exact)
+	}
+	if all, _ := s.KeyMemoryUsage("places", 3); all != exact {
+		t.Errorf("Expected sampling every member to be exact, got %d and %d", all, exact)
+	}
+	var first string
+	value.ZSet.Range(func(member string, score float64) bool {
+		first = member
+		return false
+	})
+	sampled, _ := s.KeyMemoryUsage("places", 1)
+	expected := keyOverhead + int64(len("places")) + sortedSetOverhead + 3*(sortedSetEntryOverhead+int64(len(first)))
+	if sampled != expected {
+		t.Errorf("Expected one sample to extrapolate to %d, got %d", expected, sampled)
+	}
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	m := &MemoryStats{TotalAllocated: 1 << 20}
+	if !strings.HasPrefix(m.Doctor(), "Hi Sam, this instance is empty") {
+		t.Errorf("Unexpected report for an empty instance: %q", m.Doctor())
+	}
+	m = &MemoryStats{TotalAllocated: 100 << 20, PeakAllocated: 110 << 20, AllocatorAllocated: 100 << 20, AllocatorResident: 120 << 20}
+	if !strings.HasPrefix(m.Doctor(), "Hi Sam, I can't find any memory issue") {
+		t.Errorf("Unexpected report for a healthy instance: %q", m.Doctor())
+	}
+	m.PeakAllocated = 200 << 20
+	m.AllocatorResident = 200 << 20
+	report := m.Doctor()
+	if !strings.Contains(report, " * Peak memory:") || !strings.Contains(report, " * High total RSS:") ||
+		!strings.HasSuffix(report, "I'm here to keep you safe, Sam. I want to help you.\n") {
+		t.Errorf("Expected the peak and fragmentation issues, got %q", report)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serveMetrics serves the metrics in the Prometheus text format on
+// /metrics until the listeners are closed at shutdown
+func serveMetrics(listeners []net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(renderMetrics())
+	})
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          newLogLogger(LevelVerbose),
+	}
+	for _, listener := range listeners {
+		go server.Serve(listener)
+	}
+}
+
+// metricsWriter renders metric families in the Prometheus text format
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+// family starts a metric family with its help text and type
+func (m *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a value, with labels given as name and value pairs
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.WriteString(name)
+	if len(labels) > 0 {
+		m.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.WriteByte(',')
+			}
+			fmt.Fprintf(m, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		m.WriteByte('}')
+	}
+	m.WriteByte(' ')
+	m.WriteString(formatMetricValue(value))
+	m.WriteByte('\n')
+}
+
+// metric writes a family with a single unlabeled value
+func (m *metricsWriter) metric(name, kind, help string, value float64) {
+	m.family(name, kind, help)
+	m.sample(name, value)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// renderMetrics renders the INFO counters as Prometheus metrics
+func renderMetrics() []byte {
+	c := currentConfig()
+	m := &metricsWriter{}
+	replicationStatus := replication.Status()
+
+	m.family("redis_instance_info", "gauge", "Information about the server, always 1.")
+	m.sample("redis_instance_info", 1, "redis_version", REDIS_VERSION, "run_id", stats.runID, "role", replicationStatus.role)
+	m.metric("redis_uptime_seconds", "gauge", "Seconds since the server started.", time.Since(stats.startTime).Seconds())
+
+	// Clients and traffic
+	m.metric("redis_connected_clients", "gauge", "Number of client connections.", float64(stats.connectedClients.Load()))
+	m.metric("redis_connections_received_total", "counter", "Connections accepted by the server.", float64(stats.totalConnections.Load()))
+	m.metric("redis_commands_processed_total", "counter", "Commands processed by the server.", float64(stats.totalCommands.Load()))
+	m.metric("redis_instantaneous_ops_per_sec", "gauge", "Commands processed per second, averaged over the last 1.6 seconds.", float64(stats.instantaneousOps.Load()))
+	m.metric("redis_net_input_bytes_total", "counter", "Bytes read from clients.", float64(stats.netInputBytes.Load()))
+	m.metric("redis_net_output_bytes_total", "counter", "Bytes written to clients.", float64(stats.netOutputBytes.Load()))
+
+	// Keyspace
+	m.metric("redis_keyspace_hits_total", "counter", "Successful lookups of keys.", float64(stats.keyspaceHits.Load()))
+	m.metric("redis_keyspace_misses_total", "counter", "Failed lookups of keys.", float64(stats.keyspaceMisses.Load()))
+	m.metric("redis_evicted_keys_total", "counter", "Keys evicted because of the maxmemory limit.", float64(stats.evictedKeys.Load()))
+	m.metric("redis_expired_keys_total", "counter", "Keys removed when they expired.", 0) // Keys can't expire yet
+	m.family("redis_db_keys", "gauge", "Number of keys in each database.")
+	for i, db := range databases {
+		m.sample("redis_db_keys", float64(db.Len()), "db", "db"+strconv.Itoa(i))
+	}
+
+	// Memory
+	m.metric("redis_memory_used_bytes", "gauge", "Heap memory used by live objects.", float64(stats.trackPeakMemory()))
+	m.metric("redis_memory_used_peak_bytes", "gauge", "Peak of the used memory.", float64(stats.peakMemory.Load()))
+	m.metric("redis_memory_used_dataset_bytes", "gauge", "Estimated memory used by the keys and values.", float64(datasetMemory()))
+	m.metric("redis_memory_max_bytes", "gauge", "The maxmemory setting, 0 for no limit.", float64(c.MaxMemory))
+
+	// Persistence and replication
+	m.metric("redis_rdb_last_save_timestamp_seconds", "gauge", "Unix time of the last successful RDB save.", float64(stats.lastSaveTime.Load()))
+	m.metric("redis_rdb_saves_total", "counter", "RDB snapshots saved.", float64(stats.rdbSaves.Load()))
+	m.metric("redis_rdb_bgsave_in_progress", "gauge", "Whether an RDB snapshot is being saved in the background.", 0)
+	m.metric("redis_aof_enabled", "gauge", "Whether the append only file is enabled.", 0)
+	m.metric("redis_connected_slaves", "gauge", "Number of connected replicas.", float64(len(replicationStatus.replicas)))
+	m.metric("redis_master_repl_offset", "gauge", "Replication offset of the server.", float64(replicationStatus.offset))
+	m.metric("redis_master_link_up", "gauge", "Whether the link of a replica to its master is up, 0 on a master.",
+		float64(boolToInt(replicationStatus.linkState == "connected")))
+	m.metric("redis_repl_backlog_is_active", "gauge", "Whether the replication backlog is allocated.",
+		float64(boolToInt(replicationStatus.backlogActive)))
+	m.metric("redis_repl_backlog_history_bytes", "gauge", "Bytes of replication stream held in the backlog.",
+		float64(replicationStatus.backlogHistlen))
+
+	renderCommandMetrics(m)
+	return m.Bytes()
+}
+
+// renderCommandMetrics renders the INFO commandstats counters and latency
+// histograms of the commands that ran since the stats were reset
+func renderCommandMetrics(m *metricsWriter) {
+	var used []*CommandSpec
+	forEachCommand(func(spec *CommandSpec) {
+		if spec.stats.used() {
+			used = append(used, spec)
+		}
+	})
+
+	m.family("redis_commands_total", "counter", "Calls of each command.")
+	for _, spec := range used {
+		m.sample("redis_commands_total", float64(spec.stats.calls.Load()), "cmd", spec.Name)
+	}
+	m.family("redis_commands_duration_seconds_total", "counter", "Time spent running each command.")
+	for _, spec := range used {
+		m.sample("redis_commands_duration_seconds_total", float64(spec.stats.usec.Load())/1e6, "cmd", spec.Name)
+	}
+	m.family("redis_commands_rejected_calls_total", "counter", "Calls of each command refused before running, like on arity or ACL errors.")
+	for _, spec := range used {
+		m.sample("redis_commands_rejected_calls_total", float64(spec.stats.rejectedCalls.Load()), "cmd", spec.Name)
+	}
+	m.family("redis_commands_failed_calls_total", "counter", "Calls of each command that replied with an error.")
+	for _, spec := range used {
+		m.sample("redis_commands_failed_calls_total", float64(spec.stats.failedCalls.Load()), "cmd", spec.Name)
+	}
+
+	m.family("redis_commands_latency_seconds", "histogram", "Run time of each command.")
+	for _, spec := range used {
+		// The last bucket also holds the slowest calls, so it is only part of +Inf
+		cumulative := int64(0)
+		for i := range LATENCY_HISTOGRAM_BUCKETS - 1 {
+			cumulative += spec.stats.latency[i].Load()
+			le := formatMetricValue(float64(int64(1)<<i) / 1e6)
+			m.sample("redis_commands_latency_seconds_bucket", float64(cumulative), "cmd", spec.Name, "le", le)
+		}
+		calls := spec.stats.calls.Load()
+		m.sample("redis_commands_latency_seconds_bucket", float64(calls), "cmd", spec.Name, "le", "+Inf")
+		m.sample("redis_commands_latency_seconds_sum", float64(spec.stats.usec.Load())/1e6, "cmd", spec.Name)
+		m.sample("redis_commands_latency_seconds_count", float64(calls), "cmd", spec.Name)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderMetrics(t *testing.T) {
+	spec := lookupCommand("get")
+	spec.stats.reset()
+	defer spec.stats.reset()
+	spec.stats.record(3*time.Microsecond, false)
+	spec.stats.record(40*time.Second, true)
+	spec.stats.rejectedCalls.Add(1)
+
+	metrics := string(renderMetrics())
+	for _, expected := range []string{
+		"# TYPE redis_connected_clients gauge\nredis_connected_clients ",
+		"# TYPE redis_commands_processed_total counter\n",
+		`redis_db_keys{db="db0"} `,
+		`redis_commands_total{cmd="get"} 2` + "\n",
+		`redis_commands_rejected_calls_total{cmd="get"} 1` + "\n",
+		`redis_commands_failed_calls_total{cmd="get"} 1` + "\n",
+		`redis_commands_latency_seconds_bucket{cmd="get",le="2e-06"} 0` + "\n",
+		`redis_commands_latency_seconds_bucket{cmd="get",le="4e-06"} 1` + "\n",
+		`redis_commands_latency_seconds_bucket{cmd="get",le="8.388608"} 1` + "\n",
+		`redis_commands_latency_seconds_bucket{cmd="get",le="+Inf"} 2` + "\n",
+		`redis_commands_latency_seconds_sum{cmd="get"} 40.000003` + "\n",
+		`redis_commands_latency_seconds_count{cmd="get"} 2` + "\n",
+	} {
+		if !strings.Contains(metrics, expected) {
+			t.Errorf("Expected %q in the metrics", expected)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	listener, err := net.Listen(PROTOCOL, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	serveMetrics([]net.Listener{listener})
+
+	resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected response %s %v", resp.Status, resp.Header)
+	}
+	if !strings.Contains(string(body), "redis_uptime_seconds ") {
+		t.Errorf("Expected metrics in the body, got %q", body)
+	}
+
+	resp, err = http.Get("http://" + listener.Addr().String() + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for other paths, got %s", resp.Status)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const MONITOR_BUFFER_SIZE = 1024 // Lines queued for a monitor before it is disconnected as too slow
+
+// monitor is a client in MONITOR mode. Its lines are queued on feed and
+// written by its own goroutine, so a slow monitor never holds up commands.
+type monitor struct {
+	client  *Client
+	feed    chan string
+	dropped atomic.Bool // Disconnected for falling behind
+}
+
+// MonitorRegistry tracks the clients in MONITOR mode
+type MonitorRegistry struct {
+	mu       sync.RWMutex
+	monitors map[*Client]*monitor
+	count    atomic.Int64 // Lets Feed skip formatting when nobody is watching
+}
+
+var monitors = &MonitorRegistry{monitors: make(map[*Client]*monitor)}
+
+// Add puts a client in MONITOR mode and starts writing the feed to it
+func (r *MonitorRegistry) Add(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.monitors[c]; exists {
+		return
+	}
+	m := &monitor{client: c, feed: make(chan string, MONITOR_BUFFER_SIZE)}
+	r.monitors[c] = m
+	r.count.Add(1)
+	go m.write()
+}
+
+// Remove ends MONITOR mode for a client, once it disconnects
+func (r *MonitorRegistry) Remove(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, exists := r.monitors[c]; exists {
+		delete(r.monitors, c)
+		r.count.Add(-1)
+		close(m.feed)
+	}
+}
+
+// Feed sends a command that ran to every monitor. A monitor whose queue is
+// full is disconnected, like a Redis client over its output buffer limit.
+func (r *MonitorRegistry) Feed(c *Client, argv []RESPValue) {
+	if r.count.Load() == 0 {
+		return
+	}
+	line := monitorLine(c, argv, time.Now())
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, m := range r.monitors {
+		select {
+		case m.feed <- line:
+		default:
+			if !m.dropped.Swap(true) {
+				logVerbose("Closing a monitor falling behind the command feed", "client", m.client.id)
+				m.client.kill()
+			}
+		}
+	}
+}
+
+// write sends the queued lines until the feed is closed or the connection fails
+func (m *monitor) write() {
+	for line := range m.feed {
+		if _, err := m.client.write([]byte(line)); err != nil {
+			m.client.kill()
+			for range m.feed {
+				// Drain until Remove closes the feed
+			}
+			return
+		}
+	}
+}
+
+// monitorLine formats a command in the MONITOR format of Redis, like
+// +1700000000.123456 [0 127.0.0.1:52234] "SET" "key" "value"
+func monitorLine(c *Client, argv []RESPValue, now time.Time) string {
+	source := c.addr()
+	if c.isUnixSocket() {
+		source = "unix:" + c.conn.LocalAddr().String()
+	}
+
+	var line strings.Builder
+	fmt.Fprintf(&line, "+%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, c.storage.index, source)
+	for _, arg := range redactArgs(argStrings(argv)) {
+		line.WriteByte(' ')
+		line.WriteString(quoteString(arg))
+	}
+	line.WriteString("\r\n")
+	return line.String()
+}
+
+// handleMonitor puts the client in MONITOR mode. The feed starts once the
+// OK reply is written, so it comes first.
+func (c *Client) handleMonitor() *RESPValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.monitor = true
+	return okReply()
+}
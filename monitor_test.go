@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMonitorLine(t *testing.T) {
+	client := newTestClient(t)
+	now := time.Unix(1700000000, 123456789)
+	argv := []RESPValue{{Str: "set"}, {Str: "key"}, {Str: "a \"b\"\n\x01"}}
+	expected := `+1700000000.123456 [0 pipe] "set" "key" "a \"b\"\n\x01"` + "\r\n"
+	if line := monitorLine(client, argv, now); line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+
+	argv = []RESPValue{{Str: "AUTH"}, {Str: "secret"}}
+	expected = `+1700000000.123456 [0 pipe] "AUTH" "(redacted)"` + "\r\n"
+	if line := monitorLine(client, argv, now); line != expected {
+		t.Errorf("Expected %q, got %q", expected, line)
+	}
+}
+
+func TestSlowMonitor(t *testing.T) {
+	r := &MonitorRegistry{monitors: make(map[*Client]*monitor)}
+	watcher := newTestClient(t) // Nobody reads the other end of the pipe
+	client := newTestClient(t)
+	r.Add(watcher)
+	defer r.Remove(watcher)
+
+	for range MONITOR_BUFFER_SIZE + 2 {
+		r.Feed(client, []RESPValue{{Str: "PING"}})
+	}
+	if !r.monitors[watcher].dropped.Load() {
+		t.Fatal("Expected a monitor with a full queue to be dropped")
+	}
+	if _, err := watcher.conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the connection of a dropped monitor to be closed")
+	}
+}
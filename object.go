@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OBJ_ENCODING_EMBSTR_SIZE_LIMIT = 44 // Longest string Redis embeds in its object header
+
+var (
+	ErrObjectLFUPolicy    = errors.New("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+	ErrObjectNotLFUPolicy = errors.New("ERR An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+)
+
+// encoding returns the internal encoding Redis would use for the value.
+// Strings holding a canonical 64 bit integer are int, short strings embstr
+// and others raw. Sorted sets within the zset-max-listpack-* limits are
+// listpack, larger ones skiplist.
+func (v *Value) encoding(c *Config) string {
+	if v.Type == SortedSetValue {
+		return v.ZSet.encoding(c.ZSetMaxListpackEntries, c.ZSetMaxListpackValue)
+	}
+	if len(v.Str) <= 20 {
+		if n, err := strconv.ParseInt(v.Str, 10, 64); err == nil && strconv.FormatInt(n, 10) == v.Str {
+			return "int"
+		}
+	}
+	if len(v.Str) <= OBJ_ENCODING_EMBSTR_SIZE_LIMIT {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// encoding returns listpack if the sorted set is small enough for the
+// compact encoding, skiplist otherwise
+func (z *SortedSet) encoding(maxEntries, maxValue int) string {
+	if z.Len() > maxEntries {
+		return "skiplist"
+	}
+	encoding := "listpack"
+	z.Range(func(member string, score float64) bool {
+		if len(member) > maxValue {
+			encoding = "skiplist"
+			return false
+		}
+		return true
+	})
+	return encoding
+}
+
+// lookupObject returns the value at key for OBJECT without counting it as an
+// access. Only its access fields, which are atomic, may be read once the
+// lock is released.
+func (s *Storage) lookupObject(key string) (*Value, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.Get(key)
+}
+
+// objectEncoding returns the encoding of the value at key. It is computed
+// holding the read lock, as commands like GEOADD and PFADD update values in
+// place.
+func (s *Storage) objectEncoding(key string, c *Config) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, exists := s.data.Get(key)
+	if !exists {
+		return "", false
+	}
+	return value.encoding(c), true
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleObject runs OBJECT ENCODING, FREQ, IDLETIME, REFCOUNT and HELP
+func (c *Client) handleObject(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("object|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'OBJECT|%s' command", subcommand),
+		}
+	}
+	if subcommand == "HELP" {
+		return simpleStringArrayReply(objectHelp)
+	}
+	if subcommand != "ENCODING" && subcommand != "FREQ" && subcommand != "IDLETIME" && subcommand != "REFCOUNT" {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try OBJECT HELP.", args[0].Str),
+		}
+	}
+
+	config := currentConfig()
+	if subcommand == "ENCODING" {
+		encoding, exists := c.storage.objectEncoding(args[1].Str, &config)
+		if !exists {
+			return &RESPValue{Type: BulkString, IsNull: true}
+		}
+		return &RESPValue{Type: BulkString, Str: encoding}
+	}
+
+	value, exists := c.storage.lookupObject(args[1].Str)
+	if !exists {
+		return &RESPValue{Type: BulkString, IsNull: true}
+	}
+	lfu := parseEvictionPolicy(config.MaxMemoryPolicy).kind == "lfu"
+	switch subcommand {
+	case "FREQ":
+		if !lfu {
+			return &RESPValue{Type: Error, Str: ErrObjectNotLFUPolicy.Error()}
+		}
+		return &RESPValue{Type: Integer, Int: int64(value.lfuDecay(time.Now(), config.LFUDecayTime))}
+	case "IDLETIME":
+		if lfu {
+			return &RESPValue{Type: Error, Str: ErrObjectLFUPolicy.Error()}
+		}
+		idle := time.Now().UnixMilli() - value.accessed.Load()
+		return &RESPValue{Type: Integer, Int: max(idle, 0) / 1000}
+	default:
+		// Values are never shared between keys
+		return &RESPValue{Type: Integer, Int: 1}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValueEncoding(t *testing.T) {
+	c := DefaultConfig()
+	tests := map[string]string{
+		"42":                   "int",
+		"-9223372036854775808": "int",
+		"9223372036854775808":  "embstr",
+		"007":                  "embstr",
+		"hello":                "embstr",
+		strings.Repeat("a", OBJ_ENCODING_EMBSTR_SIZE_LIMIT):   "embstr",
+		strings.Repeat("a", OBJ_ENCODING_EMBSTR_SIZE_LIMIT+1): "raw",
+	}
+	for str, expected := range tests {
+		if encoding := (&Value{Type: StringValue, Str: str}).encoding(c); encoding != expected {
+			t.Errorf("encoding(%q) = %s, expected %s", str, encoding, expected)
+		}
+	}
+
+	zset := NewSortedSet()
+	value := &Value{Type: SortedSetValue, ZSet: zset}
+	zset.Add("short", 1)
+	if encoding := value.encoding(c); encoding != "listpack" {
+		t.Errorf("Expected listpack for a small sorted set, got %s", encoding)
+	}
+	zset.Add(strings.Repeat("m", c.ZSetMaxListpackValue+1), 2)
+	if encoding := value.encoding(c); encoding != "skiplist" {
+		t.Errorf("Expected skiplist for a long member, got %s", encoding)
+	}
+	c.ZSetMaxListpackValue = 1 << 10
+	c.ZSetMaxListpackEntries = 1
+	if encoding := value.encoding(c); encoding != "skiplist" {
+		t.Errorf("Expected skiplist above zset-max-listpack-entries, got %s", encoding)
+	}
+}
@@ -0,0 +1,829 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	RDB_VERSION = 11 // Format written by Redis 7.2
+
+	// Opcodes preceding the key-value pairs
+	RDB_OPCODE_FUNCTION2     = 245
+	RDB_OPCODE_MODULE_AUX    = 247
+	RDB_OPCODE_IDLE          = 248
+	RDB_OPCODE_FREQ          = 249
+	RDB_OPCODE_AUX           = 250
+	RDB_OPCODE_RESIZEDB      = 251
+	RDB_OPCODE_EXPIRETIME_MS = 252
+	RDB_OPCODE_EXPIRETIME    = 253
+	RDB_OPCODE_SELECTDB      = 254
+	RDB_OPCODE_EOF           = 255
+
+	// Value types
+	RDB_TYPE_STRING           = 0
+	RDB_TYPE_LIST             = 1
+	RDB_TYPE_SET              = 2
+	RDB_TYPE_ZSET             = 3
+	RDB_TYPE_HASH             = 4
+	RDB_TYPE_ZSET_2           = 5
+	RDB_TYPE_HASH_ZIPMAP      = 9
+	RDB_TYPE_LIST_ZIPLIST     = 10
+	RDB_TYPE_SET_INTSET       = 11
+	RDB_TYPE_ZSET_ZIPLIST     = 12
+	RDB_TYPE_HASH_ZIPLIST     = 13
+	RDB_TYPE_LIST_QUICKLIST   = 14
+	RDB_TYPE_HASH_LISTPACK    = 16
+	RDB_TYPE_ZSET_LISTPACK    = 17
+	RDB_TYPE_LIST_QUICKLIST_2 = 18
+	RDB_TYPE_SET_LISTPACK     = 20
+
+	// Length encodings, in the top two bits of the first byte
+	RDB_6BITLEN  = 0
+	RDB_14BITLEN = 1
+	RDB_32BITLEN = 0x80
+	RDB_64BITLEN = 0x81
+	RDB_ENCVAL   = 3
+
+	// Special string encodings, when the length type is RDB_ENCVAL
+	RDB_ENC_INT8  = 0
+	RDB_ENC_INT16 = 1
+	RDB_ENC_INT32 = 2
+	RDB_ENC_LZF   = 3
+
+	RDB_MAX_STRING    = 512 * 1024 * 1024 // Longest string loaded, the proto-max-bulk-len default
+	RDB_READ_CHUNK    = 64 * 1024         // Bytes allocated ahead of the data read
+	LZF_MAX_EXPANSION = 88                // Most bytes an LZF back reference produces per input byte
+)
+
+var (
+	ErrRDBSignature = errors.New("wrong signature trying to load DB from file")
+	ErrRDBChecksum  = errors.New("wrong RDB checksum")
+)
+
+// crc64Table is the Jones polynomial used by Redis, in reversed form
+var crc64Table = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// rdbChecksum computes the CRC-64 Redis appends to RDB files. Unlike the
+// hash/crc64 default it starts from zero and has no final inversion.
+type rdbChecksum struct {
+	crc uint64
+}
+
+func (c *rdbChecksum) Write(p []byte) (int, error) {
+	c.crc = ^crc64.Update(^c.crc, crc64Table, p)
+	return len(p), nil
+}
+
+func (c *rdbChecksum) Sum64() uint64 { return c.crc }
+
+// rdbWriter encodes values in the RDB format while checksumming them
+type rdbWriter struct {
+	w   *bufio.Writer
+	crc *rdbChecksum
+	err error
+}
+
+func (w *rdbWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	w.crc.Write(p)
+	_, w.err = w.w.Write(p)
+}
+
+func (w *rdbWriter) writeByte(b byte) {
+	w.write([]byte{b})
+}
+
+func (w *rdbWriter) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		w.writeByte(byte(n))
+	case n < 1<<14:
+		w.write([]byte{byte(n>>8) | RDB_14BITLEN<<6, byte(n)})
+	case n <= math.MaxUint32:
+		w.writeByte(RDB_32BITLEN)
+		w.write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		w.writeByte(RDB_64BITLEN)
+		w.write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+// writeString writes a string, as a small integer when it is one
+func (w *rdbWriter) writeString(s string) {
+	if len(s) <= 11 {
+		if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+			switch {
+			case n >= math.MinInt8 && n <= math.MaxInt8:
+				w.write([]byte{RDB_ENCVAL<<6 | RDB_ENC_INT8, byte(n)})
+			case n >= math.MinInt16 && n <= math.MaxInt16:
+				w.write(binary.LittleEndian.AppendUint16([]byte{RDB_ENCVAL<<6 | RDB_ENC_INT16}, uint16(n)))
+			default:
+				w.write(binary.LittleEndian.AppendUint32([]byte{RDB_ENCVAL<<6 | RDB_ENC_INT32}, uint32(n)))
+			}
+			return
+		}
+	}
+	w.writeLength(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *rdbWriter) writeAux(key, value string) {
+	w.writeByte(RDB_OPCODE_AUX)
+	w.writeString(key)
+	w.writeString(value)
+}
+
+func (w *rdbWriter) writeValue(key string, value *Value) {
+	switch value.Type {
+	case StringValue:
+		w.writeByte(RDB_TYPE_STRING)
+		w.writeString(key)
+		w.writeString(value.Str)
+	case SortedSetValue:
+		w.writeByte(RDB_TYPE_ZSET_2)
+		w.writeString(key)
+		w.writeLength(uint64(value.ZSet.Len()))
+		value.ZSet.Range(func(member string, score float64) bool {
+			w.writeString(member)
+			w.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(score)))
+			return w.err == nil
+		})
+	}
+}
+
+// rdbSaveInfo holds the replication fields of a snapshot sent to a replica
+type rdbSaveInfo struct {
+	replStreamDB int // Database selected in the replication stream at the snapshot
+}
+
+// WriteRDB writes a snapshot of the databases in the RDB format. Each
+// database is read locked while it is written.
+func WriteRDB(out io.Writer, dbs []*Storage) error {
+	return writeRDB(out, dbs, nil)
+}
+
+// writeRDB writes a snapshot with the replication fields of info, if any
+func writeRDB(out io.Writer, dbs []*Storage, info *rdbSaveInfo) error {
+	w := &rdbWriter{w: bufio.NewWriter(out), crc: &rdbChecksum{}}
+	w.write([]byte(fmt.Sprintf("REDIS%04d", RDB_VERSION)))
+	w.writeAux("redis-ver", REDIS_VERSION)
+	w.writeAux("redis-bits", strconv.Itoa(32<<(^uint(0)>>63)))
+	w.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	w.writeAux("used-mem", strconv.FormatUint(usedMemory(), 10))
+	if info != nil {
+		w.writeAux("repl-stream-db", strconv.Itoa(info.replStreamDB))
+	}
+
+	for _, db := range dbs {
+		db.mu.RLock()
+		if db.data.Len() > 0 {
+			w.writeByte(RDB_OPCODE_SELECTDB)
+			w.writeLength(uint64(db.index))
+			w.writeByte(RDB_OPCODE_RESIZEDB)
+			w.writeLength(uint64(db.data.Len()))
+			w.writeLength(0)
+			db.data.Range(func(key string, value *Value) bool {
+				w.writeValue(key, value)
+				return w.err == nil
+			})
+		}
+		db.mu.RUnlock()
+	}
+
+	w.writeByte(RDB_OPCODE_EOF)
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := w.w.Write(binary.LittleEndian.AppendUint64(nil, w.crc.Sum64())); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// SaveRDB writes the databases to path through a temporary file, so a
+// failed save never leaves a truncated dump behind
+func SaveRDB(path string) error {
+	start := time.Now()
+	temp := filepath.Join(filepath.Dir(path), fmt.Sprintf("temp-%d.rdb", os.Getpid()))
+	file, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("failed opening the temp RDB file %s for saving: %w", temp, err)
+	}
+	err = WriteRDB(file, databases)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, path)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return fmt.Errorf("write error saving DB on disk: %w", err)
+	}
+
+	stats.rdbSaves.Add(1)
+	stats.lastSaveTime.Store(time.Now().Unix())
+	logNotice("DB saved on disk", "seconds", time.Since(start).Seconds())
+	return nil
+}
+
+// rdbReader decodes the RDB format while checksumming what it reads
+type rdbReader struct {
+	r   *bufio.Reader
+	crc *rdbChecksum
+}
+
+// read reads n bytes. The buffer grows as the data arrives, so a corrupt
+// length fails at the end of the input instead of allocating all of it.
+func (r *rdbReader) read(n int) ([]byte, error) {
+	if n < 0 || n > RDB_MAX_STRING {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	p := make([]byte, 0, min(n, RDB_READ_CHUNK))
+	for len(p) < n {
+		start, chunk := len(p), min(n-len(p), RDB_READ_CHUNK)
+		p = slices.Grow(p, chunk)[:start+chunk]
+		if _, err := io.ReadFull(r.r, p[start:]); err != nil {
+			if start > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	r.crc.Write(p)
+	return p, nil
+}
+
+func (r *rdbReader) readByte() (byte, error) {
+	p, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// readLength reads a length, or the encoding of a special string
+func (r *rdbReader) readLength() (length uint64, encoded bool, err error) {
+	first, err := r.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case first>>6 == RDB_6BITLEN:
+		return uint64(first & 0x3f), false, nil
+	case first>>6 == RDB_14BITLEN:
+		next, err := r.readByte()
+		return uint64(first&0x3f)<<8 | uint64(next), false, err
+	case first>>6 == RDB_ENCVAL:
+		return uint64(first & 0x3f), true, nil
+	case first == RDB_32BITLEN:
+		p, err := r.read(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(p)), false, nil
+	case first == RDB_64BITLEN:
+		p, err := r.read(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(p), false, nil
+	}
+	return 0, false, fmt.Errorf("unknown length encoding %d", first)
+}
+
+func (r *rdbReader) readPlainLength() (uint64, error) {
+	length, encoded, err := r.readLength()
+	if err == nil && encoded {
+		err = errors.New("unexpected string encoding for a length")
+	}
+	return length, err
+}
+
+func (r *rdbReader) readString() (string, error) {
+	length, encoded, err := r.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		if length > RDB_MAX_STRING {
+			return "", fmt.Errorf("invalid string length %d", length)
+		}
+		p, err := r.read(int(length))
+		return string(p), err
+	}
+
+	switch length {
+	case RDB_ENC_INT8:
+		b, err := r.readByte()
+		return strconv.Itoa(int(int8(b))), err
+	case RDB_ENC_INT16:
+		p, err := r.read(2)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(p)))), nil
+	case RDB_ENC_INT32:
+		p, err := r.read(4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(p)))), nil
+	case RDB_ENC_LZF:
+		compressedLength, err := r.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		length, err := r.readPlainLength()
+		if err != nil {
+			return "", err
+		}
+		if compressedLength > RDB_MAX_STRING || length > RDB_MAX_STRING || length > compressedLength*LZF_MAX_EXPANSION {
+			return "", fmt.Errorf("invalid LZF lengths %d and %d", compressedLength, length)
+		}
+		compressed, err := r.read(int(compressedLength))
+		if err != nil {
+			return "", err
+		}
+		return lzfDecompress(compressed, int(length))
+	}
+	return "", fmt.Errorf("unknown string encoding %d", length)
+}
+
+// readDoubleString reads a score of the old zset encoding, a length prefixed
+// decimal string with special lengths for NaN and infinities
+func (r *rdbReader) readDoubleString() (float64, error) {
+	length, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	p, err := r.read(int(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(p), 64)
+}
+
+func (r *rdbReader) readValue(valueType byte) (*Value, error) {
+	switch valueType {
+	case RDB_TYPE_STRING:
+		s, err := r.readString()
+		return &Value{Type: StringValue, Str: s}, err
+	case RDB_TYPE_ZSET, RDB_TYPE_ZSET_2:
+		length, err := r.readPlainLength()
+		if err != nil {
+			return nil, err
+		}
+		zset := NewSortedSet()
+		for range length {
+			member, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == RDB_TYPE_ZSET_2 {
+				p, err := r.read(8)
+				if err != nil {
+					return nil, err
+				}
+				score = math.Float64frombits(binary.LittleEndian.Uint64(p))
+			} else if score, err = r.readDoubleString(); err != nil {
+				return nil, err
+			}
+			zset.Add(member, score)
+		}
+		return &Value{Type: SortedSetValue, ZSet: zset}, nil
+	case RDB_TYPE_ZSET_ZIPLIST, RDB_TYPE_ZSET_LISTPACK:
+		blob, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		var entries []string
+		if valueType == RDB_TYPE_ZSET_LISTPACK {
+			entries, err = listpackEntries(blob)
+		} else {
+			entries, err = ziplistEntries(blob)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(entries)%2 != 0 {
+			return nil, errors.New("sorted set with a member without a score")
+		}
+		// Members and scores alternate
+		zset := NewSortedSet()
+		for i := 0; i < len(entries); i += 2 {
+			score, err := strconv.ParseFloat(entries[i+1], 64)
+			if err != nil || math.IsNaN(score) {
+				return nil, fmt.Errorf("invalid sorted set score '%s'", entries[i+1])
+			}
+			zset.Add(entries[i], score)
+		}
+		return &Value{Type: SortedSetValue, ZSet: zset}, nil
+	}
+	return nil, r.skipValue(valueType)
+}
+
+// skipValue reads past a value of a type the server has no equivalent for,
+// like lists, sets and hashes. Streams and module values can't be skipped.
+func (r *rdbReader) skipValue(valueType byte) error {
+	skipStrings := func(n uint64) error {
+		for range n {
+			if _, err := r.readString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch valueType {
+	case RDB_TYPE_HASH_ZIPMAP, RDB_TYPE_LIST_ZIPLIST, RDB_TYPE_SET_INTSET, RDB_TYPE_HASH_ZIPLIST,
+		RDB_TYPE_HASH_LISTPACK, RDB_TYPE_SET_LISTPACK:
+		return skipStrings(1)
+	case RDB_TYPE_LIST, RDB_TYPE_SET, RDB_TYPE_HASH, RDB_TYPE_LIST_QUICKLIST, RDB_TYPE_LIST_QUICKLIST_2:
+		length, err := r.readPlainLength()
+		if err != nil {
+			return err
+		}
+		for range length {
+			if valueType == RDB_TYPE_LIST_QUICKLIST_2 {
+				// Each node says whether it is a listpack or a plain element
+				if _, err := r.readPlainLength(); err != nil {
+					return err
+				}
+			}
+			n := uint64(1)
+			if valueType == RDB_TYPE_HASH {
+				n = 2
+			}
+			if err := skipStrings(n); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported value type %d", valueType)
+}
+
+// ReadRDB loads an RDB snapshot into the databases, replacing existing keys.
+// Keys that already expired are skipped; since keys can't expire yet, the
+// others are loaded without their expiry.
+func ReadRDB(in io.Reader, dbs []*Storage) error {
+	return readRDB(in, dbs, nil)
+}
+
+// readRDB loads a snapshot, filling info with its replication fields if it isn't nil
+func readRDB(in io.Reader, dbs []*Storage, info *rdbSaveInfo) error {
+	r := &rdbReader{r: bufio.NewReader(in), crc: &rdbChecksum{}}
+
+	header, err := r.read(9)
+	if err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return ErrRDBSignature
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > RDB_VERSION {
+		return fmt.Errorf("can't handle RDB format version %s", header[5:])
+	}
+
+	db := dbs[0]
+	var expireAt time.Time
+	for {
+		opcode, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case RDB_OPCODE_EOF:
+			if version < 5 {
+				return nil
+			}
+			expected := r.crc.Sum64()
+			p := make([]byte, 8)
+			if _, err := io.ReadFull(r.r, p); err != nil {
+				return err
+			}
+			// A zero checksum means the file was saved with checksums disabled
+			if checksum := binary.LittleEndian.Uint64(p); checksum != 0 && checksum != expected {
+				return ErrRDBChecksum
+			}
+			return nil
+		case RDB_OPCODE_SELECTDB:
+			index, err := r.readPlainLength()
+			if err != nil {
+				return err
+			}
+			if index >= uint64(len(dbs)) {
+				return fmt.Errorf("data file was created with a server configured to handle more than %d databases", len(dbs))
+			}
+			db = dbs[index]
+		case RDB_OPCODE_RESIZEDB:
+			if _, err := r.readPlainLength(); err != nil {
+				return err
+			}
+			if _, err := r.readPlainLength(); err != nil {
+				return err
+			}
+		case RDB_OPCODE_AUX:
+			key, err := r.readString()
+			if err != nil {
+				return err
+			}
+			value, err := r.readString()
+			if err != nil {
+				return err
+			}
+			if key == "repl-stream-db" && info != nil {
+				if info.replStreamDB, err = strconv.Atoi(value); err != nil {
+					return fmt.Errorf("invalid repl-stream-db '%s'", value)
+				}
+			}
+		case RDB_OPCODE_EXPIRETIME_MS:
+			p, err := r.read(8)
+			if err != nil {
+				return err
+			}
+			expireAt = time.UnixMilli(int64(binary.LittleEndian.Uint64(p)))
+		case RDB_OPCODE_EXPIRETIME:
+			p, err := r.read(4)
+			if err != nil {
+				return err
+			}
+			expireAt = time.Unix(int64(binary.LittleEndian.Uint32(p)), 0)
+		case RDB_OPCODE_FREQ:
+			if _, err := r.readByte(); err != nil {
+				return err
+			}
+		case RDB_OPCODE_IDLE:
+			if _, err := r.readPlainLength(); err != nil {
+				return err
+			}
+		case RDB_OPCODE_MODULE_AUX, RDB_OPCODE_FUNCTION2:
+			return fmt.Errorf("unsupported RDB opcode %d", opcode)
+		default:
+			key, err := r.readString()
+			if err != nil {
+				return err
+			}
+			value, err := r.readValue(opcode)
+			if err != nil {
+				return fmt.Errorf("loading key '%s': %w", key, err)
+			}
+			if value == nil {
+				logWarning("Skipping a key of a type this server doesn't support", "key", key, "type", opcode)
+			} else if expireAt.IsZero() || expireAt.After(time.Now()) {
+				db.mu.Lock()
+				db.setValue(key, value)
+				db.mu.Unlock()
+			}
+			expireAt = time.Time{}
+		}
+	}
+}
+
+// LoadRDB loads the dump file at path into the databases. A missing file
+// isn't an error, the server then starts empty.
+func LoadRDB(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start := time.Now()
+	if err := ReadRDB(file, databases); err != nil {
+		return err
+	}
+	logNotice("DB loaded from disk", "seconds", time.Since(start).Seconds())
+	return nil
+}
+
+// lzfDecompress expands LZF compressed data, which Redis uses for long strings
+func lzfDecompress(in []byte, length int) (string, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// Literal run of ctrl+1 bytes
+			end := i + ctrl + 1
+			if end > len(in) {
+				return "", errors.New("invalid LZF data")
+			}
+			if len(out)+end-i > length {
+				return "", errors.New("invalid LZF data")
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+
+		// Back reference
+		runLength := ctrl >> 5
+		if runLength == 7 {
+			if i >= len(in) {
+				return "", errors.New("invalid LZF data")
+			}
+			runLength += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return "", errors.New("invalid LZF data")
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return "", errors.New("invalid LZF data")
+		}
+		if len(out)+runLength+2 > length {
+			return "", errors.New("invalid LZF data")
+		}
+		for j := range runLength + 2 {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return "", errors.New("invalid LZF data")
+	}
+	return string(out), nil
+}
+
+var errListpack = errors.New("invalid listpack")
+
+// listpackEntries decodes the elements of a listpack, the encoding Redis 7
+// saves small sorted sets with. Integers are returned in decimal.
+func listpackEntries(lp string) ([]string, error) {
+	// slice returns n bytes from start, or false past the end of the listpack
+	slice := func(start, n int) (string, bool) {
+		if n < 0 || start+n > len(lp) {
+			return "", false
+		}
+		return lp[start : start+n], true
+	}
+
+	// A 4 byte total length and a 2 byte element count precede the elements
+	var entries []string
+	for i := 6; i < len(lp); {
+		b := lp[i]
+		if b == 0xff {
+			return entries, nil
+		}
+		var entry, header string
+		ok := false
+		size := 0 // Of the encoding and data, before the back length
+		switch {
+		case b&0x80 == 0:
+			entry, size, ok = strconv.Itoa(int(b)), 1, true
+		case b&0xc0 == 0x80:
+			size = 1 + int(b&0x3f)
+			entry, ok = slice(i+1, size-1)
+		case b&0xe0 == 0xc0:
+			if header, ok = slice(i+1, 1); ok {
+				// 13 bit two's complement
+				v := int(b&0x1f)<<8 | int(header[0])
+				if v >= 1<<12 {
+					v -= 1 << 13
+				}
+				entry, size = strconv.Itoa(v), 2
+			}
+		case b&0xf0 == 0xe0:
+			if header, ok = slice(i+1, 1); ok {
+				n := int(b&0x0f)<<8 | int(header[0])
+				entry, ok = slice(i+2, n)
+				size = 2 + n
+			}
+		case b == 0xf0:
+			if header, ok = slice(i+1, 4); ok {
+				n := int(binary.LittleEndian.Uint32([]byte(header)))
+				entry, ok = slice(i+5, n)
+				size = 5 + n
+			}
+		case b >= 0xf1 && b <= 0xf4:
+			// 16, 24, 32 and 64 bit integers
+			n := []int{2, 3, 4, 8}[b-0xf1]
+			if header, ok = slice(i+1, n); ok {
+				entry, size = strconv.FormatInt(littleEndianInt(header), 10), 1+n
+			}
+		}
+		if !ok {
+			return nil, errListpack
+		}
+		entries = append(entries, entry)
+		i += size + listpackBacklenSize(size)
+	}
+	return nil, errListpack
+}
+
+// listpackBacklenSize returns the bytes the length of an entry takes when
+// stored after it, for traversing the listpack backwards
+func listpackBacklenSize(size int) int {
+	switch {
+	case size <= 127:
+		return 1
+	case size < 16383:
+		return 2
+	case size < 2097151:
+		return 3
+	case size < 268435455:
+		return 4
+	}
+	return 5
+}
+
+var errZiplist = errors.New("invalid ziplist")
+
+// ziplistEntries decodes the elements of a ziplist, the encoding Redis
+// saved small sorted sets with before 7.0
+func ziplistEntries(zl string) ([]string, error) {
+	// The total length, tail offset and element count take 10 bytes
+	var entries []string
+	for i := 10; i < len(zl); {
+		if zl[i] == 0xff {
+			return entries, nil
+		}
+		// Skip the length of the previous entry
+		if zl[i] == 0xfe {
+			i += 5
+		} else {
+			i++
+		}
+		if i >= len(zl) {
+			return nil, errZiplist
+		}
+
+		b := zl[i]
+		header, length := 1, 0
+		integer := false
+		switch {
+		case b>>6 == 0:
+			length = int(b & 0x3f)
+		case b>>6 == 1:
+			header = 2
+			if i+2 <= len(zl) {
+				length = int(b&0x3f)<<8 | int(zl[i+1])
+			}
+		case b>>6 == 2:
+			header = 5
+			if i+5 <= len(zl) {
+				length = int(binary.BigEndian.Uint32([]byte(zl[i+1 : i+5])))
+			}
+		case b == 0xc0, b == 0xd0, b == 0xe0, b == 0xf0, b == 0xfe:
+			integer = true
+			length = map[byte]int{0xc0: 2, 0xd0: 4, 0xe0: 8, 0xf0: 3, 0xfe: 1}[b]
+		case b >= 0xf1 && b <= 0xfd:
+			// Small integers are stored in the encoding itself
+			entries = append(entries, strconv.Itoa(int(b&0x0f)-1))
+			i++
+			continue
+		default:
+			return nil, errZiplist
+		}
+		if length < 0 || i+header+length > len(zl) {
+			return nil, errZiplist
+		}
+		data := zl[i+header : i+header+length]
+		if integer {
+			data = strconv.FormatInt(littleEndianInt(data), 10)
+		}
+		entries = append(entries, data)
+		i += header + length
+	}
+	return nil, errZiplist
+}
+
+// littleEndianInt decodes a signed little endian integer of len(p) bytes
+func littleEndianInt(p string) int64 {
+	var v uint64
+	for i := len(p) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(p[i])
+	}
+	shift := 64 - 8*len(p)
+	return int64(v<<shift) >> shift
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestRDBChecksum(t *testing.T) {
+	// Test vector from the Redis crc64 implementation
+	crc := &rdbChecksum{}
+	crc.Write([]byte("123456789"))
+	if crc.Sum64() != 0xe9c6d914c4b8d9ca {
+		t.Errorf("Expected checksum e9c6d914c4b8d9ca, got %x", crc.Sum64())
+	}
+}
+
+func TestWriteRDB(t *testing.T) {
+	dbs := NewDatabases(4)
+	dbs[0].Set("plain", "hello")
+	dbs[0].Set("small", "-12")
+	dbs[3].Set("other", "value")
+
+	var buf bytes.Buffer
+	if err := WriteRDB(&buf, dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("REDIS0011")) {
+		t.Errorf("Unexpected header %q", data[:9])
+	}
+	// Only the databases holding keys are written, integers in their short encoding
+	for _, expected := range [][]byte{
+		{RDB_OPCODE_SELECTDB, 0, RDB_OPCODE_RESIZEDB, 2, 0},
+		[]byte("\x00\x05plain\x05hello"),
+		[]byte("\x00\x05small\xc0\xf4"),
+		{RDB_OPCODE_SELECTDB, 3, RDB_OPCODE_RESIZEDB, 1, 0},
+	} {
+		if !bytes.Contains(data, expected) {
+			t.Errorf("Expected %q in the dump", expected)
+		}
+	}
+	if bytes.Contains(data, []byte{RDB_OPCODE_SELECTDB, 1}) {
+		t.Error("Expected the empty databases to be skipped")
+	}
+
+	end := len(data) - 8
+	crc := &rdbChecksum{}
+	crc.Write(data[:end])
+	if data[end-1] != RDB_OPCODE_EOF || binary.LittleEndian.Uint64(data[end:]) != crc.Sum64() {
+		t.Errorf("Expected the dump to end with EOF and its checksum, got %x", data[end-1:])
+	}
+}
+
+func TestRDBRoundTrip(t *testing.T) {
+	dbs := NewDatabases(4)
+	long := strings.Repeat("x", 20000)
+	dbs[0].Set("plain", "hello")
+	dbs[0].Set("small", "-12")
+	dbs[0].Set("medium", "30000")
+	dbs[0].Set("large", "2000000000")
+	dbs[0].Set("padded", "007")
+	dbs[0].Set("long", long)
+	dbs[0].Set("empty", "")
+	zset := NewSortedSet()
+	zset.Add("a", 1.5)
+	zset.Add("b", math.Inf(1))
+	zset.Add("c", math.Inf(-1))
+	dbs[3].data.Set("zset", &Value{Type: SortedSetValue, ZSet: zset})
+
+	var buf bytes.Buffer
+	if err := WriteRDB(&buf, dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("REDIS0011")) {
+		t.Errorf("Unexpected header %q", buf.Bytes()[:9])
+	}
+
+	loaded := NewDatabases(4)
+	if err := ReadRDB(bytes.NewReader(buf.Bytes()), loaded); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, key := range []string{"plain", "small", "medium", "large", "padded", "long", "empty"} {
+		expected, _ := dbs[0].Get(key)
+		if value, ok := loaded[0].Get(key); !ok || value != expected {
+			t.Errorf("Expected %s to be %.20q, got %.20q", key, expected, value)
+		}
+	}
+	if loaded[1].Len() != 0 || loaded[2].Len() != 0 {
+		t.Error("Expected databases 1 and 2 to stay empty")
+	}
+	loadedZSet, err := loaded[3].sortedSetValue("zset")
+	if err != nil || loadedZSet == nil || loadedZSet.Len() != 3 {
+		t.Fatalf("Expected the sorted set to be loaded, got %v, %v", loadedZSet, err)
+	}
+	for member, score := range map[string]float64{"a": 1.5, "b": math.Inf(1), "c": math.Inf(-1)} {
+		if loadedScore, _ := loadedZSet.Score(member); loadedScore != score {
+			t.Errorf("Expected %s to have score %v, got %v", member, score, loadedScore)
+		}
+	}
+}
+
+func TestRDBErrors(t *testing.T) {
+	dbs := NewDatabases(2)
+	dbs[1].Set("key", "value")
+	var buf bytes.Buffer
+	if err := WriteRDB(&buf, dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	valid := buf.Bytes()
+
+	corrupted := bytes.Clone(valid)
+	corrupted[len(corrupted)-1] ^= 0xff
+	// A string key k whose value claims a length far beyond the input
+	hostile := func(value ...byte) []byte {
+		return append([]byte("REDIS0011\x00\x01k"), value...)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		dbs      int
+		expected string
+	}{
+		{"signature", []byte("RADIS0011"), 2, "wrong signature trying to load DB from file"},
+		{"version", []byte("REDIS0099"), 2, "can't handle RDB format version 0099"},
+		{"checksum", corrupted, 2, "wrong RDB checksum"},
+		{"databases", valid, 1, "data file was created with a server configured to handle more than 1 databases"},
+		{"truncated", valid[:len(valid)-12], 2, "loading key 'key': unexpected EOF"},
+		{"long string", hostile(0x80, 0x10, 0, 0, 0, 'a', 'b', 'c'), 2, "loading key 'k': unexpected EOF"},
+		{"too long string", hostile(0x80, 0x7f, 0xff, 0xff, 0xff), 2, "loading key 'k': invalid string length 2147483647"},
+		{"LZF lengths", hostile(0xc3, 0x01, 0x80, 0x10, 0, 0, 0, 'a'), 2, "loading key 'k': invalid LZF lengths 1 and 268435456"},
+	}
+	for _, tt := range tests {
+		err := ReadRDB(bytes.NewReader(tt.data), NewDatabases(tt.dbs))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestRDBCompactEncodings(t *testing.T) {
+	// Sorted sets as Redis 7 saves them, as a listpack: a {1 a 2.5 b -300 c}
+	listpack := "\x1a\x00\x00\x00\x06\x00" +
+		"\x81a\x02" + "\x01\x01" + "\x81b\x02" + "\x832.5\x04" + "\x81c\x02" + "\xde\xd4\x02" + "\xff"
+	// And as older versions did, as a ziplist: z {5 x 1.5 y 1000 w}
+	ziplist := "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x00\x01x" + "\x03\xf6" + "\x02\x01y" + "\x03\x031.5" + "\x05\x01w" + "\x03\xc0\xe8\x03" + "\xff"
+	data := "REDIS0011" +
+		"\x11\x01a" + string(byte(len(listpack))) + listpack +
+		"\x0c\x01z" + string(byte(len(ziplist))) + ziplist +
+		// A list, which is skipped
+		"\x01\x01l\x02\x01p\x01q" +
+		"\x00\x01k\x01v" +
+		"\xff\x00\x00\x00\x00\x00\x00\x00\x00"
+
+	dbs := NewDatabases(1)
+	if err := ReadRDB(strings.NewReader(data), dbs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for key, scores := range map[string]map[string]float64{
+		"a": {"a": 1, "b": 2.5, "c": -300},
+		"z": {"x": 5, "y": 1.5, "w": 1000},
+	} {
+		zset, err := dbs[0].sortedSetValue(key)
+		if err != nil || zset == nil || zset.Len() != len(scores) {
+			t.Fatalf("Expected %s to load as a sorted set of %d members, got %v, %v", key, len(scores), zset, err)
+		}
+		for member, score := range scores {
+			if loaded, _ := zset.Score(member); loaded != score {
+				t.Errorf("Expected %s to have score %v in %s, got %v", member, score, key, loaded)
+			}
+		}
+	}
+	if dbs[0].Exists("l") != 0 {
+		t.Error("Expected the list to be skipped")
+	}
+	if value, _ := dbs[0].Get("k"); value != "v" {
+		t.Errorf("Expected the key after the list to load, got %q", value)
+	}
+
+	if _, err := listpackEntries("\x00\x00\x00\x00\x00\x00\x85ab"); err == nil {
+		t.Error("Expected an error for a truncated listpack")
+	}
+	if err := ReadRDB(strings.NewReader("REDIS0011\x0f\x01s"), NewDatabases(1)); err == nil ||
+		err.Error() != "loading key 's': unsupported value type 15" {
+		t.Errorf("Expected streams to be unsupported, got %v", err)
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	// A literal run of "abc" followed by an overlapping back reference of 6 bytes
+	compressed := []byte{2, 'a', 'b', 'c', 4 << 5, 2}
+	if value, err := lzfDecompress(compressed, 9); err != nil || value != "abcabcabc" {
+		t.Errorf("Expected abcabcabc, got %q, %v", value, err)
+	}
+	if _, err := lzfDecompress([]byte{0x20, 5}, 3); err == nil {
+		t.Error("Expected an error for a reference before the start")
+	}
+}
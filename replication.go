@@ -0,0 +1,1073 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	REPLICA_BUFFER_SIZE   = 1 << 16   // Stream writes queued for a replica before it is disconnected as too slow
+	REPL_BACKLOG_MIN_SIZE = 16 * 1024 // Smallest backlog repl-backlog-size can set
+	REPL_BACKLOG_DEFAULT  = 1024 * 1024
+	REPL_ID_NONE          = "0000000000000000000000000000000000000000" // replid2 without a previous history
+)
+
+var (
+	ErrReadOnly     = errors.New("READONLY You can't write against a read only replica.")
+	ErrNoMasterLink = errors.New("NOMASTERLINK Can't SYNC while not connected with my master")
+	ErrMasterPort   = errors.New("ERR Invalid master port")
+	errLinkStopped  = errors.New("replication from this master was stopped")
+)
+
+// replica is a client synchronizing from this server. The stream is queued
+// on feed from the offset of its snapshot, and written by its own goroutine
+// once the snapshot is sent, so a slow replica never holds up writes.
+type replica struct {
+	client    *Client
+	feed      chan []byte
+	snapshot  []byte      // RDB sent after the sync reply, nil once sent or when continuing the stream
+	online    atomic.Bool // The snapshot was sent and the stream is being written
+	dropped   atomic.Bool // Disconnected for falling behind
+	ackOffset atomic.Int64
+	ackTime   atomic.Int64 // Unix time of the last REPLCONF ACK
+}
+
+// masterLink is the connection of a replica to its master. Its fields are
+// guarded by Replication.mu.
+type masterLink struct {
+	host      string
+	port      int
+	stop      chan struct{} // Closed when the server stops replicating from this master
+	conn      net.Conn
+	client    *Client // Applies the stream once the snapshot is loaded
+	state     string  // connect, connecting, sync or connected
+	lastIO    time.Time
+	downSince time.Time
+	cachedDB  int // Database the stream was on when the link was lost, -1 if the stream can't be continued
+}
+
+// stopped reports whether the server stopped replicating from this master
+func (l *masterLink) stopped() bool {
+	select {
+	case <-l.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Replication holds both sides of replication: the stream of writes fed to
+// the replicas of this server, and the link to its own master if it is a
+// replica. A replica feeds its replicas the stream of its master unchanged,
+// so the offsets are the same along a chain of replicas.
+type Replication struct {
+	// writeMu is held shared by write commands while they run, so a
+	// snapshot taken holding it always matches a stream offset. While there
+	// is a stream to feed they hold it exclusively until they are fed to
+	// it, so replicas apply writes in the order this server did.
+	writeMu   sync.RWMutex
+	streaming atomic.Bool // Writes are fed to the stream, set holding writeMu and mu
+
+	mu           sync.Mutex
+	replID       string // Identifies the history of the dataset
+	replID2      string // History the dataset shared until a promotion, REPL_ID_NONE if none
+	offset       int64  // Bytes of stream fed to the replicas, or applied from the master
+	secondOffset int64  // First offset the history of replID2 doesn't have, -1 if none
+	streamDB     int    // Database the stream last selected, -1 to select one before the next write
+	backlog      *backlog
+	replicas     map[*Client]*replica
+	noReplicas   time.Time   // When the last replica disconnected, for repl-backlog-ttl
+	master       *masterLink // nil when this server is a master
+}
+
+var replication = &Replication{
+	replID:       randomHexID(),
+	replID2:      REPL_ID_NONE,
+	secondOffset: -1,
+	streamDB:     -1,
+	replicas:     make(map[*Client]*replica),
+}
+
+// backlog keeps the end of the replication stream in a circular buffer, so
+// a replica that lost its link can continue from its offset instead of
+// loading a new snapshot
+type backlog struct {
+	buf     []byte
+	idx     int   // Where the next byte is written
+	histlen int   // Bytes of stream held, up to len(buf)
+	offset  int64 // Replication offset of the first byte held
+}
+
+// newBacklog creates an empty backlog continuing a stream at offset
+func newBacklog(size int64, offset int64) *backlog {
+	return &backlog{buf: make([]byte, size), offset: offset + 1}
+}
+
+// write appends to the stream held, overwriting its oldest bytes once full
+func (b *backlog) write(p []byte) {
+	b.offset += int64(max(0, b.histlen+len(p)-len(b.buf)))
+	b.histlen = min(b.histlen+len(p), len(b.buf))
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+	n := copy(b.buf[b.idx:], p)
+	copy(b.buf, p[n:])
+	b.idx = (b.idx + len(p)) % len(b.buf)
+}
+
+// contains reports whether the stream from offset on is held
+func (b *backlog) contains(offset int64) bool {
+	return offset >= b.offset && offset <= b.offset+int64(b.histlen)
+}
+
+// since returns a copy of the stream from offset on, which must be held
+func (b *backlog) since(offset int64) []byte {
+	n := b.histlen - int(offset-b.offset)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	stream := make([]byte, 0, n)
+	if start+n <= len(b.buf) {
+		return append(stream, b.buf[start:start+n]...)
+	}
+	stream = append(stream, b.buf[start:]...)
+	return append(stream, b.buf[:b.idx]...)
+}
+
+// resize returns a backlog of another size holding as much of the stream as fits
+func (b *backlog) resize(size int64) *backlog {
+	resized := &backlog{buf: make([]byte, size), offset: b.offset}
+	resized.write(b.since(b.offset))
+	return resized
+}
+
+// IsReplica reports whether the server replicates from a master
+func (r *Replication) IsReplica() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.master != nil
+}
+
+// Offset returns the replication offset of the server
+func (r *Replication) Offset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offset
+}
+
+// beginWrite is called before a write command runs and returns whether the
+// write must be propagated, in which case writeMu is held exclusively. A
+// master without a backlog or replicas has no stream to feed, so its writes
+// only hold writeMu shared and run concurrently. endWrite releases it.
+func (r *Replication) beginWrite() bool {
+	r.writeMu.RLock()
+	if !r.streaming.Load() {
+		return false
+	}
+	r.writeMu.RUnlock()
+	r.writeMu.Lock()
+	// The stream may have stopped while waiting for the lock, which only
+	// skips a Propagate that would return without feeding it
+	return true
+}
+
+// endWrite releases writeMu after a write begun with beginWrite
+func (r *Replication) endWrite(propagate bool) {
+	if propagate {
+		r.writeMu.Unlock()
+	} else {
+		r.writeMu.RUnlock()
+	}
+}
+
+// updateStreaming records whether writes are fed to the stream, which is
+// when the server is a master with a backlog. The caller holds writeMu
+// exclusively and mu.
+func (r *Replication) updateStreaming() {
+	r.streaming.Store(r.master == nil && r.backlog != nil)
+}
+
+// Propagate feeds a write that ran on database db to the replicas and the
+// backlog. A replica only forwards the stream of its master, its own writes
+// stay local. The caller holds writeMu exclusively.
+func (r *Replication) Propagate(db int, argv []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != nil || r.backlog == nil {
+		return
+	}
+	var stream []byte
+	if db != r.streamDB {
+		stream = bulkStringArray([]string{"SELECT", strconv.Itoa(db)}).Serialize()
+		r.streamDB = db
+	}
+	r.feed(append(stream, bulkStringArray(argv).Serialize()...))
+}
+
+// feed appends to the stream and the backlog, and queues it for every
+// replica. A replica whose queue is full is disconnected, like a Redis
+// replica over its output buffer limit. The caller holds mu.
+func (r *Replication) feed(stream []byte) {
+	r.offset += int64(len(stream))
+	if r.backlog != nil {
+		r.backlog.write(stream)
+	}
+	for _, rep := range r.replicas {
+		select {
+		case rep.feed <- stream:
+		default:
+			if !rep.dropped.Swap(true) {
+				logWarning("Closing a replica falling behind the replication stream", "replica", rep.client.addr())
+				rep.client.kill()
+			}
+		}
+	}
+}
+
+// addReplica registers a client asking to continue the history psyncID
+// from psyncOffset. If the backlog still holds the stream from there, the
+// replica gets the rest of it and no snapshot. Otherwise writes wait while
+// the dataset is copied for a full resynchronization, and the replica gets
+// every write after the copy. The copy is encoded once writes go on, like
+// the forked child of a Redis BGSAVE. It returns the replication id and the
+// offset the replica continues from.
+func (r *Replication) addReplica(c *Client, psyncID string, psyncOffset int64) (*replica, string, int64, error) {
+	backlogSize := currentConfig().ReplBacklogSize
+	r.writeMu.Lock()
+	r.mu.Lock()
+	info := &rdbSaveInfo{}
+	if link := r.master; link != nil {
+		if link.client == nil {
+			r.mu.Unlock()
+			r.writeMu.Unlock()
+			return nil, "", 0, ErrNoMasterLink
+		}
+		// The forwarded stream goes on in the database the master last selected
+		info.replStreamDB = link.client.db()
+	}
+	if stream, ok := r.continueFrom(c, psyncID, psyncOffset); ok {
+		defer r.writeMu.Unlock()
+		defer r.mu.Unlock()
+		rep := &replica{client: c, feed: make(chan []byte, REPLICA_BUFFER_SIZE)}
+		if len(stream) > 0 {
+			rep.feed <- stream
+		}
+		r.replicas[c] = rep
+		logNotice("Partial resynchronization request accepted", "replica", c.addr(), "offset", psyncOffset, "bytes", len(stream))
+		return rep, r.replID, psyncOffset - 1, nil
+	}
+	r.mu.Unlock()
+	dbs := copyDatabases()
+
+	r.mu.Lock()
+	if r.master == nil {
+		// The replica starts on database 0, so select the database of the next write
+		r.streamDB = -1
+		if r.backlog == nil {
+			// The offset didn't move without a backlog, so replicas of the
+			// current id may have missed writes and can't continue it
+			r.replID = randomHexID()
+			r.clearReplID2()
+			r.backlog = newBacklog(backlogSize, r.offset)
+			r.updateStreaming()
+		}
+	}
+	// The stream after the copy is queued while the snapshot is encoded
+	rep := &replica{client: c, feed: make(chan []byte, REPLICA_BUFFER_SIZE)}
+	r.replicas[c] = rep
+	replID, offset := r.replID, r.offset
+	r.mu.Unlock()
+	r.writeMu.Unlock()
+
+	var snapshot bytes.Buffer
+	if err := writeRDB(&snapshot, dbs, info); err != nil {
+		r.RemoveReplica(c)
+		return nil, "", 0, err
+	}
+	rep.snapshot = snapshot.Bytes()
+	return rep, replID, offset, nil
+}
+
+// copyDatabases copies every database to take a snapshot of them. The
+// values are copied as some commands update them in place. The caller holds
+// writeMu exclusively, so no write is running.
+func copyDatabases() []*Storage {
+	dbs := NewDatabases(len(databases))
+	for i, db := range databases {
+		db.mu.RLock()
+		db.data.Range(func(key string, value *Value) bool {
+			dbs[i].data.Set(key, value.Copy())
+			return true
+		})
+		db.mu.RUnlock()
+	}
+	return dbs
+}
+
+// continueFrom returns the stream a replica misses to continue the history
+// replID from offset, or false if the replica needs a full
+// resynchronization. The caller holds mu.
+func (r *Replication) continueFrom(c *Client, replID string, offset int64) ([]byte, bool) {
+	if replID == "?" {
+		return nil, false
+	}
+	if replID != r.replID && (replID != r.replID2 || offset > r.secondOffset) {
+		if replID == r.replID2 {
+			logNotice("Partial resynchronization not accepted, the replica is ahead of the previous history",
+				"replica", c.addr(), "offset", offset, "second_repl_offset", r.secondOffset)
+		} else {
+			logNotice("Partial resynchronization not accepted, replication id mismatch",
+				"replica", c.addr(), "replid", replID, "master_replid", r.replID, "master_replid2", r.replID2)
+		}
+		return nil, false
+	}
+	if r.backlog == nil || !r.backlog.contains(offset) {
+		logNotice("Unable to partial resync with replica, the backlog lacks its offset", "replica", c.addr(), "offset", offset)
+		return nil, false
+	}
+	return r.backlog.since(offset), true
+}
+
+// shiftReplID starts the history replID, keeping the current one as the
+// history the dataset shares up to the current offset
+func (r *Replication) shiftReplID(replID string) {
+	r.replID2 = r.replID
+	r.secondOffset = r.offset + 1
+	r.replID = replID
+	logNotice("Setting secondary replication ID", "replid2", r.replID2, "second_repl_offset", r.secondOffset)
+}
+
+// clearReplID2 forgets the previous history. The caller holds mu.
+func (r *Replication) clearReplID2() {
+	r.replID2 = REPL_ID_NONE
+	r.secondOffset = -1
+}
+
+// ResizeBacklog changes the size of the backlog, keeping as much of the
+// stream as fits
+func (r *Replication) ResizeBacklog(size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.backlog != nil && int64(len(r.backlog.buf)) != size {
+		r.backlog = r.backlog.resize(size)
+	}
+}
+
+// BacklogSize returns the memory used by the backlog
+func (r *Replication) BacklogSize() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.backlog == nil {
+		return 0
+	}
+	return int64(len(r.backlog.buf))
+}
+
+// Attach sends its snapshot to a replica once the reply to its sync request
+// is written, then starts writing the stream to it
+func (r *Replication) Attach(c *Client) {
+	r.mu.Lock()
+	rep := r.replicas[c]
+	r.mu.Unlock()
+	if rep == nil || rep.online.Load() {
+		return
+	}
+
+	if snapshot := rep.snapshot; snapshot != nil {
+		rep.snapshot = nil
+		if _, err := c.write(append([]byte(fmt.Sprintf("$%d\r\n", len(snapshot))), snapshot...)); err != nil {
+			c.kill()
+			return
+		}
+		logNotice("Synchronization with replica succeeded", "replica", c.addr())
+	}
+	rep.ackTime.Store(time.Now().Unix())
+	rep.online.Store(true)
+	go rep.write()
+}
+
+// RemoveReplica stops feeding a replica, once it disconnects
+func (r *Replication) RemoveReplica(c *Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rep, exists := r.replicas[c]; exists {
+		delete(r.replicas, c)
+		close(rep.feed)
+		if len(r.replicas) == 0 {
+			r.noReplicas = time.Now()
+		}
+		logNotice("Connection with replica lost", "replica", c.addr())
+	}
+}
+
+// disconnectReplicas closes the connections of the replicas, which have to
+// synchronize again. The caller holds mu.
+func (r *Replication) disconnectReplicas() {
+	for c := range r.replicas {
+		c.kill()
+	}
+}
+
+// write sends the queued stream until the feed is closed or the connection fails
+func (rep *replica) write() {
+	for stream := range rep.feed {
+		if _, err := rep.client.write(stream); err != nil {
+			rep.client.kill()
+			for range rep.feed {
+				// Drain until RemoveReplica closes the feed
+			}
+			return
+		}
+	}
+}
+
+// SetMaster makes the server a replica of host:port. It returns false if it
+// already replicates from that master. The server asks the new master to
+// continue the stream it has, either from its previous master or its own as
+// a master, which works when the new master replicated from the same
+// history. Its replicas stay connected unless the dataset is replaced.
+func (r *Replication) SetMaster(host string, port int) bool {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link := &masterLink{host: host, port: port, stop: make(chan struct{}), state: "connect", downSince: time.Now(), cachedDB: -1}
+	if previous := r.master; previous != nil {
+		if previous.host == host && previous.port == port {
+			return false
+		}
+		r.stopLink()
+		link.cachedDB = previous.cachedDB
+		if previous.client != nil {
+			link.cachedDB = previous.client.db()
+		}
+	} else if r.backlog != nil {
+		link.cachedDB = max(r.streamDB, 0)
+	}
+	r.master = link
+	r.updateStreaming()
+	logNotice("Connecting to MASTER", "address", net.JoinHostPort(host, strconv.Itoa(port)))
+	go r.replicate(link)
+	return true
+}
+
+// Promote stops replicating and makes the server a master. The dataset may
+// now diverge from the old master, so it gets a new replication id, keeping
+// the old one as its history up to the current offset. Replicas of the old
+// master can then continue their stream from this server. Its own replicas
+// are disconnected to learn the new id when they continue.
+func (r *Replication) Promote() {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.master == nil {
+		return
+	}
+	r.stopLink()
+	r.master = nil
+	r.updateStreaming()
+	r.shiftReplID(randomHexID())
+	r.streamDB = -1
+	r.disconnectReplicas()
+	logNotice("MASTER MODE enabled")
+}
+
+// stopLink stops replicating from the current master. The caller holds
+// writeMu, so no command of the master is running, and mu.
+func (r *Replication) stopLink() {
+	close(r.master.stop)
+	if r.master.conn != nil {
+		r.master.conn.Close()
+	}
+}
+
+// replicate synchronizes from a master until the server stops replicating
+// from it, connecting again a second after the link fails
+func (r *Replication) replicate(link *masterLink) {
+	for {
+		err := r.syncWithMaster(link)
+		if link.stopped() {
+			return
+		}
+		logWarning("Connection with master lost", "error", err)
+
+		r.mu.Lock()
+		if link.client != nil {
+			// Remember where the stream was to continue it
+			link.cachedDB = link.client.db()
+		}
+		link.state = "connect"
+		link.conn = nil
+		link.client = nil
+		link.downSince = time.Now()
+		r.mu.Unlock()
+
+		select {
+		case <-link.stop:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// timeoutReader extends the read deadline of a connection on every read, so
+// a master silent for longer than the timeout fails the link
+type timeoutReader struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (t timeoutReader) Read(p []byte) (int, error) {
+	t.conn.SetReadDeadline(time.Now().Add(t.timeout))
+	return t.conn.Read(p)
+}
+
+// syncWithMaster connects to the master, loads its snapshot and applies its
+// stream until the link fails
+func (r *Replication) syncWithMaster(link *masterLink) error {
+	c := currentConfig()
+	timeout := time.Duration(c.ReplTimeout) * time.Second
+
+	r.mu.Lock()
+	link.state = "connecting"
+	r.mu.Unlock()
+	conn, err := net.DialTimeout(PROTOCOL, net.JoinHostPort(link.host, strconv.Itoa(link.port)), timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r.mu.Lock()
+	if link.stopped() {
+		r.mu.Unlock()
+		return errLinkStopped
+	}
+	link.conn = conn
+	psyncID, psyncOffset := "?", int64(-1)
+	if link.cachedDB >= 0 {
+		psyncID, psyncOffset = r.replID, r.offset+1
+	}
+	r.mu.Unlock()
+
+	logNotice("MASTER <-> REPLICA sync started")
+	reader := bufio.NewReader(timeoutReader{conn, timeout})
+	reply, err := masterHandshake(conn, reader, &c, psyncID, psyncOffset)
+	if err != nil {
+		return err
+	}
+
+	var master *Client
+	fields := strings.Fields(reply.Str)
+	switch {
+	case reply.Type != Error && len(fields) >= 1 && fields[0] == "CONTINUE":
+		// Masters without the psync2 capability don't send their id
+		replID := ""
+		if len(fields) > 1 {
+			replID = fields[1]
+		}
+		if master, err = r.partialSync(link, conn, reader, replID, c.ReplBacklogSize); err != nil {
+			return err
+		}
+	case reply.Type != Error && len(fields) == 3 && fields[0] == "FULLRESYNC":
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset in the reply to PSYNC from master: %s", reply.Str)
+		}
+		logNotice("Full resync from master", "replid", fields[1], "offset", offset)
+
+		r.mu.Lock()
+		link.state = "sync"
+		r.mu.Unlock()
+		if master, err = r.fullSync(link, conn, reader, fields[1], offset, c.ReplBacklogSize); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unexpected reply to PSYNC from master: %s", reply.Str)
+	}
+	defer clients.Remove(master)
+	return r.applyStream(link, master, reader)
+}
+
+// masterHandshake introduces the replica to its master and asks for the
+// stream of the history psyncID from psyncOffset, returning the reply to PSYNC
+func masterHandshake(conn net.Conn, reader *bufio.Reader, c *Config, psyncID string, psyncOffset int64) (*RESPValue, error) {
+	send := func(args ...string) (*RESPValue, error) {
+		if _, err := conn.Write(bulkStringArray(args).Serialize()); err != nil {
+			return nil, err
+		}
+		return ParseRESP(reader)
+	}
+
+	// A master requiring a password answers NOAUTH until the AUTH below
+	reply, err := send("PING")
+	if err != nil {
+		return nil, err
+	}
+	if reply.Type == Error && !strings.HasPrefix(reply.Str, "NOAUTH") && !strings.HasPrefix(reply.Str, "NOPERM") {
+		return nil, fmt.Errorf("error reply to PING from master: '%s'", reply.Str)
+	}
+	if c.MasterAuth != "" {
+		args := []string{"AUTH", c.MasterAuth}
+		if c.MasterUser != "" {
+			args = []string{"AUTH", c.MasterUser, c.MasterAuth}
+		}
+		if reply, err = send(args...); err != nil {
+			return nil, err
+		}
+		if reply.Type == Error {
+			return nil, fmt.Errorf("unable to AUTH to MASTER: %s", reply.Str)
+		}
+	}
+
+	// Like Redis, errors are ignored as older masters don't know every option
+	if _, err := send("REPLCONF", "listening-port", strconv.Itoa(c.Port)); err != nil {
+		return nil, err
+	}
+	if _, err := send("REPLCONF", "capa", "psync2"); err != nil {
+		return nil, err
+	}
+	if psyncID != "?" {
+		logNotice("Trying a partial resynchronization", "replid", psyncID, "offset", psyncOffset)
+	}
+	return send("PSYNC", psyncID, strconv.FormatInt(psyncOffset, 10))
+}
+
+// readSnapshotLength reads the header of the RDB payload of a full
+// resynchronization. The master may send newlines to keep the link alive
+// while it prepares the snapshot.
+func readSnapshotLength(reader *bufio.Reader) (int64, error) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "$") || strings.HasPrefix(line, "$EOF:") {
+			return 0, fmt.Errorf("bad protocol from MASTER, the first byte is not '$': %s", line)
+		}
+		length, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || length < 0 {
+			return 0, fmt.Errorf("invalid snapshot length from MASTER: %s", line)
+		}
+		return length, nil
+	}
+}
+
+// fullSync replaces the dataset with the snapshot of the master and returns
+// the client applying the stream that follows it
+func (r *Replication) fullSync(link *masterLink, conn net.Conn, reader *bufio.Reader, replID string, offset int64, backlogSize int64) (*Client, error) {
+	length, err := readSnapshotLength(reader)
+	if err != nil {
+		return nil, err
+	}
+	logNotice("MASTER <-> REPLICA sync: receiving bytes from master", "bytes", length)
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if link.stopped() {
+		return nil, errLinkStopped
+	}
+	start := time.Now()
+	r.mu.Lock()
+	// The stream of the old dataset can't be continued once it is flushed
+	link.cachedDB = -1
+	r.disconnectReplicas()
+	r.mu.Unlock()
+	FlushAll(true)
+
+	info := &rdbSaveInfo{}
+	payload := io.LimitReader(reader, length)
+	if err := readRDB(payload, databases, info); err != nil {
+		return nil, fmt.Errorf("failed trying to load the MASTER synchronization DB: %w", err)
+	}
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return nil, err
+	}
+
+	master := newMasterClient(conn, reader, info.replStreamDB)
+	r.mu.Lock()
+	r.replID = replID
+	r.clearReplID2()
+	r.offset = offset
+	r.backlog = newBacklog(backlogSize, offset)
+	link.client = master
+	link.state = "connected"
+	link.lastIO = time.Now()
+	r.mu.Unlock()
+	logNotice("MASTER <-> REPLICA sync: Finished with success", "seconds", time.Since(start).Seconds())
+	return master, nil
+}
+
+// partialSync continues the stream of the master from the offset of the
+// replica, keeping the dataset. A master with a new replication id was
+// promoted, or replicates from a promoted server: the dataset shares its
+// history up to the current offset, which is kept as the second id.
+func (r *Replication) partialSync(link *masterLink, conn net.Conn, reader *bufio.Reader, replID string, backlogSize int64) (*Client, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if link.stopped() {
+		return nil, errLinkStopped
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	master := newMasterClient(conn, reader, link.cachedDB)
+	if replID != "" && replID != r.replID {
+		r.shiftReplID(replID)
+		// The replicas of this server learn the new id when they continue
+		r.disconnectReplicas()
+	}
+	if r.backlog == nil {
+		r.backlog = newBacklog(backlogSize, r.offset)
+	}
+	link.client = master
+	link.state = "connected"
+	link.lastIO = time.Now()
+	logNotice("MASTER <-> REPLICA sync: Master accepted a Partial Resynchronization", "offset", r.offset)
+	return master, nil
+}
+
+// newMasterClient registers the client applying the stream of the master,
+// starting on database db
+func newMasterClient(conn net.Conn, reader *bufio.Reader, db int) *Client {
+	if db < 0 || db >= len(databases) {
+		db = 0
+	}
+	master := clients.Add(conn, reader)
+	master.mu.Lock()
+	defer master.mu.Unlock()
+	master.master = true
+	master.authenticated = true
+	master.storage = databases[db]
+	return master
+}
+
+// applyStream runs the commands the master streams until the link fails
+func (r *Replication) applyStream(link *masterLink, master *Client, reader *bufio.Reader) error {
+	for {
+		value, err := ParseRESP(reader)
+		if err != nil {
+			return err
+		}
+		if value.Type != Array || len(value.Array) == 0 {
+			continue
+		}
+		command := strings.ToUpper(value.Array[0].Str)
+		response, err := r.apply(link, master, command, value)
+		if err != nil {
+			return err
+		}
+		if command == "REPLCONF" && response != nil {
+			// Only REPLCONF GETACK is answered
+			if _, err := master.write(response.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// apply runs a command of the master and forwards it to the replicas of
+// this server. It fails if the server stopped replicating meanwhile. A
+// CLIENT PAUSE holds the stream before writeMu is taken, so the pause
+// doesn't block the cron, REPLICAOF or replicas syncing from this server.
+func (r *Replication) apply(link *masterLink, master *Client, command string, value *RESPValue) (*RESPValue, error) {
+	if spec := resolveCommand(command, value.Array[1:]); spec != nil {
+		pause.Wait(spec)
+	}
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if link.stopped() {
+		return nil, errLinkStopped
+	}
+
+	stats.totalCommands.Add(1)
+	start := time.Now()
+	response := master.execute(command, value.Array[1:])
+	if master.called != nil {
+		master.called.stats.record(time.Since(start), response != nil && response.Type == Error)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link.lastIO = time.Now()
+	r.feed(value.Serialize())
+	return response, nil
+}
+
+var replicationCronOnce sync.Once
+
+// startReplicationCron runs the periodic replication tasks every second
+func startReplicationCron() {
+	replicationCronOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for tick := int64(1); ; tick++ {
+				<-ticker.C
+				replication.cron(currentConfig(), tick)
+			}
+		}()
+	})
+}
+
+// cron pings the replicas every repl-ping-replica-period seconds so they can
+// tell a quiet master from a lost one, disconnects replicas that stopped
+// acknowledging the stream, frees the backlog of a master without replicas
+// for repl-backlog-ttl seconds, and acknowledges the stream of the master
+func (r *Replication) cron(c Config, tick int64) {
+	r.writeMu.Lock()
+	r.mu.Lock()
+	if r.master == nil && len(r.replicas) > 0 && tick%int64(c.ReplPingReplicaPeriod) == 0 {
+		r.feed(bulkStringArray([]string{"PING"}).Serialize())
+	}
+	if r.master == nil && len(r.replicas) == 0 && r.backlog != nil && c.ReplBacklogTTL > 0 &&
+		time.Since(r.noReplicas) > time.Duration(c.ReplBacklogTTL)*time.Second {
+		// Replicas always keep theirs, as they may be promoted
+		r.backlog = nil
+		r.updateStreaming()
+		logNotice("Replication backlog freed after being unused", "seconds", c.ReplBacklogTTL)
+	}
+	now := time.Now().Unix()
+	for client, rep := range r.replicas {
+		if rep.online.Load() && now-rep.ackTime.Load() > int64(c.ReplTimeout) {
+			logWarning("Disconnecting timedout replica (streaming sync)", "replica", client.addr())
+			client.kill()
+		}
+	}
+	var master *Client
+	if r.master != nil {
+		master = r.master.client
+	}
+	offset := r.offset
+	r.mu.Unlock()
+	r.writeMu.Unlock()
+
+	if master != nil {
+		master.write(bulkStringArray([]string{"REPLCONF", "ACK", strconv.FormatInt(offset, 10)}).Serialize())
+	}
+}
+
+// replicaStatus describes a replica of this server for INFO and ROLE
+type replicaStatus struct {
+	id     int64
+	ip     string
+	port   int
+	state  string // send_bulk or online
+	offset int64
+	lag    int64 // Seconds since its last acknowledgement
+}
+
+// replicationStatus is a snapshot of the replication state for INFO and ROLE
+type replicationStatus struct {
+	role         string // master or slave
+	replID       string
+	replID2      string
+	offset       int64
+	secondOffset int64
+	replicas     []replicaStatus
+
+	backlogActive    bool
+	backlogFirstByte int64 // Offset of the first byte in the backlog
+	backlogHistlen   int64 // Bytes of stream in the backlog
+
+	// Replica side
+	masterHost      string
+	masterPort      int
+	linkState       string
+	lastIOSeconds   int64 // -1 when the link is down
+	linkDownSeconds int64 // -1 when the link is up
+}
+
+// Status returns a snapshot of the replication state
+func (r *Replication) Status() replicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	status := replicationStatus{role: "master", replID: r.replID, replID2: r.replID2, offset: r.offset, secondOffset: r.secondOffset}
+	if r.backlog != nil {
+		status.backlogActive = true
+		status.backlogFirstByte, status.backlogHistlen = r.backlog.offset, int64(r.backlog.histlen)
+	}
+	for client, rep := range r.replicas {
+		status.replicas = append(status.replicas, rep.status(client, now))
+	}
+	slices.SortFunc(status.replicas, func(a, b replicaStatus) int { return cmp.Compare(a.id, b.id) })
+
+	if link := r.master; link != nil {
+		status.role = "slave"
+		status.masterHost, status.masterPort = link.host, link.port
+		status.linkState = link.state
+		status.lastIOSeconds, status.linkDownSeconds = -1, -1
+		if link.state == "connected" {
+			status.lastIOSeconds = int64(now.Sub(link.lastIO).Seconds())
+		} else {
+			status.linkDownSeconds = int64(now.Sub(link.downSince).Seconds())
+		}
+	}
+	return status
+}
+
+func (rep *replica) status(c *Client, now time.Time) replicaStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := replicaStatus{id: c.id, ip: c.replicaAddr, port: c.replicaPort, state: "send_bulk"}
+	if status.ip == "" {
+		status.ip, _, _ = net.SplitHostPort(c.addr())
+	}
+	if rep.online.Load() {
+		status.state = "online"
+		status.offset = rep.ackOffset.Load()
+		status.lag = now.Unix() - rep.ackTime.Load()
+	}
+	return status
+}
+
+// setMasterConfig records the master of the server in the replicaof setting,
+// for CONFIG GET and CONFIG REWRITE
+func setMasterConfig(host string, port int) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config.MasterHost, config.MasterPort = host, port
+}
+
+// handleReplicaof runs REPLICAOF host port and REPLICAOF NO ONE
+func handleReplicaof(args []RESPValue) *RESPValue {
+	if strings.EqualFold(args[0].Str, "no") && strings.EqualFold(args[1].Str, "one") {
+		replication.Promote()
+		setMasterConfig("", 0)
+		return okReply()
+	}
+
+	port, err := strconv.Atoi(args[1].Str)
+	if err != nil || port < 0 || port > 65535 {
+		return &RESPValue{Type: Error, Str: ErrMasterPort.Error()}
+	}
+	if !replication.SetMaster(args[0].Str, port) {
+		return &RESPValue{Type: SimpleString, Str: "OK Already connected to specified master"}
+	}
+	setMasterConfig(args[0].Str, port)
+	return okReply()
+}
+
+// handleRole runs ROLE, which describes the replication role of the server
+func handleRole() *RESPValue {
+	status := replication.Status()
+	bulk := func(s string) RESPValue { return RESPValue{Type: BulkString, Str: s} }
+	integer := func(n int64) RESPValue { return RESPValue{Type: Integer, Int: n} }
+
+	if status.role == "slave" {
+		offset := int64(-1)
+		if status.linkState == "connected" {
+			offset = status.offset
+		}
+		return &RESPValue{Type: Array, Array: []RESPValue{
+			bulk("slave"), bulk(status.masterHost), integer(int64(status.masterPort)),
+			bulk(status.linkState), integer(offset),
+		}}
+	}
+
+	replicas := []RESPValue{}
+	for _, rep := range status.replicas {
+		if rep.state == "online" {
+			replicas = append(replicas, *bulkStringArray([]string{
+				rep.ip, strconv.Itoa(rep.port), strconv.FormatInt(rep.offset, 10),
+			}))
+		}
+	}
+	return &RESPValue{Type: Array, Array: []RESPValue{
+		bulk("master"), integer(status.offset), {Type: Array, Array: replicas},
+	}}
+}
+
+// handleReplconf runs the REPLCONF options a replica sends to its master
+func (c *Client) handleReplconf(args []RESPValue) *RESPValue {
+	if len(args)%2 != 0 {
+		return &RESPValue{Type: Error, Str: ErrSyntax.Error()}
+	}
+	for i := 0; i < len(args); i += 2 {
+		option, value := args[i].Str, args[i+1].Str
+		switch strings.ToLower(option) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return &RESPValue{Type: Error, Str: ErrNotInteger.Error()}
+			}
+			c.mu.Lock()
+			c.replicaPort = port
+			c.mu.Unlock()
+		case "ip-address":
+			c.mu.Lock()
+			c.replicaAddr = value
+			c.mu.Unlock()
+		case "capa":
+			// The replica supports the format this server sends, nothing to adapt
+		case "ack":
+			// Acknowledgements of the stream have no reply
+			if offset, err := strconv.ParseInt(value, 10, 64); err == nil && c.replica != nil {
+				c.replica.ackOffset.Store(offset)
+				c.replica.ackTime.Store(time.Now().Unix())
+			}
+			return nil
+		case "getack":
+			if !c.master {
+				return nil
+			}
+			return bulkStringArray([]string{"REPLCONF", "ACK", strconv.FormatInt(replication.Offset(), 10)})
+		default:
+			return &RESPValue{Type: Error, Str: fmt.Sprintf("ERR Unrecognized REPLCONF option: %s", option)}
+		}
+	}
+	return okReply()
+}
+
+// handlePsync starts the synchronization of a replica, continuing its stream
+// if possible. The snapshot of a full resynchronization or the stream the
+// replica misses is sent once the reply is written. SYNC always asks for a
+// full resynchronization, with no reply before the snapshot.
+func (c *Client) handlePsync(command string, args []RESPValue) *RESPValue {
+	if c.replica != nil {
+		// Already synchronizing
+		return nil
+	}
+	psyncID, psyncOffset := "?", int64(-1)
+	if command == "PSYNC" {
+		offset, err := strconv.ParseInt(args[1].Str, 10, 64)
+		if err != nil {
+			return &RESPValue{Type: Error, Str: ErrNotInteger.Error()}
+		}
+		psyncID, psyncOffset = args[0].Str, offset
+	}
+	logNotice("Replica asks for synchronization", "replica", c.addr())
+	rep, replID, offset, err := replication.addReplica(c, psyncID, psyncOffset)
+	if err != nil {
+		return &RESPValue{Type: Error, Str: err.Error()}
+	}
+	c.mu.Lock()
+	c.replica = rep
+	c.mu.Unlock()
+
+	switch {
+	case command == "SYNC":
+		return nil
+	case rep.snapshot == nil:
+		return &RESPValue{Type: SimpleString, Str: "CONTINUE " + replID}
+	default:
+		return &RESPValue{Type: SimpleString, Str: fmt.Sprintf("FULLRESYNC %s %d", replID, offset)}
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSnapshot reads the RDB payload following a FULLRESYNC reply into new databases
+func readSnapshot(t *testing.T, reader *bufio.Reader) []*Storage {
+	t.Helper()
+	length, err := readSnapshotLength(reader)
+	if err != nil {
+		t.Fatalf("Failed to read the snapshot header: %v", err)
+	}
+	dbs := NewDatabases(DEFAULT_DATABASES)
+	if err := ReadRDB(io.LimitReader(reader, length), dbs); err != nil {
+		t.Fatalf("Failed to load the snapshot: %v", err)
+	}
+	return dbs
+}
+
+// expectStream reads the next commands of a replication stream
+func expectStream(t *testing.T, reader *bufio.Reader, commands ...[]string) {
+	t.Helper()
+	for _, expected := range commands {
+		value, err := ParseRESP(reader)
+		if err != nil {
+			t.Fatalf("Failed to read the stream: %v", err)
+		}
+		if got := argStrings(value.Array); strings.Join(got, " ") != strings.Join(expected, " ") {
+			t.Fatalf("Expected %q in the stream, got %q", expected, got)
+		}
+	}
+}
+
+// waitFor polls a condition, failing the test if it doesn't hold within a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBacklog(t *testing.T) {
+	b := newBacklog(8, 100)
+	b.write([]byte("abcdef"))
+	if b.offset != 101 || string(b.since(101)) != "abcdef" || string(b.since(104)) != "def" || len(b.since(107)) != 0 {
+		t.Errorf("Unexpected backlog %+v", b)
+	}
+	if !b.contains(101) || !b.contains(107) || b.contains(100) || b.contains(108) {
+		t.Errorf("Expected the backlog to hold offsets 101 to 107, got %+v", b)
+	}
+
+	// Once full the oldest bytes are overwritten
+	b.write([]byte("ghij"))
+	if b.offset != 103 || string(b.since(103)) != "cdefghij" || string(b.since(110)) != "j" {
+		t.Errorf("Unexpected backlog after wrapping %+v, holding %q", b, b.since(b.offset))
+	}
+	b.write([]byte("0123456789abcdefghij"))
+	if b.offset != 123 || string(b.since(123)) != "cdefghij" {
+		t.Errorf("Unexpected backlog after a write larger than it %+v, holding %q", b, b.since(b.offset))
+	}
+
+	// Resizing keeps as much of the stream as fits
+	if smaller := b.resize(4); smaller.offset != 127 || string(smaller.since(127)) != "ghij" {
+		t.Errorf("Unexpected smaller backlog %+v", smaller)
+	}
+	if larger := b.resize(16); larger.offset != 123 || string(larger.since(123)) != "cdefghij" {
+		t.Errorf("Unexpected larger backlog %+v", larger)
+	}
+}
+
+func TestReplicationBeginWrite(t *testing.T) {
+	r := &Replication{streamDB: -1, replicas: make(map[*Client]*replica)}
+	if r.beginWrite() {
+		t.Errorf("Expected writes of a master without a backlog not to be propagated")
+	}
+	// Other writes hold writeMu shared at the same time
+	if !r.writeMu.TryRLock() {
+		t.Errorf("Expected writes of a master without a backlog to run concurrently")
+	} else {
+		r.writeMu.RUnlock()
+	}
+	r.endWrite(false)
+
+	r.writeMu.Lock()
+	r.backlog = newBacklog(16, 1)
+	r.updateStreaming()
+	r.writeMu.Unlock()
+	if !r.beginWrite() {
+		t.Errorf("Expected writes of a master with a backlog to be propagated")
+	}
+	if r.writeMu.TryRLock() {
+		t.Errorf("Expected writes of a master with a backlog to hold writeMu exclusively")
+		r.writeMu.RUnlock()
+	}
+	r.endWrite(true)
+
+	// A replica only forwards the stream of its master
+	r.writeMu.Lock()
+	r.master = &masterLink{}
+	r.updateStreaming()
+	r.writeMu.Unlock()
+	if r.beginWrite() {
+		t.Errorf("Expected writes of a replica not to be propagated")
+	}
+	r.endWrite(false)
+}
+
+func TestReplicationMaster(t *testing.T) {
+	sendCommand(t, "SET", "repl:before", "snapshot")
+	defer sendCommand(t, "DEL", "repl:before", "repl:after")
+
+	conn, reader := dialTestServer(t)
+	writeCommand(t, conn, "REPLCONF", "listening-port", "7000")
+	if reply, _ := ParseRESP(reader); reply.Str != "OK" {
+		t.Fatalf("Unexpected reply to REPLCONF: %v", reply)
+	}
+	writeCommand(t, conn, "PSYNC", "?", "-1")
+	reply, err := ParseRESP(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(reply.Str)
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		t.Fatalf("Expected a full resync, got %v", reply)
+	}
+	snapshot := readSnapshot(t, reader)
+	if value, _ := snapshot[0].Get("repl:before"); value != "snapshot" {
+		t.Errorf("Expected the snapshot to have repl:before, got %q", value)
+	}
+
+	// Writes follow the snapshot, starting with the database they ran in
+	offset, _ := strconv.ParseInt(fields[2], 10, 64)
+	sendCommands(t, []string{"SELECT", "0"}, []string{"SET", "repl:after", "streamed"}, []string{"GET", "repl:after"})
+	expectStream(t, reader, []string{"SELECT", "0"}, []string{"SET", "repl:after", "streamed"})
+	streamed := int64(len(bulkStringArray([]string{"SELECT", "0"}).Serialize()) +
+		len(bulkStringArray([]string{"SET", "repl:after", "streamed"}).Serialize()))
+
+	writeCommand(t, conn, "REPLCONF", "ACK", strconv.FormatInt(offset+streamed, 10))
+	waitFor(t, "the replica acknowledgement", func() bool {
+		return strings.Contains(sendCommand(t, "INFO", "replication").Str,
+			fmt.Sprintf("slave0:ip=127.0.0.1,port=7000,state=online,offset=%d,", offset+streamed))
+	})
+	info := sendCommand(t, "INFO", "replication").Str
+	for _, expected := range []string{"role:master", "connected_slaves:1", fmt.Sprintf("master_repl_offset:%d", offset+streamed)} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected %q in %q", expected, info)
+		}
+	}
+	role := sendCommand(t, "ROLE")
+	if role.Array[0].Str != "master" || len(role.Array[2].Array) != 1 || role.Array[2].Array[0].Array[1].Str != "7000" {
+		t.Errorf("Unexpected ROLE reply %v", role)
+	}
+	if list := sendCommand(t, "CLIENT", "LIST", "TYPE", "replica").Str; !strings.Contains(list, "flags=S") {
+		t.Errorf("Expected the replica in %q", list)
+	}
+
+	conn.Close()
+	waitFor(t, "the replica to be removed", func() bool {
+		return strings.Contains(sendCommand(t, "INFO", "replication").Str, "connected_slaves:0")
+	})
+}
+
+func TestReplicationMasterContinue(t *testing.T) {
+	defer sendCommand(t, "DEL", "repl:first", "repl:missed")
+	conn, reader := dialTestServer(t)
+	writeCommand(t, conn, "PSYNC", "?", "-1")
+	reply, err := ParseRESP(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(reply.Str)
+	if len(fields) != 3 || fields[0] != "FULLRESYNC" {
+		t.Fatalf("Expected a full resync, got %v", reply)
+	}
+	replID := fields[1]
+	offset, _ := strconv.ParseInt(fields[2], 10, 64)
+	readSnapshot(t, reader)
+	sendCommand(t, "SET", "repl:first", "1")
+	expectStream(t, reader, []string{"SELECT", "0"}, []string{"SET", "repl:first", "1"})
+	offset += int64(len(bulkStringArray([]string{"SELECT", "0"}).Serialize()) +
+		len(bulkStringArray([]string{"SET", "repl:first", "1"}).Serialize()))
+	conn.Close()
+
+	// Writes while the replica is away are kept in the backlog
+	sendCommand(t, "SET", "repl:missed", "2")
+	conn, reader = dialTestServer(t)
+	writeCommand(t, conn, "PSYNC", replID, strconv.FormatInt(offset+1, 10))
+	if reply, _ := ParseRESP(reader); reply.Str != "CONTINUE "+replID {
+		t.Fatalf("Expected the stream to continue, got %v", reply)
+	}
+	expectStream(t, reader, []string{"SET", "repl:missed", "2"})
+	info := sendCommand(t, "INFO", "replication").Str
+	for _, expected := range []string{"repl_backlog_active:1", "repl_backlog_size:1048576", "master_replid:" + replID} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected %q in %q", expected, info)
+		}
+	}
+
+	// Another history, or an offset the backlog doesn't hold, needs a full resync
+	for _, psync := range [][]string{
+		{strings.Repeat("b", 40), strconv.FormatInt(offset+1, 10)},
+		{replID, strconv.FormatInt(offset+1000, 10)},
+	} {
+		other, otherReader := dialTestServer(t)
+		writeCommand(t, other, "PSYNC", psync[0], psync[1])
+		if reply, _ := ParseRESP(otherReader); !strings.HasPrefix(reply.Str, "FULLRESYNC "+replID) {
+			t.Errorf("Expected a full resync for PSYNC %v, got %v", psync, reply)
+		}
+		other.Close()
+	}
+}
+
+// acceptReplica accepts a replica on a fake master and answers its
+// handshake, returning the connection and the arguments of its PSYNC
+func acceptReplica(t *testing.T, listener net.Listener) (net.Conn, *bufio.Reader, []string) {
+	t.Helper()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	reader := bufio.NewReader(conn)
+	for _, expected := range []string{"PING", "REPLCONF listening-port", "REPLCONF capa", "PSYNC"} {
+		command, err := ParseRESP(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := argStrings(command.Array)
+		if got := strings.Join(args, " "); !strings.HasPrefix(got, expected) {
+			t.Fatalf("Expected %q from the replica, got %q", expected, got)
+		}
+		switch expected {
+		case "PING":
+			conn.Write([]byte("+PONG\r\n"))
+		case "PSYNC":
+			return conn, reader, args[1:]
+		default:
+			conn.Write([]byte("+OK\r\n"))
+		}
+	}
+	return nil, nil, nil
+}
+
+// fullResync answers PSYNC with a snapshot of dbs, whose stream is on database 2
+func fullResync(t *testing.T, conn net.Conn, dbs []*Storage, replID string, offset int64) {
+	t.Helper()
+	var snapshot bytes.Buffer
+	if err := writeRDB(&snapshot, dbs, &rdbSaveInfo{replStreamDB: 2}); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "+FULLRESYNC %s %d\r\n\n$%d\r\n", replID, offset, snapshot.Len())
+	conn.Write(snapshot.Bytes())
+}
+
+func TestReplicationReplica(t *testing.T) {
+	startTestServer()
+	listener, err := net.Listen(PROTOCOL, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	defer sendCommand(t, "REPLICAOF", "NO", "ONE")
+
+	if reply := sendCommand(t, "REPLICAOF", "127.0.0.1", port); reply.Str != "OK" {
+		t.Fatalf("Unexpected reply to REPLICAOF: %v", reply)
+	}
+	if reply := sendCommand(t, "REPLICAOF", "127.0.0.1", port); reply.Str != "OK Already connected to specified master" {
+		t.Errorf("Unexpected reply to a repeated REPLICAOF: %v", reply)
+	}
+	dbs := NewDatabases(DEFAULT_DATABASES)
+	dbs[0].Set("repl:snapshot", "loaded")
+	replID := strings.Repeat("a", 40)
+	conn, reader, _ := acceptReplica(t, listener)
+	fullResync(t, conn, dbs, replID, 100)
+
+	// The stream goes on in the database the snapshot says the master selected
+	stream := bulkStringArray([]string{"SET", "repl:streamed", "2"}).Serialize()
+	stream = append(stream, bulkStringArray([]string{"SELECT", "0"}).Serialize()...)
+	stream = append(stream, bulkStringArray([]string{"SET", "repl:streamed", "0"}).Serialize()...)
+	conn.Write(stream)
+	waitFor(t, "the stream to be applied", func() bool {
+		return sendCommand(t, "GET", "repl:streamed").Str == "0"
+	})
+	offset := 100 + int64(len(stream))
+
+	responses := sendCommands(t,
+		[]string{"GET", "repl:snapshot"},
+		[]string{"SELECT", "2"},
+		[]string{"GET", "repl:streamed"},
+		[]string{"SET", "repl:local", "x"},
+		[]string{"ROLE"},
+		[]string{"INFO", "replication"},
+		[]string{"CLIENT", "LIST", "TYPE", "master"},
+	)
+	if responses[0].Str != "loaded" || responses[2].Str != "2" {
+		t.Errorf("Expected the snapshot and stream in their databases, got %v and %v", responses[0], responses[2])
+	}
+	if responses[3].Str != ErrReadOnly.Error() {
+		t.Errorf("Expected a read only replica to refuse writes, got %v", responses[3])
+	}
+	role := argStrings(responses[4].Array)
+	if role[0] != "slave" || role[1] != "127.0.0.1" || responses[4].Array[2].Int != int64(listener.Addr().(*net.TCPAddr).Port) ||
+		role[3] != "connected" || responses[4].Array[4].Int != offset {
+		t.Errorf("Unexpected ROLE reply %v", responses[4])
+	}
+	for _, expected := range []string{"role:slave", "master_link_status:up", "slave_read_only:1",
+		"master_replid:" + replID, fmt.Sprintf("slave_repl_offset:%d", offset)} {
+		if !strings.Contains(responses[5].Str, expected) {
+			t.Errorf("Expected %q in %q", expected, responses[5].Str)
+		}
+	}
+	if !strings.Contains(responses[6].Str, "flags=M") {
+		t.Errorf("Expected the master client in %q", responses[6].Str)
+	}
+
+	// GETACK is answered with the offset before it, the cron may acknowledge first
+	getack := bulkStringArray([]string{"REPLCONF", "GETACK", "*"}).Serialize()
+	conn.Write(getack)
+	ack, err := ParseRESP(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(argStrings(ack.Array), " "); got != fmt.Sprintf("REPLCONF ACK %d", offset) {
+		t.Errorf("Unexpected acknowledgement %q", got)
+	}
+
+	// Once promoted the server takes writes under a new replication id,
+	// keeping the one of the master as its history
+	if reply := sendCommand(t, "REPLICAOF", "NO", "ONE"); reply.Str != "OK" {
+		t.Fatalf("Unexpected reply to REPLICAOF NO ONE: %v", reply)
+	}
+	if reply := sendCommand(t, "SET", "repl:local", "x"); reply.Str != "OK" {
+		t.Errorf("Expected writes after the promotion, got %v", reply)
+	}
+	info := sendCommand(t, "INFO", "replication").Str
+	if !strings.Contains(info, "role:master") || strings.Contains(info, "master_replid:"+replID) ||
+		!strings.Contains(info, "master_replid2:"+replID) || !strings.Contains(info, fmt.Sprintf("second_repl_offset:%d", offset+int64(len(getack))+1)) {
+		t.Errorf("Expected a master with a new replication id, got %q", info)
+	}
+	sendCommand(t, "DEL", "repl:local", "repl:streamed", "repl:snapshot")
+	sendCommands(t, []string{"SELECT", "2"}, []string{"DEL", "repl:streamed"})
+}
+
+func TestReplicationReplicaPaused(t *testing.T) {
+	startTestServer()
+	listener, err := net.Listen(PROTOCOL, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	defer sendCommand(t, "REPLICAOF", "NO", "ONE")
+
+	sendCommand(t, "REPLICAOF", "127.0.0.1", port)
+	conn, _, _ := acceptReplica(t, listener)
+	fullResync(t, conn, NewDatabases(DEFAULT_DATABASES), strings.Repeat("a", 40), 100)
+	waitFor(t, "the link to the master", func() bool {
+		return strings.Contains(sendCommand(t, "INFO", "replication").Str, "master_link_status:up")
+	})
+
+	// A write of the master waits for the pause without holding up a promotion
+	defer sendCommand(t, "CLIENT", "UNPAUSE")
+	sendCommand(t, "CLIENT", "PAUSE", "10000", "WRITE")
+	conn.Write(bulkStringArray([]string{"SET", "repl:paused", "x"}).Serialize())
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if reply := sendCommand(t, "REPLICAOF", "NO", "ONE"); reply.Str != "OK" {
+		t.Fatalf("Unexpected reply to REPLICAOF NO ONE: %v", reply)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected REPLICAOF NO ONE not to wait for the pause, took %v", elapsed)
+	}
+}
+
+func TestReplicationReplicaContinue(t *testing.T) {
+	startTestServer()
+	listener, err := net.Listen(PROTOCOL, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	defer sendCommand(t, "REPLICAOF", "NO", "ONE")
+	sendCommand(t, "REPLICAOF", "127.0.0.1", strconv.Itoa(listener.Addr().(*net.TCPAddr).Port))
+
+	replID := strings.Repeat("c", 40)
+	conn, _, _ := acceptReplica(t, listener)
+	fullResync(t, conn, NewDatabases(DEFAULT_DATABASES), replID, 100)
+	stream := bulkStringArray([]string{"SET", "repl:continue", "before"}).Serialize()
+	conn.Write(stream)
+	offset := 100 + int64(len(stream))
+	waitFor(t, "the stream to be applied", func() bool {
+		return sendCommands(t, []string{"SELECT", "2"}, []string{"GET", "repl:continue"})[1].Str == "before"
+	})
+
+	// The replica asks the master to continue after it lost the link
+	conn.Close()
+	conn, _, psync := acceptReplica(t, listener)
+	if strings.Join(psync, " ") != fmt.Sprintf("%s %d", replID, offset+1) {
+		t.Fatalf("Expected the replica to continue from its offset, got PSYNC %v", psync)
+	}
+	newID := strings.Repeat("d", 40)
+	conn.Write([]byte("+CONTINUE " + newID + "\r\n"))
+	stream = bulkStringArray([]string{"SET", "repl:continue", "after"}).Serialize()
+	conn.Write(stream)
+	waitFor(t, "the continued stream to be applied", func() bool {
+		return sendCommands(t, []string{"SELECT", "2"}, []string{"GET", "repl:continue"})[1].Str == "after"
+	})
+
+	// A master with a new id shares the history of the old one up to the offset
+	info := sendCommand(t, "INFO", "replication").Str
+	for _, expected := range []string{"master_link_status:up", "master_replid:" + newID, "master_replid2:" + replID,
+		fmt.Sprintf("second_repl_offset:%d", offset+1), fmt.Sprintf("master_repl_offset:%d", offset+int64(len(stream))),
+		"repl_backlog_active:1", fmt.Sprintf("repl_backlog_first_byte_offset:%d", 101)} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected %q in %q", expected, info)
+		}
+	}
+	sendCommand(t, "REPLICAOF", "NO", "ONE")
+	sendCommands(t, []string{"SELECT", "2"}, []string{"DEL", "repl:continue"})
+}
+
+func TestReplicationCommandErrors(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"REPLICAOF", "127.0.0.1", "notaport"},
+		[]string{"REPLCONF", "listening-port"},
+		[]string{"REPLCONF", "nosuchoption", "x"},
+		[]string{"REPLCONF", "listening-port", "x"},
+	)
+	for i, expected := range []string{
+		"ERR Invalid master port",
+		"ERR syntax error",
+		"ERR Unrecognized REPLCONF option: nosuchoption",
+		"ERR value is not an integer or out of range",
+	} {
+		if responses[i].Str != expected {
+			t.Errorf("Response %d: expected %q, got %v", i, expected, responses[i])
+		}
+	}
+}
@@ -82,6 +82,15 @@ func (v *RESPValue) String() string {
 	}
 }
 
+// argStrings returns the strings of a command's arguments
+func argStrings(values []RESPValue) []string {
+	args := make([]string, len(values))
+	for i, value := range values {
+		args[i] = value.Str
+	}
+	return args
+}
+
 // ParseRESP parses a RESP message from a reader
 func ParseRESP(reader *bufio.Reader) (*RESPValue, error) {
 	// Read the first byte to determine the type
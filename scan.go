@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const SCAN_DEFAULT_COUNT = 10
+
+var ErrInvalidCursor = errors.New("ERR invalid cursor")
+
+// ScanOptions holds the MATCH, COUNT and TYPE options of the SCAN family
+type ScanOptions struct {
+	Pattern string // Empty matches everything
+	Count   int
+	Type    string // Empty matches every type, SCAN only
+}
+
+// matches reports whether a key or member passes the MATCH filter
+func (o *ScanOptions) matches(key string) bool {
+	return o.Pattern == "" || o.Pattern == "*" || StringMatch(o.Pattern, key, false)
+}
+
+// ParseScanCursor parses a cursor the way Redis does, as an unsigned 64 bit integer
+func ParseScanCursor(arg string) (uint64, error) {
+	cursor, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// ParseScanOptions parses [MATCH pattern] [COUNT count], and [TYPE type] if allowType is set
+func ParseScanOptions(args []string, allowType bool) (*ScanOptions, error) {
+	options := &ScanOptions{Count: SCAN_DEFAULT_COUNT}
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, ErrSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			options.Pattern = args[i+1]
+		case "COUNT":
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, ErrNotInteger
+			}
+			if count < 1 {
+				return nil, ErrSyntax
+			}
+			options.Count = count
+		case "TYPE":
+			if !allowType {
+				return nil, ErrSyntax
+			}
+			if !isValueTypeName(args[i+1]) {
+				return nil, fmt.Errorf("ERR unknown type name '%s'", args[i+1])
+			}
+			options.Type = strings.ToLower(args[i+1])
+		default:
+			return nil, ErrSyntax
+		}
+	}
+	return options, nil
+}
+
+// isValueTypeName reports whether name is a type TYPE can return
+func isValueTypeName(name string) bool {
+	switch strings.ToLower(name) {
+	case "string", "list", "set", "zset", "hash", "stream":
+		return true
+	}
+	return false
+}
+
+// scanDict runs Dict.Scan until at least options.Count elements were visited
+// or the iteration completes, like the Redis scanGenericCommand loop
+func scanDict[V any](d *Dict[V], cursor uint64, options *ScanOptions, fn func(key string, value V)) uint64 {
+	maxIterations := options.Count * 10
+	visited := 0
+	for {
+		cursor = d.Scan(cursor, func(key string, value V) {
+			visited++
+			fn(key, value)
+		})
+		maxIterations--
+		if cursor == 0 || maxIterations <= 0 || visited >= options.Count {
+			return cursor
+		}
+	}
+}
+
+// Keys returns every key matching pattern
+func (s *Storage) Keys(pattern string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	s.data.Range(func(key string, value *Value) bool {
+		if pattern == "*" || StringMatch(pattern, key, false) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// Scan returns a batch of keys and the cursor to continue from, 0 when the
+// iteration is complete. Every key present for the whole iteration is
+// returned at least once, even if keys are added or removed in between.
+func (s *Storage) Scan(cursor uint64, options *ScanOptions) (uint64, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := []string{}
+	cursor = scanDict(s.data, cursor, options, func(key string, value *Value) {
+		if options.Type != "" && value.Type.String() != options.Type {
+			return
+		}
+		if options.matches(key) {
+			keys = append(keys, key)
+		}
+	})
+	return cursor, keys
+}
+
+// ZScan returns a batch of members and scores of the sorted set at key,
+// flattened as member, score pairs
+func (s *Storage) ZScan(key string, cursor uint64, options *ScanOptions) (uint64, []string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	zset, err := s.sortedSetValue(key)
+	if err != nil || zset == nil {
+		return 0, []string{}, err
+	}
+
+	elements := []string{}
+	cursor = scanDict(zset.dict, cursor, options, func(member string, score float64) {
+		if options.matches(member) {
+			elements = append(elements, member, FormatScore(score))
+		}
+	})
+	return cursor, elements, nil
+}
+
+// ScanMissingType handles HSCAN and SSCAN. Hashes and sets aren't supported
+// yet, so a missing key is an empty collection and any other key has the
+// wrong type.
+func (s *Storage) ScanMissingType(key string) (uint64, []string, error) {
+	if _, exists := s.Type(key); exists {
+		return 0, nil, ErrWrongType
+	}
+	return 0, []string{}, nil
+}
+
+// FormatScore formats a sorted set score like Redis replies with it
+func FormatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', 17, 64)
+}
+
+// ScanReply builds the [cursor, [elements...]] reply of the SCAN family
+func ScanReply(cursor uint64, elements []string) *RESPValue {
+	return &RESPValue{Type: Array, Array: []RESPValue{
+		{Type: BulkString, Str: strconv.FormatUint(cursor, 10)},
+		*bulkStringArray(elements),
+	}}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrShutdownFailed     = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+	ErrNoShutdown         = errors.New("ERR No shutdown in progress.")
+	ErrShutdownInProgress = errors.New("ERR Shutdown already in progress.")
+)
+
+// ShutdownFlags are the SHUTDOWN options
+type ShutdownFlags int
+
+const (
+	ShutdownNoSave ShutdownFlags = 1 << iota // Skip the final save even if save points are configured
+	ShutdownSave                             // Save even if no save points are configured
+	ShutdownNow                              // Don't wait for running commands
+	ShutdownForce                            // Exit even if the final save fails
+)
+
+// shutdownState coordinates stopping the server, from SHUTDOWN or a signal
+type shutdownState struct {
+	mu        sync.Mutex
+	listeners []net.Listener
+	aborted   chan struct{} // Closed by SHUTDOWN ABORT, nil when no shutdown is in progress
+	inFlight  atomic.Int64  // Commands being run or replied to
+	exit      func(code int)
+}
+
+var shutdown = &shutdownState{exit: os.Exit}
+
+// AddListener registers a listener to close when the server shuts down
+func (s *shutdownState) AddListener(listener net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// InProgress reports whether a shutdown is waiting for commands or saving
+func (s *shutdownState) InProgress() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted != nil
+}
+
+// Abort cancels a shutdown that is still waiting for running commands
+func (s *shutdownState) Abort() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.aborted == nil {
+		return ErrNoShutdown
+	}
+	close(s.aborted)
+	s.aborted = nil
+	return nil
+}
+
+// Shutdown stops the server: it waits for running commands unless
+// ShutdownNow is given, saves the databases if needed, then stops accepting,
+// closes every client and exits. It only returns if the shutdown was aborted
+// or the save failed. own is the number of running commands belonging to the
+// caller, 1 for the SHUTDOWN command itself.
+func (s *shutdownState) Shutdown(flags ShutdownFlags, own int64) error {
+	s.mu.Lock()
+	if s.aborted != nil {
+		s.mu.Unlock()
+		return ErrShutdownInProgress
+	}
+	aborted := make(chan struct{})
+	s.aborted = aborted
+	s.mu.Unlock()
+
+	logWarning("User requested shutdown...")
+	c := currentConfig()
+	if flags&ShutdownNow == 0 && !s.drain(own, time.Duration(c.ShutdownTimeout)*time.Second, aborted) {
+		logWarning("Shutdown manually aborted.")
+		return ErrShutdownFailed
+	}
+
+	if flags&ShutdownSave != 0 || (len(c.Save) > 0 && flags&ShutdownNoSave == 0) {
+		logNotice("Saving the final RDB snapshot before exiting.")
+		if err := SaveRDB(c.DBFilename); err != nil {
+			if flags&ShutdownForce == 0 {
+				logWarning("Error trying to save the DB, can't exit", "error", err)
+				s.finish()
+				return ErrShutdownFailed
+			}
+			logWarning("Error trying to save the DB, exiting anyway", "error", err)
+		}
+	}
+	if c.AppendOnly {
+		logWarning("appendonly is set but the append only file isn't supported, nothing to flush")
+	}
+
+	s.mu.Lock()
+	s.aborted = nil
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.listeners = nil
+	s.mu.Unlock()
+	for _, client := range clients.List() {
+		client.kill()
+	}
+
+	logWarning("Redis-lite is now ready to exit, bye bye...")
+	s.exit(0)
+	return nil
+}
+
+// drain waits until only the caller's own commands are running, the timeout
+// passes or the shutdown is aborted. It returns false if it was aborted.
+func (s *shutdownState) drain(own int64, timeout time.Duration, aborted chan struct{}) bool {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for s.inFlight.Load() > own {
+		select {
+		case <-aborted:
+			return false
+		case <-deadline:
+			logWarning("Commands still running after the shutdown timeout, shutting down anyway", "commands", s.inFlight.Load()-own)
+			return true
+		case <-ticker.C:
+		}
+	}
+	select {
+	case <-aborted:
+		return false
+	default:
+		return true
+	}
+}
+
+// finish marks a failed shutdown as over
+func (s *shutdownState) finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = nil
+}
+
+// handleSignals shuts the server down on SIGINT or SIGTERM. Another SIGINT
+// during the shutdown exits immediately, like in Redis.
+func handleSignals(signals <-chan os.Signal) {
+	for signal := range signals {
+		name := "SIGTERM"
+		if signal == os.Interrupt {
+			name = "SIGINT"
+		}
+
+		if shutdown.InProgress() && signal == os.Interrupt {
+			logWarning("You insist... exiting now.")
+			shutdown.exit(1)
+			return
+		}
+		logWarning("Received a signal, scheduling shutdown...", "signal", name)
+		go func() {
+			if err := shutdown.Shutdown(0, 0); err != nil {
+				logWarning("Signal received but errors trying to shut down the server, check the logs for more information", "signal", name)
+			}
+		}()
+	}
+}
+
+// parseShutdownFlags parses the SHUTDOWN arguments. ABORT can't be combined
+// with other options, and neither can NOSAVE with SAVE.
+func parseShutdownFlags(args []RESPValue) (flags ShutdownFlags, abort bool, err error) {
+	for _, arg := range args {
+		switch strings.ToUpper(arg.Str) {
+		case "NOSAVE":
+			flags |= ShutdownNoSave
+		case "SAVE":
+			flags |= ShutdownSave
+		case "NOW":
+			flags |= ShutdownNow
+		case "FORCE":
+			flags |= ShutdownForce
+		case "ABORT":
+			abort = true
+		default:
+			return 0, false, ErrSyntax
+		}
+	}
+	if (abort && flags != 0) || (flags&ShutdownNoSave != 0 && flags&ShutdownSave != 0) {
+		return 0, false, ErrSyntax
+	}
+	return flags, abort, nil
+}
+
+// handleShutdown runs SHUTDOWN [NOSAVE|SAVE] [NOW] [FORCE] [ABORT]. On
+// success the server exits without replying.
+func handleShutdown(args []RESPValue) *RESPValue {
+	flags, abort, err := parseShutdownFlags(args)
+	switch {
+	case err != nil:
+	case abort:
+		if err = shutdown.Abort(); err == nil {
+			return okReply()
+		}
+	default:
+		if err = shutdown.Shutdown(flags, 1); err == nil {
+			return nil
+		}
+	}
+	return &RESPValue{Type: Error, Str: err.Error()}
+}
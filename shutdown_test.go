@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseShutdownFlags(t *testing.T) {
+	args := func(values ...string) []RESPValue {
+		result := make([]RESPValue, len(values))
+		for i, value := range values {
+			result[i] = RESPValue{Type: BulkString, Str: value}
+		}
+		return result
+	}
+
+	flags, abort, err := parseShutdownFlags(args("nosave", "NOW", "force"))
+	if err != nil || abort || flags != ShutdownNoSave|ShutdownNow|ShutdownForce {
+		t.Errorf("Unexpected result %v, %v, %v", flags, abort, err)
+	}
+	if _, abort, err := parseShutdownFlags(args("ABORT")); err != nil || !abort {
+		t.Errorf("Expected ABORT to parse, got %v, %v", abort, err)
+	}
+	for _, invalid := range [][]RESPValue{args("NOSAVE", "SAVE"), args("ABORT", "NOW"), args("LATER")} {
+		if _, _, err := parseShutdownFlags(invalid); err != ErrSyntax {
+			t.Errorf("Expected a syntax error for %v, got %v", invalid, err)
+		}
+	}
+}
+
+func TestShutdownAbort(t *testing.T) {
+	s := &shutdownState{exit: func(code int) { t.Errorf("Unexpected exit with status %d", code) }}
+	if err := s.Abort(); err != ErrNoShutdown {
+		t.Errorf("Expected %v, got %v", ErrNoShutdown, err)
+	}
+
+	// Another running command keeps the shutdown waiting until it is aborted
+	s.inFlight.Store(2)
+	done := make(chan error)
+	go func() {
+		done <- s.Shutdown(ShutdownNoSave, 1)
+	}()
+	for !s.InProgress() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Shutdown(0, 0); err != ErrShutdownInProgress {
+		t.Errorf("Expected %v, got %v", ErrShutdownInProgress, err)
+	}
+	if err := s.Abort(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != ErrShutdownFailed {
+			t.Errorf("Expected %v, got %v", ErrShutdownFailed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the shutdown to stop after ABORT")
+	}
+	if s.InProgress() {
+		t.Error("Expected no shutdown in progress after ABORT")
+	}
+}
+
+func TestShutdownSave(t *testing.T) {
+	startTestServer()
+	dir := t.TempDir()
+	c := DefaultConfig()
+
+	// Without save points a shutdown doesn't save
+	c.DBFilename = filepath.Join(dir, "dump.rdb")
+	withConfig(t, c, func() {
+		var exitCode []int
+		s := &shutdownState{exit: func(code int) { exitCode = append(exitCode, code) }}
+		if err := s.Shutdown(ShutdownNow, 0); err != nil || !reflect.DeepEqual(exitCode, []int{0}) {
+			t.Errorf("Expected an exit with status 0, got %v, %v", exitCode, err)
+		}
+		if _, err := os.Stat(c.DBFilename); !os.IsNotExist(err) {
+			t.Errorf("Expected no dump file, got %v", err)
+		}
+	})
+
+	// A failed save keeps the server running unless FORCE is given
+	c.DBFilename = filepath.Join(dir, "missing", "dump.rdb")
+	c.Save = []SavePoint{{3600, 1}}
+	withConfig(t, c, func() {
+		s := &shutdownState{exit: func(code int) { t.Errorf("Unexpected exit with status %d", code) }}
+		if err := s.Shutdown(ShutdownNow, 0); err != ErrShutdownFailed {
+			t.Errorf("Expected %v, got %v", ErrShutdownFailed, err)
+		}
+		if s.InProgress() {
+			t.Error("Expected no shutdown in progress after a failed save")
+		}
+
+		var exitCode []int
+		s.exit = func(code int) { exitCode = append(exitCode, code) }
+		if err := s.Shutdown(ShutdownNow|ShutdownForce, 0); err != nil || !reflect.DeepEqual(exitCode, []int{0}) {
+			t.Errorf("Expected FORCE to exit with status 0, got %v, %v", exitCode, err)
+		}
+	})
+
+	// A successful shutdown saves, closes the listeners and exits
+	c.DBFilename = filepath.Join(dir, "dump.rdb")
+	c.Save = nil
+	withConfig(t, c, func() {
+		listener, err := net.Listen(PROTOCOL, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var exitCode []int
+		s := &shutdownState{exit: func(code int) { exitCode = append(exitCode, code) }}
+		s.AddListener(listener)
+
+		databases[0].Set("shutdown:key", "saved")
+		if err := s.Shutdown(ShutdownSave|ShutdownNow, 0); err != nil || !reflect.DeepEqual(exitCode, []int{0}) {
+			t.Fatalf("Expected an exit with status 0, got %v, %v", exitCode, err)
+		}
+		if _, err := listener.Accept(); err == nil {
+			t.Error("Expected the listener to be closed")
+		}
+
+		data, err := os.ReadFile(c.DBFilename)
+		if err != nil {
+			t.Fatalf("Expected a dump file: %v", err)
+		}
+		if !bytes.HasPrefix(data, []byte("REDIS")) || !bytes.Contains(data, []byte("\x0cshutdown:key\x05saved")) {
+			t.Errorf("Expected the saved key in the dump, got %q", data)
+		}
+	})
+}
+
+func TestShutdownCommand(t *testing.T) {
+	responses := sendCommands(t,
+		[]string{"SHUTDOWN", "ABORT"},
+		[]string{"SHUTDOWN", "NOSAVE", "SAVE"},
+		[]string{"SHUTDOWN", "SOON"},
+	)
+	expected := []string{"ERR No shutdown in progress.", "ERR syntax error", "ERR syntax error"}
+	for i, want := range expected {
+		if responses[i].Type != Error || responses[i].Str != want {
+			t.Errorf("Expected %q, got %v", want, responses[i])
+		}
+	}
+}
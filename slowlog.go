@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SLOWLOG_ENTRY_MAX_ARGC   = 32  // Arguments kept per entry, the last one summarizes the rest
+	SLOWLOG_ENTRY_MAX_STRING = 128 // Bytes kept per argument
+)
+
+var ErrSlowlogCount = errors.New("ERR count should be greater than or equal to -1")
+
+// SlowlogEntry is a command that took longer than slowlog-log-slower-than
+type SlowlogEntry struct {
+	ID         int64
+	Time       time.Time
+	Duration   time.Duration
+	Args       []string // Truncated like Redis, with secrets redacted
+	ClientAddr string
+	ClientName string
+}
+
+// Slowlog keeps the most recent slow commands in a ring buffer of
+// slowlog-max-len entries
+type Slowlog struct {
+	mu      sync.Mutex
+	entries []SlowlogEntry
+	next    int // Position of the oldest entry, overwritten next once the buffer is full
+	nextID  int64
+}
+
+var slowlog = &Slowlog{}
+
+// Add records a command if it ran for longer than the threshold
+func (s *Slowlog) Add(c *Client, command []RESPValue, duration time.Duration) {
+	config := currentConfig()
+	if config.SlowlogLogSlowerThan < 0 || duration < time.Duration(config.SlowlogLogSlowerThan)*time.Microsecond {
+		return
+	}
+
+	c.mu.Lock()
+	name := c.name
+	c.mu.Unlock()
+	entry := SlowlogEntry{
+		Time:       time.Now(),
+		Duration:   duration,
+		Args:       slowlogArgs(redactArgs(argStrings(command))),
+		ClientAddr: c.addr(),
+		ClientName: name,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.ID = s.nextID
+	s.nextID++
+	maxLen := config.SlowlogMaxLen
+	if len(s.entries) > maxLen || (s.next != 0 && len(s.entries) < maxLen) {
+		// slowlog-max-len changed, keep the newest entries that still fit
+		s.entries = s.newest(maxLen)
+		slices.Reverse(s.entries)
+		s.next = 0
+	}
+	switch {
+	case maxLen == 0:
+	case len(s.entries) < maxLen:
+		s.entries = append(s.entries, entry)
+	default:
+		s.entries[s.next] = entry
+		s.next = (s.next + 1) % len(s.entries)
+	}
+}
+
+// newest returns up to count entries, newest first. The buffer is in
+// chronological order from next on, so the newest entry is just before it.
+// The caller must hold the lock.
+func (s *Slowlog) newest(count int) []SlowlogEntry {
+	entries := make([]SlowlogEntry, 0, min(count, len(s.entries)))
+	for i := range min(count, len(s.entries)) {
+		entries = append(entries, s.entries[(s.next-1-i+2*len(s.entries))%len(s.entries)])
+	}
+	return entries
+}
+
+// Get returns up to count entries, newest first
+func (s *Slowlog) Get(count int) []SlowlogEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newest(count)
+}
+
+// Len returns the number of entries
+func (s *Slowlog) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Reset removes every entry
+func (s *Slowlog) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.next = 0
+}
+
+// slowlogArgs truncates the arguments of an entry like Redis: at most
+// SLOWLOG_ENTRY_MAX_ARGC of them, each at most SLOWLOG_ENTRY_MAX_STRING bytes
+func slowlogArgs(argv []string) []string {
+	args := make([]string, 0, min(len(argv), SLOWLOG_ENTRY_MAX_ARGC))
+	for i, arg := range argv {
+		if i == SLOWLOG_ENTRY_MAX_ARGC-1 && len(argv) > SLOWLOG_ENTRY_MAX_ARGC {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(argv)-i))
+			break
+		}
+		if len(arg) > SLOWLOG_ENTRY_MAX_STRING {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:SLOWLOG_ENTRY_MAX_STRING], len(arg)-SLOWLOG_ENTRY_MAX_STRING)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// redactArgs returns a copy of a command with its passwords replaced, so they
+// don't end up in the slow log, MONITOR or the debug log
+func redactArgs(argv []string) []string {
+	redacted := append([]string(nil), argv...)
+	if len(redacted) == 0 {
+		return redacted
+	}
+	switch strings.ToUpper(redacted[0]) {
+	case "AUTH":
+		for i := 1; i < len(redacted); i++ {
+			redacted[i] = "(redacted)"
+		}
+	case "HELLO":
+		for i := 2; i+2 < len(redacted); i++ {
+			if strings.EqualFold(redacted[i], "AUTH") {
+				redacted[i+1], redacted[i+2] = "(redacted)", "(redacted)"
+				i += 2
+			}
+		}
+	case "CONFIG":
+		if len(redacted) > 1 && strings.EqualFold(redacted[1], "SET") {
+			for i := 2; i+1 < len(redacted); i += 2 {
+				switch strings.ToLower(redacted[i]) {
+				case "requirepass", "masterauth", "masteruser":
+					redacted[i+1] = "(redacted)"
+				}
+			}
+		}
+	case "ACL":
+		if len(redacted) > 1 && strings.EqualFold(redacted[1], "SETUSER") {
+			// Rules adding or removing a password or its hash
+			for i := 3; i < len(redacted); i++ {
+				if redacted[i] != "" && strings.ContainsRune("><#!", rune(redacted[i][0])) {
+					redacted[i] = "(redacted)"
+				}
+			}
+		}
+	}
+	return redacted
+}
+
+var slowlogHelp = []string{
+	"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"GET [<count>]",
+	"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+	"    Entries are made of:",
+	"    id, timestamp, time in microseconds, arguments array, client IP and port,",
+	"    client name",
+	"LEN",
+	"    Return the length of the slowlog.",
+	"RESET",
+	"    Reset the slowlog.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleSlowlog runs SLOWLOG GET, LEN, RESET and HELP
+func handleSlowlog(args []RESPValue) *RESPValue {
+	subcommand := strings.ToUpper(args[0].Str)
+	if spec := lookupCommand("slowlog|" + subcommand); spec != nil && !spec.CheckArity(len(args)+1) {
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR wrong number of arguments for 'SLOWLOG|%s' command", subcommand),
+		}
+	}
+
+	switch subcommand {
+	case "GET":
+		count := 10
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1].Str)
+			if err != nil || n < -1 {
+				return &RESPValue{Type: Error, Str: ErrSlowlogCount.Error()}
+			}
+			count = n
+		}
+		if count == -1 {
+			count = slowlog.Len()
+		}
+		entries := slowlog.Get(count)
+		reply := &RESPValue{Type: Array, Array: make([]RESPValue, 0, len(entries))}
+		for _, entry := range entries {
+			reply.Array = append(reply.Array, RESPValue{Type: Array, Array: []RESPValue{
+				{Type: Integer, Int: entry.ID},
+				{Type: Integer, Int: entry.Time.Unix()},
+				{Type: Integer, Int: entry.Duration.Microseconds()},
+				*bulkStringArray(entry.Args),
+				{Type: BulkString, Str: entry.ClientAddr},
+				{Type: BulkString, Str: entry.ClientName},
+			}})
+		}
+		return reply
+	case "LEN":
+		return &RESPValue{Type: Integer, Int: int64(slowlog.Len())}
+	case "RESET":
+		slowlog.Reset()
+		return okReply()
+	case "HELP":
+		return simpleStringArrayReply(slowlogHelp)
+	default:
+		return &RESPValue{
+			Type: Error,
+			Str:  fmt.Sprintf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", args[0].Str),
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlowlogRing(t *testing.T) {
+	c := DefaultConfig()
+	c.SlowlogLogSlowerThan = 1000
+	c.SlowlogMaxLen = 3
+	client := newTestClient(t)
+	s := &Slowlog{}
+	ids := func() []int64 {
+		var ids []int64
+		for _, entry := range s.Get(10) {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+
+	withConfig(t, c, func() {
+		s.Add(client, []RESPValue{{Str: "GET"}, {Str: "fast"}}, time.Microsecond)
+		if s.Len() != 0 {
+			t.Fatalf("Expected a fast command not to be logged, got %d entries", s.Len())
+		}
+		for i := range 5 {
+			s.Add(client, []RESPValue{{Str: "GET"}, {Str: fmt.Sprint(i)}}, time.Second)
+		}
+		if got := ids(); !reflect.DeepEqual(got, []int64{4, 3, 2}) {
+			t.Errorf("Expected the 3 newest entries, got %v", got)
+		}
+		if entry := s.Get(1)[0]; entry.Args[1] != "4" || entry.Duration != time.Second {
+			t.Errorf("Unexpected entry %+v", entry)
+		}
+
+		// Resizing keeps the newest entries
+		c.SlowlogMaxLen = 2
+		s.Add(client, []RESPValue{{Str: "GET"}}, time.Second)
+		if got := ids(); !reflect.DeepEqual(got, []int64{5, 4}) {
+			t.Errorf("Expected entries 5 and 4 after shrinking, got %v", got)
+		}
+		c.SlowlogMaxLen = 4
+		s.Add(client, []RESPValue{{Str: "GET"}}, time.Second)
+		s.Add(client, []RESPValue{{Str: "GET"}}, time.Second)
+		s.Add(client, []RESPValue{{Str: "GET"}}, time.Second)
+		if got := ids(); !reflect.DeepEqual(got, []int64{8, 7, 6, 5}) {
+			t.Errorf("Expected entries 8 to 5 after growing, got %v", got)
+		}
+
+		c.SlowlogLogSlowerThan = -1
+		s.Add(client, []RESPValue{{Str: "GET"}}, time.Hour)
+		if s.Len() != 4 {
+			t.Errorf("Expected nothing logged with the slow log disabled, got %d entries", s.Len())
+		}
+		s.Reset()
+		if s.Len() != 0 || len(s.Get(10)) != 0 {
+			t.Errorf("Expected an empty slow log after reset")
+		}
+	})
+}
+
+func TestSlowlogArgs(t *testing.T) {
+	argv := []string{"DEL"}
+	for i := range 40 {
+		argv = append(argv, fmt.Sprint(i))
+	}
+	args := slowlogArgs(argv)
+	if len(args) != SLOWLOG_ENTRY_MAX_ARGC || args[len(args)-1] != "... (10 more arguments)" || args[30] != "29" {
+		t.Errorf("Unexpected truncated arguments %q", args)
+	}
+
+	long := strings.Repeat("x", SLOWLOG_ENTRY_MAX_STRING+5)
+	if args := slowlogArgs([]string{"SET", "key", long}); args[2] != long[:SLOWLOG_ENTRY_MAX_STRING]+"... (5 more bytes)" {
+		t.Errorf("Unexpected truncated argument %q", args[2])
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		argv     []string
+		expected []string
+	}{
+		{[]string{"auth", "user", "pass"}, []string{"auth", "(redacted)", "(redacted)"}},
+		{[]string{"HELLO", "3", "AUTH", "user", "pass", "SETNAME", "app"},
+			[]string{"HELLO", "3", "AUTH", "(redacted)", "(redacted)", "SETNAME", "app"}},
+		{[]string{"CONFIG", "SET", "timeout", "5", "requirepass", "secret"},
+			[]string{"CONFIG", "SET", "timeout", "5", "requirepass", "(redacted)"}},
+		{[]string{"CONFIG", "SET", "masteruser", "replicator", "masterauth", "secret"},
+			[]string{"CONFIG", "SET", "masteruser", "(redacted)", "masterauth", "(redacted)"}},
+		{[]string{"acl", "setuser", "alice", "on", ">secret", "#" + strings.Repeat("a", 64), "<old", "!" + strings.Repeat("b", 64), "~*", "+@all"},
+			[]string{"acl", "setuser", "alice", "on", "(redacted)", "(redacted)", "(redacted)", "(redacted)", "~*", "+@all"}},
+		{[]string{"GET", "pass"}, []string{"GET", "pass"}},
+	}
+	for _, tt := range tests {
+		if redacted := redactArgs(tt.argv); !reflect.DeepEqual(redacted, tt.expected) {
+			t.Errorf("redactArgs(%q) = %q, expected %q", tt.argv, redacted, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math/rand"
+)
+
+const (
+	SKIPLIST_MAXLEVEL = 32   // Enough for 2^64 elements
+	SKIPLIST_P        = 0.25 // Skiplist P = 1/4, same as Redis
+)
+
+// SortedSet is a set of unique members ordered by score, then by member.
+// Like the Redis zset it pairs a hash table for O(1) score lookups and
+// cursor based scans with a skiplist for ordered range queries.
+type SortedSet struct {
+	dict        *Dict[float64]
+	skiplist    *skiplist
+	memberBytes int64 // Total length of the members, for memory accounting
+}
+
+type skiplistNode struct {
+	member string
+	score  float64
+	next   []*skiplistNode
+}
+
+type skiplist struct {
+	header *skiplistNode
+	level  int
+}
+
+// NewSortedSet creates an empty SortedSet
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict: NewDict[float64](),
+		skiplist: &skiplist{
+			header: &skiplistNode{next: make([]*skiplistNode, SKIPLIST_MAXLEVEL)},
+			level:  1,
+		},
+	}
+}
+
+// Len returns the number of members
+func (z *SortedSet) Len() int {
+	return z.dict.Len()
+}
+
+// Score returns the score of a member
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, exists := z.dict.Get(member)
+	return score, exists
+}
+
+// Add inserts a member or updates its score and reports whether it was added
+func (z *SortedSet) Add(member string, score float64) bool {
+	if current, exists := z.dict.Get(member); exists {
+		if current != score {
+			z.skiplist.delete(member, current)
+			z.skiplist.insert(member, score)
+			z.dict.Set(member, score)
+		}
+		return false
+	}
+	z.skiplist.insert(member, score)
+	z.dict.Set(member, score)
+	z.memberBytes += int64(len(member))
+	return true
+}
+
+// Remove deletes a member and reports whether it existed
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.dict.Get(member)
+	if !exists {
+		return false
+	}
+	z.skiplist.delete(member, score)
+	z.dict.Delete(member)
+	z.memberBytes -= int64(len(member))
+	return true
+}
+
+// RangeByScore calls fn for each member with min <= score <= max, or
+// min <= score < max if maxExclusive is set, in ascending order. Iteration
+// stops when fn returns false.
+func (z *SortedSet) RangeByScore(min, max float64, maxExclusive bool, fn func(member string, score float64) bool) {
+	node := z.skiplist.firstInRange(min)
+	for ; node != nil; node = node.next[0] {
+		if node.score > max || (maxExclusive && node.score == max) {
+			return
+		}
+		if !fn(node.member, node.score) {
+			return
+		}
+	}
+}
+
+// Range calls fn for every member in ascending order until fn returns false
+func (z *SortedSet) Range(fn func(member string, score float64) bool) {
+	for node := z.skiplist.header.next[0]; node != nil; node = node.next[0] {
+		if !fn(node.member, node.score) {
+			return
+		}
+	}
+}
+
+// Copy returns a deep copy of the sorted set
+func (z *SortedSet) Copy() *SortedSet {
+	copied := NewSortedSet()
+	z.Range(func(member string, score float64) bool {
+		copied.Add(member, score)
+		return true
+	})
+	return copied
+}
+
+// clear drops all members and unlinks the skiplist nodes
+func (z *SortedSet) clear() {
+	node := z.skiplist.header.next[0]
+	for node != nil {
+		next := node.next[0]
+		clear(node.next)
+		node = next
+	}
+	clear(z.skiplist.header.next)
+	z.skiplist.level = 1
+	z.dict.Clear()
+	z.memberBytes = 0
+}
+
+// less orders nodes by score and then lexicographically by member
+func (n *skiplistNode) less(member string, score float64) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < SKIPLIST_MAXLEVEL && rand.Float64() < SKIPLIST_P {
+		level++
+	}
+	return level
+}
+
+func (sl *skiplist) insert(member string, score float64) {
+	var update [SKIPLIST_MAXLEVEL]*skiplistNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].less(member, score) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.header
+		}
+		sl.level = level
+	}
+
+	node := &skiplistNode{member: member, score: score, next: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = update[i].next[i]
+		update[i].next[i] = node
+	}
+}
+
+func (sl *skiplist) delete(member string, score float64) {
+	var update [SKIPLIST_MAXLEVEL]*skiplistNode
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].less(member, score) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	x = x.next[0]
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+	for i := 0; i < sl.level; i++ {
+		if update[i].next[i] != x {
+			break
+		}
+		update[i].next[i] = x.next[i]
+	}
+	for sl.level > 1 && sl.header.next[sl.level-1] == nil {
+		sl.level--
+	}
+}
+
+// firstInRange returns the first node with a score >= min
+func (sl *skiplist) firstInRange(min float64) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].score < min {
+			x = x.next[i]
+		}
+	}
+	return x.next[0]
+}
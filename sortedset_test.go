@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	z := NewSortedSet()
+
+	if !z.Add("b", 2) || !z.Add("a", 1) || !z.Add("c", 2) {
+		t.Fatal("Expected new members to be added")
+	}
+	if z.Add("a", 3) {
+		t.Error("Expected updating a member not to count as added")
+	}
+	if score, exists := z.Score("a"); !exists || score != 3 {
+		t.Errorf("Expected score 3, got %v", score)
+	}
+
+	// Members are ordered by score, then lexicographically
+	var members []string
+	z.Range(func(member string, score float64) bool {
+		members = append(members, member)
+		return true
+	})
+	if !reflect.DeepEqual(members, []string{"b", "c", "a"}) {
+		t.Errorf("Unexpected order %v", members)
+	}
+
+	members = nil
+	z.RangeByScore(2, 3, true, func(member string, score float64) bool {
+		members = append(members, member)
+		return true
+	})
+	if !reflect.DeepEqual(members, []string{"b", "c"}) {
+		t.Errorf("Unexpected exclusive range %v", members)
+	}
+
+	if !z.Remove("b") || z.Remove("b") {
+		t.Error("Expected to remove b exactly once")
+	}
+	if z.Len() != 2 {
+		t.Errorf("Expected length 2, got %d", z.Len())
+	}
+}
+
+func TestSortedSetLarge(t *testing.T) {
+	z := NewSortedSet()
+	for i := 0; i < 1000; i++ {
+		z.Add(fmt.Sprintf("member%d", i), float64(1000-i))
+	}
+	for i := 0; i < 1000; i += 2 {
+		z.Remove(fmt.Sprintf("member%d", i))
+	}
+
+	previous := 0.0
+	count := 0
+	z.Range(func(member string, score float64) bool {
+		if score < previous {
+			t.Fatalf("Scores out of order: %v after %v", score, previous)
+		}
+		previous = score
+		count++
+		return true
+	})
+	if count != 500 || z.Len() != 500 {
+		t.Errorf("Expected 500 members, got %d iterated and %d stored", count, z.Len())
+	}
+}
@@ -1,35 +1,93 @@
 package main
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 )
 
+var (
+	ErrWrongType  = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNoSuchKey  = errors.New("ERR no such key")
+	ErrSameObject = errors.New("ERR source and destination objects are the same")
+)
+
+// ValueType identifies the data type of a stored value
+type ValueType int
+
+const (
+	StringValue ValueType = iota
+	SortedSetValue
+)
+
+// String returns the type name reported by Redis
+func (t ValueType) String() string {
+	switch t {
+	case StringValue:
+		return "string"
+	case SortedSetValue:
+		return "zset"
+	default:
+		return "none"
+	}
+}
+
+// Value is a stored value of any supported type
+type Value struct {
+	Type ValueType
+	Str  string
+	ZSet *SortedSet
+
+	// Access tracking for eviction, updated atomically since reads only hold
+	// the read lock
+	accessed atomic.Int64  // Unix milliseconds of the last access
+	lfu      atomic.Uint64 // LFU counter in the low 8 bits, minutes of its last decrement above
+}
+
 // Storage represents our thread-safe key-value store
 type Storage struct {
-	mu   sync.RWMutex
-	data map[string]string
+	mu    sync.RWMutex
+	data  *Dict[*Value]
+	used  atomic.Int64 // Approximate bytes used by the keys and values
+	index int          // Database number, orders locking across databases
 }
 
 // NewStorage creates a new Storage instance
 func NewStorage() *Storage {
 	return &Storage{
-		data: make(map[string]string),
+		data: NewDict[*Value](),
 	}
 }
 
-// Set stores a key-value pair
+// Set stores a key-value pair, replacing a value of any type
 func (s *Storage) Set(key, value string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.data[key] = value
+	s.setValue(key, &Value{Type: StringValue, Str: value})
 }
 
-// Get retrieves a value by key
+// Get retrieves a string value by key. Keys holding other types are reported as missing.
 func (s *Storage) Get(key string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	value, exists := s.data[key]
-	return value, exists
+	value, exists := s.lookup(key)
+	if !exists || value.Type != StringValue {
+		stats.keyspaceMisses.Add(1)
+		return "", false
+	}
+	stats.keyspaceHits.Add(1)
+	return value.Str, true
+}
+
+// Type returns the type of the value stored at key
+func (s *Storage) Type(key string) (ValueType, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, exists := s.data.Get(key)
+	if !exists {
+		return 0, false
+	}
+	return value.Type, true
 }
 
 // Del removes one or more key-value pairs and returns the number of keys that were deleted
@@ -39,8 +97,7 @@ func (s *Storage) Del(keys ...string) int64 {
 
 	var deleted int64
 	for _, key := range keys {
-		if _, exists := s.data[key]; exists {
-			delete(s.data, key)
+		if _, exists := s.deleteKey(key); exists {
 			deleted++
 		}
 	}
@@ -51,5 +108,183 @@ func (s *Storage) Del(keys ...string) int64 {
 func (s *Storage) Len() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return len(s.data)
+	return s.data.Len()
+}
+
+// Copy returns a deep copy of the value
+func (v *Value) Copy() *Value {
+	copied := &Value{Type: v.Type, Str: v.Str}
+	if v.ZSet != nil {
+		copied.ZSet = v.ZSet.Copy()
+	}
+	return copied
+}
+
+// Exists returns how many of the keys exist, counting repeated keys every time
+func (s *Storage) Exists(keys ...string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, key := range keys {
+		if _, exists := s.data.Get(key); exists {
+			count++
+		}
+	}
+	return count
+}
+
+// Rename moves the value at key to newKey, overwriting newKey if it exists.
+// If nx is set the rename only happens when newKey doesn't exist.
+func (s *Storage) Rename(key, newKey string, nx bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, exists := s.data.Get(key)
+	if !exists {
+		return false, ErrNoSuchKey
+	}
+	if key == newKey {
+		return !nx, nil
+	}
+	if _, exists := s.data.Get(newKey); exists && nx {
+		return false, nil
+	}
+	s.deleteKey(key)
+	s.setValue(newKey, value)
+	return true, nil
+}
+
+// Copy copies the value at source to destination and reports whether it was
+// copied. An existing destination is only overwritten if replace is set.
+func (s *Storage) Copy(source, destination string, replace bool) (bool, error) {
+	return s.CopyTo(source, s, destination, replace)
+}
+
+// CopyTo copies the value at source to destination in the dst database
+func (s *Storage) CopyTo(source string, dst *Storage, destination string, replace bool) (bool, error) {
+	if s == dst && source == destination {
+		return false, ErrSameObject
+	}
+
+	unlock := lockPair(s, dst)
+	defer unlock()
+
+	value, exists := s.data.Get(source)
+	if !exists {
+		return false, nil
+	}
+	if _, exists := dst.data.Get(destination); exists && !replace {
+		return false, nil
+	}
+	dst.setValue(destination, value.Copy())
+	return true, nil
+}
+
+// Unlink removes keys like Del but releases large values in the background,
+// so the write lock is only held for removing the keys from the keyspace
+func (s *Storage) Unlink(keys ...string) int64 {
+	var unlinked []*Value
+
+	s.mu.Lock()
+	for _, key := range keys {
+		if value, exists := s.deleteKey(key); exists {
+			unlinked = append(unlinked, value)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, value := range unlinked {
+		freeValueAsync(value)
+	}
+	return int64(len(unlinked))
+}
+
+// Touch updates the access time of the keys and returns how many exist
+func (s *Storage) Touch(keys ...string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, key := range keys {
+		if _, exists := s.lookup(key); exists {
+			count++
+		}
+	}
+	return count
+}
+
+// RandomKey returns a random key, or false if the storage is empty
+func (s *Storage) RandomKey() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, _, exists := s.data.RandomKey()
+	return key, exists
+}
+
+// stringValue returns the string stored at key, or ErrWrongType for other types.
+// The caller must hold the lock.
+func (s *Storage) stringValue(key string) (string, bool, error) {
+	value, exists := s.lookup(key)
+	if !exists {
+		return "", false, nil
+	}
+	if value.Type != StringValue {
+		return "", false, ErrWrongType
+	}
+	return value.Str, true, nil
+}
+
+// sortedSetValue returns the sorted set stored at key, or ErrWrongType for
+// other types. The caller must hold the lock.
+func (s *Storage) sortedSetValue(key string) (*SortedSet, error) {
+	value, exists := s.lookup(key)
+	if !exists {
+		return nil, nil
+	}
+	if value.Type != SortedSetValue {
+		return nil, ErrWrongType
+	}
+	return value.ZSet, nil
+}
+
+// lookup returns the value stored at key and records the access for
+// eviction. Commands that only check a key, like EXISTS and TYPE, read
+// s.data directly so the key doesn't look recently used. The caller must
+// hold the lock.
+func (s *Storage) lookup(key string) (*Value, bool) {
+	value, exists := s.data.Get(key)
+	if exists {
+		value.touch()
+	}
+	return value, exists
+}
+
+// setValue stores value at key and keeps the memory accounting up to date.
+// The caller must hold the write lock.
+func (s *Storage) setValue(key string, value *Value) {
+	if old, exists := s.data.Get(key); exists {
+		s.used.Add(-entryMemoryUsage(key, old))
+	}
+	if value.lfu.Load() == 0 {
+		value.initAccess()
+	}
+	s.data.Set(key, value)
+	s.used.Add(entryMemoryUsage(key, value))
+}
+
+// deleteKey removes key and returns its value. The caller must hold the
+// write lock.
+func (s *Storage) deleteKey(key string) (*Value, bool) {
+	value, exists := s.data.Delete(key)
+	if exists {
+		s.used.Add(-entryMemoryUsage(key, value))
+	}
+	return value, exists
+}
+
+// MemoryUsage returns the approximate bytes used by the keys and values
+func (s *Storage) MemoryUsage() int64 {
+	return s.used.Load()
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestStorage(t *testing.T) {
@@ -163,3 +164,169 @@ func TestStorageRaceConditions(t *testing.T) {
 	// We can't assert the final state as it depends on the order of operations,
 	// but we can verify that no panics occurred
 }
+
+func TestStorageKeyspace(t *testing.T) {
+	s := NewStorage()
+	s.Set("key1", "value1")
+	s.Set("key2", "value2")
+
+	// Exists counts repeated keys every time
+	if count := s.Exists("key1", "key1", "key2", "missing"); count != 3 {
+		t.Errorf("Expected 3, got %d", count)
+	}
+
+	// Rename overwrites the destination
+	if _, err := s.Rename("key1", "key2", false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, _ := s.Get("key2"); value != "value1" || s.Len() != 1 {
+		t.Errorf("Expected key2 to hold value1 after RENAME, got %s", value)
+	}
+	if _, err := s.Rename("missing", "key3", false); err != ErrNoSuchKey {
+		t.Errorf("Expected ErrNoSuchKey, got %v", err)
+	}
+
+	// RenameNX doesn't overwrite the destination
+	s.Set("key3", "value3")
+	if renamed, _ := s.Rename("key2", "key3", true); renamed {
+		t.Error("Expected RENAMENX not to overwrite an existing key")
+	}
+
+	// Copy only replaces the destination when asked to
+	if copied, _ := s.Copy("key2", "key3", false); copied {
+		t.Error("Expected COPY not to overwrite an existing key")
+	}
+	if copied, _ := s.Copy("key2", "key3", true); !copied {
+		t.Error("Expected COPY REPLACE to overwrite the destination")
+	}
+	if value, _ := s.Get("key3"); value != "value1" {
+		t.Errorf("Expected key3 to hold value1, got %s", value)
+	}
+	if _, err := s.Copy("key2", "key2", false); err != ErrSameObject {
+		t.Errorf("Expected ErrSameObject, got %v", err)
+	}
+
+	// Copied sorted sets are independent of the source
+	s.GeoAdd("geo", false, false, false, []float64{1}, []float64{1}, []string{"a"})
+	s.Copy("geo", "geocopy", false)
+	s.GeoAdd("geo", false, false, false, []float64{2}, []float64{2}, []string{"b"})
+	if lookupValue(s, "geocopy").ZSet.Len() != 1 {
+		t.Error("Expected the copy not to change with the source")
+	}
+	if valueType, _ := s.Type("geocopy"); valueType != SortedSetValue {
+		t.Errorf("Expected zset, got %s", valueType)
+	}
+
+	if key, exists := s.RandomKey(); !exists || s.Exists(key) != 1 {
+		t.Errorf("Expected an existing random key, got %q", key)
+	}
+}
+
+func TestStorageUnlink(t *testing.T) {
+	s := NewStorage()
+	s.Set("small", "value")
+	for i := 0; i < LAZYFREE_THRESHOLD*2; i++ {
+		s.GeoAdd("large", false, false, false, []float64{0}, []float64{0}, []string{fmt.Sprintf("member%d", i)})
+	}
+	zset := lookupValue(s, "large").ZSet
+	freed := lazyFreedTotal.Load()
+
+	if unlinked := s.Unlink("small", "large", "missing"); unlinked != 2 {
+		t.Errorf("Expected 2 keys unlinked, got %d", unlinked)
+	}
+	if s.Len() != 0 {
+		t.Errorf("Expected empty storage, got %d keys", s.Len())
+	}
+
+	// The large value is released by the background worker
+	deadline := time.Now().Add(time.Second)
+	for lazyFreedTotal.Load() == freed && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if lazyFreedTotal.Load() == freed || zset.Len() != 0 {
+		t.Error("Expected the large value to be freed in the background")
+	}
+}
+
+// lookupValue returns the value stored at key, or nil if it doesn't exist
+func lookupValue(s *Storage, key string) *Value {
+	value, _ := s.data.Get(key)
+	return value
+}
+
+func TestStorageKeysAndScan(t *testing.T) {
+	s := NewStorage()
+	for i := 0; i < 100; i++ {
+		s.Set(fmt.Sprintf("user:%d", i), "value")
+	}
+	s.GeoAdd("places", false, false, false, []float64{1, 2}, []float64{1, 2}, []string{"a", "b"})
+
+	if keys := s.Keys("user:1?"); len(keys) != 10 {
+		t.Errorf("Expected 10 keys, got %d", len(keys))
+	}
+	if keys := s.Keys("*"); len(keys) != 101 {
+		t.Errorf("Expected 101 keys, got %d", len(keys))
+	}
+
+	// Scanning with keys being added still returns every original key
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for i := 0; ; i++ {
+		var keys []string
+		cursor, keys = s.Scan(cursor, &ScanOptions{Pattern: "user:*", Count: 5})
+		for _, key := range keys {
+			seen[key] = true
+		}
+		if cursor == 0 {
+			break
+		}
+		s.Set(fmt.Sprintf("new:%d", i), "value")
+	}
+	if len(seen) != 100 {
+		t.Errorf("Expected 100 distinct keys, got %d", len(seen))
+	}
+
+	_, keys := s.Scan(0, &ScanOptions{Count: 1000, Type: "zset"})
+	if len(keys) != 1 || keys[0] != "places" {
+		t.Errorf("Expected only the zset, got %v", keys)
+	}
+
+	cursor, elements, err := s.ZScan("places", 0, &ScanOptions{Pattern: "a", Count: 10})
+	if err != nil || cursor != 0 || len(elements) != 2 || elements[0] != "a" {
+		t.Errorf("Unexpected ZSCAN result %d %v %v", cursor, elements, err)
+	}
+	if _, _, err := s.ZScan("user:1", 0, &ScanOptions{Count: 10}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestStorageDatabases(t *testing.T) {
+	dbs := NewDatabases(2)
+	dbs[0].Set("key", "value")
+
+	if moved, _ := dbs[0].Move("key", dbs[1]); !moved || dbs[0].Len() != 0 || dbs[1].Len() != 1 {
+		t.Errorf("Expected key to be moved to database 1")
+	}
+	if _, err := dbs[1].Move("key", dbs[1]); err != ErrSameObject {
+		t.Errorf("Expected ErrSameObject, got %v", err)
+	}
+
+	dbs[0].Swap(dbs[1])
+	if value, exists := dbs[0].Get("key"); !exists || value != "value" {
+		t.Errorf("Expected key in database 0 after swapping, got %q, %v", value, exists)
+	}
+
+	if copied, _ := dbs[0].CopyTo("key", dbs[1], "key", false); !copied {
+		t.Error("Expected key to be copied to database 1")
+	}
+	// Both names being the same is fine across databases
+	if _, err := dbs[0].CopyTo("key", dbs[1], "key", true); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	dbs[0].Flush(true)
+	dbs[1].Flush(false)
+	if dbs[0].Len() != 0 || dbs[1].Len() != 0 {
+		t.Error("Expected both databases to be empty after flushing")
+	}
+}
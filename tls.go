@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+var (
+	ErrTLSNoCert   = errors.New("tls-cert-file and tls-key-file are required when tls-port is set")
+	ErrTLSNoCACert = errors.New("tls-ca-cert-file is required to authenticate clients, set tls-auth-clients no to disable")
+	ErrTLSCACert   = errors.New("no certificates found in tls-ca-cert-file")
+)
+
+// tlsHandshakeTimeout bounds how long a TLS client may take to handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// loadTLSConfig builds the server TLS settings from the tls-* directives. It
+// returns nil if tls-port isn't set.
+func loadTLSConfig(c *Config) (*tls.Config, error) {
+	if c.TLSPort == 0 {
+		return nil, nil
+	}
+	if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+		return nil, ErrTLSNoCert
+	}
+	certificate, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch c.TLSAuthClients {
+	case "yes":
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		tlsConfig.ClientAuth = tls.NoClientCert
+	}
+	if c.TLSCACertFile == "" {
+		if tlsConfig.ClientAuth != tls.NoClientCert {
+			return nil, ErrTLSNoCACert
+		}
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(c.TLSCACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading the TLS CA certificate: %w", err)
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, ErrTLSCACert
+	}
+	return tlsConfig, nil
+}
+
+// tlsHandshake completes the handshake of a TLS connection and, if
+// tls-auth-clients-user is CN, authenticates the client as the ACL user named
+// by the common name of its certificate. Connections that aren't TLS are left
+// alone.
+func (c *Client) tlsHandshake(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	state := tlsConn.ConnectionState()
+	if currentConfig().TLSAuthClientsUser != "CN" || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	name := state.PeerCertificates[0].Subject.CommonName
+	user := acl.authenticateCertificate(name)
+	if user == nil {
+		logVerbose("No enabled ACL user matches the client certificate common name", "name", name)
+		acl.Log("auth", "TLS", name, c)
+		return nil
+	}
+	c.mu.Lock()
+	c.user = user
+	c.authenticated = true
+	c.mu.Unlock()
+	return nil
+}
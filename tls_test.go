@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificates holds the PEM files of a CA, a server certificate for
+// 127.0.0.1 and a client certificate with the common name "tenant", all
+// generated in a temporary directory
+type testCertificates struct {
+	CA, ServerCert, ServerKey, ClientCert, ClientKey string
+}
+
+func writeTestCertificates(t *testing.T) testCertificates {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name, blockType string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	caKey := newKey()
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis-lite test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	issue := func(serial int64, commonName string, usage x509.ExtKeyUsage, name string) (string, string) {
+		key := newKey()
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: commonName},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return write(name+".crt", "CERTIFICATE", der), write(name+".key", "EC PRIVATE KEY", keyDER)
+	}
+
+	certs := testCertificates{CA: write("ca.crt", "CERTIFICATE", caDER)}
+	certs.ServerCert, certs.ServerKey = issue(2, "redis-lite", x509.ExtKeyUsageServerAuth, "server")
+	certs.ClientCert, certs.ClientKey = issue(3, "tenant", x509.ExtKeyUsageClientAuth, "client")
+	return certs
+}
+
+func TestLoadTLSConfig(t *testing.T) {
+	certs := writeTestCertificates(t)
+	c := DefaultConfig()
+	if tlsConfig, err := loadTLSConfig(c); tlsConfig != nil || err != nil {
+		t.Errorf("Expected no TLS without tls-port, got %v and %v", tlsConfig, err)
+	}
+
+	c.TLSPort = 6380
+	if _, err := loadTLSConfig(c); err != ErrTLSNoCert {
+		t.Errorf("Expected %v, got %v", ErrTLSNoCert, err)
+	}
+	c.TLSCertFile, c.TLSKeyFile = certs.ServerCert, certs.ServerKey
+	if _, err := loadTLSConfig(c); err != ErrTLSNoCACert {
+		t.Errorf("Expected %v, got %v", ErrTLSNoCACert, err)
+	}
+	c.TLSCACertFile = certs.ServerKey
+	if _, err := loadTLSConfig(c); err != ErrTLSCACert {
+		t.Errorf("Expected %v, got %v", ErrTLSCACert, err)
+	}
+
+	c.TLSCACertFile = certs.CA
+	for authClients, expected := range map[string]tls.ClientAuthType{
+		"yes":      tls.RequireAndVerifyClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"no":       tls.NoClientCert,
+	} {
+		c.TLSAuthClients = authClients
+		tlsConfig, err := loadTLSConfig(c)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", authClients, err)
+		}
+		if tlsConfig.ClientAuth != expected || len(tlsConfig.Certificates) != 1 {
+			t.Errorf("%s: unexpected client authentication %v", authClients, tlsConfig.ClientAuth)
+		}
+	}
+}
+
+func TestTLSConnection(t *testing.T) {
+	startTestServer()
+	certs := writeTestCertificates(t)
+	c := DefaultConfig()
+	c.TLSPort = 6380
+	c.TLSCertFile, c.TLSKeyFile, c.TLSCACertFile = certs.ServerCert, certs.ServerKey, certs.CA
+	c.TLSAuthClientsUser = "CN"
+	c.RequirePass = "secret"
+	acl.SetRequirePass(c.RequirePass)
+	defer acl.SetRequirePass("")
+
+	if err := acl.SetUser("tenant", []string{"on", "+@all", "~*"}); err != nil {
+		t.Fatal(err)
+	}
+	defer acl.DeleteUsers([]string{"tenant"})
+
+	withConfig(t, c, func() {
+		tlsConfig, err := loadTLSConfig(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := net.Listen(PROTOCOL, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+		defer listener.Close()
+		go acceptConnections(listener)
+
+		roots := x509.NewCertPool()
+		caPEM, _ := os.ReadFile(certs.CA)
+		roots.AppendCertsFromPEM(caPEM)
+		clientCert, err := tls.LoadX509KeyPair(certs.ClientCert, certs.ClientKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Without a client certificate the handshake fails
+		conn, err := tls.Dial(PROTOCOL, listener.Addr().String(), &tls.Config{RootCAs: roots})
+		if err == nil {
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = conn.Read(make([]byte, 1))
+			conn.Close()
+		}
+		if err == nil {
+			t.Error("Expected a connection without a client certificate to be rejected")
+		}
+
+		// The certificate common name authenticates the client as tenant
+		conn, err = tls.Dial(PROTOCOL, listener.Addr().String(), &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{clientCert},
+		})
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		writeCommand(t, conn, "ACL", "WHOAMI")
+		resp, err := ParseRESP(bufio.NewReader(conn))
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		if resp.Str != "tenant" {
+			t.Errorf("Expected tenant, got %v", resp)
+		}
+	})
+}